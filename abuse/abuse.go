@@ -0,0 +1,244 @@
+// Package abuse flags senders and api keys matching abuse rules for review
+// and can pause them.
+package abuse
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm/clause"
+
+	"github.com/ququzone/verifying-paymaster-service/cache"
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// Rules.
+const (
+	// RuleIPSenders is an api key sponsoring many senders from one client IP.
+	RuleIPSenders = "ip_senders"
+	// RuleCallDataBlast is the same callData sponsored for many senders.
+	RuleCallDataBlast = "calldata_blast"
+	// RuleQuotaDrain is an account spending its quota right after claiming
+	// it.
+	RuleQuotaDrain = "quota_drain"
+)
+
+// Operator is the audit log operator of automatic pauses.
+const Operator = "abuse-detector"
+
+// maxFlaggedSenders bounds the senders flagged for one callData.
+const maxFlaggedSenders = 500
+
+type Config struct {
+	Interval time.Duration
+	// Window is the trailing period the rules look at.
+	Window time.Duration
+	// IPSenders is the senders per client IP and api key above which the key
+	// is flagged.
+	IPSenders int64
+	// CallDataSenders is the senders of the same callData above which they
+	// are flagged.
+	CallDataSenders int64
+	// DrainPercent of its quota spent within DrainWindow of claiming it flags
+	// an account.
+	DrainPercent int64
+	DrainWindow  time.Duration
+	// AutoPause are the rules whose subjects are disabled when flagged.
+	AutoPause []string
+}
+
+// Detector checks the rules every interval and flags new matches.
+type Detector struct {
+	conf  *Config
+	rep   db.Repository
+	bus   *cache.Bus
+	pause map[string]bool
+}
+
+func NewDetector(conf *Config, rep db.Repository, bus *cache.Bus) *Detector {
+	if conf.Interval == 0 {
+		conf.Interval = 5 * time.Minute
+	}
+	if conf.Window == 0 {
+		conf.Window = time.Hour
+	}
+	if conf.DrainWindow == 0 {
+		conf.DrainWindow = 10 * time.Minute
+	}
+	pause := make(map[string]bool, len(conf.AutoPause))
+	for _, rule := range conf.AutoPause {
+		if rule != RuleIPSenders && rule != RuleCallDataBlast && rule != RuleQuotaDrain {
+			logger.S().Warnf("Unknown abuse rule %s to auto pause", rule)
+			continue
+		}
+		pause[rule] = true
+	}
+	return &Detector{conf: conf, rep: rep, bus: bus, pause: pause}
+}
+
+// Run checks the rules every interval until ctx is cancelled.
+func (d *Detector) Run(ctx context.Context) {
+	for {
+		if err := d.check(time.Now()); err != nil {
+			logger.S().Errorf("abuse detector error: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.conf.Interval):
+		}
+	}
+}
+
+func (d *Detector) check(now time.Time) error {
+	from := now.Add(-d.conf.Window)
+	if d.conf.IPSenders > 0 {
+		stats, err := models.FindIPSenders(d.rep, from, d.conf.IPSenders)
+		if err != nil {
+			return err
+		}
+		for _, s := range stats {
+			if s.ApiKeyID == 0 {
+				continue
+			}
+			detail := fmt.Sprintf("%d senders from %s in %s", s.Senders, s.ClientIP, d.conf.Window)
+			if err := d.flag(RuleIPSenders, models.SubjectApiKey, strconv.FormatUint(uint64(s.ApiKeyID), 10), detail); err != nil {
+				return err
+			}
+		}
+	}
+
+	if d.conf.CallDataSenders > 0 {
+		stats, err := models.FindCallDataSenders(d.rep, from, d.conf.CallDataSenders)
+		if err != nil {
+			return err
+		}
+		for _, s := range stats {
+			senders, err := models.SendersOfCallData(d.rep, s.CallDataHash, from, maxFlaggedSenders)
+			if err != nil {
+				return err
+			}
+			detail := fmt.Sprintf("callData %s sponsored for %d senders in %s", s.CallDataHash, s.Senders, d.conf.Window)
+			for _, sender := range senders {
+				if err := d.flag(RuleCallDataBlast, models.SubjectSender, sender, detail); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if d.conf.DrainPercent > 0 {
+		drains, err := models.FindQuotaDrains(d.rep, from, d.conf.DrainWindow)
+		if err != nil {
+			return err
+		}
+		for _, dr := range drains {
+			spent := models.ParseGas(dr.Spent)
+			quota := new(big.Int).Add(spent, models.ParseGas(dr.RemainGas))
+			if quota.Sign() <= 0 {
+				continue
+			}
+			// spent * 100 >= quota * DrainPercent
+			if new(big.Int).Mul(spent, big.NewInt(100)).Cmp(new(big.Int).Mul(quota, big.NewInt(d.conf.DrainPercent))) < 0 {
+				continue
+			}
+			detail := fmt.Sprintf("spent %s of %s wei quota in %d operations within %s of claiming it", spent, quota, dr.Ops, d.conf.DrainWindow)
+			if err := d.flag(RuleQuotaDrain, models.SubjectSender, dr.Address, detail); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// flag raises a flag for the subject unless one is open, pausing the
+// subject when the rule auto pauses.
+func (d *Detector) flag(rule, kind, subject, detail string) error {
+	var paused bool
+	err := d.rep.Transaction(func(tx db.Repository) error {
+		flag := &models.AbuseFlag{Rule: rule, Kind: kind, Subject: subject, Detail: detail}
+		created, err := models.RaiseAbuseFlag(tx, flag)
+		if err != nil || !created {
+			return err
+		}
+		logger.S().Warnf("Abuse rule %s flagged %s %s: %s", rule, kind, subject, detail)
+		if !d.pause[rule] {
+			return nil
+		}
+		paused, err = SetPaused(tx, kind, subject, true, "abuse_"+rule)
+		if err != nil || !paused {
+			return err
+		}
+		flag.Paused = true
+		if err := tx.Save(flag).Error; err != nil {
+			return err
+		}
+		logger.S().Warnf("Paused %s %s flagged by abuse rule %s", kind, subject, rule)
+		return models.Audit(tx, &models.AuditLog{
+			Operator: Operator,
+			Action:   "abuse_pause",
+			Subject:  subjectLabel(kind, subject),
+			Reason:   "abuse_" + rule,
+			Note:     detail,
+		}, &PauseState{Enabled: true}, &PauseState{Enabled: false})
+	})
+	if err == nil && paused && kind == models.SubjectApiKey {
+		d.bus.Publish(context.Background(), cache.ApiKey, subject)
+	}
+	return err
+}
+
+// PauseState is the audited state of a paused subject.
+type PauseState struct {
+	Enabled bool `json:"enabled"`
+}
+
+func subjectLabel(kind, subject string) string {
+	if kind == models.SubjectApiKey {
+		return "api_key:" + subject
+	}
+	return subject
+}
+
+// SetPaused disables or enables the accounts or api key subject and reports
+// whether any changed. The accounts of a sender on the shared and dedicated
+// paymasters are paused together. reason is the disabled reason of
+// accounts, only accounts disabled for reason are enabled again.
+func SetPaused(tx db.Repository, kind, subject string, paused bool, reason string) (bool, error) {
+	if kind == models.SubjectApiKey {
+		id, err := strconv.ParseUint(subject, 10, 32)
+		if err != nil {
+			return false, err
+		}
+		res := tx.Model(&models.ApiKeys{}).Where(`"id" = ? AND "enable" = ?`, id, paused).Update("enable", !paused)
+		return res.RowsAffected == 1, res.Error
+	}
+	var accounts []models.Account
+	err := tx.Model(&models.Account{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where(`"address" = ?`, subject).Find(&accounts).Error
+	if err != nil {
+		return false, err
+	}
+	changed := false
+	for n := range accounts {
+		account := &accounts[n]
+		if account.Enable == !paused || (!paused && account.DisabledReason != reason) {
+			continue
+		}
+		account.Enable = !paused
+		account.DisabledReason = ""
+		if paused {
+			account.DisabledReason = reason
+		}
+		if err := tx.Save(account).Error; err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
@@ -0,0 +1,115 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/abuse"
+	"github.com/ququzone/verifying-paymaster-service/cache"
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// abuseFlags returns the latest abuse flags, open ones by default.
+func (a *Admin) abuseFlags(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageSize)))
+	if err != nil || limit <= 0 || limit > maxPageSize {
+		badRequest(c, fmt.Errorf("limit must be between 1 and %d", maxPageSize))
+		return
+	}
+	status := c.DefaultQuery("status", models.AbuseOpen)
+	if status == "all" {
+		status = ""
+	}
+	flags, err := models.FindAbuseFlags(a.rep, status, limit)
+	if err != nil {
+		logger.S().Errorf("query abuse flags error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"flags": flags})
+}
+
+type reviewAbuseRequest struct {
+	// Status is dismissed or confirmed.
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+	Note   string `json:"note"`
+}
+
+// reviewAbuseFlag closes an open flag. Dismissing a flag that paused its
+// subject enables the subject again.
+func (a *Admin) reviewAbuseFlag(c *gin.Context) {
+	op, err := operator(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		badRequest(c, fmt.Errorf("invalid flag id: %s", c.Param("id")))
+		return
+	}
+	var req reviewAbuseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err)
+		return
+	}
+	if req.Status != models.AbuseDismissed && req.Status != models.AbuseConfirmed {
+		badRequest(c, fmt.Errorf("status must be %s or %s", models.AbuseDismissed, models.AbuseConfirmed))
+		return
+	}
+	if !reasonCode.MatchString(req.Reason) {
+		badRequest(c, fmt.Errorf("reason must match %s", reasonCode))
+		return
+	}
+
+	var flag models.AbuseFlag
+	var resumed bool
+	errNotOpen := fmt.Errorf("flag already reviewed")
+	err = a.rep.Transaction(func(tx db.Repository) error {
+		if err := tx.First(&flag, id).Error; err != nil {
+			return err
+		}
+		if flag.Status != models.AbuseOpen {
+			return errNotOpen
+		}
+		before := flag
+		flag.Status = req.Status
+		if flag.Paused && req.Status == models.AbuseDismissed {
+			if resumed, err = abuse.SetPaused(tx, flag.Kind, flag.Subject, false, "abuse_"+flag.Rule); err != nil {
+				return err
+			}
+			flag.Paused = false
+		}
+		if err := tx.Save(&flag).Error; err != nil {
+			return err
+		}
+		return models.Audit(tx, &models.AuditLog{
+			Operator: op,
+			Action:   "abuse_review",
+			Subject:  fmt.Sprintf("abuse_flag:%d", flag.ID),
+			Reason:   req.Reason,
+			Note:     req.Note,
+		}, &before, &flag)
+	})
+	switch {
+	case err == nil:
+		if resumed && flag.Kind == models.SubjectApiKey {
+			a.bus.Publish(c.Request.Context(), cache.ApiKey, flag.Subject)
+		}
+		c.JSON(http.StatusOK, &flag)
+	case err == gorm.ErrRecordNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "flag not found"})
+	case err == errNotOpen:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		logger.S().Errorf("review abuse flag error: %v", err)
+		c.Status(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,419 @@
+package admin
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/utils"
+)
+
+// AccountView is an account in the admin listing.
+type AccountView struct {
+	Address string `json:"address"`
+	// Paymaster is the dedicated paymaster of the account, empty for the
+	// shared one.
+	Paymaster string `json:"paymaster,omitempty"`
+	Enabled   bool   `json:"enabled"`
+	// DisabledReason is the reason code of a disabled account.
+	DisabledReason string    `json:"disabledReason,omitempty"`
+	VipID          int64     `json:"vipId"`
+	RemainGas      string    `json:"remainGas"`
+	UsedGas        string    `json:"usedGas"`
+	ReservedGas    string    `json:"reservedGas"`
+	LastRequest    time.Time `json:"lastRequest"`
+}
+
+type AccountList struct {
+	Accounts []*AccountView `json:"accounts"`
+	// Cursor fetches the next page, empty on the last page.
+	Cursor string `json:"cursor"`
+}
+
+// accountSortValue returns the sort key of rec as stored in the cursor.
+func accountSortValue(rec *models.Account, sort string) string {
+	switch sort {
+	case models.AccountSortLastRequest:
+		return rec.LastRequest.UTC().Format(time.RFC3339Nano)
+	case models.AccountSortUsedGas:
+		return models.ParseGas(rec.UsedGas).String()
+	default:
+		return ""
+	}
+}
+
+func parseAccountFilter(c *gin.Context) (*models.AccountFilter, error) {
+	filter := &models.AccountFilter{}
+	var err error
+	if filter.Enabled, err = parseBool(c, "enabled"); err != nil {
+		return nil, err
+	}
+	if filter.Vip, err = parseBool(c, "vip"); err != nil {
+		return nil, err
+	}
+	if v := c.Query("minUsedGas"); v != "" {
+		gas, ok := new(big.Int).SetString(v, 10)
+		if !ok || gas.Sign() < 0 {
+			return nil, fmt.Errorf("invalid minUsedGas: %s", v)
+		}
+		filter.MinUsedGas = gas.String()
+	}
+	if filter.LastRequestFrom, err = parseUnix(c, "lastRequestFrom"); err != nil {
+		return nil, err
+	}
+	if filter.LastRequestTo, err = parseUnix(c, "lastRequestTo"); err != nil {
+		return nil, err
+	}
+	if v, ok := c.GetQuery("paymaster"); ok {
+		paymaster, err := paymasterQuery(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid paymaster: %s", v)
+		}
+		filter.Paymaster = &paymaster
+	}
+	return filter, nil
+}
+
+// parseAccountPage reads the sort order and the cursor, which encodes the
+// sort key, sort value and id of the last account of the previous page.
+func parseAccountPage(c *gin.Context) (*models.AccountPage, error) {
+	page := &models.AccountPage{Sort: c.DefaultQuery("sort", models.AccountSortID)}
+	if !models.ValidAccountSort(page.Sort) {
+		return nil, fmt.Errorf("invalid sort: %s", page.Sort)
+	}
+	switch order := c.DefaultQuery("order", "asc"); order {
+	case "asc":
+	case "desc":
+		page.Desc = true
+	default:
+		return nil, fmt.Errorf("invalid order: %s", order)
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageSize)))
+	if err != nil || limit <= 0 || limit > maxPageSize {
+		return nil, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+	}
+	page.Limit = limit
+
+	cursor := c.Query("cursor")
+	if cursor == "" {
+		return page, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	parts := strings.SplitN(string(raw), "|", 3)
+	if len(parts) != 3 || parts[0] != page.Sort {
+		return nil, fmt.Errorf("invalid cursor for sort %s", page.Sort)
+	}
+	id, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil || id == 0 {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	page.ID = uint(id)
+	page.After = parts[2]
+	return page, nil
+}
+
+// accounts lists accounts with filters, sorting and cursor pagination.
+func (a *Admin) accounts(c *gin.Context) {
+	filter, err := parseAccountFilter(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	page, err := parseAccountPage(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	recs, err := models.FindAccounts(a.rep, filter, page)
+	if err != nil {
+		logger.S().Errorf("query accounts error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	list := &AccountList{Accounts: make([]*AccountView, len(recs))}
+	for n := range recs {
+		list.Accounts[n] = newAccountView(&recs[n])
+	}
+	if len(recs) == page.Limit {
+		last := &recs[len(recs)-1]
+		raw := fmt.Sprintf("%s|%d|%s", page.Sort, last.ID, accountSortValue(last, page.Sort))
+		list.Cursor = base64.RawURLEncoding.EncodeToString([]byte(raw))
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// AccountStatus is the audited state of an account status change.
+type AccountStatus struct {
+	Paymaster      string `json:"paymaster,omitempty"`
+	Enabled        bool   `json:"enabled"`
+	DisabledReason string `json:"disabledReason"`
+}
+
+type accountStatusRequest struct {
+	Enabled *bool  `json:"enabled"`
+	Reason  string `json:"reason"`
+	Note    string `json:"note"`
+}
+
+// setAccountStatus enables or disables an account. Disabling requires a
+// reason code, which pm_gasRemain reports to the account.
+func (a *Admin) setAccountStatus(c *gin.Context) {
+	op, err := operator(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	address, err := addressParam(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	var req accountStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err)
+		return
+	}
+	if req.Enabled == nil {
+		badRequest(c, fmt.Errorf("enabled required"))
+		return
+	}
+	if req.Reason != "" && !reasonCode.MatchString(req.Reason) {
+		badRequest(c, fmt.Errorf("reason must match %s", reasonCode))
+		return
+	}
+	if !*req.Enabled && req.Reason == "" {
+		badRequest(c, fmt.Errorf("reason required to disable an account"))
+		return
+	}
+
+	paymaster, err := paymasterQuery(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	var account *models.Account
+	err = a.rep.Transaction(func(tx db.Repository) error {
+		account, err = models.NewAccountRepository(tx).ForPaymaster(paymaster).FindForUpdate(address)
+		if err != nil {
+			return err
+		}
+		if account == nil {
+			return models.ErrAccountNotFound
+		}
+		before := &AccountStatus{Paymaster: paymaster, Enabled: account.Enable, DisabledReason: account.DisabledReason}
+		account.Enable = *req.Enabled
+		account.DisabledReason = ""
+		if !account.Enable {
+			account.DisabledReason = req.Reason
+		}
+		if err := tx.Save(account).Error; err != nil {
+			return err
+		}
+		action := "account_enable"
+		if !account.Enable {
+			action = "account_disable"
+		}
+		return models.Audit(tx, &models.AuditLog{
+			Operator: op,
+			Action:   action,
+			Subject:  address,
+			Reason:   req.Reason,
+			Note:     req.Note,
+		}, before, &AccountStatus{Paymaster: paymaster, Enabled: account.Enable, DisabledReason: account.DisabledReason})
+	})
+	if err == models.ErrAccountNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logger.S().Errorf("update account status error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, newAccountView(account))
+}
+
+func newAccountView(rec *models.Account) *AccountView {
+	return &AccountView{
+		Address:        rec.Address,
+		Paymaster:      rec.Paymaster,
+		Enabled:        rec.Enable,
+		DisabledReason: rec.DisabledReason,
+		VipID:          rec.VipID,
+		RemainGas:      rec.RemainGas,
+		UsedGas:        rec.UsedGas,
+		ReservedGas:    rec.ReservedGas,
+		LastRequest:    rec.LastRequest,
+	}
+}
+
+// AccountBalance is the audited state of a balance adjustment.
+type AccountBalance struct {
+	Paymaster string `json:"paymaster,omitempty"`
+	RemainGas string `json:"remainGas"`
+}
+
+type adjustBalanceRequest struct {
+	// Amount is the signed gas in wei credited to RemainGas.
+	Amount string `json:"amount"`
+	Reason string `json:"reason"`
+	Note   string `json:"note"`
+}
+
+// adjustBalance credits or debits the remaining gas of an account. A debit
+// cannot exceed the remaining gas.
+func (a *Admin) adjustBalance(c *gin.Context) {
+	op, err := operator(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	address, err := addressParam(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	var req adjustBalanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err)
+		return
+	}
+	amount, ok := new(big.Int).SetString(req.Amount, 10)
+	if !ok || amount.Sign() == 0 {
+		badRequest(c, fmt.Errorf("invalid amount: %s", req.Amount))
+		return
+	}
+	if !reasonCode.MatchString(req.Reason) {
+		badRequest(c, fmt.Errorf("reason must match %s", reasonCode))
+		return
+	}
+
+	paymaster, err := paymasterQuery(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	var account *models.Account
+	err = a.rep.Transaction(func(tx db.Repository) error {
+		account, err = models.NewAccountRepository(tx).ForPaymaster(paymaster).FindForUpdate(address)
+		if err != nil {
+			return err
+		}
+		if account == nil {
+			return models.ErrAccountNotFound
+		}
+		before := &AccountBalance{Paymaster: paymaster, RemainGas: models.ParseGas(account.RemainGas).String()}
+		remain := new(big.Int).Add(models.ParseGas(account.RemainGas), amount)
+		if remain.Sign() < 0 {
+			return models.ErrInsufficientGas
+		}
+		account.RemainGas = remain.String()
+		if err := tx.Save(account).Error; err != nil {
+			return err
+		}
+		action := "balance_credit"
+		if amount.Sign() < 0 {
+			action = "balance_debit"
+		}
+		return models.Audit(tx, &models.AuditLog{
+			Operator: op,
+			Action:   action,
+			Subject:  address,
+			Reason:   req.Reason,
+			Note:     req.Note,
+		}, before, &AccountBalance{Paymaster: paymaster, RemainGas: account.RemainGas})
+	})
+	switch err {
+	case nil:
+		c.JSON(http.StatusOK, newAccountView(account))
+	case models.ErrAccountNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case models.ErrInsufficientGas:
+		c.JSON(http.StatusConflict, gin.H{"error": "debit exceeds remaining gas"})
+	default:
+		logger.S().Errorf("adjust balance error: %v", err)
+		c.Status(http.StatusInternalServerError)
+	}
+}
+
+type migrateAccountRequest struct {
+	To     string `json:"to"`
+	Merge  bool   `json:"merge"`
+	Reason string `json:"reason"`
+	Note   string `json:"note"`
+}
+
+// migrateAccount moves an account's quota, vip linkage and history to a new
+// address, e.g. after the user redeployed their smart account.
+func (a *Admin) migrateAccount(c *gin.Context) {
+	op, err := operator(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	from, err := addressParam(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	var req migrateAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err)
+		return
+	}
+	to, err := utils.NormalizeAddress(req.To)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	if to == from {
+		badRequest(c, fmt.Errorf("cannot migrate an account to itself"))
+		return
+	}
+	if !reasonCode.MatchString(req.Reason) {
+		badRequest(c, fmt.Errorf("reason must match %s", reasonCode))
+		return
+	}
+
+	var dest *models.Account
+	err = a.rep.Transaction(func(tx db.Repository) error {
+		var source *models.Account
+		source, dest, err = models.MigrateAccount(tx, from, to, req.Merge)
+		if err != nil {
+			return err
+		}
+		return models.Audit(tx, &models.AuditLog{
+			Operator: op,
+			Action:   "account_migrate",
+			Subject:  from,
+			Reason:   req.Reason,
+			Note:     req.Note,
+		}, newAccountView(source), newAccountView(dest))
+	})
+	switch err {
+	case nil:
+		c.JSON(http.StatusOK, newAccountView(dest))
+	case models.ErrAccountNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case models.ErrAccountExists, models.ErrVipConflict, models.ErrGasReserved:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		logger.S().Errorf("migrate account error: %v", err)
+		c.Status(http.StatusInternalServerError)
+	}
+}
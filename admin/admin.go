@@ -0,0 +1,204 @@
+// Package admin serves the operator endpoints under /admin.
+package admin
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ququzone/verifying-paymaster-service/apikeys"
+	"github.com/ququzone/verifying-paymaster-service/cache"
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/utils"
+)
+
+// Admin serves the operator endpoints: reports and stats, and the writes
+// managing accounts, api keys, holds, pauses and the other operator state.
+// Every route requires the admin token or an operator token, writes are
+// recorded under the name of the operator.
+type Admin struct {
+	rep   db.Repository
+	token string
+	// operators maps the bearer token of each operator to its name
+	operators map[string]string
+	chainID   *big.Int
+	// bus invalidates the entries changed by admin writes on every replica
+	bus *cache.Bus
+	// keyRotation is the grace and lifetime of rotated api keys
+	keyRotation *apikeys.Config
+}
+
+func NewAdmin(rep db.Repository, token string, operators map[string]string, chainID *big.Int, bus *cache.Bus, keyRotation *apikeys.Config) *Admin {
+	return &Admin{rep: rep, token: token, operators: operators, chainID: chainID, bus: bus, keyRotation: keyRotation}
+}
+
+// ParseOperators parses comma separated name:token pairs into a map of
+// operator names by token.
+func ParseOperators(list string) (map[string]string, error) {
+	operators := make(map[string]string)
+	names := make(map[string]bool)
+	for _, pair := range strings.Split(list, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, token, ok := strings.Cut(pair, ":")
+		name, token = strings.TrimSpace(name), strings.TrimSpace(token)
+		if !ok || name == "" || len(name) > 64 || token == "" {
+			return nil, fmt.Errorf("invalid operator token %q, want name:token", name)
+		}
+		if names[name] || operators[token] != "" {
+			return nil, fmt.Errorf("duplicate operator token of %s", name)
+		}
+		names[name] = true
+		operators[token] = name
+	}
+	return operators, nil
+}
+
+// Register mounts the admin endpoints on r behind bearer token auth.
+func (a *Admin) Register(r gin.IRouter) {
+	g := r.Group("/admin", a.auth)
+	g.GET("/stats", a.stats)
+	g.GET("/stats/targets", a.targets)
+	g.GET("/stats/rejections", a.rejections)
+	g.GET("/reports/top-spenders", a.topSpenders)
+	g.GET("/reports/anomalies", a.anomalies)
+	g.GET("/reports/geo-blocks", a.geoBlocks)
+	g.GET("/reports/api-keys", a.keyOutcomes)
+	g.GET("/entrypoint-deposit", a.entryPointDeposit)
+	g.GET("/v1/sponsorships", a.sponsorships)
+	g.GET("/accounts", a.accounts)
+	g.POST("/accounts/:address/status", a.setAccountStatus)
+	g.POST("/accounts/:address/balance", a.adjustBalance)
+	g.POST("/accounts/:address/migrate", a.migrateAccount)
+	g.POST("/accounts/:address/passkey/reset", a.resetPasskey)
+	g.POST("/accounts/:address/identities/unlink", a.unlinkIdentities)
+	g.GET("/projects/:id/credits", a.credits)
+	g.POST("/projects/:id/credits", a.grantCredits)
+	g.GET("/throttles", a.throttles)
+	g.POST("/throttles/:id/lift", a.liftThrottle)
+	g.GET("/abuse-flags", a.abuseFlags)
+	g.POST("/abuse-flags/:id/review", a.reviewAbuseFlag)
+	g.GET("/screening/appeals", a.screeningAppeals)
+	g.POST("/screening/appeals/:id/resolve", a.resolveAppeal)
+	g.GET("/screening/:address", a.screeningDecision)
+	g.POST("/screening/:address/override", a.overrideScreening)
+	g.GET("/holds", a.holds)
+	g.POST("/holds/:id/review", a.reviewHold)
+	g.GET("/pauses", a.pauses)
+	g.POST("/pauses", a.pause)
+	g.POST("/pauses/:apiKeyId/resume", a.resume)
+	g.GET("/maintenance", a.maintenanceWindows)
+	g.POST("/maintenance", a.scheduleMaintenance)
+	g.POST("/maintenance/:id/cancel", a.cancelMaintenance)
+	g.POST("/api-keys/:id/rotate", a.rotateApiKey)
+	g.POST("/api-keys/:id/expiry", a.setApiKeyExpiry)
+	g.POST("/api-keys/:id/scopes", a.setApiKeyScopes)
+	g.POST("/api-keys/:id/paymaster", a.setApiKeyPaymaster)
+	g.GET("/bot-campaigns", a.botCampaigns)
+	g.POST("/bot-campaigns", a.createBotCampaign)
+	g.POST("/bot-campaigns/:id/end", a.endBotCampaign)
+	g.GET("/error-templates", a.errorTemplates)
+	g.POST("/error-templates", a.setErrorTemplate)
+	g.GET("/audit", a.auditLog)
+}
+
+// auth accepts the shared token and the operator tokens. A request with an
+// operator token is attributed to that operator.
+func (a *Admin) auth(c *gin.Context) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	for operatorToken, name := range a.operators {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(operatorToken)) == 1 {
+			c.Set(operatorKey, name)
+			c.Next()
+			return
+		}
+	}
+	if a.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	c.Next()
+}
+
+// operatorHeader names the operator responsible for a change made with the
+// shared token.
+const operatorHeader = "X-Admin-Operator"
+
+// operatorKey is the context key of the operator authenticated by its token.
+const operatorKey = "admin-operator"
+
+var reasonCode = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// operator returns the operator of a mutating request, which is required for
+// the audit log: the operator of the token, or the one named in
+// operatorHeader with the shared token.
+func operator(c *gin.Context) (string, error) {
+	if op, ok := authenticatedOperator(c); ok {
+		return op, nil
+	}
+	op := strings.TrimSpace(c.GetHeader(operatorHeader))
+	if op == "" || len(op) > 64 {
+		return "", fmt.Errorf("%s header required", operatorHeader)
+	}
+	return op, nil
+}
+
+// authenticatedOperator returns the operator authenticated by its token.
+func authenticatedOperator(c *gin.Context) (string, bool) {
+	op, ok := c.Get(operatorKey)
+	if !ok {
+		return "", false
+	}
+	return op.(string), true
+}
+
+// addressParam returns the normalized :address path parameter.
+func addressParam(c *gin.Context) (string, error) {
+	return utils.NormalizeAddress(c.Param("address"))
+}
+
+// paymasterQuery is the dedicated paymaster of the paymaster query param,
+// empty for the shared one.
+func paymasterQuery(c *gin.Context) (string, error) {
+	if c.Query("paymaster") == "" {
+		return "", nil
+	}
+	return utils.NormalizeAddress(c.Query("paymaster"))
+}
+
+func badRequest(c *gin.Context, err error) {
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+func parseBool(c *gin.Context, name string) (*bool, error) {
+	v := c.Query(name)
+	if v == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", name, v)
+	}
+	return &b, nil
+}
+
+func parseUnix(c *gin.Context, name string) (time.Time, error) {
+	v := c.Query(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	sec, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: %s", name, v)
+	}
+	return time.Unix(sec, 0), nil
+}
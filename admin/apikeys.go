@@ -0,0 +1,314 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ququzone/verifying-paymaster-service/apikeys"
+	"github.com/ququzone/verifying-paymaster-service/cache"
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/utils"
+)
+
+type rotateApiKeyRequest struct {
+	// Grace is how long the current key stays valid in seconds, the
+	// configured grace when zero.
+	Grace  int64  `json:"grace"`
+	Reason string `json:"reason"`
+	Note   string `json:"note"`
+}
+
+// rotateApiKey replaces the key of an api key with a new one, the current key
+// staying valid for the grace period.
+func (a *Admin) rotateApiKey(c *gin.Context) {
+	op, err := operator(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		badRequest(c, fmt.Errorf("invalid api key id: %s", c.Param("id")))
+		return
+	}
+	var req rotateApiKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err)
+		return
+	}
+	if !reasonCode.MatchString(req.Reason) {
+		badRequest(c, fmt.Errorf("reason must match %s", reasonCode))
+		return
+	}
+	if req.Grace < 0 {
+		badRequest(c, fmt.Errorf("grace must not be negative"))
+		return
+	}
+	grace := a.keyRotation.Grace
+	if req.Grace > 0 {
+		grace = time.Duration(req.Grace) * time.Second
+	}
+
+	var key models.ApiKeys
+	err = a.rep.Transaction(func(tx db.Repository) error {
+		if err := tx.Model(&models.ApiKeys{}).Clauses(clause.Locking{Strength: "UPDATE"}).First(&key, id).Error; err != nil {
+			return err
+		}
+		before := apikeys.NewRotationState(&key)
+		if err := key.Rotate(time.Now(), grace, a.keyRotation.Lifetime); err != nil {
+			return err
+		}
+		if err := tx.Save(&key).Error; err != nil {
+			return err
+		}
+		return models.Audit(tx, &models.AuditLog{
+			Operator: op,
+			Action:   "api_key_rotate",
+			Subject:  fmt.Sprintf("api_key:%d", key.ID),
+			Reason:   req.Reason,
+			Note:     req.Note,
+		}, before, apikeys.NewRotationState(&key))
+	})
+	switch {
+	case err == nil:
+		a.bus.Publish(c.Request.Context(), cache.ApiKey, strconv.FormatUint(id, 10))
+		c.JSON(http.StatusOK, gin.H{
+			"id":                   key.ID,
+			"key":                  key.Key,
+			"expiresAt":            key.ExpiresAt,
+			"previousKeyExpiresAt": key.PreviousKeyExpiresAt,
+		})
+	case err == gorm.ErrRecordNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "api key not found"})
+	default:
+		logger.S().Errorf("rotate api key error: %v", err)
+		c.Status(http.StatusInternalServerError)
+	}
+}
+
+type apiKeyExpiryRequest struct {
+	// ExpiresAt is the unix time the key expires at, zero never.
+	ExpiresAt  int64  `json:"expiresAt"`
+	AutoRotate bool   `json:"autoRotate"`
+	Reason     string `json:"reason"`
+	Note       string `json:"note"`
+}
+
+// setApiKeyExpiry sets when an api key expires and whether it is rotated
+// automatically before.
+func (a *Admin) setApiKeyExpiry(c *gin.Context) {
+	op, err := operator(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		badRequest(c, fmt.Errorf("invalid api key id: %s", c.Param("id")))
+		return
+	}
+	var req apiKeyExpiryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err)
+		return
+	}
+	if !reasonCode.MatchString(req.Reason) {
+		badRequest(c, fmt.Errorf("reason must match %s", reasonCode))
+		return
+	}
+
+	var key models.ApiKeys
+	err = a.rep.Transaction(func(tx db.Repository) error {
+		if err := tx.Model(&models.ApiKeys{}).Clauses(clause.Locking{Strength: "UPDATE"}).First(&key, id).Error; err != nil {
+			return err
+		}
+		before := gin.H{"expiresAt": key.ExpiresAt, "autoRotate": key.AutoRotate}
+		key.ExpiresAt = nil
+		if req.ExpiresAt > 0 {
+			expiresAt := time.Unix(req.ExpiresAt, 0)
+			key.ExpiresAt = &expiresAt
+		}
+		key.AutoRotate = req.AutoRotate
+		// a new expiry is reminded again
+		key.ExpiryNotifiedAt = nil
+		if err := tx.Save(&key).Error; err != nil {
+			return err
+		}
+		return models.Audit(tx, &models.AuditLog{
+			Operator: op,
+			Action:   "api_key_expiry",
+			Subject:  fmt.Sprintf("api_key:%d", key.ID),
+			Reason:   req.Reason,
+			Note:     req.Note,
+		}, before, gin.H{"expiresAt": key.ExpiresAt, "autoRotate": key.AutoRotate})
+	})
+	switch {
+	case err == nil:
+		a.bus.Publish(c.Request.Context(), cache.ApiKey, strconv.FormatUint(id, 10))
+		c.JSON(http.StatusOK, gin.H{"id": key.ID, "expiresAt": key.ExpiresAt, "autoRotate": key.AutoRotate})
+	case err == gorm.ErrRecordNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "api key not found"})
+	default:
+		logger.S().Errorf("set api key expiry error: %v", err)
+		c.Status(http.StatusInternalServerError)
+	}
+}
+
+type apiKeyScopesRequest struct {
+	// Scopes the key may call, every scope when empty.
+	Scopes []string `json:"scopes"`
+	Reason string   `json:"reason"`
+	Note   string   `json:"note"`
+}
+
+// setApiKeyScopes limits the methods an api key may call.
+func (a *Admin) setApiKeyScopes(c *gin.Context) {
+	op, err := operator(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		badRequest(c, fmt.Errorf("invalid api key id: %s", c.Param("id")))
+		return
+	}
+	var req apiKeyScopesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err)
+		return
+	}
+	if !reasonCode.MatchString(req.Reason) {
+		badRequest(c, fmt.Errorf("reason must match %s", reasonCode))
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !models.ValidScope(scope) {
+			badRequest(c, fmt.Errorf("unknown scope %q, scopes are %s", scope, strings.Join(models.Scopes, ", ")))
+			return
+		}
+	}
+
+	var key models.ApiKeys
+	err = a.rep.Transaction(func(tx db.Repository) error {
+		if err := tx.Model(&models.ApiKeys{}).Clauses(clause.Locking{Strength: "UPDATE"}).First(&key, id).Error; err != nil {
+			return err
+		}
+		before := key.Scopes
+		key.Scopes = strings.Join(req.Scopes, ",")
+		if err := tx.Model(&key).Update("scopes", key.Scopes).Error; err != nil {
+			return err
+		}
+		return models.Audit(tx, &models.AuditLog{
+			Operator: op,
+			Action:   "api_key_scopes",
+			Subject:  fmt.Sprintf("api_key:%d", key.ID),
+			Reason:   req.Reason,
+			Note:     req.Note,
+		}, gin.H{"scopes": before}, gin.H{"scopes": key.Scopes})
+	})
+	switch {
+	case err == nil:
+		a.bus.Publish(c.Request.Context(), cache.ApiKey, strconv.FormatUint(id, 10))
+		c.JSON(http.StatusOK, gin.H{"id": key.ID, "scopes": req.Scopes})
+	case err == gorm.ErrRecordNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "api key not found"})
+	default:
+		logger.S().Errorf("set api key scopes error: %v", err)
+		c.Status(http.StatusInternalServerError)
+	}
+}
+
+type apiKeyPaymasterRequest struct {
+	// ChainID is the chain of the binding, the default chain when zero.
+	ChainID uint64 `json:"chainId"`
+	// Contract is the dedicated paymaster, empty to return to the shared
+	// one.
+	Contract string `json:"contract"`
+	Reason   string `json:"reason"`
+	Note     string `json:"note"`
+}
+
+// setApiKeyPaymaster binds an api key to a dedicated paymaster contract.
+func (a *Admin) setApiKeyPaymaster(c *gin.Context) {
+	op, err := operator(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		badRequest(c, fmt.Errorf("invalid api key id: %s", c.Param("id")))
+		return
+	}
+	var req apiKeyPaymasterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err)
+		return
+	}
+	if !reasonCode.MatchString(req.Reason) {
+		badRequest(c, fmt.Errorf("reason must match %s", reasonCode))
+		return
+	}
+	contract := ""
+	if req.Contract != "" {
+		if contract, err = utils.NormalizeAddress(req.Contract); err != nil {
+			badRequest(c, err)
+			return
+		}
+	}
+	chainID := req.ChainID
+	if chainID == 0 {
+		chainID = a.chainID.Uint64()
+	}
+
+	err = a.rep.Transaction(func(tx db.Repository) error {
+		var key models.ApiKeys
+		if err := tx.Model(&models.ApiKeys{}).Clauses(clause.Locking{Strength: "UPDATE"}).First(&key, id).Error; err != nil {
+			return err
+		}
+		rec, err := models.FindKeyPaymaster(tx, key.ID, chainID)
+		if err != nil {
+			return err
+		}
+		before := ""
+		switch {
+		case rec != nil && contract == "":
+			before = rec.Contract
+			err = tx.Model(rec).Unscoped().Delete(rec).Error
+		case rec != nil:
+			before = rec.Contract
+			err = tx.Model(rec).Update("contract", contract).Error
+		case contract != "":
+			err = tx.Create(&models.KeyPaymaster{ApiKeyID: key.ID, ChainID: chainID, Contract: contract}).Error
+		}
+		if err != nil {
+			return err
+		}
+		return models.Audit(tx, &models.AuditLog{
+			Operator: op,
+			Action:   "api_key_paymaster",
+			Subject:  fmt.Sprintf("api_key:%d", key.ID),
+			Reason:   req.Reason,
+			Note:     req.Note,
+		}, gin.H{"chainId": chainID, "contract": before}, gin.H{"chainId": chainID, "contract": contract})
+	})
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"id": id, "chainId": chainID, "contract": contract})
+	case err == gorm.ErrRecordNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "api key not found"})
+	default:
+		logger.S().Errorf("set api key paymaster error: %v", err)
+		c.Status(http.StatusInternalServerError)
+	}
+}
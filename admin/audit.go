@@ -0,0 +1,36 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/utils"
+)
+
+// auditLog returns the latest audit entries, optionally of one subject.
+func (a *Admin) auditLog(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageSize)))
+	if err != nil || limit <= 0 || limit > maxPageSize {
+		badRequest(c, fmt.Errorf("limit must be between 1 and %d", maxPageSize))
+		return
+	}
+	var subject string
+	if v := c.Query("subject"); v != "" {
+		if subject, err = utils.NormalizeAddress(v); err != nil {
+			badRequest(c, err)
+			return
+		}
+	}
+	entries, err := (&models.AuditLog{}).FindBySubject(a.rep, subject, limit)
+	if err != nil {
+		logger.S().Errorf("query audit log error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
@@ -0,0 +1,173 @@
+package admin
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/bots"
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// botCampaigns lists the community bot campaigns, latest first.
+func (a *Admin) botCampaigns(c *gin.Context) {
+	var campaigns []models.BotCampaign
+	if err := a.rep.Model(&models.BotCampaign{}).Order("id DESC").Find(&campaigns).Error; err != nil {
+		logger.S().Errorf("query bot campaigns error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"campaigns": campaigns})
+}
+
+type botCampaignRequest struct {
+	Name     string `json:"name"`
+	Platform string `json:"platform"`
+	GuildID  string `json:"guildId"`
+	// VerifyKey is the Discord application public key or the Telegram bot
+	// token.
+	VerifyKey string `json:"verifyKey"`
+	ClaimGas  string `json:"claimGas"`
+	Budget    string `json:"budget"`
+	// EndsAt is unix seconds, 0 runs until ended.
+	EndsAt int64  `json:"endsAt"`
+	Reason string `json:"reason"`
+	Note   string `json:"note"`
+}
+
+// createBotCampaign starts a campaign and returns the token of its bot,
+// which is not shown again.
+func (a *Admin) createBotCampaign(c *gin.Context) {
+	op, err := operator(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	var req botCampaignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err)
+		return
+	}
+	if !reasonCode.MatchString(req.Reason) {
+		badRequest(c, fmt.Errorf("reason must match %s", reasonCode))
+		return
+	}
+	if !models.ValidBotPlatform(req.Platform) {
+		badRequest(c, fmt.Errorf("platform must be %s or %s", models.BotDiscord, models.BotTelegram))
+		return
+	}
+	if req.GuildID == "" || len(req.GuildID) > 64 || req.VerifyKey == "" || len(req.VerifyKey) > 128 {
+		badRequest(c, fmt.Errorf("guildId and verifyKey required"))
+		return
+	}
+	claimGas, ok := new(big.Int).SetString(req.ClaimGas, 10)
+	if !ok || claimGas.Sign() <= 0 {
+		badRequest(c, fmt.Errorf("invalid claimGas: %s", req.ClaimGas))
+		return
+	}
+	budget, ok := new(big.Int).SetString(req.Budget, 10)
+	if !ok || budget.Cmp(claimGas) < 0 {
+		badRequest(c, fmt.Errorf("budget must cover a claim: %s", req.Budget))
+		return
+	}
+	token, tokenHash, err := bots.NewToken()
+	if err != nil {
+		logger.S().Errorf("generate bot token error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	campaign := models.BotCampaign{
+		Name:      req.Name,
+		Platform:  req.Platform,
+		GuildID:   req.GuildID,
+		TokenHash: tokenHash,
+		VerifyKey: req.VerifyKey,
+		ClaimGas:  claimGas.String(),
+		Budget:    budget.String(),
+		SpentGas:  "0",
+	}
+	if req.EndsAt > 0 {
+		endsAt := time.Unix(req.EndsAt, 0)
+		campaign.EndsAt = &endsAt
+	}
+	err = a.rep.Transaction(func(tx db.Repository) error {
+		if err := tx.Create(&campaign).Error; err != nil {
+			return err
+		}
+		return models.Audit(tx, &models.AuditLog{
+			Operator: op,
+			Action:   "bot_campaign_create",
+			Subject:  fmt.Sprintf("bot_campaign:%d", campaign.ID),
+			Reason:   req.Reason,
+			Note:     req.Note,
+		}, nil, &campaign)
+	})
+	if err != nil {
+		logger.S().Errorf("create bot campaign error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"campaign": &campaign, "token": token})
+}
+
+type endBotCampaignRequest struct {
+	Reason string `json:"reason"`
+	Note   string `json:"note"`
+}
+
+// endBotCampaign stops the claims of a campaign.
+func (a *Admin) endBotCampaign(c *gin.Context) {
+	op, err := operator(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		badRequest(c, fmt.Errorf("invalid campaign id: %s", c.Param("id")))
+		return
+	}
+	var req endBotCampaignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err)
+		return
+	}
+	if !reasonCode.MatchString(req.Reason) {
+		badRequest(c, fmt.Errorf("reason must match %s", reasonCode))
+		return
+	}
+
+	var campaign models.BotCampaign
+	err = a.rep.Transaction(func(tx db.Repository) error {
+		if err := tx.First(&campaign, id).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&campaign).Update("ended", true).Error; err != nil {
+			return err
+		}
+		return models.Audit(tx, &models.AuditLog{
+			Operator: op,
+			Action:   "bot_campaign_end",
+			Subject:  fmt.Sprintf("bot_campaign:%d", campaign.ID),
+			Reason:   req.Reason,
+			Note:     req.Note,
+		}, gin.H{"spentGas": campaign.SpentGas}, nil)
+	})
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, &campaign)
+	case err == gorm.ErrRecordNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "campaign not found"})
+	default:
+		logger.S().Errorf("end bot campaign error: %v", err)
+		c.Status(http.StatusInternalServerError)
+	}
+}
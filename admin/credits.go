@@ -0,0 +1,153 @@
+package admin
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// CreditPackView is an unexpired credit pack of a project.
+type CreditPackView struct {
+	ID        uint   `json:"id"`
+	Amount    string `json:"amount"`
+	Remaining string `json:"remaining"`
+	Source    string `json:"source"`
+	Reference string `json:"reference"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+func projectParam(c *gin.Context) (uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("invalid project id: %s", c.Param("id"))
+	}
+	return uint(id), nil
+}
+
+// credits lists the unexpired credit packs of a project in consumption
+// order.
+func (a *Admin) credits(c *gin.Context) {
+	id, err := projectParam(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	packs, err := models.FindCreditPacks(a.rep, id, time.Now())
+	if err != nil {
+		logger.S().Errorf("query credit packs error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	views := make([]CreditPackView, 0, len(packs))
+	for _, pack := range packs {
+		views = append(views, CreditPackView{
+			ID:        pack.ID,
+			Amount:    pack.Amount,
+			Remaining: pack.Remaining.String(),
+			Source:    pack.Source,
+			Reference: pack.Reference,
+			ExpiresAt: pack.ExpiresAt.Unix(),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"packs": views})
+}
+
+type grantCreditsRequest struct {
+	// Amount is the gas cost in wei the pack covers.
+	Amount string `json:"amount"`
+	// ValidDays is the lifetime of the pack.
+	ValidDays uint16 `json:"validDays"`
+	// Reference identifies the purchase, a pack is granted once per
+	// reference.
+	Reference string `json:"reference"`
+	Reason    string `json:"reason"`
+	Note      string `json:"note"`
+}
+
+// grantCredits adds a credit pack to a project.
+func (a *Admin) grantCredits(c *gin.Context) {
+	op, err := operator(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	id, err := projectParam(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	var req grantCreditsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err)
+		return
+	}
+	amount, ok := new(big.Int).SetString(req.Amount, 10)
+	if !ok || amount.Sign() <= 0 {
+		badRequest(c, fmt.Errorf("invalid amount: %s", req.Amount))
+		return
+	}
+	if req.ValidDays == 0 {
+		badRequest(c, fmt.Errorf("validDays required"))
+		return
+	}
+	if req.Reference == "" || len(req.Reference) > 200 {
+		badRequest(c, fmt.Errorf("reference required"))
+		return
+	}
+	if !reasonCode.MatchString(req.Reason) {
+		badRequest(c, fmt.Errorf("reason must match %s", reasonCode))
+		return
+	}
+
+	pack := &models.CreditEntry{
+		UserID:    id,
+		Amount:    amount.String(),
+		Source:    models.CreditSourceAdmin,
+		Reference: "admin:" + req.Reference,
+		ExpiresAt: time.Now().Add(time.Duration(req.ValidDays) * 24 * time.Hour),
+	}
+	var granted bool
+	err = a.rep.Transaction(func(tx db.Repository) error {
+		if err := tx.First(&models.User{}, id).Error; err != nil {
+			return err
+		}
+		granted, err = models.GrantCredits(tx, pack)
+		if err != nil || !granted {
+			return err
+		}
+		return models.Audit(tx, &models.AuditLog{
+			Operator: op,
+			Action:   "credit_grant",
+			Subject:  fmt.Sprintf("project:%d", id),
+			Reason:   req.Reason,
+			Note:     req.Note,
+		}, nil, pack)
+	})
+	switch {
+	case err == nil && !granted:
+		c.JSON(http.StatusConflict, gin.H{"error": "reference already granted"})
+	case err == nil:
+		c.JSON(http.StatusOK, &CreditPackView{
+			ID:        pack.ID,
+			Amount:    pack.Amount,
+			Remaining: pack.Amount,
+			Source:    pack.Source,
+			Reference: pack.Reference,
+			ExpiresAt: pack.ExpiresAt.Unix(),
+		})
+	case err == gorm.ErrRecordNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+	default:
+		logger.S().Errorf("grant credits error: %v", err)
+		c.Status(http.StatusInternalServerError)
+	}
+}
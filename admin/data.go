@@ -0,0 +1,115 @@
+package admin
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ququzone/verifying-paymaster-service/export"
+	"github.com/ququzone/verifying-paymaster-service/indexer"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/utils"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// SponsorshipPage is a page of the sponsorship change feed. Cursor resumes
+// after the last record and is returned even when the page is empty.
+type SponsorshipPage struct {
+	Records      []*export.Record `json:"records"`
+	Cursor       string           `json:"cursor"`
+	IndexedBlock uint64           `json:"indexedBlock"`
+}
+
+// encodeCursor returns the opaque cursor of the (updatedAt, id) position.
+func encodeCursor(updatedAt time.Time, id uint) string {
+	if id == 0 {
+		return ""
+	}
+	raw := fmt.Sprintf("%d:%d", updatedAt.UnixMicro(), id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (time.Time, uint, error) {
+	if cursor == "" {
+		return time.Time{}, 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor")
+	}
+	micros, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor")
+	}
+	m, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor")
+	}
+	n, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor")
+	}
+	return time.UnixMicro(m), uint(n), nil
+}
+
+// sponsorships serves sponsorship versions in the order they were created or
+// updated, so consumers can mirror the accounting by following the cursor.
+func (a *Admin) sponsorships(c *gin.Context) {
+	updatedAt, id, err := decodeCursor(c.Query("cursor"))
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageSize)))
+	if err != nil || limit <= 0 || limit > maxPageSize {
+		badRequest(c, fmt.Errorf("limit must be between 1 and %d", maxPageSize))
+		return
+	}
+	var sender string
+	if v := c.Query("sender"); v != "" {
+		if sender, err = utils.NormalizeAddress(v); err != nil {
+			badRequest(c, err)
+			return
+		}
+	}
+
+	checkpoint, err := (&models.Checkpoint{}).FindByName(a.rep, indexer.Checkpoint(a.chainID))
+	if err != nil {
+		logger.S().Errorf("query indexer checkpoint error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	recs, err := (&models.Sponsorship{}).FindUpdatedAfter(a.rep, updatedAt, id, 0, sender, limit)
+	if err != nil {
+		logger.S().Errorf("query sponsorships error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	page := &SponsorshipPage{
+		Records: make([]*export.Record, len(recs)),
+		Cursor:  c.Query("cursor"),
+	}
+	if checkpoint != nil {
+		page.IndexedBlock = checkpoint.BlockNumber
+	}
+	now := time.Now()
+	for n := range recs {
+		page.Records[n] = export.NewRecord(a.chainID, &recs[n], now)
+	}
+	if len(recs) > 0 {
+		last := recs[len(recs)-1]
+		page.Cursor = encodeCursor(last.UpdatedAt, last.ID)
+	}
+	c.JSON(http.StatusOK, page)
+}
@@ -0,0 +1,23 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// entryPointDeposit shows the EntryPoint deposit of every paymaster, shared
+// and dedicated, against the outstanding sponsorships and the safety buffer, as last
+// recorded by the deposit monitor.
+func (a *Admin) entryPointDeposit(c *gin.Context) {
+	snapshots, err := models.DepositSnapshots(a.rep)
+	if err != nil {
+		logger.S().Errorf("query deposit snapshots error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deposits": snapshots})
+}
@@ -0,0 +1,177 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// HoldView is a held operation with its current status and approvers.
+type HoldView struct {
+	models.HeldOperation
+	Status    string   `json:"status"`
+	Approvers []string `json:"approvers"`
+}
+
+func (a *Admin) holdView(hold *models.HeldOperation, now time.Time) (*HoldView, error) {
+	approvals, err := models.HoldApprovals(a.rep, hold.ID)
+	if err != nil {
+		return nil, err
+	}
+	view := &HoldView{HeldOperation: *hold, Status: hold.CurrentStatus(now), Approvers: make([]string, 0, len(approvals))}
+	for _, approval := range approvals {
+		view.Approvers = append(view.Approvers, approval.Operator)
+	}
+	return view, nil
+}
+
+// holds returns the latest held operations, those awaiting a decision by
+// default.
+func (a *Admin) holds(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageSize)))
+	if err != nil || limit <= 0 || limit > maxPageSize {
+		badRequest(c, fmt.Errorf("limit must be between 1 and %d", maxPageSize))
+		return
+	}
+	status := c.DefaultQuery("status", models.HoldPending)
+	if status == "all" {
+		status = ""
+	}
+	now := time.Now()
+	holds, err := models.FindHolds(a.rep, status, now, limit)
+	if err != nil {
+		logger.S().Errorf("query held operations error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	views := make([]*HoldView, 0, len(holds))
+	for n := range holds {
+		view, err := a.holdView(&holds[n], now)
+		if err != nil {
+			logger.S().Errorf("query hold approvals error: %v", err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		views = append(views, view)
+	}
+	c.JSON(http.StatusOK, gin.H{"holds": views})
+}
+
+type reviewHoldRequest struct {
+	// Status is approved or rejected.
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+	Note   string `json:"note"`
+}
+
+// reviewHold approves or rejects a held operation within its approval
+// window. Operations needing several approvals stay held until as many
+// distinct operators approved them, one rejection rejects them. Operators
+// review those with their own token, the shared token cannot tell them
+// apart. An approved operation is signed when the client resubmits it.
+func (a *Admin) reviewHold(c *gin.Context) {
+	op, err := operator(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		badRequest(c, fmt.Errorf("invalid hold id: %s", c.Param("id")))
+		return
+	}
+	var req reviewHoldRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err)
+		return
+	}
+	if req.Status != models.HoldApproved && req.Status != models.HoldRejected {
+		badRequest(c, fmt.Errorf("status must be %s or %s", models.HoldApproved, models.HoldRejected))
+		return
+	}
+	if !reasonCode.MatchString(req.Reason) {
+		badRequest(c, fmt.Errorf("reason must match %s", reasonCode))
+		return
+	}
+
+	var hold models.HeldOperation
+	errDecided := fmt.Errorf("operation not awaiting approval")
+	errApproved := fmt.Errorf("operator already approved the operation")
+	errShared := fmt.Errorf("operation needs several approvals, review it with an operator token")
+	_, identified := authenticatedOperator(c)
+	err = a.rep.Transaction(func(tx db.Repository) error {
+		err := tx.Model(&models.HeldOperation{}).Clauses(clause.Locking{Strength: "UPDATE"}).First(&hold, id).Error
+		if err != nil {
+			return err
+		}
+		if hold.CurrentStatus(time.Now()) != models.HoldPending {
+			return errDecided
+		}
+		if hold.RequiredApprovals > 1 && !identified {
+			return errShared
+		}
+		before := hold
+		action := "hold_reject"
+		if req.Status == models.HoldRejected {
+			hold.Status = models.HoldRejected
+			hold.Operator = op
+		} else {
+			approvals, err := models.HoldApprovals(tx, hold.ID)
+			if err != nil {
+				return err
+			}
+			for _, approval := range approvals {
+				if approval.Operator == op {
+					return errApproved
+				}
+			}
+			if err := tx.Create(&models.HoldApproval{HoldID: hold.ID, Operator: op}).Error; err != nil {
+				return err
+			}
+			action = "hold_approve"
+			hold.Approvals = uint(len(approvals)) + 1
+			if hold.Approvals >= hold.RequiredApprovals {
+				hold.Status = models.HoldApproved
+				hold.Operator = op
+			}
+		}
+		if err := tx.Save(&hold).Error; err != nil {
+			return err
+		}
+		return models.Audit(tx, &models.AuditLog{
+			Operator: op,
+			Action:   action,
+			Subject:  hold.Sender,
+			Reason:   req.Reason,
+			Note:     req.Note,
+		}, &before, &hold)
+	})
+	switch {
+	case err == nil:
+		view, err := a.holdView(&hold, time.Now())
+		if err != nil {
+			logger.S().Errorf("query hold approvals error: %v", err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.JSON(http.StatusOK, view)
+	case err == gorm.ErrRecordNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "hold not found"})
+	case err == errDecided, err == errApproved:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err == errShared:
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		logger.S().Errorf("review held operation error: %v", err)
+		c.Status(http.StatusInternalServerError)
+	}
+}
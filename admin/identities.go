@@ -0,0 +1,75 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+type unlinkIdentitiesRequest struct {
+	Reason string `json:"reason"`
+	Note   string `json:"note"`
+}
+
+// unlinkIdentities removes the web2 identities linked to an address, which
+// can then be linked to another one.
+func (a *Admin) unlinkIdentities(c *gin.Context) {
+	op, err := operator(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	address, err := addressParam(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	var req unlinkIdentitiesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err)
+		return
+	}
+	if !reasonCode.MatchString(req.Reason) {
+		badRequest(c, fmt.Errorf("reason must match %s", reasonCode))
+		return
+	}
+
+	err = a.rep.Transaction(func(tx db.Repository) error {
+		var identities []models.Identity
+		if err := tx.Model(&models.Identity{}).Where(`"address" = ?`, address).Find(&identities).Error; err != nil {
+			return err
+		}
+		if len(identities) == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		linked := make([]gin.H, 0, len(identities))
+		for _, identity := range identities {
+			linked = append(linked, gin.H{"provider": identity.Provider, "email": identity.Email})
+		}
+		if err := tx.Model(&models.Identity{}).Unscoped().Where(`"address" = ?`, address).Delete(&models.Identity{}).Error; err != nil {
+			return err
+		}
+		return models.Audit(tx, &models.AuditLog{
+			Operator: op,
+			Action:   "identity_unlink",
+			Subject:  address,
+			Reason:   req.Reason,
+			Note:     req.Note,
+		}, linked, nil)
+	})
+	switch {
+	case err == nil:
+		c.Status(http.StatusNoContent)
+	case err == gorm.ErrRecordNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "no identity linked"})
+	default:
+		logger.S().Errorf("unlink identities error: %v", err)
+		c.Status(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,141 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/cache"
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// maintenanceWindows lists the maintenance windows not over yet.
+func (a *Admin) maintenanceWindows(c *gin.Context) {
+	windows, err := models.ScheduledMaintenance(a.rep, time.Now())
+	if err != nil {
+		logger.S().Errorf("query maintenance windows error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"windows": windows})
+}
+
+type maintenanceRequest struct {
+	// Start and End are unix seconds.
+	Start  int64  `json:"start"`
+	End    int64  `json:"end"`
+	Reason string `json:"reason"`
+	Note   string `json:"note"`
+}
+
+// scheduleMaintenance adds a maintenance window.
+func (a *Admin) scheduleMaintenance(c *gin.Context) {
+	op, err := operator(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	var req maintenanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err)
+		return
+	}
+	if !reasonCode.MatchString(req.Reason) {
+		badRequest(c, fmt.Errorf("reason must match %s", reasonCode))
+		return
+	}
+	start, end := time.Unix(req.Start, 0), time.Unix(req.End, 0)
+	if !end.After(start) || !end.After(time.Now()) {
+		badRequest(c, fmt.Errorf("end must be after start and in the future"))
+		return
+	}
+
+	window := models.MaintenanceWindow{
+		Start:    start,
+		End:      end,
+		Reason:   req.Reason,
+		Note:     req.Note,
+		Operator: op,
+	}
+	err = a.rep.Transaction(func(tx db.Repository) error {
+		if err := tx.Create(&window).Error; err != nil {
+			return err
+		}
+		return models.Audit(tx, &models.AuditLog{
+			Operator: op,
+			Action:   "maintenance_schedule",
+			Subject:  fmt.Sprintf("maintenance:%d", window.ID),
+			Reason:   req.Reason,
+			Note:     req.Note,
+		}, nil, gin.H{"start": req.Start, "end": req.End})
+	})
+	if err != nil {
+		logger.S().Errorf("schedule maintenance error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	a.bus.Publish(c.Request.Context(), cache.Config, "")
+	c.JSON(http.StatusOK, &window)
+}
+
+type cancelMaintenanceRequest struct {
+	Reason string `json:"reason"`
+	Note   string `json:"note"`
+}
+
+// cancelMaintenance removes a maintenance window, ending it early when it is
+// in force.
+func (a *Admin) cancelMaintenance(c *gin.Context) {
+	op, err := operator(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		badRequest(c, fmt.Errorf("invalid maintenance window id: %s", c.Param("id")))
+		return
+	}
+	var req cancelMaintenanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err)
+		return
+	}
+	if !reasonCode.MatchString(req.Reason) {
+		badRequest(c, fmt.Errorf("reason must match %s", reasonCode))
+		return
+	}
+
+	var window models.MaintenanceWindow
+	err = a.rep.Transaction(func(tx db.Repository) error {
+		if err := tx.First(&window, id).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&window).Error; err != nil {
+			return err
+		}
+		return models.Audit(tx, &models.AuditLog{
+			Operator: op,
+			Action:   "maintenance_cancel",
+			Subject:  fmt.Sprintf("maintenance:%d", window.ID),
+			Reason:   req.Reason,
+			Note:     req.Note,
+		}, gin.H{"start": window.Start.Unix(), "end": window.End.Unix()}, nil)
+	})
+	switch {
+	case err == nil:
+		a.bus.Publish(c.Request.Context(), cache.Config, "")
+		c.JSON(http.StatusOK, gin.H{"id": window.ID, "cancelled": true})
+	case err == gorm.ErrRecordNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "maintenance window not found"})
+	default:
+		logger.S().Errorf("cancel maintenance error: %v", err)
+		c.Status(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,71 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+type resetPasskeyRequest struct {
+	Reason string `json:"reason"`
+	Note   string `json:"note"`
+}
+
+// resetPasskey unbinds the passkey of an address, which can then register
+// a new one, e.g. after the user lost the device.
+func (a *Admin) resetPasskey(c *gin.Context) {
+	op, err := operator(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	address, err := addressParam(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	var req resetPasskeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err)
+		return
+	}
+	if !reasonCode.MatchString(req.Reason) {
+		badRequest(c, fmt.Errorf("reason must match %s", reasonCode))
+		return
+	}
+
+	err = a.rep.Transaction(func(tx db.Repository) error {
+		passkey, err := models.FindPasskey(tx, address)
+		if err != nil {
+			return err
+		}
+		if passkey == nil {
+			return gorm.ErrRecordNotFound
+		}
+		if err := tx.Model(passkey).Unscoped().Delete(passkey).Error; err != nil {
+			return err
+		}
+		return models.Audit(tx, &models.AuditLog{
+			Operator: op,
+			Action:   "passkey_reset",
+			Subject:  address,
+			Reason:   req.Reason,
+			Note:     req.Note,
+		}, gin.H{"credentialId": passkey.CredentialID}, nil)
+	})
+	switch {
+	case err == nil:
+		c.Status(http.StatusNoContent)
+	case err == gorm.ErrRecordNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "passkey not found"})
+	default:
+		logger.S().Errorf("reset passkey error: %v", err)
+		c.Status(http.StatusInternalServerError)
+	}
+}
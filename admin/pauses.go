@@ -0,0 +1,156 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// pauses lists the sponsorship pauses in force.
+func (a *Admin) pauses(c *gin.Context) {
+	pauses, err := models.Pauses(a.rep)
+	if err != nil {
+		logger.S().Errorf("query pauses error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"pauses": pauses})
+}
+
+type pauseRequest struct {
+	// ApiKeyID is the api key to pause, zero pauses every key.
+	ApiKeyID uint   `json:"apiKeyId"`
+	Reason   string `json:"reason"`
+	Note     string `json:"note"`
+}
+
+func pauseSubject(apiKeyID uint) string {
+	if apiKeyID == models.PauseAll {
+		return "sponsorship"
+	}
+	return fmt.Sprintf("api_key:%d", apiKeyID)
+}
+
+// pause stops new sponsorships globally or for one api key, read methods
+// keep working.
+func (a *Admin) pause(c *gin.Context) {
+	op, err := operator(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	var req pauseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err)
+		return
+	}
+	if !reasonCode.MatchString(req.Reason) {
+		badRequest(c, fmt.Errorf("reason must match %s", reasonCode))
+		return
+	}
+
+	pause := models.SponsorshipPause{
+		ApiKeyID: req.ApiKeyID,
+		Reason:   req.Reason,
+		Note:     req.Note,
+		Operator: op,
+	}
+	err = a.rep.Transaction(func(tx db.Repository) error {
+		if req.ApiKeyID != models.PauseAll {
+			if err := tx.Model(&models.ApiKeys{}).First(&models.ApiKeys{}, req.ApiKeyID).Error; err != nil {
+				return err
+			}
+		}
+		var current models.SponsorshipPause
+		err := tx.Model(&models.SponsorshipPause{}).First(&current, `"api_key_id" = ?`, req.ApiKeyID).Error
+		if err == nil {
+			pause = current
+			return nil
+		}
+		if err != gorm.ErrRecordNotFound {
+			return err
+		}
+		if err := tx.Create(&pause).Error; err != nil {
+			return err
+		}
+		return models.Audit(tx, &models.AuditLog{
+			Operator: op,
+			Action:   "sponsorship_pause",
+			Subject:  pauseSubject(req.ApiKeyID),
+			Reason:   req.Reason,
+			Note:     req.Note,
+		}, gin.H{"paused": false}, gin.H{"paused": true})
+	})
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, &pause)
+	case err == gorm.ErrRecordNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "api key not found"})
+	default:
+		logger.S().Errorf("pause sponsorship error: %v", err)
+		c.Status(http.StatusInternalServerError)
+	}
+}
+
+type resumeRequest struct {
+	Reason string `json:"reason"`
+	Note   string `json:"note"`
+}
+
+// resume lifts the pause of an api key, or the global pause for key 0.
+func (a *Admin) resume(c *gin.Context) {
+	op, err := operator(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("apiKeyId"), 10, 32)
+	if err != nil {
+		badRequest(c, fmt.Errorf("invalid api key id: %s", c.Param("apiKeyId")))
+		return
+	}
+	var req resumeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err)
+		return
+	}
+	if !reasonCode.MatchString(req.Reason) {
+		badRequest(c, fmt.Errorf("reason must match %s", reasonCode))
+		return
+	}
+
+	err = a.rep.Transaction(func(tx db.Repository) error {
+		res := tx.Model(&models.SponsorshipPause{}).Unscoped().
+			Where(`"api_key_id" = ?`, id).
+			Delete(&models.SponsorshipPause{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return models.Audit(tx, &models.AuditLog{
+			Operator: op,
+			Action:   "sponsorship_resume",
+			Subject:  pauseSubject(uint(id)),
+			Reason:   req.Reason,
+			Note:     req.Note,
+		}, gin.H{"paused": true}, gin.H{"paused": false})
+	})
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"apiKeyId": id, "paused": false})
+	case err == gorm.ErrRecordNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "pause not found"})
+	default:
+		logger.S().Errorf("resume sponsorship error: %v", err)
+		c.Status(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,112 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+const maxTopSpenders = 100
+
+// topSpenders returns the senders or api keys with the highest gas cost.
+func (a *Admin) topSpenders(c *gin.Context) {
+	kind := c.DefaultQuery("by", models.SubjectSender)
+	if kind != models.SubjectSender && kind != models.SubjectApiKey {
+		badRequest(c, fmt.Errorf("invalid by: %s", kind))
+		return
+	}
+	from, to, err := timeRange(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 || limit > maxTopSpenders {
+		badRequest(c, fmt.Errorf("limit must be between 1 and %d", maxTopSpenders))
+		return
+	}
+	stats, err := models.TopSpenders(a.rep, kind, from, to, limit)
+	if err != nil {
+		logger.S().Errorf("query top spenders error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"by": kind, "spenders": stats})
+}
+
+// anomalies returns the usage anomalies reported since from.
+func (a *Admin) anomalies(c *gin.Context) {
+	from, _, err := timeRange(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	anomalies, err := (&models.Anomaly{}).FindSince(a.rep, from)
+	if err != nil {
+		logger.S().Errorf("query anomalies error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"anomalies": anomalies})
+}
+
+// geoBlocks counts the requests the GeoIP policy refused by country and api
+// key.
+func (a *Admin) geoBlocks(c *gin.Context) {
+	from, to, err := timeRange(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	stats, err := models.GeoBlockStats(a.rep, from, to)
+	if err != nil {
+		logger.S().Errorf("query geoip blocks error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"from": from.Unix(), "to": to.Unix(), "blocks": stats})
+}
+
+// keyOutcomes reports the acceptance and inclusion rates of api keys per day
+// or week with their rejections by reason, of the apiKeyId query parameter
+// or every key.
+func (a *Admin) keyOutcomes(c *gin.Context) {
+	period := c.DefaultQuery("period", "day")
+	if period != "day" && period != "week" {
+		badRequest(c, fmt.Errorf("invalid period: %s", period))
+		return
+	}
+	from, to, err := timeRange(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	var apiKeyID *uint
+	if v := c.Query("apiKeyId"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			badRequest(c, fmt.Errorf("invalid api key id: %s", v))
+			return
+		}
+		key := uint(id)
+		apiKeyID = &key
+	}
+	outcomes, err := models.KeyOutcomes(a.rep, period, from, to, apiKeyID)
+	if err != nil {
+		logger.S().Errorf("query api key outcomes error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	rejections, err := models.KeyRejections(a.rep, from, to, apiKeyID)
+	if err != nil {
+		logger.S().Errorf("query api key rejections error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"period": period, "outcomes": outcomes, "rejections": rejections})
+}
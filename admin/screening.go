@@ -0,0 +1,195 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// screeningDecision returns the cached screening decision of an address.
+func (a *Admin) screeningDecision(c *gin.Context) {
+	address, err := addressParam(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	decision, err := models.FindScreening(a.rep, address)
+	if err != nil {
+		logger.S().Errorf("query screening decision error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if decision == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "address not screened"})
+		return
+	}
+	c.JSON(http.StatusOK, decision)
+}
+
+type screeningOverrideRequest struct {
+	// Allowed overrides the decision, null removes the override.
+	Allowed *bool  `json:"allowed"`
+	Reason  string `json:"reason"`
+	Note    string `json:"note"`
+}
+
+// overrideScreening sets or clears the operator decision of an address.
+func (a *Admin) overrideScreening(c *gin.Context) {
+	op, err := operator(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	address, err := addressParam(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	var req screeningOverrideRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err)
+		return
+	}
+	if !reasonCode.MatchString(req.Reason) {
+		badRequest(c, fmt.Errorf("reason must match %s", reasonCode))
+		return
+	}
+
+	var after *models.ScreeningDecision
+	err = a.rep.Transaction(func(tx db.Repository) error {
+		before, err := models.FindScreening(tx, address)
+		if err != nil {
+			return err
+		}
+		if req.Allowed == nil {
+			err = models.ClearScreening(tx, address)
+		} else {
+			after = &models.ScreeningDecision{Address: address, Allowed: *req.Allowed, Reason: req.Reason, Operator: op}
+			err = models.OverrideScreening(tx, after)
+		}
+		if err != nil {
+			return err
+		}
+		return models.Audit(tx, &models.AuditLog{
+			Operator: op,
+			Action:   "screening_override",
+			Subject:  address,
+			Reason:   req.Reason,
+			Note:     req.Note,
+		}, before, after)
+	})
+	if err != nil {
+		logger.S().Errorf("override screening error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"decision": after})
+}
+
+// screeningAppeals returns the latest appeals, pending ones by default.
+func (a *Admin) screeningAppeals(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageSize)))
+	if err != nil || limit <= 0 || limit > maxPageSize {
+		badRequest(c, fmt.Errorf("limit must be between 1 and %d", maxPageSize))
+		return
+	}
+	status := c.DefaultQuery("status", models.AppealPending)
+	if status == "all" {
+		status = ""
+	}
+	appeals, err := models.FindAppeals(a.rep, status, limit)
+	if err != nil {
+		logger.S().Errorf("query screening appeals error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"appeals": appeals})
+}
+
+type resolveAppealRequest struct {
+	// Status is approved or rejected.
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+	Note   string `json:"note"`
+}
+
+// resolveAppeal closes a pending appeal, approving it overrides the
+// decision of the address to allowed.
+func (a *Admin) resolveAppeal(c *gin.Context) {
+	op, err := operator(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		badRequest(c, fmt.Errorf("invalid appeal id: %s", c.Param("id")))
+		return
+	}
+	var req resolveAppealRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err)
+		return
+	}
+	if req.Status != models.AppealApproved && req.Status != models.AppealRejected {
+		badRequest(c, fmt.Errorf("status must be %s or %s", models.AppealApproved, models.AppealRejected))
+		return
+	}
+	if !reasonCode.MatchString(req.Reason) {
+		badRequest(c, fmt.Errorf("reason must match %s", reasonCode))
+		return
+	}
+
+	var appeal models.ScreeningAppeal
+	errResolved := fmt.Errorf("appeal already resolved")
+	err = a.rep.Transaction(func(tx db.Repository) error {
+		if err := tx.First(&appeal, id).Error; err != nil {
+			return err
+		}
+		if appeal.Status != models.AppealPending {
+			return errResolved
+		}
+		before := appeal
+		appeal.Status = req.Status
+		appeal.Operator = op
+		if err := tx.Save(&appeal).Error; err != nil {
+			return err
+		}
+		if req.Status == models.AppealApproved {
+			err := models.OverrideScreening(tx, &models.ScreeningDecision{
+				Address:  appeal.Address,
+				Allowed:  true,
+				Reason:   req.Reason,
+				Operator: op,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return models.Audit(tx, &models.AuditLog{
+			Operator: op,
+			Action:   "screening_appeal",
+			Subject:  appeal.Address,
+			Reason:   req.Reason,
+			Note:     req.Note,
+		}, &before, &appeal)
+	})
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, &appeal)
+	case err == gorm.ErrRecordNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "appeal not found"})
+	case err == errResolved:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		logger.S().Errorf("resolve screening appeal error: %v", err)
+		c.Status(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,99 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+const (
+	defaultStatsRange = 30 * 24 * time.Hour
+	maxTopTargets     = 100
+)
+
+// timeRange reads the from/to query parameters, unix seconds, defaulting to
+// the last 30 days.
+func timeRange(c *gin.Context) (time.Time, time.Time, error) {
+	to, err := parseUnix(c, "to")
+	if err != nil {
+		return to, to, err
+	}
+	if to.IsZero() {
+		to = time.Now()
+	}
+	from, err := parseUnix(c, "from")
+	if err != nil {
+		return from, to, err
+	}
+	if from.IsZero() {
+		from = to.Add(-defaultStatsRange)
+	}
+	if !from.Before(to) {
+		return from, to, fmt.Errorf("from must be before to")
+	}
+	return from, to, nil
+}
+
+// stats returns daily or weekly sponsorship aggregates.
+func (a *Admin) stats(c *gin.Context) {
+	period := c.DefaultQuery("period", "day")
+	if period != "day" && period != "week" {
+		badRequest(c, fmt.Errorf("invalid period: %s", period))
+		return
+	}
+	from, to, err := timeRange(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	stats, err := models.SponsorshipStats(a.rep, period, from, to, 0)
+	if err != nil {
+		logger.S().Errorf("query sponsorship stats error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"period": period, "stats": stats})
+}
+
+// targets returns the most sponsored call targets.
+func (a *Admin) targets(c *gin.Context) {
+	from, to, err := timeRange(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 || limit > maxTopTargets {
+		badRequest(c, fmt.Errorf("limit must be between 1 and %d", maxTopTargets))
+		return
+	}
+	stats, err := models.TopTargets(a.rep, from, to, limit)
+	if err != nil {
+		logger.S().Errorf("query top targets error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"targets": stats})
+}
+
+// rejections breaks down refused sponsorships by error code and reason.
+func (a *Admin) rejections(c *gin.Context) {
+	from, to, err := timeRange(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	stats, err := models.RejectionBreakdown(a.rep, from, to)
+	if err != nil {
+		logger.S().Errorf("query rejections error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rejections": stats})
+}
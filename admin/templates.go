@@ -0,0 +1,107 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/cache"
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// localeTag is a normalized language tag, such as de or pt-br.
+var localeTag = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8}){0,2}$`)
+
+// errorTemplates lists the error templates stored by operators with the
+// catalog of slugs and default messages they override.
+func (a *Admin) errorTemplates(c *gin.Context) {
+	templates, err := models.ErrorTemplates(a.rep)
+	if err != nil {
+		logger.S().Errorf("query error templates error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"templates": templates, "catalog": errors.Catalog()})
+}
+
+type errorTemplateRequest struct {
+	Slug string `json:"slug"`
+	// Locale is empty for the template of every client.
+	Locale string `json:"locale"`
+	// Message is the template, empty to restore the catalog message.
+	Message string `json:"message"`
+	Reason  string `json:"reason"`
+	Note    string `json:"note"`
+}
+
+// setErrorTemplate stores or removes the template of a slug and locale.
+func (a *Admin) setErrorTemplate(c *gin.Context) {
+	op, err := operator(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	var req errorTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err)
+		return
+	}
+	if errors.Lookup(req.Slug) == nil {
+		badRequest(c, fmt.Errorf("unknown error slug %q", req.Slug))
+		return
+	}
+	locale := errors.NormalizeLocale(req.Locale)
+	if locale != "" && !localeTag.MatchString(locale) {
+		badRequest(c, fmt.Errorf("invalid locale %q", req.Locale))
+		return
+	}
+	if req.Reason != "" && !reasonCode.MatchString(req.Reason) {
+		badRequest(c, fmt.Errorf("reason must match %s", reasonCode))
+		return
+	}
+
+	template := models.ErrorTemplate{Slug: req.Slug, Locale: locale, Message: req.Message, Operator: op}
+	err = a.rep.Transaction(func(tx db.Repository) error {
+		var before *string
+		var current models.ErrorTemplate
+		err := tx.Where(`"slug" = ? AND "locale" = ?`, req.Slug, locale).First(&current).Error
+		switch err {
+		case nil:
+			before = &current.Message
+		case gorm.ErrRecordNotFound:
+		default:
+			return err
+		}
+
+		var after *string
+		if req.Message == "" {
+			err = models.DeleteErrorTemplate(tx, req.Slug, locale)
+		} else {
+			after = &req.Message
+			err = models.SaveErrorTemplate(tx, &template)
+		}
+		if err != nil {
+			return err
+		}
+		return models.Audit(tx, &models.AuditLog{
+			Operator: op,
+			Action:   "error_template_set",
+			Subject:  fmt.Sprintf("error_template:%s:%s", req.Slug, locale),
+			Reason:   req.Reason,
+			Note:     req.Note,
+		}, gin.H{"message": before}, gin.H{"message": after})
+	})
+	if err != nil {
+		logger.S().Errorf("set error template error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	a.bus.Publish(c.Request.Context(), cache.ErrorTemplates, req.Slug)
+	c.JSON(http.StatusOK, gin.H{"slug": req.Slug, "locale": locale, "message": req.Message})
+}
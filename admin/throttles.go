@@ -0,0 +1,88 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/cache"
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// throttles lists the client fingerprint throttles in force.
+func (a *Admin) throttles(c *gin.Context) {
+	throttles, err := models.ActiveThrottles(a.rep, time.Now())
+	if err != nil {
+		logger.S().Errorf("query throttles error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"throttles": throttles})
+}
+
+type liftThrottleRequest struct {
+	Reason string `json:"reason"`
+	Note   string `json:"note"`
+}
+
+// liftThrottle ends a throttle on every replica.
+func (a *Admin) liftThrottle(c *gin.Context) {
+	op, err := operator(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		badRequest(c, fmt.Errorf("invalid throttle id: %s", c.Param("id")))
+		return
+	}
+	var req liftThrottleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err)
+		return
+	}
+	if !reasonCode.MatchString(req.Reason) {
+		badRequest(c, fmt.Errorf("reason must match %s", reasonCode))
+		return
+	}
+
+	var throttle models.Throttle
+	err = a.rep.Transaction(func(tx db.Repository) error {
+		if err := tx.First(&throttle, id).Error; err != nil {
+			return err
+		}
+		before := throttle.Until
+		now := time.Now()
+		if !throttle.Until.After(now) {
+			return nil
+		}
+		throttle.Until = now
+		if err := tx.Save(&throttle).Error; err != nil {
+			return err
+		}
+		return models.Audit(tx, &models.AuditLog{
+			Operator: op,
+			Action:   "throttle_lift",
+			Subject:  "fingerprint:" + throttle.Fingerprint,
+			Reason:   req.Reason,
+			Note:     req.Note,
+		}, gin.H{"until": before.Unix()}, gin.H{"until": throttle.Until.Unix()})
+	})
+	switch {
+	case err == nil:
+		a.bus.Publish(c.Request.Context(), cache.Throttle, throttle.Fingerprint)
+		c.JSON(http.StatusOK, &throttle)
+	case err == gorm.ErrRecordNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "throttle not found"})
+	default:
+		logger.S().Errorf("lift throttle error: %v", err)
+		c.Status(http.StatusInternalServerError)
+	}
+}
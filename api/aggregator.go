@@ -0,0 +1,84 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/ququzone/verifying-paymaster-service/chain"
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/types"
+)
+
+// aggregatorABI is the part of IAggregator the service calls.
+var aggregatorABI, _ = abi.JSON(strings.NewReader(`[{"inputs":[{"components":[` +
+	`{"name":"sender","type":"address"},{"name":"nonce","type":"uint256"},{"name":"initCode","type":"bytes"},` +
+	`{"name":"callData","type":"bytes"},{"name":"callGasLimit","type":"uint256"},` +
+	`{"name":"verificationGasLimit","type":"uint256"},{"name":"preVerificationGas","type":"uint256"},` +
+	`{"name":"maxFeePerGas","type":"uint256"},{"name":"maxPriorityFeePerGas","type":"uint256"},` +
+	`{"name":"paymasterAndData","type":"bytes"},{"name":"signature","type":"bytes"}],` +
+	`"name":"userOp","type":"tuple"}],"name":"validateUserOpSignature",` +
+	`"outputs":[{"name":"sigForUserOp","type":"bytes"}],"stateMutability":"view","type":"function"}]`))
+
+// validationAggregator runs simulateValidation for op, which must carry a
+// valid paymaster signature, and returns the aggregator the account
+// validates with, zero when it validates the signature itself.
+func validationAggregator(client chain.Client, entryPoint common.Address, op *types.UserOperation) (common.Address, error) {
+	input, err := entryPointABI.Pack("simulateValidation", op)
+	if err != nil {
+		return common.Address{}, err
+	}
+	_, err = client.CallContract(context.Background(), ethereum.CallMsg{To: &entryPoint, Data: input}, nil)
+	if err == nil {
+		return common.Address{}, rpcerrors.NewRPCError(rpcerrors.REJECTED_BY_TYPE, "simulateValidation did not revert", nil)
+	}
+	data, ok := revertData(err)
+	if !ok {
+		return common.Address{}, err
+	}
+	if result := entryPointABI.Errors["ValidationResult"]; bytes.Equal(data[:4], result.ID[:4]) {
+		return common.Address{}, nil
+	}
+	result := entryPointABI.Errors["ValidationResultWithAggregation"]
+	if !bytes.Equal(data[:4], result.ID[:4]) {
+		return common.Address{}, revertRPCError(err)
+	}
+	args, err := result.Inputs.Unpack(data[4:])
+	if err != nil {
+		return common.Address{}, err
+	}
+	// aggregatorInfo is an (address aggregator, StakeInfo stakeInfo) tuple
+	info := reflect.ValueOf(args[4])
+	if info.Kind() != reflect.Struct || !info.FieldByName("Aggregator").IsValid() {
+		return common.Address{}, fmt.Errorf("unexpected aggregatorInfo %T", args[4])
+	}
+	aggregator, ok := info.FieldByName("Aggregator").Interface().(common.Address)
+	if !ok {
+		return common.Address{}, fmt.Errorf("unexpected aggregatorInfo %T", args[4])
+	}
+	return aggregator, nil
+}
+
+// checkAggregatedSignature lets aggregator validate the signature of op, as a
+// bundler does before it aggregates the operation.
+func checkAggregatedSignature(client chain.Client, aggregator common.Address, op *types.UserOperation) error {
+	input, err := aggregatorABI.Pack("validateUserOpSignature", op)
+	if err != nil {
+		return err
+	}
+	_, err = client.CallContract(context.Background(), ethereum.CallMsg{To: &aggregator, Data: input}, nil)
+	if err != nil {
+		return rpcerrors.NewRPCError(
+			rpcerrors.INVALID_SIGNATURE,
+			"aggregator rejected the signature",
+			map[string]any{"aggregator": aggregator.Hex(), "error": err.Error()},
+		)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/utils"
+)
+
+// maxAllocationAddresses bounds the addresses of one pm_allocateQuota call.
+const maxAllocationAddresses = 1000
+
+type QuotaAllocationResult struct {
+	Addresses  int    `json:"addresses"`
+	Allocated  string `json:"allocated"`
+	Budget     string `json:"budget"`
+	ValidUntil int64  `json:"validUntil"`
+}
+
+// Pm_allocateQuota moves amount gas from the budget of the api key to each
+// address until validUntil, when the unused part returns to the budget.
+func (s *Signer) Pm_allocateQuota(ctx context.Context, addresses []any, amount string, validUntil int64) (*QuotaAllocationResult, error) {
+	apiKey := ApiKeyFromContext(ctx)
+	if apiKey == nil {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_API_KEY, "api key required", map[string]any{"reason": rpcerrors.REASON_API_KEY_REQUIRED})
+	}
+	if len(addresses) == 0 || len(addresses) > maxAllocationAddresses {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, fmt.Sprintf("between 1 and %d addresses required", maxAllocationAddresses), nil)
+	}
+	seen := make(map[string]bool, len(addresses))
+	var accounts []string
+	for _, a := range addresses {
+		s, _ := a.(string)
+		address, err := utils.NormalizeAddress(s)
+		if err != nil {
+			return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, err.Error(), nil)
+		}
+		if !seen[address] {
+			seen[address] = true
+			accounts = append(accounts, address)
+		}
+	}
+	gas, ok := new(big.Int).SetString(amount, 0)
+	if !ok || gas.Sign() <= 0 {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, "invalid amount", nil)
+	}
+	expiresAt := time.Unix(validUntil, 0)
+	if !expiresAt.After(time.Now()) {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, "validUntil must be in the future", nil)
+	}
+
+	chain, err := s.keyChain(ctx, s.ChainContext)
+	if err != nil {
+		return nil, err
+	}
+	key, err := models.AllocateQuota(s.Container.GetRepository(), apiKey.ID, chain.QuotaPaymaster, accounts, gas, expiresAt)
+	if err == models.ErrInsufficientBudget {
+		return nil, rpcerrors.RejectedByPaymaster("insufficient api key budget", rpcerrors.REASON_INSUFFICIENT_BUDGET)
+	}
+	if nil != err {
+		logger.S().Errorf("allocate quota error: %v", err)
+		return nil, err
+	}
+	return &QuotaAllocationResult{
+		Addresses:  len(accounts),
+		Allocated:  new(big.Int).Mul(gas, big.NewInt(int64(len(accounts)))).String(),
+		Budget:     key.Budget,
+		ValidUntil: validUntil,
+	}, nil
+}
+
+// RunQuotaReclaimer returns unused quota of expired allocations to the api
+// key budgets every interval until ctx is done.
+func (s *Signer) RunQuotaReclaimer(ctx context.Context, interval time.Duration) {
+	for {
+		n, err := models.ReclaimExpiredQuota(s.Container.GetRepository(), time.Now(), 100)
+		if err != nil {
+			logger.S().Errorf("reclaim quota error: %v", err)
+		} else if n > 0 {
+			logger.S().Infof("Reclaimed %d expired quota allocations", n)
+		}
+		if err == nil && n == 100 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/ququzone/verifying-paymaster-service/bundler"
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/types"
+)
+
+// sponsorshipChain returns the context of the chain rec was signed on.
+func (s *Signer) sponsorshipChain(rec *models.Sponsorship) *ChainContext {
+	if chain := s.Chain(rec.ChainID); chain != nil {
+		return chain
+	}
+	return s.ChainContext
+}
+
+func bundlerOf(chain *ChainContext) (*ChainContext, error) {
+	if chain.Bundler == nil {
+		return nil, rpcerrors.NewRPCError(rpcerrors.METHOD_NOT_FOUND, fmt.Sprintf("no bundler configured for chain %s", chain.ChainID), nil)
+	}
+	return chain, nil
+}
+
+// Eth_sendUserOperation forwards an operation sponsored for the api key to
+// the bundler of its chain and records the submission.
+func (s *Signer) Eth_sendUserOperation(ctx context.Context, op map[string]any, entryPoint string) (json.RawMessage, error) {
+	userOp, err := types.NewUserOperation(op)
+	if err != nil {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, err.Error(), nil)
+	}
+	hashes := make([]string, 0, len(s.Chains))
+	for _, chain := range s.Chains {
+		hashes = append(hashes, userOp.GetUserOpHash(chain.EntryPoint, chain.ChainID).Hex())
+	}
+	rec, err := (&models.Sponsorship{}).FindByUserOpHashes(s.Container.GetRepository(), hashes)
+	if nil != err {
+		logger.S().Errorf("Query sponsorship error: %v", err)
+		return nil, err
+	}
+	key := ApiKeyFromContext(ctx)
+	if rec == nil || key == nil || rec.ApiKeyID != key.ID {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, "user operation not sponsored by this paymaster", nil)
+	}
+	chain := s.sponsorshipChain(rec)
+	if !common.IsHexAddress(entryPoint) || common.HexToAddress(entryPoint) != chain.EntryPoint {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, fmt.Sprintf("unsupported entryPoint %s", entryPoint), nil)
+	}
+	if chain.SelfBundler != nil {
+		return s.queue(chain, rec, userOp)
+	}
+	if chain, err = bundlerOf(chain); err != nil {
+		return nil, err
+	}
+
+	result, url, err := chain.Bundler.Call(ctx, "eth_sendUserOperation", op, entryPoint)
+	if err != nil {
+		logger.S().Warnf("bundler send user operation %s error: %v", rec.UserOpHash, err)
+		return nil, err
+	}
+	err = (&models.Submission{
+		SponsorshipID: rec.ID,
+		UserOpHash:    rec.UserOpHash,
+		ApiKeyID:      rec.ApiKeyID,
+		ChainID:       chain.ChainID.Uint64(),
+		Bundler:       url,
+	}).Record(s.Container.GetRepository())
+	if err != nil {
+		// the operation is already with the bundler
+		logger.S().Errorf("save submission error: %v", err)
+	}
+	return result, nil
+}
+
+// queue hands op to the self bundler of chain and returns its hash.
+func (s *Signer) queue(chain *ChainContext, rec *models.Sponsorship, op *types.UserOperation) (json.RawMessage, error) {
+	data, err := bundler.EncodeOp(op)
+	if err != nil {
+		return nil, err
+	}
+	err = (&models.Submission{
+		SponsorshipID: rec.ID,
+		UserOpHash:    rec.UserOpHash,
+		ApiKeyID:      rec.ApiKeyID,
+		ChainID:       chain.ChainID.Uint64(),
+		Bundler:       models.SelfBundler,
+		Op:            data,
+		Status:        models.SubmissionQueued,
+	}).Record(s.Container.GetRepository())
+	if err != nil {
+		logger.S().Errorf("save submission error: %v", err)
+		return nil, err
+	}
+	return json.Marshal(rec.UserOpHash)
+}
+
+// SelfBundledReceipt is the eth_getUserOperationReceipt result of an operation
+// sent by the self bundler.
+type SelfBundledReceipt struct {
+	UserOpHash    string            `json:"userOpHash"`
+	EntryPoint    string            `json:"entryPoint"`
+	Sender        string            `json:"sender"`
+	Nonce         string            `json:"nonce"`
+	Paymaster     string            `json:"paymaster"`
+	ActualGasCost string            `json:"actualGasCost"`
+	Success       bool              `json:"success"`
+	Receipt       *ethtypes.Receipt `json:"receipt"`
+}
+
+// selfBundledReceipt builds the receipt of rec from the indexed settlement,
+// nil until the operation is included.
+func (s *Signer) selfBundledReceipt(ctx context.Context, chain *ChainContext, rec *models.Sponsorship) (json.RawMessage, error) {
+	if rec == nil || (rec.Status != models.SponsorshipIncluded && rec.Status != models.SponsorshipReverted) {
+		return json.RawMessage("null"), nil
+	}
+	receipt, err := chain.Client.TransactionReceipt(ctx, common.HexToHash(rec.TxHash))
+	if err != nil {
+		return nil, err
+	}
+	nonce, _ := new(big.Int).SetString(rec.Nonce, 10)
+	return json.Marshal(&SelfBundledReceipt{
+		UserOpHash:    rec.UserOpHash,
+		EntryPoint:    chain.EntryPoint.Hex(),
+		Sender:        rec.Sender,
+		Nonce:         hexutil.EncodeBig(nonce),
+		Paymaster:     sponsorshipPaymaster(chain, rec),
+		ActualGasCost: hexutil.EncodeBig(models.ParseGas(rec.ActualGasCost)),
+		Success:       rec.Status == models.SponsorshipIncluded,
+		Receipt:       receipt,
+	})
+}
+
+// Eth_getUserOperationReceipt forwards to the bundler of the chain the
+// operation was sponsored on, the default chain for unknown hashes.
+func (s *Signer) Eth_getUserOperationReceipt(ctx context.Context, userOpHash string) (json.RawMessage, error) {
+	rec, err := (&models.Sponsorship{}).FindByUserOpHash(s.Container.GetRepository(), common.HexToHash(userOpHash).Hex())
+	if nil != err {
+		logger.S().Errorf("Query sponsorship error: %v", err)
+		return nil, err
+	}
+	chain := s.ChainContext
+	if rec != nil {
+		chain = s.sponsorshipChain(rec)
+	}
+	if chain.Bundler == nil && chain.SelfBundler != nil {
+		return s.selfBundledReceipt(ctx, chain, rec)
+	}
+	if chain, err = bundlerOf(chain); err != nil {
+		return nil, err
+	}
+	result, _, err := chain.Bundler.Call(ctx, "eth_getUserOperationReceipt", userOpHash)
+	return result, err
+}
+
+// Eth_estimateUserOperationGas forwards the estimation to the bundler of the
+// default chain with the paymaster stub data in place, so the estimate covers
+// the paymaster verification.
+func (s *Signer) Eth_estimateUserOperationGas(ctx context.Context, op map[string]any, entryPoint string, stateOverride map[string]any) (json.RawMessage, error) {
+	chain, err := bundlerOf(s.ChainContext)
+	if err != nil {
+		return nil, err
+	}
+	if !common.IsHexAddress(entryPoint) || common.HexToAddress(entryPoint) != chain.EntryPoint {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, fmt.Sprintf("unsupported entryPoint %s", entryPoint), nil)
+	}
+	paymasterAndData, err := stubPaymasterAndData(chain)
+	if err != nil {
+		return nil, err
+	}
+	stubbed := make(map[string]any, len(op)+1)
+	for k, v := range op {
+		stubbed[k] = v
+	}
+	stubbed["paymasterAndData"] = paymasterAndData
+	params := []any{stubbed, entryPoint}
+	if stateOverride != nil {
+		params = append(params, stateOverride)
+	}
+	result, _, err := chain.Bundler.Call(ctx, "eth_estimateUserOperationGas", params...)
+	return result, err
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/ququzone/verifying-paymaster-service/chain"
+	"github.com/ququzone/verifying-paymaster-service/types"
+)
+
+// callGasEntry is a call gas estimate, done is closed once it is known.
+type callGasEntry struct {
+	done chan struct{}
+	gas  uint64
+	err  error
+}
+
+// callGasCache keeps the call gas estimates of a chain by sender and call
+// data for one block, so a burst of identical operations is estimated once.
+// Concurrent requests for the same call wait for the first estimate.
+type callGasCache struct {
+	mu      sync.Mutex
+	block   uint64
+	entries map[common.Hash]*callGasEntry
+}
+
+func newCallGasCache() *callGasCache {
+	return &callGasCache{entries: make(map[common.Hash]*callGasEntry)}
+}
+
+// estimate returns the call gas of op at block, running fn when it is not
+// known yet. Failed estimates are not kept. Waiting for the estimate of
+// another request stops when ctx is done.
+func (c *callGasCache) estimate(ctx context.Context, block uint64, op *types.UserOperation, fn func() (uint64, error)) (uint64, error) {
+	key := crypto.Keccak256Hash(op.Sender.Bytes(), crypto.Keccak256(op.CallData))
+	c.mu.Lock()
+	if block != c.block {
+		c.block = block
+		c.entries = make(map[common.Hash]*callGasEntry)
+	}
+	entry, ok := c.entries[key]
+	if ok {
+		c.mu.Unlock()
+		select {
+		case <-entry.done:
+			return entry.gas, entry.err
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	entry = &callGasEntry{done: make(chan struct{})}
+	c.entries[key] = entry
+	c.mu.Unlock()
+
+	entry.gas, entry.err = fn()
+	close(entry.done)
+	if entry.err != nil {
+		c.mu.Lock()
+		if c.entries[key] == entry {
+			delete(c.entries, key)
+		}
+		c.mu.Unlock()
+	}
+	return entry.gas, entry.err
+}
+
+// estimateCallGas estimates the gas of the call of op into its sender. Senders
+// deployed by the operation get a fixed 100000 for their call data.
+func estimateCallGas(ctx context.Context, client chain.Client, entryPoint common.Address, op *types.UserOperation) (uint64, error) {
+	code, err := client.CodeAt(ctx, op.Sender, nil)
+	if err != nil {
+		return 0, err
+	}
+	if len(code) == 0 && len(op.CallData) > 0 {
+		return 100000, nil
+	}
+	return client.EstimateGas(ctx, ethereum.CallMsg{
+		From: entryPoint,
+		To:   &op.Sender,
+		Data: op.CallData,
+	})
+}
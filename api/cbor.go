@@ -0,0 +1,110 @@
+package api
+
+import (
+	"errors"
+	"math"
+)
+
+// cborMaxDepth bounds the nesting of decoded CBOR items.
+const cborMaxDepth = 16
+
+var errCBOR = errors.New("invalid cbor")
+
+// decodeCBOR decodes the first CBOR item of data and returns it with the
+// bytes after it. It reads the subset WebAuthn uses: integers as int64, byte
+// strings as []byte, text strings as string, arrays as []any, maps as
+// map[any]any with integer or text keys, booleans and null. Tags are
+// skipped, indefinite lengths and floats are refused.
+func decodeCBOR(data []byte) (any, []byte, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (any, []byte, error) {
+	if depth > cborMaxDepth || len(data) == 0 {
+		return nil, nil, errCBOR
+	}
+	major, info := data[0]>>5, data[0]&0x1f
+	data = data[1:]
+	var arg uint64
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info <= 27:
+		n := 1 << (info - 24)
+		if len(data) < n {
+			return nil, nil, errCBOR
+		}
+		for _, b := range data[:n] {
+			arg = arg<<8 | uint64(b)
+		}
+		data = data[n:]
+	default:
+		return nil, nil, errCBOR
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, errCBOR
+		}
+		return int64(arg), data, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, errCBOR
+		}
+		return -1 - int64(arg), data, nil
+	case 2, 3:
+		if arg > uint64(len(data)) {
+			return nil, nil, errCBOR
+		}
+		if major == 3 {
+			return string(data[:arg]), data[arg:], nil
+		}
+		return data[:arg:arg], data[arg:], nil
+	case 4:
+		// every item takes a byte at least
+		if arg > uint64(len(data)) {
+			return nil, nil, errCBOR
+		}
+		items := make([]any, arg)
+		for i := range items {
+			var err error
+			if items[i], data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+		}
+		return items, data, nil
+	case 5:
+		if arg > uint64(len(data)) {
+			return nil, nil, errCBOR
+		}
+		items := make(map[any]any, arg)
+		for i := uint64(0); i < arg; i++ {
+			key, rest, err := decodeCBORItem(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errCBOR
+			}
+			if items[key], data, err = decodeCBORItem(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+		}
+		return items, data, nil
+	case 6:
+		return decodeCBORItem(data, depth+1)
+	default:
+		switch info {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22, 23:
+			return nil, data, nil
+		}
+		return nil, nil, errCBOR
+	}
+}
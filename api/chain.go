@@ -0,0 +1,247 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+
+	"github.com/ququzone/verifying-paymaster-service/bundler"
+	"github.com/ququzone/verifying-paymaster-service/chain"
+	"github.com/ququzone/verifying-paymaster-service/config"
+	"github.com/ququzone/verifying-paymaster-service/container"
+	"github.com/ququzone/verifying-paymaster-service/contracts"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/policy"
+	"github.com/ququzone/verifying-paymaster-service/screening"
+	"github.com/ququzone/verifying-paymaster-service/store"
+)
+
+// ChainContext holds the clients, contracts and limits of one chain.
+type ChainContext struct {
+	Client      chain.Client
+	ChainID     *big.Int
+	Contract    common.Address
+	Paymaster   chain.Paymaster
+	EntryPoint  common.Address
+	VipContract chain.VipNFT
+	CreateGas   *big.Int
+	MaxGas      *big.Int
+	MaxVipGas   *big.Int
+	// Checks are the policy checks applied to every api key on this chain.
+	Checks []policy.Checker
+	// SignRate reserves the cost and gas of every signed operation against
+	// the signed cost and gas caps of the chain.
+	SignRate *policy.SignRate
+	// Factories is the known factory registry, nil when disabled.
+	Factories *policy.FactoryRegistry
+	// Bundler proxies the eth_ methods, nil when not configured.
+	Bundler *bundler.Pool
+	// SelfBundler sends the operations itself instead of Bundler, nil when
+	// not configured.
+	SelfBundler *bundler.SelfBundler
+	// QuotaRate converts gas costs to the shared quota unit per native
+	// token, nil when quotas are kept in wei.
+	QuotaRate *big.Rat
+	// MultiSend are the MultiSend contracts trusted to run Safe batches.
+	MultiSend map[common.Address]bool
+	// Config is the chain configuration the context was built from.
+	Config *config.Chain
+	// QuotaPaymaster is the dedicated paymaster whose sender accounts are
+	// charged, empty for the shared accounts.
+	QuotaPaymaster string
+
+	callGas *callGasCache
+}
+
+func newChainContext(con container.Container, conf *config.Chain, values *config.Values, states store.Store) (*ChainContext, error) {
+	var rpc chain.Client
+	var err error
+	if values.MockChain {
+		rpc, err = chain.NewMockBackend(values.MockVipOwners)
+	} else {
+		rpc, err = ethclient.Dial(conf.RPC)
+	}
+	if err != nil {
+		return nil, err
+	}
+	chainID, err := rpc.ChainID(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if conf.ChainID != 0 && conf.ChainID != chainID.Uint64() {
+		return nil, fmt.Errorf("chain %d: rpc serves chain %s", conf.ChainID, chainID)
+	}
+	logger.S().Infof("Chain %s VerifyingPaymaster contract: %s", chainID, conf.Contract)
+	if conf.RPCConcurrency > 0 {
+		rpc = chain.NewLimited(rpc, chainID, conf.RPCConcurrency, conf.RPCQueue, values.RpcQueueTimeout)
+	}
+
+	contract := common.HexToAddress(conf.Contract)
+	paymaster, err := contracts.NewVerifyingPaymaster(contract, rpc)
+	if err != nil {
+		return nil, err
+	}
+	vipContract, err := contracts.NewVipNFT(common.HexToAddress(conf.VipContract), rpc)
+	if err != nil {
+		return nil, err
+	}
+	var createGas, maxGas, maxVipGas, maxPrefund, maxOpCost, maxSignedCost, freeTierGas *big.Int
+	for _, amount := range []struct {
+		name  string
+		value string
+		dest  **big.Int
+	}{
+		{"CREATE_GAS", conf.CreateGas, &createGas},
+		{"MAX_GAS", conf.MaxGas, &maxGas},
+		{"VIP_MAX_GAS", conf.VipMaxGas, &maxVipGas},
+		{"MAX_PREFUND", conf.MaxPrefund, &maxPrefund},
+		{"MAX_OP_COST", conf.MaxOpCost, &maxOpCost},
+		{"MAX_SIGNED_COST", conf.MaxSignedCost, &maxSignedCost},
+		{"FREE_TIER_GAS", values.FreeTierGas, &freeTierGas},
+	} {
+		var ok bool
+		if *amount.dest, ok = parseWei(amount.value); !ok {
+			return nil, fmt.Errorf("chain %s: invalid %s %q", chainID, amount.name, amount.value)
+		}
+	}
+
+	signRate := &policy.SignRate{
+		States:      states,
+		MaxCost:     maxSignedCost,
+		MaxGas:      conf.MaxSignedGas,
+		Window:      values.SignedCostWindow,
+		MaxBlockGas: conf.MaxBlockSignedGas,
+	}
+	checks := []policy.Checker{
+		&policy.GasBounds{
+			MinVerificationGas:    new(big.Int).SetUint64(conf.MinVerificationGas),
+			MaxVerificationGas:    new(big.Int).SetUint64(conf.MaxVerificationGas),
+			MinPreVerificationGas: new(big.Int).SetUint64(conf.MinPreVerificationGas),
+			MaxPreVerificationGas: new(big.Int).SetUint64(conf.MaxPreVerificationGas),
+		},
+		&policy.PrefundCeiling{Max: maxPrefund},
+		&policy.OpCostCap{Max: maxOpCost},
+		signRate,
+		&policy.BudgetPause{Rep: con.GetRepository()},
+		&policy.PaymentHold{Rep: con.GetRepository()},
+		policy.NewAggregatorAllowlist(values.Aggregators),
+	}
+	if values.FreeTierOps > 0 || freeTierGas != nil {
+		checks = append(checks, &policy.FreeTier{
+			Rep:      con.GetRepository(),
+			Ops:      values.FreeTierOps,
+			Gas:      freeTierGas,
+			Throttle: values.FreeTierThrottle,
+		})
+	}
+	if above, ok := new(big.Int).SetString(values.DualApprovalAbove, 10); ok && above.Sign() > 0 {
+		checks = append(checks, &policy.HoldRule{Above: above})
+	}
+	if values.ScreeningURL != "" {
+		checks = append(checks, screening.New(&screening.Config{
+			URL:      values.ScreeningURL,
+			Token:    values.ScreeningToken,
+			TTL:      values.ScreeningTTL,
+			Timeout:  values.ScreeningTimeout,
+			FailOpen: values.ScreeningFailOpen,
+		}, con.GetRepository()))
+	}
+	var factories *policy.FactoryRegistry
+	if values.FactoryRegistry {
+		factories = policy.NewFactoryRegistry(rpc, con.GetRepository())
+		if err := factories.Refresh(context.Background()); err != nil {
+			return nil, err
+		}
+		checks = append(checks, factories)
+	}
+	if !values.MockChain {
+		// the mock chain has no EntryPoint to run initCode
+		checks = append(checks, &policy.CounterfactualSender{Client: rpc})
+		if values.NonceMaxGap >= 0 {
+			checks = append(checks, &policy.NonceGap{Client: rpc, MaxGap: uint64(values.NonceMaxGap)})
+		}
+	}
+
+	var bundlers *bundler.Pool
+	if len(conf.Bundlers) > 0 {
+		bundlers = bundler.NewPool(conf.Bundlers)
+	}
+	var selfBundler *bundler.SelfBundler
+	if conf.SelfBundlerKey != "" {
+		key, err := crypto.HexToECDSA(strings.TrimPrefix(conf.SelfBundlerKey, "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("chain %s: self bundler key: %v", chainID, err)
+		}
+		selfBundler, err = bundler.NewSelfBundler(&bundler.SelfConfig{
+			Key:         key,
+			ChainID:     chainID,
+			EntryPoint:  common.HexToAddress(conf.EntryPoint),
+			Beneficiary: common.HexToAddress(values.SelfBundlerBeneficiary),
+			MaxBatch:    values.SelfBundlerMaxBatch,
+			Interval:    values.SelfBundlerInterval,
+			ResendAfter: values.SelfBundlerResendAfter,
+		}, rpc, con.GetRepository())
+		if err != nil {
+			return nil, err
+		}
+		logger.S().Infof("Chain %s self bundler: %s", chainID, selfBundler.Address())
+	}
+	var quotaRate *big.Rat
+	if values.QuotaUnit != "" {
+		quotaRate, _ = conf.Rate()
+	}
+
+	return &ChainContext{
+		Client:      rpc,
+		ChainID:     chainID,
+		Contract:    contract,
+		Paymaster:   paymaster,
+		EntryPoint:  common.HexToAddress(conf.EntryPoint),
+		VipContract: vipContract,
+		CreateGas:   createGas,
+		MaxGas:      maxGas,
+		MaxVipGas:   maxVipGas,
+		Checks:      checks,
+		SignRate:    signRate,
+		Factories:   factories,
+		Bundler:     bundlers,
+		SelfBundler: selfBundler,
+		QuotaRate:   quotaRate,
+		MultiSend:   policy.AddressSet(values.SafeMultiSend),
+		Config:      conf,
+		callGas:     newCallGasCache(),
+	}, nil
+}
+
+// parseWei parses a non negative wei amount, nil when value is empty.
+func parseWei(value string) (*big.Int, bool) {
+	if value == "" {
+		return nil, true
+	}
+	amount, ok := new(big.Int).SetString(value, 10)
+	return amount, ok && amount.Sign() >= 0
+}
+
+var weiPerToken = new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+
+// quotaCost converts a gas cost in wei to the quota it is charged, rounding
+// up.
+func (c *ChainContext) quotaCost(gasCost *big.Int) *big.Int {
+	if c.QuotaRate == nil {
+		return gasCost
+	}
+	cost := new(big.Int).Mul(gasCost, c.QuotaRate.Num())
+	den := new(big.Int).Mul(weiPerToken, c.QuotaRate.Denom())
+	cost.Add(cost, new(big.Int).Sub(den, big.NewInt(1)))
+	return cost.Div(cost, den)
+}
+
+// Chain returns the context of chainID, nil when the chain is not served.
+func (s *Signer) Chain(chainID uint64) *ChainContext {
+	return s.Chains[chainID]
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// configTTL is how long pm_config is served from memory. Everything but the
+// maintenance windows is static until restart.
+const configTTL = 30 * time.Second
+
+// CacheableMethods are the read only methods without params clients and
+// CDNs may reuse the responses of, for how long.
+var CacheableMethods = map[string]time.Duration{
+	"pm_config":                configTTL,
+	"eth_supportedEntryPoints": 5 * time.Minute,
+}
+
+// apiKeyTTL is how long api keys are served from memory, changes published
+// on the cache bus apply at once.
+const apiKeyTTL = 30 * time.Second
+
+// configCache keeps the pm_config response.
+type configCache struct {
+	mu      sync.Mutex
+	value   *PaymasterConfig
+	expires time.Time
+}
+
+// InvalidateConfig drops the cached pm_config response, so the next call
+// reads the maintenance windows again.
+func (s *Signer) InvalidateConfig() {
+	s.cachedConfig.mu.Lock()
+	defer s.cachedConfig.mu.Unlock()
+	s.cachedConfig.value = nil
+}
+
+// Pm_config returns the limits and contracts of the service, served from
+// memory for configTTL.
+func (s *Signer) Pm_config() (*PaymasterConfig, error) {
+	s.cachedConfig.mu.Lock()
+	defer s.cachedConfig.mu.Unlock()
+	now := time.Now()
+	if s.cachedConfig.value != nil && now.Before(s.cachedConfig.expires) {
+		return s.cachedConfig.value, nil
+	}
+
+	windows, err := models.UpcomingMaintenance(s.Container.GetRepository(), now, now.Add(s.MaintenanceNotice))
+	if err != nil {
+		logger.S().Errorf("Query maintenance windows error: %v", err)
+		return nil, err
+	}
+	var maintenance []MaintenanceNotice
+	for _, w := range windows {
+		maintenance = append(maintenance, MaintenanceNotice{Start: w.Start.Unix(), End: w.End.Unix(), Reason: w.Reason})
+	}
+	var chains []ChainMetadata
+	for _, chain := range s.sortedChains() {
+		chains = append(chains, ChainMetadata{
+			ChainID:    chain.ChainID.String(),
+			Paymaster:  chain.Contract.Hex(),
+			EntryPoint: chain.EntryPoint.Hex(),
+		})
+	}
+	s.cachedConfig.value = &PaymasterConfig{
+		MaxGas:      s.Config.MaxGas,
+		VipContract: s.Config.VipContract,
+		MaxVipGas:   s.Config.VipMaxGas,
+		QuotaUnit:   s.QuotaUnit,
+		Maintenance: maintenance,
+		Chains:      chains,
+	}
+	s.cachedConfig.expires = now.Add(configTTL)
+	return s.cachedConfig.value, nil
+}
+
+// Eth_supportedEntryPoints returns the EntryPoints of the served chains, the
+// one of the default chain first. It is answered from the configuration,
+// without asking the bundler.
+func (s *Signer) Eth_supportedEntryPoints() ([]string, error) {
+	seen := make(map[string]bool)
+	entryPoints := []string{}
+	for _, chain := range s.sortedChains() {
+		entryPoint := chain.EntryPoint.Hex()
+		if !seen[entryPoint] {
+			seen[entryPoint] = true
+			entryPoints = append(entryPoints, entryPoint)
+		}
+	}
+	return entryPoints, nil
+}
+
+// sortedChains returns the default chain, then the others by chain id.
+func (s *Signer) sortedChains() []*ChainContext {
+	chains := []*ChainContext{s.ChainContext}
+	for _, chain := range s.Chains {
+		if chain != s.ChainContext {
+			chains = append(chains, chain)
+		}
+	}
+	others := chains[1:]
+	sort.Slice(others, func(i, j int) bool {
+		return others[i].ChainID.Cmp(others[j].ChainID) < 0
+	})
+	return chains
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+type apiKeyCtxKey struct{}
+
+type clientIPCtxKey struct{}
+
+type headerCtxKey struct{}
+
+// WithApiKey returns a copy of ctx carrying the api key of the request.
+func WithApiKey(ctx context.Context, key *models.ApiKeys) context.Context {
+	return context.WithValue(ctx, apiKeyCtxKey{}, key)
+}
+
+// ApiKeyFromContext returns the api key of the request, or nil.
+func ApiKeyFromContext(ctx context.Context) *models.ApiKeys {
+	key, _ := ctx.Value(apiKeyCtxKey{}).(*models.ApiKeys)
+	return key
+}
+
+// WithClientIP returns a copy of ctx carrying the client IP of the request.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPCtxKey{}, ip)
+}
+
+// ClientIPFromContext returns the client IP of the request, or "".
+func ClientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPCtxKey{}).(string)
+	return ip
+}
+
+// WithRequestHeader returns a copy of ctx carrying the HTTP header of the
+// request.
+func WithRequestHeader(ctx context.Context, header http.Header) context.Context {
+	return context.WithValue(ctx, headerCtxKey{}, header)
+}
+
+// RequestHeaderFromContext returns the HTTP header of the request, empty
+// when there is none.
+func RequestHeaderFromContext(ctx context.Context) http.Header {
+	header, _ := ctx.Value(headerCtxKey{}).(http.Header)
+	if header == nil {
+		return http.Header{}
+	}
+	return header
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+type CreditClaimResult struct {
+	Amount    string `json:"amount"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// Pm_claimCredits grants the project of the api key a credit pack for a
+// confirmed payment of the native token to the credit payment address on
+// the default chain. The payment data must be the project id as a 32 byte
+// word, so a payment can only be claimed by the project it was made for.
+func (s *Signer) Pm_claimCredits(ctx context.Context, txHash string) (*CreditClaimResult, error) {
+	apiKey := ApiKeyFromContext(ctx)
+	if apiKey == nil {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_API_KEY, "api key required", map[string]any{"reason": rpcerrors.REASON_API_KEY_REQUIRED})
+	}
+	if s.CreditPaymentAddress == (common.Address{}) {
+		return nil, rpcerrors.NewRPCError(rpcerrors.METHOD_NOT_FOUND, "on-chain credit payments not enabled", nil)
+	}
+	hash := common.HexToHash(txHash)
+	tx, pending, err := s.Client.TransactionByHash(ctx, hash)
+	if err == ethereum.NotFound || (err == nil && pending) {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, "payment transaction not mined", nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	projectID := common.LeftPadBytes(new(big.Int).SetUint64(uint64(apiKey.UserID)).Bytes(), 32)
+	if tx.To() == nil || *tx.To() != s.CreditPaymentAddress || tx.Value().Sign() <= 0 || !bytes.Equal(tx.Data(), projectID) {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, "not a credit payment of this project", nil)
+	}
+	receipt, err := s.Client.TransactionReceipt(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if receipt.Status != ethtypes.ReceiptStatusSuccessful {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, "payment transaction reverted", nil)
+	}
+	head, err := s.Client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	confirmations := new(big.Int).Sub(head.Number, receipt.BlockNumber)
+	if confirmations.Cmp(new(big.Int).SetUint64(s.CreditConfirmations)) < 0 {
+		return nil, rpcerrors.NewRPCError(
+			rpcerrors.INVALID_PARAMS,
+			fmt.Sprintf("payment has %s of %d confirmations", confirmations, s.CreditConfirmations),
+			nil,
+		)
+	}
+
+	pack := &models.CreditEntry{
+		UserID:    apiKey.UserID,
+		Amount:    tx.Value().String(),
+		Source:    models.CreditSourceOnchain,
+		Reference: fmt.Sprintf("onchain:%s:%s", s.ChainID, hash.Hex()),
+		ExpiresAt: time.Now().Add(s.CreditPackValidity),
+	}
+	granted, err := models.GrantCredits(s.Container.GetRepository(), pack)
+	if err != nil {
+		logger.S().Errorf("grant credits error: %v", err)
+		return nil, err
+	}
+	if !granted {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, "payment already claimed", nil)
+	}
+	logger.S().Infof("Granted project %d credits of %s for payment %s", apiKey.UserID, pack.Amount, hash.Hex())
+	return &CreditClaimResult{Amount: pack.Amount, ExpiresAt: pack.ExpiresAt.Unix()}, nil
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/utils"
+)
+
+// RunDepositMonitor records the deposit headroom of the shared and the
+// dedicated paymasters of every chain every interval until ctx is cancelled.
+func (s *Signer) RunDepositMonitor(ctx context.Context, interval time.Duration) {
+	for {
+		for _, chain := range s.depositChains() {
+			if err := s.checkDeposit(ctx, chain, time.Now()); err != nil {
+				logger.S().Errorf("deposit monitor error on chain %s paymaster %s: %v", chain.ChainID, chain.Contract.Hex(), err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// depositChains returns the chains, each followed by the chain contexts of
+// the dedicated paymasters of api keys on it.
+func (s *Signer) depositChains() []*ChainContext {
+	rep := s.Container.GetRepository()
+	var chains []*ChainContext
+	for _, chain := range s.sortedChains() {
+		chains = append(chains, chain)
+		dedicated, err := models.KeyPaymasterContracts(rep, chain.ChainID.Uint64())
+		if err != nil {
+			logger.S().Errorf("query dedicated paymasters of chain %s error: %v", chain.ChainID, err)
+			continue
+		}
+		for _, contract := range dedicated {
+			paymaster, err := s.dedicatedChain(chain, common.HexToAddress(contract))
+			if err != nil {
+				logger.S().Errorf("load dedicated paymaster %s of chain %s error: %v", contract, chain.ChainID, err)
+				continue
+			}
+			if paymaster != chain {
+				chains = append(chains, paymaster)
+			}
+		}
+	}
+	return chains
+}
+
+// checkDeposit compares the EntryPoint deposit of the paymaster of chain
+// with the gas cost its sponsorships may still be charged and the safety
+// buffer.
+func (s *Signer) checkDeposit(ctx context.Context, chain *ChainContext, now time.Time) error {
+	info, err := chain.DepositInfo(ctx)
+	if err != nil {
+		return err
+	}
+	rep := s.Container.GetRepository()
+	outstanding, err := models.OutstandingGasCost(rep, chain.ChainID.Uint64(), utils.LowerAddress(chain.Contract), chain == s.ChainContext, now)
+	if err != nil {
+		return err
+	}
+	buffer := s.DepositBuffer
+	if buffer == nil {
+		buffer = new(big.Int)
+	}
+	headroom := new(big.Int).Sub(info.Deposit, outstanding)
+	headroom.Sub(headroom, buffer)
+	if headroom.Sign() < 0 {
+		logger.S().Warnf("Paymaster %s deposit on chain %s is %s wei short of outstanding sponsorships and buffer", chain.Contract.Hex(), chain.ChainID, new(big.Int).Neg(headroom))
+	}
+	return models.SaveDepositSnapshot(rep, &models.DepositSnapshot{
+		ChainID:     chain.ChainID.Uint64(),
+		Paymaster:   chain.Contract.Hex(),
+		EntryPoint:  chain.EntryPoint.Hex(),
+		Deposit:     info.Deposit.String(),
+		Outstanding: outstanding.String(),
+		Buffer:      buffer.String(),
+		Headroom:    headroom.String(),
+		CheckedAt:   now,
+	})
+}
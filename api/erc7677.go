@@ -0,0 +1,166 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+)
+
+// stubSignature is a well formed signature that recovers to an unrelated
+// address, so gas estimation runs the paymaster signature check without
+// reverting.
+var stubSignature = hexutil.MustDecode("0xfffffffffffffffffffffffffffffff0000000000000000000000000000000007aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa1c")
+
+// PaymasterStubData is the ERC-7677 pm_getPaymasterStubData result.
+type PaymasterStubData struct {
+	PaymasterAndData string `json:"paymasterAndData"`
+	IsFinal          bool   `json:"isFinal"`
+}
+
+// PaymasterData is the ERC-7677 pm_getPaymasterData result.
+type PaymasterData struct {
+	PaymasterAndData string `json:"paymasterAndData"`
+}
+
+// resolveChain returns the context of the hex or decimal chain id, the
+// default chain when empty.
+func (s *Signer) resolveChain(chainID string) (*ChainContext, error) {
+	if chainID == "" {
+		return s.ChainContext, nil
+	}
+	number, base := chainID, 10
+	if strings.HasPrefix(chainID, "0x") || strings.HasPrefix(chainID, "0X") {
+		number, base = chainID[2:], 16
+	}
+	id, ok := new(big.Int).SetString(number, base)
+	if !ok || !id.IsUint64() {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, fmt.Sprintf("invalid chainId %q", chainID), nil)
+	}
+	chain := s.Chain(id.Uint64())
+	if chain == nil {
+		supported := make([]string, 0, len(s.Chains))
+		for id := range s.Chains {
+			supported = append(supported, hexutil.EncodeUint64(id))
+		}
+		sort.Strings(supported)
+		return nil, rpcerrors.NewRPCError(
+			rpcerrors.INVALID_PARAMS,
+			fmt.Sprintf("unsupported chain %s", hexutil.EncodeBig(id)),
+			map[string]any{"supportedChains": supported},
+		)
+	}
+	return chain, nil
+}
+
+// contextChain resolves the optional chainId of a sponsor context.
+func (s *Signer) contextChain(sponsorContext map[string]any) (*ChainContext, error) {
+	switch id := sponsorContext["chainId"].(type) {
+	case nil:
+		return s.ChainContext, nil
+	case string:
+		return s.resolveChain(id)
+	case float64:
+		if id < 0 || id != float64(uint64(id)) {
+			return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, fmt.Sprintf("invalid chainId %v", id), nil)
+		}
+		return s.resolveChain(fmt.Sprint(uint64(id)))
+	default:
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, fmt.Sprintf("invalid chainId %v", id), nil)
+	}
+}
+
+// erc7677Chain resolves the chain of an ERC-7677 request and checks that the
+// paymaster serves entryPoint on it.
+func (s *Signer) erc7677Chain(entryPoint, chainID string) (*ChainContext, error) {
+	if chainID == "" {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, "missing chainId", nil)
+	}
+	chain, err := s.resolveChain(chainID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkEntryPoint(chain, entryPoint); err != nil {
+		return nil, err
+	}
+	return chain, nil
+}
+
+// sponsorChain resolves the chain of the context of a sponsorship request
+// and checks that the paymaster serves entryPoint on it.
+func (s *Signer) sponsorChain(entryPoint string, sponsorContext map[string]any) (*ChainContext, error) {
+	chain, err := s.contextChain(sponsorContext)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkEntryPoint(chain, entryPoint); err != nil {
+		return nil, err
+	}
+	return chain, nil
+}
+
+// checkEntryPoint rejects an entryPoint other than the EntryPoint of chain.
+func checkEntryPoint(chain *ChainContext, entryPoint string) error {
+	if !common.IsHexAddress(entryPoint) || common.HexToAddress(entryPoint) != chain.EntryPoint {
+		return rpcerrors.NewRPCError(
+			rpcerrors.INVALID_PARAMS,
+			fmt.Sprintf("unsupported entryPoint %s on chain %s", entryPoint, hexutil.EncodeBig(chain.ChainID)),
+			nil,
+		)
+	}
+	return nil
+}
+
+// Pm_getPaymasterStubData returns paymasterAndData with a stub signature for
+// gas estimation on chainId (ERC-7677). Nothing is checked or charged.
+func (s *Signer) Pm_getPaymasterStubData(ctx context.Context, op json.RawMessage, entryPoint string, chainID string, sponsorContext map[string]any) (*PaymasterStubData, error) {
+	chain, err := s.erc7677Chain(entryPoint, chainID)
+	if err != nil {
+		return nil, err
+	}
+	chain, err = s.keyChain(ctx, chain)
+	if err != nil {
+		return nil, err
+	}
+	paymasterAndData, err := stubPaymasterAndData(chain)
+	if err != nil {
+		return nil, err
+	}
+	return &PaymasterStubData{PaymasterAndData: paymasterAndData}, nil
+}
+
+// stubPaymasterAndData returns the paymasterAndData of chain with a day long
+// validity window and the stub signature.
+func stubPaymasterAndData(chain *ChainContext) (string, error) {
+	validAfter := big.NewInt(time.Now().Unix())
+	validUntil := new(big.Int).Add(validAfter, validTimeDelay)
+	timeRangeData, err := timeRangeABI.Pack(validUntil, validAfter)
+	if err != nil {
+		return "", err
+	}
+	return hexutil.Encode(append(append(chain.Contract.Bytes(), timeRangeData...), stubSignature...)), nil
+}
+
+// Pm_getPaymasterData sponsors op on chainId (ERC-7677). Unlike
+// pm_sponsorUserOperation the gas limits of op are signed as given.
+// sponsorContext may carry the sessionId the operation is charged to and the
+// requested validUntil.
+func (s *Signer) Pm_getPaymasterData(ctx context.Context, op json.RawMessage, entryPoint string, chainID string, sponsorContext map[string]any) (*PaymasterData, error) {
+	chain, err := s.erc7677Chain(entryPoint, chainID)
+	if err != nil {
+		return nil, err
+	}
+	result, err := s.sponsor(ctx, chain, op, sponsorContext, true)
+	if err != nil {
+		return nil, err
+	}
+	return &PaymasterData{PaymasterAndData: result.PaymasterAndData}, nil
+}
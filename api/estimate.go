@@ -2,7 +2,6 @@ package api
 
 import (
 	"context"
-	"crypto/ecdsa"
 	"errors"
 	"fmt"
 	"math/big"
@@ -13,35 +12,13 @@ import (
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/rpc"
 
+	"github.com/ququzone/verifying-paymaster-service/chain"
 	"github.com/ququzone/verifying-paymaster-service/contracts"
 	"github.com/ququzone/verifying-paymaster-service/types"
-	"github.com/ququzone/verifying-paymaster-service/utils"
 )
 
-type RPCError struct {
-	code    int
-	message string
-	data    any
-}
-
-// New returns a new custom RPCError.
-func NewRPCError(code int, message string, data any) error {
-	return &RPCError{code, message, data}
-}
-
-// Code returns the message field of the JSON-RPC error object.
-func (e *RPCError) Error() string {
-	return e.message
-}
-
-// Data returns the data field of the JSON-RPC error object.
-func (e *RPCError) Data() any {
-	return e.data
-}
-
 type ExecutionResultRevert struct {
 	PreOpGas      *big.Int
 	Paid          *big.Int
@@ -164,10 +141,10 @@ func NewFailedOp(err error) (*FailedOpRevert, error) {
 }
 
 func estimate(
-	client *ethclient.Client,
-	key *ecdsa.PrivateKey,
+	client chain.Client,
 	paymasterAddr common.Address,
-	paymaster *contracts.VerifyingPaymaster,
+	sign func(op *types.UserOperation, validUntil, validAfter *big.Int) ([]byte, error),
+	estimateCall func(op *types.UserOperation) (uint64, error),
 	entryPoint common.Address,
 	op *types.UserOperation,
 ) (preVerificationGas *big.Int, verificationGas *big.Int, callGas *big.Int, err error) {
@@ -189,23 +166,7 @@ func estimate(
 	preSignature := op.Signature
 	op.Signature = []byte{}
 
-	hash, err := paymaster.GetHash(nil, contracts.UserOperation{
-		Sender:               op.Sender,
-		Nonce:                op.Nonce,
-		InitCode:             op.InitCode,
-		CallData:             op.CallData,
-		CallGasLimit:         op.CallGasLimit,
-		VerificationGasLimit: op.VerificationGasLimit,
-		PreVerificationGas:   op.PreVerificationGas,
-		MaxFeePerGas:         op.MaxFeePerGas,
-		MaxPriorityFeePerGas: op.MaxPriorityFeePerGas,
-		PaymasterAndData:     op.PaymasterAndData,
-		Signature:            op.Signature,
-	}, validUntil, validAfter)
-	if err != nil {
-		return nil, nil, nil, err
-	}
-	signature, err := utils.SignMessage(key, hash[:])
+	signature, err := sign(op, validUntil, validAfter)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -231,7 +192,11 @@ func estimate(
 		nil,
 	)
 	if err != nil {
-		return nil, nil, nil, err
+		revert, ok := revertData(err)
+		if !ok {
+			return nil, nil, nil, err
+		}
+		data = revert
 	}
 	err = &revertError{
 		reason: hexutil.Encode(data),
@@ -239,28 +204,13 @@ func estimate(
 
 	sim, simErr := NewExecutionResult(err)
 	if simErr != nil {
-		fo, foErr := NewFailedOp(err)
-		if foErr != nil {
-			return nil, nil, nil, fmt.Errorf("%s, %s", simErr, foErr)
-		}
-		return nil, nil, nil, NewRPCError(-32500, fo.Reason, fo)
+		return nil, nil, nil, revertRPCError(err)
 	}
 
-	code, err := client.CodeAt(context.Background(), op.Sender, nil)
+	est, err := estimateCall(op)
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	var est uint64 = 100000
-	if len(code) > 0 || len(op.CallData) == 0 {
-		est, err = client.EstimateGas(context.Background(), ethereum.CallMsg{
-			From: entryPoint,
-			To:   &op.Sender,
-			Data: op.CallData,
-		})
-		if err != nil {
-			return nil, nil, nil, err
-		}
-	}
 
 	pvg, err := CalcPreVerificationGas(op)
 	if err != nil {
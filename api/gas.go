@@ -7,6 +7,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/common/hexutil"
 
+	"github.com/ququzone/verifying-paymaster-service/models"
 	"github.com/ququzone/verifying-paymaster-service/types"
 )
 
@@ -56,3 +57,21 @@ func CalcPreVerificationGas(op *types.UserOperation) (*big.Int, error) {
 
 	return static, nil
 }
+
+// markupGas adds the gas limit markup of key, or of the chain when the key
+// has none, to an estimated gas limit.
+func (c *ChainContext) markupGas(key *models.ApiKeys, gas *big.Int) *big.Int {
+	percent, absolute := c.Config.GasMarkupPercent, c.Config.GasMarkup
+	if key != nil && key.GasMarkupPercent != nil {
+		percent = *key.GasMarkupPercent
+	}
+	if key != nil && key.GasMarkup != nil {
+		absolute = *key.GasMarkup
+	}
+	if percent == 0 && absolute == 0 {
+		return gas
+	}
+	marked := new(big.Int).Mul(gas, new(big.Int).SetUint64(100+percent))
+	marked.Div(marked, big.NewInt(100))
+	return marked.Add(marked, new(big.Int).SetUint64(absolute))
+}
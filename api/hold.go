@@ -0,0 +1,128 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/policy"
+	"github.com/ququzone/verifying-paymaster-service/types"
+)
+
+// holdWindow is how long a held operation can be approved and an approved
+// one resubmitted, the validity of a paymaster signature.
+var holdWindow = time.Duration(validTimeDelay.Int64()) * time.Second
+
+// holdHash identifies op as submitted by the client, paymasterAndData is not
+// part of it since it changes when signed.
+func holdHash(chain *ChainContext, op *types.UserOperation) common.Hash {
+	paymasterAndData := op.PaymasterAndData
+	op.PaymasterAndData = nil
+	hash := op.GetUserOpHash(chain.EntryPoint, chain.ChainID)
+	op.PaymasterAndData = paymasterAndData
+	return hash
+}
+
+// checkHold turns a policy hold of sp into a HELD_FOR_APPROVAL error unless
+// an operator approved the operation. Other errors are returned as is.
+func (s *Signer) checkHold(sp *sponsorship, err error) error {
+	hold, ok := err.(*policy.Hold)
+	if !ok {
+		return err
+	}
+	rec, err := models.FindHold(s.Container.GetRepository(), sp.holdHash.Hex())
+	if err != nil {
+		logger.S().Errorf("Query held operation error: %v", err)
+		return err
+	}
+	if rec != nil && rec.ApiKeyID == sp.apiKeyID && rec.ChainID == sp.chain.ChainID.Uint64() {
+		switch rec.CurrentStatus(time.Now()) {
+		case models.HoldApproved:
+			// the approval covers the reviewed cost, not a costlier resubmission
+			if sp.totalGas.Cmp(models.ParseGas(rec.MaxGasCost)) <= 0 {
+				sp.hold = rec
+				return nil
+			}
+		case models.HoldRejected:
+			return rpcerrors.RejectedByPaymaster("rejected by operator", rpcerrors.REASON_HOLD_REJECTED)
+		}
+	}
+	sp.held = hold.Reason
+	return rpcerrors.NewRPCError(rpcerrors.HELD_FOR_APPROVAL, "operation held for approval", map[string]any{
+		"reason":     hold.Reason,
+		"userOpHash": sp.holdHash.Hex(),
+	})
+}
+
+// queueHold stores the held operation sp for review, a decided or expired
+// hold of the same operation is reopened.
+func (s *Signer) queueHold(sp *sponsorship, op json.RawMessage) {
+	var operation bytes.Buffer
+	if err := json.Compact(&operation, op); err != nil {
+		logger.S().Errorf("encode held operation error: %v", err)
+		return
+	}
+	rep := s.Container.GetRepository()
+	rec, err := models.FindHold(rep, sp.holdHash.Hex())
+	if err != nil {
+		logger.S().Errorf("Query held operation error: %v", err)
+		return
+	}
+	now := time.Now()
+	if rec != nil && rec.CurrentStatus(now) == models.HoldPending {
+		return
+	}
+	if rec == nil {
+		rec = &models.HeldOperation{HoldHash: sp.holdHash.Hex()}
+	}
+	rec.ChainID = sp.chain.ChainID.Uint64()
+	rec.ApiKeyID = sp.apiKeyID
+	rec.Sender = sp.sender
+	rec.Nonce = sp.op.Nonce.String()
+	rec.Operation = operation.String()
+	rec.MaxGasCost = sp.totalGas.String()
+	rec.Reason = sp.held
+	if len(rec.Reason) > 255 {
+		rec.Reason = rec.Reason[:255]
+	}
+	rec.Status = models.HoldPending
+	rec.Operator = ""
+	rec.RequiredApprovals = 1
+	if s.DualApprovalAbove != nil && sp.totalGas.Cmp(s.DualApprovalAbove) >= 0 {
+		rec.RequiredApprovals = 2
+	}
+	rec.Approvals = 0
+	rec.ExpiresAt = now.Add(holdWindow)
+	rec.SponsorshipHash = ""
+	err = rep.Transaction(func(tx db.Repository) error {
+		if rec.ID != 0 {
+			// approvals of an earlier round do not count
+			err := tx.Model(&models.HoldApproval{}).Unscoped().Where(`"hold_id" = ?`, rec.ID).Delete(&models.HoldApproval{}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Save(rec).Error
+	})
+	if err != nil {
+		logger.S().Errorf("save held operation error: %v", err)
+		return
+	}
+	logger.S().Infof("Held operation %s of %s for %d approvals: %s", rec.HoldHash, rec.Sender, rec.RequiredApprovals, rec.Reason)
+}
+
+// useHold marks the approved hold of sp signed.
+func (s *Signer) useHold(sp *sponsorship, sponsorshipHash string) {
+	err := s.Container.GetRepository().Model(&models.HeldOperation{}).
+		Where(`"id" = ? AND "status" = ?`, sp.hold.ID, models.HoldApproved).
+		Updates(map[string]any{"status": models.HoldSigned, "sponsorship_hash": sponsorshipHash}).Error
+	if err != nil {
+		logger.S().Errorf("update held operation error: %v", err)
+	}
+}
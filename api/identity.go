@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"math/big"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/utils"
+)
+
+// LinkedIdentity is a web2 identity linked to an address.
+type LinkedIdentity struct {
+	Address  string `json:"address"`
+	Provider string `json:"provider"`
+	Email    string `json:"email"`
+	LinkedAt int64  `json:"linkedAt"`
+}
+
+// Pm_linkIdentity links the web2 identity an OAuth authorization code was
+// issued to with addr. params holds the provider (google or github) and the
+// code, issued for IDENTITY_REDIRECT_URL. An identity is linked to a single
+// address; linking it again to the same address is a no-op.
+func (s *Signer) Pm_linkIdentity(ctx context.Context, addr string, params map[string]any) (*LinkedIdentity, error) {
+	if s.Identities == nil {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_REQUEST, "identity linking is not enabled", nil)
+	}
+	address, err := utils.NormalizeAddress(addr)
+	if err != nil {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, err.Error(), nil)
+	}
+	provider, _ := params["provider"].(string)
+	code, _ := params["code"].(string)
+	if provider == "" || code == "" {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, "provider and code required", nil)
+	}
+	verified, err := s.Identities.Verify(ctx, provider, code)
+	if err != nil {
+		logger.S().Debugf("verify %s identity error: %v", provider, err)
+		return nil, rpcerrors.RejectedByPaymaster(err.Error(), rpcerrors.REASON_IDENTITY_INVALID)
+	}
+
+	rep := s.Container.GetRepository()
+	rec, err := models.FindIdentity(rep, verified.Provider, verified.Subject)
+	if err != nil {
+		logger.S().Errorf("Query identity error: %v", err)
+		return nil, err
+	}
+	switch {
+	case rec != nil && rec.Address != address:
+		return nil, rpcerrors.RejectedByPaymaster("identity already linked to another address", rpcerrors.REASON_IDENTITY_IN_USE)
+	case rec != nil:
+		rec.Email = verified.Email
+		err = rep.Save(rec).Error
+	default:
+		rec = &models.Identity{
+			Provider: verified.Provider,
+			Subject:  verified.Subject,
+			Email:    verified.Email,
+			Address:  address,
+		}
+		err = rep.Create(rec).Error
+	}
+	if err != nil {
+		logger.S().Errorf("save identity error: %v", err)
+		return nil, err
+	}
+	return &LinkedIdentity{
+		Address:  rec.Address,
+		Provider: rec.Provider,
+		Email:    rec.Email,
+		LinkedAt: rec.CreatedAt.Unix(),
+	}, nil
+}
+
+// identityGas returns the quota a refresh grants to address, gas raised to
+// the identity tier of the policy p when the address has a linked identity.
+// VIP quotas are kept.
+func (s *Signer) identityGas(p *models.Policy, address string, gas *big.Int, vip int64) (*big.Int, error) {
+	if p == nil || p.IdentityMaxGas == "" || vip != -1 {
+		return gas, nil
+	}
+	tier := models.ParseGas(p.IdentityMaxGas)
+	if tier.Cmp(gas) <= 0 {
+		return gas, nil
+	}
+	linked, err := models.HasIdentity(s.Container.GetRepository(), address)
+	if err != nil {
+		logger.S().Errorf("Query identity error: %v", err)
+		return nil, err
+	}
+	if !linked {
+		return gas, nil
+	}
+	return tier, nil
+}
+
+// checkIdentity refuses the refresh of addresses without linked identity
+// when the policy p requires one.
+func (s *Signer) checkIdentity(p *models.Policy, address string) error {
+	if p == nil || !p.RequireIdentity {
+		return nil
+	}
+	linked, err := models.HasIdentity(s.Container.GetRepository(), address)
+	if err != nil {
+		logger.S().Errorf("Query identity error: %v", err)
+		return err
+	}
+	if !linked {
+		return rpcerrors.RejectedByPaymaster("link an identity with pm_linkIdentity to claim gas", rpcerrors.REASON_IDENTITY_REQUIRED)
+	}
+	return nil
+}
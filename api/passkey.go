@@ -0,0 +1,556 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/utils"
+)
+
+// authenticator data flags of a user present ceremony and of attested
+// credential data
+const (
+	flagUserPresent  = 0x01
+	flagAttestedData = 0x40
+)
+
+// attestation policies of passkey registrations
+const (
+	// PasskeyAttestationNone accepts any authenticator
+	PasskeyAttestationNone = "none"
+	// PasskeyAttestationPacked accepts packed attestations certified by the
+	// trusted attestation roots
+	PasskeyAttestationPacked = "packed"
+)
+
+// COSE key parameters of an ES256 P-256 credential key
+const (
+	coseKty      = 1
+	coseAlg      = 3
+	coseCrv      = -1
+	coseX        = -2
+	coseY        = -3
+	coseKtyEC2   = 2
+	coseAlgES256 = -7
+	coseCrvP256  = 1
+)
+
+// oidAAGUID is the certificate extension of packed attestations naming the
+// authenticator model.
+var oidAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+// erc1271Magic is the isValidSignature(bytes32,bytes) result of a valid
+// contract signature.
+var erc1271Magic = []byte{0x16, 0x26, 0xba, 0x7e}
+
+// PasskeyChallenge is the WebAuthn challenge the next passkey registration
+// or assertion of an address must sign, base64url encoded.
+type PasskeyChallenge struct {
+	Challenge string `json:"challenge"`
+	RPID      string `json:"rpId"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// clientData is the part of the WebAuthn client data the service checks.
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+func passkeyRejected(message string) error {
+	return rpcerrors.RejectedByPaymaster(message, rpcerrors.REASON_PASSKEY_INVALID)
+}
+
+// decodeBase64URL decodes the base64url, padded or not, field of params.
+func decodeBase64URL(params map[string]any, field string) ([]byte, error) {
+	value, _ := params[field].(string)
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil || len(decoded) == 0 {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, fmt.Sprintf("invalid %s", field), nil)
+	}
+	return decoded, nil
+}
+
+func (s *Signer) passkeysEnabled() error {
+	if s.PasskeyRPID == "" {
+		return rpcerrors.NewRPCError(rpcerrors.INVALID_REQUEST, "passkeys are not enabled", nil)
+	}
+	return nil
+}
+
+// Pm_passkeyChallenge issues the challenge of the next passkey registration
+// or assertion of addr. A new challenge replaces the previous one.
+func (s *Signer) Pm_passkeyChallenge(ctx context.Context, addr string) (*PasskeyChallenge, error) {
+	if err := s.passkeysEnabled(); err != nil {
+		return nil, err
+	}
+	address, err := utils.NormalizeAddress(addr)
+	if err != nil {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, err.Error(), nil)
+	}
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	if err := s.States.Set(ctx, "passkey:"+address, challenge, s.PasskeyChallengeTTL); err != nil {
+		logger.S().Errorf("passkey challenge store error: %v", err)
+		return nil, err
+	}
+	return &PasskeyChallenge{
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		RPID:      s.PasskeyRPID,
+		ExpiresAt: time.Now().Add(s.PasskeyChallengeTTL).Unix(),
+	}, nil
+}
+
+// pendingChallenge returns the challenge issued to address, nil when none is
+// pending.
+func (s *Signer) pendingChallenge(ctx context.Context, address string) ([]byte, error) {
+	challenge, err := s.States.Get(ctx, "passkey:"+address)
+	if err != nil {
+		logger.S().Errorf("passkey challenge store error: %v", err)
+		return nil, err
+	}
+	if len(challenge) == 0 {
+		return nil, nil
+	}
+	return challenge, nil
+}
+
+// consumeChallenge forgets the challenge of address once a ceremony answering
+// it verified, so each challenge is answered once. It fails when challenge
+// was answered or replaced in between.
+func (s *Signer) consumeChallenge(ctx context.Context, address string, challenge []byte) error {
+	err := s.States.Update(ctx, "passkey:"+address, s.PasskeyChallengeTTL, func(value []byte) ([]byte, error) {
+		if !bytes.Equal(value, challenge) {
+			return nil, passkeyRejected("passkey challenge already answered")
+		}
+		return []byte{}, nil
+	})
+	if _, ok := err.(*rpcerrors.RPCError); err != nil && !ok {
+		logger.S().Errorf("passkey challenge store error: %v", err)
+	}
+	return err
+}
+
+// checkCeremony verifies the client data and authenticator data of a
+// WebAuthn ceremony of type against the pending challenge of address. It
+// returns the challenge, left pending until the caller verified the rest of
+// the ceremony, and the signature counter.
+func (s *Signer) checkCeremony(ctx context.Context, address, ceremony string, clientDataJSON, authData []byte) ([]byte, uint32, error) {
+	challenge, err := s.pendingChallenge(ctx, address)
+	if err != nil {
+		return nil, 0, err
+	}
+	if challenge == nil {
+		return nil, 0, passkeyRejected("no pending passkey challenge, call pm_passkeyChallenge")
+	}
+	var client clientData
+	if err := json.Unmarshal(clientDataJSON, &client); err != nil {
+		return nil, 0, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, "invalid clientDataJSON", nil)
+	}
+	if client.Type != ceremony {
+		return nil, 0, passkeyRejected(fmt.Sprintf("client data type %q, want %q", client.Type, ceremony))
+	}
+	if client.Challenge != base64.RawURLEncoding.EncodeToString(challenge) {
+		return nil, 0, passkeyRejected("passkey challenge mismatch")
+	}
+	if !s.passkeyOrigin(client.Origin) {
+		return nil, 0, passkeyRejected(fmt.Sprintf("origin %q not allowed", client.Origin))
+	}
+	// rpIdHash (32) | flags (1) | signCount (4) | ...
+	if len(authData) < 37 {
+		return nil, 0, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, "invalid authenticatorData", nil)
+	}
+	rpIDHash := sha256.Sum256([]byte(s.PasskeyRPID))
+	if !bytes.Equal(authData[:32], rpIDHash[:]) {
+		return nil, 0, passkeyRejected("passkey of another relying party")
+	}
+	if authData[32]&flagUserPresent == 0 {
+		return nil, 0, passkeyRejected("user not present")
+	}
+	return challenge, binary.BigEndian.Uint32(authData[33:37]), nil
+}
+
+// passkeyOrigin reports whether ceremonies run on origin are accepted,
+// PasskeyOrigins or the https origin of the relying party.
+func (s *Signer) passkeyOrigin(origin string) bool {
+	if len(s.PasskeyOrigins) == 0 {
+		return origin == "https://"+s.PasskeyRPID
+	}
+	for _, o := range s.PasskeyOrigins {
+		if o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// Pm_registerPasskey binds a passkey to addr. credential holds the
+// credential id, clientDataJSON and attestationObject of a registration
+// signing the challenge of pm_passkeyChallenge, base64url encoded, and the
+// ownerSignature of addr over the passkeyOwnerMessage, hex encoded. An
+// address keeps its first passkey until an operator resets it.
+func (s *Signer) Pm_registerPasskey(ctx context.Context, addr string, credential map[string]any) (bool, error) {
+	if err := s.passkeysEnabled(); err != nil {
+		return false, err
+	}
+	address, err := utils.NormalizeAddress(addr)
+	if err != nil {
+		return false, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, err.Error(), nil)
+	}
+	id, _ := credential["id"].(string)
+	id = strings.TrimRight(id, "=")
+	if id == "" {
+		return false, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, "invalid id", nil)
+	}
+	clientDataJSON, err := decodeBase64URL(credential, "clientDataJSON")
+	if err != nil {
+		return false, err
+	}
+	attestationObject, err := decodeBase64URL(credential, "attestationObject")
+	if err != nil {
+		return false, err
+	}
+	ownerSignature, _ := credential["ownerSignature"].(string)
+	signature, err := hexutil.Decode(ownerSignature)
+	if err != nil {
+		return false, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, "invalid ownerSignature", nil)
+	}
+	attestation, err := parseAttestation(attestationObject)
+	if err != nil {
+		return false, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, "invalid attestationObject", nil)
+	}
+
+	rep := s.Container.GetRepository()
+	existing, err := models.FindPasskey(rep, address)
+	if err != nil {
+		logger.S().Errorf("Query passkey error: %v", err)
+		return false, err
+	}
+	if existing != nil {
+		return false, passkeyRejected("address already has a passkey")
+	}
+	challenge, signCount, err := s.checkCeremony(ctx, address, "webauthn.create", clientDataJSON, attestation.authData)
+	if err != nil {
+		return false, err
+	}
+	credentialID, pub, err := attestedCredential(attestation.authData)
+	if err != nil {
+		return false, passkeyRejected(err.Error())
+	}
+	if base64.RawURLEncoding.EncodeToString(credentialID) != id {
+		return false, passkeyRejected("credential id does not match the attested credential")
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	if err := s.checkAttestation(attestation, clientDataHash[:]); err != nil {
+		return false, err
+	}
+	if err := s.checkOwner(ctx, address, passkeyOwnerMessage(s.PasskeyRPID, address, id, challenge), signature); err != nil {
+		return false, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return false, err
+	}
+	if err := s.consumeChallenge(ctx, address, challenge); err != nil {
+		return false, err
+	}
+	err = rep.Create(&models.Passkey{
+		Address:      address,
+		CredentialID: id,
+		PublicKey:    hex.EncodeToString(der),
+		SignCount:    signCount,
+	}).Error
+	if err != nil {
+		logger.S().Errorf("save passkey error: %v", err)
+		return false, err
+	}
+	return true, nil
+}
+
+// attestation is a decoded WebAuthn attestation object.
+type attestation struct {
+	format   string
+	stmt     map[any]any
+	authData []byte
+}
+
+func parseAttestation(data []byte) (*attestation, error) {
+	value, rest, err := decodeCBOR(data)
+	if err != nil {
+		return nil, err
+	}
+	object, ok := value.(map[any]any)
+	if !ok || len(rest) != 0 {
+		return nil, errCBOR
+	}
+	format, _ := object["fmt"].(string)
+	stmt, _ := object["attStmt"].(map[any]any)
+	authData, _ := object["authData"].([]byte)
+	if format == "" || stmt == nil || authData == nil {
+		return nil, errCBOR
+	}
+	return &attestation{format: format, stmt: stmt, authData: authData}, nil
+}
+
+// attestedCredential returns the credential id and the public key of the
+// attested credential data of authData.
+func attestedCredential(authData []byte) ([]byte, *ecdsa.PublicKey, error) {
+	// rpIdHash (32) | flags (1) | signCount (4) | aaguid (16) |
+	// credentialIdLength (2) | credentialId | credentialPublicKey | ...
+	if len(authData) < 55 || authData[32]&flagAttestedData == 0 {
+		return nil, nil, fmt.Errorf("no attested credential data")
+	}
+	n := int(binary.BigEndian.Uint16(authData[53:55]))
+	if len(authData) < 55+n {
+		return nil, nil, fmt.Errorf("invalid attested credential data")
+	}
+	value, _, err := decodeCBOR(authData[55+n:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid credential public key")
+	}
+	pub, err := coseKey(value)
+	if err != nil {
+		return nil, nil, err
+	}
+	return authData[55 : 55+n], pub, nil
+}
+
+// coseKey returns the P-256 key of an ES256 COSE key.
+func coseKey(value any) (*ecdsa.PublicKey, error) {
+	key, _ := value.(map[any]any)
+	x, _ := key[int64(coseX)].([]byte)
+	y, _ := key[int64(coseY)].([]byte)
+	if key[int64(coseKty)] != int64(coseKtyEC2) ||
+		key[int64(coseAlg)] != int64(coseAlgES256) ||
+		key[int64(coseCrv)] != int64(coseCrvP256) ||
+		len(x) != 32 || len(y) != 32 {
+		return nil, fmt.Errorf("credential public key must be an ES256 P-256 key")
+	}
+	pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, fmt.Errorf("credential public key is not on P-256")
+	}
+	return pub, nil
+}
+
+// checkAttestation applies the PasskeyAttestation policy to the attestation
+// of a registration. The packed policy needs a full packed attestation whose
+// certificate chains to PasskeyAttestationRoots, self attestation proves
+// nothing about the authenticator.
+func (s *Signer) checkAttestation(att *attestation, clientDataHash []byte) error {
+	if s.PasskeyAttestation != PasskeyAttestationPacked {
+		return nil
+	}
+	if att.format != "packed" {
+		return passkeyRejected(fmt.Sprintf("attestation format %q not accepted", att.format))
+	}
+	sig, _ := att.stmt["sig"].([]byte)
+	x5c, _ := att.stmt["x5c"].([]any)
+	if att.stmt["alg"] != int64(coseAlgES256) || len(sig) == 0 || len(x5c) == 0 {
+		return passkeyRejected("packed attestation with an ES256 certificate required")
+	}
+	certs := make([]*x509.Certificate, len(x5c))
+	for n, item := range x5c {
+		der, _ := item.([]byte)
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return passkeyRejected("invalid attestation certificate")
+		}
+		certs[n] = cert
+	}
+	leaf := certs[0]
+	signed := append(append([]byte{}, att.authData...), clientDataHash...)
+	if err := leaf.CheckSignature(x509.ECDSAWithSHA256, signed, sig); err != nil {
+		return passkeyRejected("invalid attestation signature")
+	}
+	if leaf.Version != 3 || leaf.IsCA || !containsString(leaf.Subject.OrganizationalUnit, "Authenticator Attestation") {
+		return passkeyRejected("invalid attestation certificate")
+	}
+	for _, ext := range leaf.Extensions {
+		if !ext.Id.Equal(oidAAGUID) {
+			continue
+		}
+		var aaguid []byte
+		if _, err := asn1.Unmarshal(ext.Value, &aaguid); err != nil || !bytes.Equal(aaguid, att.authData[37:53]) {
+			return passkeyRejected("attestation certificate of another authenticator")
+		}
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         s.PasskeyAttestationRoots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return passkeyRejected("attestation certificate not trusted")
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// passkeyOwnerMessage is the message address signs to bind the passkey
+// credentialID to itself, answering challenge.
+func passkeyOwnerMessage(rpID, address, credentialID string, challenge []byte) string {
+	return fmt.Sprintf("Register passkey %s for %s on %s\nChallenge: %s",
+		credentialID, address, rpID, base64.RawURLEncoding.EncodeToString(challenge))
+}
+
+// checkOwner verifies that address signed message, with its key for an
+// externally owned account or through ERC-1271 isValidSignature on the
+// default chain for a deployed smart account.
+func (s *Signer) checkOwner(ctx context.Context, address, message string, signature []byte) error {
+	hash := accounts.TextHash([]byte(message))
+	owner := common.HexToAddress(address)
+	if len(signature) == crypto.SignatureLength {
+		sig := common.CopyBytes(signature)
+		if sig[crypto.RecoveryIDOffset] >= 27 {
+			sig[crypto.RecoveryIDOffset] -= 27
+		}
+		if pub, err := crypto.SigToPub(hash, sig); err == nil && crypto.PubkeyToAddress(*pub) == owner {
+			return nil
+		}
+	}
+	code, err := s.Client.CodeAt(ctx, owner, nil)
+	if err != nil {
+		logger.S().Errorf("Query code of %s error: %v", address, err)
+		return err
+	}
+	if len(code) == 0 {
+		return passkeyRejected("ownerSignature not signed by the address")
+	}
+	args, err := isValidSignatureArgs.Pack(common.BytesToHash(hash), signature)
+	if err != nil {
+		return err
+	}
+	result, err := s.Client.CallContract(ctx, ethereum.CallMsg{
+		To:   &owner,
+		Data: append(append([]byte{}, erc1271Magic...), args...),
+	}, nil)
+	if err != nil || len(result) < 4 || !bytes.Equal(result[:4], erc1271Magic) {
+		return passkeyRejected("ownerSignature not accepted by the account")
+	}
+	return nil
+}
+
+// isValidSignatureArgs are the arguments of ERC-1271 isValidSignature, whose
+// selector is the magic value it returns.
+var isValidSignatureArgs = func() abi.Arguments {
+	bytes32, _ := abi.NewType("bytes32", "", nil)
+	bytes, _ := abi.NewType("bytes", "", nil)
+	return abi.Arguments{{Type: bytes32}, {Type: bytes}}
+}()
+
+// checkPasskey verifies the assertion of the passkey of address, which holds
+// the credential id, clientDataJSON, authenticatorData and signature of an
+// assertion of the challenge of pm_passkeyChallenge, base64url encoded.
+func (s *Signer) checkPasskey(ctx context.Context, address string, assertion map[string]any) error {
+	if err := s.passkeysEnabled(); err != nil {
+		return err
+	}
+	rep := s.Container.GetRepository()
+	passkey, err := models.FindPasskey(rep, address)
+	if err != nil {
+		logger.S().Errorf("Query passkey error: %v", err)
+		return err
+	}
+	if passkey == nil {
+		return rpcerrors.RejectedByPaymaster("register a passkey to claim gas", rpcerrors.REASON_PASSKEY_REQUIRED)
+	}
+	if assertion == nil {
+		return rpcerrors.RejectedByPaymaster("passkey assertion required", rpcerrors.REASON_PASSKEY_REQUIRED)
+	}
+	if id, _ := assertion["id"].(string); strings.TrimRight(id, "=") != passkey.CredentialID {
+		return passkeyRejected("unknown passkey")
+	}
+	clientDataJSON, err := decodeBase64URL(assertion, "clientDataJSON")
+	if err != nil {
+		return err
+	}
+	authData, err := decodeBase64URL(assertion, "authenticatorData")
+	if err != nil {
+		return err
+	}
+	signature, err := decodeBase64URL(assertion, "signature")
+	if err != nil {
+		return err
+	}
+	challenge, signCount, err := s.checkCeremony(ctx, address, "webauthn.get", clientDataJSON, authData)
+	if err != nil {
+		return err
+	}
+
+	der, err := hex.DecodeString(passkey.PublicKey)
+	if err != nil {
+		return err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return err
+	}
+	pub, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("passkey of %s is not an ecdsa key", address)
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	if !ecdsa.VerifyASN1(pub, digest[:], signature) {
+		return passkeyRejected("invalid passkey signature")
+	}
+	if err := s.consumeChallenge(ctx, address, challenge); err != nil {
+		return err
+	}
+
+	// a counter that does not grow reveals a cloned authenticator
+	if signCount == 0 && passkey.SignCount == 0 {
+		return nil
+	}
+	res := rep.Model(&models.Passkey{}).
+		Where(`"id" = ? AND "sign_count" < ?`, passkey.ID, signCount).
+		Update("sign_count", signCount)
+	if res.Error != nil {
+		logger.S().Errorf("update passkey counter error: %v", res.Error)
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return passkeyRejected("passkey signature counter did not increase")
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// checkPaused rejects the operation while sponsorships are paused globally
+// or for the api key of ctx, or during a maintenance window.
+func (s *Signer) checkPaused(ctx context.Context) error {
+	now := time.Now()
+	window, err := models.ActiveMaintenance(s.Container.GetRepository(), now)
+	if err != nil {
+		logger.S().Errorf("Query maintenance window error: %v", err)
+		return err
+	}
+	if window != nil {
+		retryAfter := int64((window.End.Sub(now) + time.Second - 1) / time.Second)
+		return rpcerrors.NewRPCError(
+			rpcerrors.SPONSORSHIP_PAUSED,
+			fmt.Sprintf("scheduled maintenance, retry in %ds", retryAfter),
+			map[string]any{"reason": rpcerrors.REASON_MAINTENANCE, "retryAfter": retryAfter, "until": window.End.Unix()},
+		)
+	}
+
+	var keyID uint
+	if key := ApiKeyFromContext(ctx); key != nil {
+		keyID = key.ID
+	}
+	pause, err := models.FindPause(s.Container.GetRepository(), keyID)
+	if err != nil {
+		logger.S().Errorf("Query sponsorship pause error: %v", err)
+		return err
+	}
+	if pause == nil {
+		return nil
+	}
+	scope := "api_key"
+	if pause.ApiKeyID == models.PauseAll {
+		scope = "global"
+	}
+	return rpcerrors.NewRPCError(
+		rpcerrors.SPONSORSHIP_PAUSED,
+		"sponsorship paused for maintenance",
+		map[string]any{"reason": rpcerrors.REASON_SPONSORSHIP_PAUSED, "scope": scope},
+	)
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/ququzone/verifying-paymaster-service/contracts"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/policy"
+	"github.com/ququzone/verifying-paymaster-service/utils"
+)
+
+// dedicatedChain identifies a dedicated paymaster contract on a chain.
+type dedicatedChain struct {
+	chainID  uint64
+	contract common.Address
+}
+
+// dedicatedChains keeps the chain contexts of the dedicated paymasters.
+type dedicatedChains struct {
+	mu     sync.Mutex
+	chains map[dedicatedChain]*ChainContext
+}
+
+// keyChain returns chain with the dedicated paymaster of the api key in ctx,
+// chain itself for keys sponsored from the shared paymaster.
+func (s *Signer) keyChain(ctx context.Context, chain *ChainContext) (*ChainContext, error) {
+	key := ApiKeyFromContext(ctx)
+	if key == nil {
+		return chain, nil
+	}
+	rec, err := models.FindKeyPaymaster(s.Container.GetRepository(), key.ID, chain.ChainID.Uint64())
+	if nil != err {
+		logger.S().Errorf("Query key paymaster error: %v", err)
+		return nil, err
+	}
+	if rec == nil {
+		return chain, nil
+	}
+	return s.dedicatedChain(chain, common.HexToAddress(rec.Contract))
+}
+
+// dedicatedChain returns chain signing for contract instead of the shared
+// paymaster. The sender quotas and signed totals of the contract are kept
+// apart from the shared ones, the rules applied to them stay the same.
+func (s *Signer) dedicatedChain(chain *ChainContext, contract common.Address) (*ChainContext, error) {
+	if contract == chain.Contract {
+		return chain, nil
+	}
+	s.dedicated.mu.Lock()
+	defer s.dedicated.mu.Unlock()
+	id := dedicatedChain{chainID: chain.ChainID.Uint64(), contract: contract}
+	if dedicated, ok := s.dedicated.chains[id]; ok {
+		return dedicated, nil
+	}
+	paymaster, err := contracts.NewVerifyingPaymaster(contract, chain.Client)
+	if err != nil {
+		return nil, err
+	}
+	dedicated := *chain
+	dedicated.Contract = contract
+	dedicated.Paymaster = paymaster
+	dedicated.QuotaPaymaster = utils.LowerAddress(contract)
+	dedicated.SignRate = chain.SignRate.ForPaymaster(dedicated.QuotaPaymaster)
+	dedicated.Checks = make([]policy.Checker, len(chain.Checks))
+	for n, check := range chain.Checks {
+		if check == policy.Checker(chain.SignRate) {
+			check = dedicated.SignRate
+		}
+		dedicated.Checks[n] = check
+	}
+	if s.dedicated.chains == nil {
+		s.dedicated.chains = make(map[dedicatedChain]*ChainContext)
+	}
+	s.dedicated.chains[id] = &dedicated
+	return &dedicated, nil
+}
+
+// accounts returns the sender accounts charged on chain.
+func (s *Signer) accounts(chain *ChainContext) models.AccountRepository {
+	return s.Container.GetAccounts().ForPaymaster(chain.QuotaPaymaster)
+}
+
+// keyAccounts returns the sender accounts the api key in ctx sponsors from
+// on the default chain, for the quota granted outside of a sponsorship.
+func (s *Signer) keyAccounts(ctx context.Context) (models.AccountRepository, error) {
+	chain, err := s.keyChain(ctx, s.ChainContext)
+	if err != nil {
+		return nil, err
+	}
+	return s.accounts(chain), nil
+}
+
+// sponsorshipPaymaster returns the contract rec was signed for.
+func sponsorshipPaymaster(chain *ChainContext, rec *models.Sponsorship) string {
+	if rec.Paymaster != "" {
+		return common.HexToAddress(rec.Paymaster).Hex()
+	}
+	return chain.Contract.Hex()
+}
@@ -0,0 +1,236 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/types"
+	"github.com/ququzone/verifying-paymaster-service/utils"
+)
+
+// Quote is the pm_quote result. Presenting Token in the sponsorship context
+// before ExpiresAt signs the quoted gas limits and charges at most
+// QuotaCost.
+type Quote struct {
+	Token                string   `json:"token"`
+	ExpiresAt            int64    `json:"expiresAt"`
+	Paymaster            string   `json:"paymaster"`
+	MaxGasCost           string   `json:"maxGasCost"`
+	QuotaCost            string   `json:"quotaCost"`
+	QuotaUnit            string   `json:"quotaUnit,omitempty"`
+	SurchargePercent     uint64   `json:"surchargePercent,omitempty"`
+	PreVerificationGas   string   `json:"preVerificationGas"`
+	VerificationGasLimit string   `json:"verificationGasLimit"`
+	CallGasLimit         string   `json:"callGasLimit"`
+	Policies             []string `json:"policies"`
+}
+
+// quoteTerms are the terms a quote token guarantees, bound to the operation
+// it was computed for. Gas values are decimal strings.
+type quoteTerms struct {
+	ApiKeyID             uint   `json:"apiKeyId"`
+	ChainID              uint64 `json:"chainId"`
+	Paymaster            string `json:"paymaster"`
+	Sender               string `json:"sender"`
+	Nonce                string `json:"nonce"`
+	CodeHash             string `json:"codeHash"`
+	MaxFeePerGas         string `json:"maxFeePerGas"`
+	PreVerificationGas   string `json:"preVerificationGas"`
+	VerificationGasLimit string `json:"verificationGasLimit"`
+	CallGasLimit         string `json:"callGasLimit"`
+	MaxGasCost           string `json:"maxGasCost"`
+	Quota                string `json:"quota"`
+	ExpiresAt            int64  `json:"expiresAt"`
+}
+
+// Pm_quote evaluates op like pm_checkSponsorship and returns its cost, the
+// policy rules of the api key that apply and a token guaranteeing those
+// terms to a sponsorship of the same operation for QuoteTTL.
+func (s *Signer) Pm_quote(ctx context.Context, op json.RawMessage, entryPoint string, sponsorContext map[string]any) (*Quote, error) {
+	chain, err := s.sponsorChain(entryPoint, sponsorContext)
+	if err != nil {
+		return nil, err
+	}
+	sp, _, err := s.evaluate(ctx, chain, op, sponsorContext, false)
+	if sp != nil {
+		defer sp.release()
+	}
+	if err != nil {
+		return nil, err
+	}
+	terms := &quoteTerms{
+		ApiKeyID:             sp.apiKeyID,
+		ChainID:              sp.chain.ChainID.Uint64(),
+		Paymaster:            utils.LowerAddress(sp.chain.Contract),
+		Sender:               sp.sender,
+		Nonce:                sp.op.Nonce.String(),
+		CodeHash:             codeHash(sp.op),
+		MaxFeePerGas:         sp.op.MaxFeePerGas.String(),
+		PreVerificationGas:   sp.preVerificationGas.String(),
+		VerificationGasLimit: sp.verificationGas.String(),
+		CallGasLimit:         sp.callGas.String(),
+		MaxGasCost:           sp.totalGas.String(),
+		Quota:                sp.quota.String(),
+		ExpiresAt:            time.Now().Add(s.QuoteTTL).Unix(),
+	}
+	token, err := s.signQuote(terms)
+	if err != nil {
+		return nil, err
+	}
+	policies := policyRules(sp.policy)
+	if sp.session != nil {
+		policies = append(policies, "session")
+	}
+	return &Quote{
+		Token:                token,
+		ExpiresAt:            terms.ExpiresAt,
+		Paymaster:            sp.chain.Contract.Hex(),
+		MaxGasCost:           terms.MaxGasCost,
+		QuotaCost:            terms.Quota,
+		QuotaUnit:            s.QuotaUnit,
+		SurchargePercent:     sp.surcharge,
+		PreVerificationGas:   hexutil.EncodeBig(sp.preVerificationGas),
+		VerificationGasLimit: hexutil.EncodeBig(sp.verificationGas),
+		CallGasLimit:         hexutil.EncodeBig(sp.callGas),
+		Policies:             policies,
+	}, nil
+}
+
+// policyRules names the rules of p that operations are checked against.
+func policyRules(p *models.Policy) []string {
+	rules := []string{}
+	if p == nil {
+		return rules
+	}
+	if p.Expression != "" {
+		rules = append(rules, "expression")
+	}
+	if p.WebhookURL != "" {
+		rules = append(rules, "webhook")
+	}
+	if len(p.Targets) > 0 {
+		rules = append(rules, "targets")
+	}
+	if len(p.GasCaps) > 0 {
+		rules = append(rules, "gas_caps")
+	}
+	if p.MaxValuePerOp != "" || p.MaxValuePerDay != "" {
+		rules = append(rules, "value_limit")
+	}
+	if len(p.Windows) > 0 {
+		rules = append(rules, "windows")
+	}
+	if p.HoldExpression != "" || p.HoldAbove != "" {
+		rules = append(rules, "hold")
+	}
+	if p.OverdraftPercent > 0 {
+		rules = append(rules, "overdraft")
+	}
+	if p.AutoRefresh {
+		rules = append(rules, "auto_refresh")
+	}
+	if p.MaxValidity > 0 {
+		rules = append(rules, "max_validity")
+	}
+	return rules
+}
+
+// codeHash binds a quote to the initCode and callData of op.
+func codeHash(op *types.UserOperation) string {
+	return crypto.Keccak256Hash(op.InitCode, op.CallData).Hex()
+}
+
+// signQuote encodes terms as a token: the base64url JSON terms and the
+// signature of their keccak256 by the paymaster signer, dot separated.
+func (s *Signer) signQuote(terms *quoteTerms) (string, error) {
+	payload, err := json.Marshal(terms)
+	if err != nil {
+		return "", err
+	}
+	signature, err := crypto.Sign(crypto.Keccak256(payload), s.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// quoteTerms returns the terms of the quote token in sponsorContext, nil
+// without one. Tokens not signed by this service, expired or issued for
+// another operation are rejected.
+func (s *Signer) quoteTerms(ctx context.Context, chain *ChainContext, op *types.UserOperation, sponsorContext map[string]any, now time.Time) (*quoteTerms, error) {
+	token, _ := sponsorContext["quote"].(string)
+	if token == "" {
+		return nil, nil
+	}
+	invalid := func(message string) error {
+		return rpcerrors.RejectedByPaymaster(message, rpcerrors.REASON_QUOTE_INVALID)
+	}
+	encodedPayload, encodedSignature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, invalid("malformed quote")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, invalid("malformed quote")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return nil, invalid("malformed quote")
+	}
+	pub, err := crypto.SigToPub(crypto.Keccak256(payload), signature)
+	if err != nil || !bytes.Equal(crypto.FromECDSAPub(pub), crypto.FromECDSAPub(&s.PrivateKey.PublicKey)) {
+		return nil, invalid("quote not signed by this paymaster")
+	}
+	var terms quoteTerms
+	if err := json.Unmarshal(payload, &terms); err != nil {
+		return nil, invalid("malformed quote")
+	}
+	if now.Unix() >= terms.ExpiresAt {
+		return nil, invalid("quote expired")
+	}
+	var apiKeyID uint
+	if key := ApiKeyFromContext(ctx); key != nil {
+		apiKeyID = key.ID
+	}
+	maxFeePerGas, _ := new(big.Int).SetString(terms.MaxFeePerGas, 10)
+	if terms.ApiKeyID != apiKeyID ||
+		terms.ChainID != chain.ChainID.Uint64() ||
+		terms.Paymaster != utils.LowerAddress(chain.Contract) ||
+		terms.Sender != utils.LowerAddress(op.Sender) ||
+		terms.Nonce != op.Nonce.String() ||
+		terms.CodeHash != codeHash(op) {
+		return nil, invalid("quote issued for another operation")
+	}
+	if maxFeePerGas == nil || op.MaxFeePerGas.Cmp(maxFeePerGas) > 0 {
+		return nil, invalid("maxFeePerGas above the quote")
+	}
+	return &terms, nil
+}
+
+// gasLimits returns the quoted preVerificationGas, verificationGasLimit and
+// callGasLimit.
+func (t *quoteTerms) gasLimits() (*big.Int, *big.Int, *big.Int) {
+	return models.ParseGas(t.PreVerificationGas), models.ParseGas(t.VerificationGasLimit), models.ParseGas(t.CallGasLimit)
+}
+
+// apply holds sp to the quoted terms: the operation may not cost more than
+// quoted and is charged at most the quoted quota.
+func (t *quoteTerms) apply(sp *sponsorship) error {
+	if sp.totalGas.Cmp(models.ParseGas(t.MaxGasCost)) > 0 {
+		return rpcerrors.RejectedByPaymaster("operation costs more than quoted", rpcerrors.REASON_QUOTE_INVALID)
+	}
+	if quota := models.ParseGas(t.Quota); sp.quota.Cmp(quota) > 0 {
+		sp.quota = quota
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// userOperationEventTopic is the topic0 of EntryPoint.UserOperationEvent.
+var userOperationEventTopic = entryPointABI.Events["UserOperationEvent"].ID
+
+// LogProof locates the UserOperationEvent log of a sponsored operation. The
+// receipts root lets a verifier check a receipt trie proof for the log
+// against the block header.
+type LogProof struct {
+	BlockNumber     uint64 `json:"blockNumber"`
+	BlockHash       string `json:"blockHash"`
+	ReceiptsRoot    string `json:"receiptsRoot"`
+	TransactionHash string `json:"transactionHash"`
+	LogIndex        uint   `json:"logIndex"`
+	Address         string `json:"address"`
+	Topic           string `json:"topic"`
+}
+
+type SponsorshipReceipt struct {
+	UserOpHash       string    `json:"userOpHash"`
+	Sender           string    `json:"sender"`
+	Nonce            string    `json:"nonce"`
+	Status           string    `json:"status"`
+	Paymaster        string    `json:"paymaster"`
+	PaymasterAndData string    `json:"paymasterAndData"`
+	ValidUntil       int64     `json:"validUntil"`
+	MaxGasCost       string    `json:"maxGasCost"`
+	TransactionHash  string    `json:"transactionHash,omitempty"`
+	ActualGasCost    string    `json:"actualGasCost,omitempty"`
+	Proof            *LogProof `json:"proof,omitempty"`
+}
+
+// Pm_sponsorshipReceipt returns the signed sponsorship of an operation and,
+// once it is included, a reference to the log proving the gas was paid.
+func (s *Signer) Pm_sponsorshipReceipt(userOpHash string) (*SponsorshipReceipt, error) {
+	rec, err := (&models.Sponsorship{}).FindByUserOpHash(s.Container.GetRepository(), common.HexToHash(userOpHash).Hex())
+	if nil != err {
+		logger.S().Errorf("Query sponsorship error: %v", err)
+		return nil, err
+	}
+	if rec == nil {
+		return nil, nil
+	}
+
+	chain := s.sponsorshipChain(rec)
+	receipt := &SponsorshipReceipt{
+		UserOpHash:       rec.UserOpHash,
+		Sender:           rec.Sender,
+		Nonce:            rec.Nonce,
+		Status:           rec.CurrentStatus(time.Now()),
+		Paymaster:        sponsorshipPaymaster(chain, rec),
+		PaymasterAndData: rec.PaymasterAndData,
+		ValidUntil:       rec.ValidUntil.Unix(),
+		MaxGasCost:       rec.MaxGasCost,
+		TransactionHash:  rec.TxHash,
+		ActualGasCost:    rec.ActualGasCost,
+	}
+	if rec.Status != models.SponsorshipIncluded && rec.Status != models.SponsorshipReverted {
+		return receipt, nil
+	}
+
+	header, err := chain.Client.HeaderByNumber(context.Background(), new(big.Int).SetUint64(rec.BlockNumber))
+	if err != nil {
+		logger.S().Errorf("Query block header error: %v", err)
+		return nil, err
+	}
+	if header.Hash().Hex() != rec.BlockHash {
+		// the indexer has not processed a reorg of this block yet
+		receipt.Status = models.SponsorshipPending
+		receipt.TransactionHash = ""
+		receipt.ActualGasCost = ""
+		return receipt, nil
+	}
+	receipt.Proof = &LogProof{
+		BlockNumber:     rec.BlockNumber,
+		BlockHash:       rec.BlockHash,
+		ReceiptsRoot:    header.ReceiptHash.Hex(),
+		TransactionHash: rec.TxHash,
+		LogIndex:        rec.LogIndex,
+		Address:         chain.EntryPoint.Hex(),
+		Topic:           userOperationEventTopic.Hex(),
+	}
+	return receipt, nil
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// refreshWindow is how often an account can refresh its quota.
+const refreshWindow = 24 * time.Hour
+
+// vipOf returns the VIP NFT held by address, -1 without one.
+func (s *Signer) vipOf(address string) int64 {
+	index, err := s.VipContract.TokenOfOwnerByIndex(nil, common.HexToAddress(address), big.NewInt(0))
+	if err != nil {
+		return -1
+	}
+	return index.Int64()
+}
+
+// refreshGas returns the quota of a refresh by the holder of the VIP NFT
+// vip, -1 without one. A VIP NFT refreshes one account per window.
+func (s *Signer) refreshGas(tx models.AccountRepository, vip int64) (*big.Int, error) {
+	if vip == -1 {
+		return s.MaxGas, nil
+	}
+	last, err := tx.FindByVipID(vip)
+	if nil != err {
+		logger.S().Errorf("Query account by vip id error: %v", err)
+		return nil, err
+	}
+	if last != nil && last.LastRequest.Add(refreshWindow).After(time.Now()) {
+		return nil, tooFrequent(last.LastRequest.Add(refreshWindow))
+	}
+	return s.MaxVipGas, nil
+}
+
+// tooFrequent rejects a gas request before the refresh window ending at next
+// is over.
+func tooFrequent(next time.Time) error {
+	retryAfter := int64((time.Until(next) + time.Second - 1) / time.Second)
+	return rpcerrors.NewRPCError(
+		rpcerrors.RATE_LIMITED,
+		fmt.Sprintf("gas requested too frequently, retry in %ds", retryAfter),
+		map[string]any{"reason": rpcerrors.REASON_REQUEST_TOO_FREQUENT, "retryAfter": retryAfter},
+	)
+}
+
+// refresh resets the quota of account to gas, less a previous overdraft or
+// plus the unused quota the policy p rolls over.
+func refresh(p *models.Policy, account *models.Account, gas *big.Int, vip int64, now time.Time) {
+	account.RemainGas = refreshedGas(p, account, gas, vip).String()
+	account.LastRequest = now
+	account.VipID = vip
+}
+
+func refreshedGas(p *models.Policy, account *models.Account, gas *big.Int, vip int64) *big.Int {
+	remain := models.ParseGas(account.RemainGas)
+	if remain.Sign() < 0 {
+		return new(big.Int).Add(gas, remain)
+	}
+	if p == nil {
+		return gas
+	}
+	limit := p.RolloverCap
+	if vip != -1 {
+		limit = p.RolloverVipCap
+	}
+	rollover := models.ParseGas(limit)
+	if remain.Cmp(rollover) < 0 {
+		rollover = remain
+	}
+	return new(big.Int).Add(gas, rollover)
+}
+
+// autoRefreshDue reports whether the policy p refreshes the quota of account
+// in the sponsorship path: its refresh window elapsed less than the refresh
+// grace period ago.
+func (s *Signer) autoRefreshDue(p *models.Policy, account *models.Account, now time.Time) bool {
+	if p == nil || !p.AutoRefresh || !account.Enable {
+		return false
+	}
+	due := account.LastRequest.Add(refreshWindow)
+	return !due.After(now) && now.Sub(due) <= s.RefreshGrace
+}
+
+// autoRefreshedGas is the remaining quota of account after an auto refresh.
+func (s *Signer) autoRefreshedGas(p *models.Policy, account *models.Account) (*big.Int, error) {
+	gas := s.MaxGas
+	if account.VipID != -1 {
+		gas = s.MaxVipGas
+	}
+	gas, err := s.identityGas(p, account.Address, gas, account.VipID)
+	if err != nil {
+		return nil, err
+	}
+	return refreshedGas(p, account, gas, account.VipID), nil
+}
+
+// autoRefresh refreshes the quota of address in accounts like pm_requestGas
+// unless it was refreshed in the meantime.
+func (s *Signer) autoRefresh(accounts models.AccountRepository, p *models.Policy, address string) error {
+	vip := s.vipOf(address)
+	return accounts.Transaction(func(tx models.AccountRepository) error {
+		now := time.Now()
+		account, err := tx.FindForUpdate(address)
+		if err != nil || account == nil || !s.autoRefreshDue(p, account, now) {
+			return err
+		}
+		gas, err := s.refreshGas(tx, vip)
+		if err != nil {
+			return err
+		}
+		if gas, err = s.identityGas(p, address, gas, vip); err != nil {
+			return err
+		}
+		logger.S().Debugf("Auto refreshed quota of %s", address)
+		refresh(p, account, gas, vip, now)
+		return tx.Save(account)
+	})
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+)
+
+// Headers of the sponsorship requests of api keys with replay protection:
+// the unix time the request was made at and a nonce never used before by
+// the key.
+const (
+	TimestampHeader = "X-Request-Timestamp"
+	NonceHeader     = "X-Request-Nonce"
+)
+
+const maxNonceLength = 64
+
+func replayRejected(message string) error {
+	return rpcerrors.RejectedByPaymaster(message, rpcerrors.REASON_REPLAYED_REQUEST)
+}
+
+// checkReplay rejects the sponsorship requests of keys with replay
+// protection whose timestamp is more than ReplayWindow from now or whose
+// nonce the key already used. Nonces are remembered until their timestamp
+// leaves the window, in the store shared by the replicas.
+func (s *Signer) checkReplay(ctx context.Context, now time.Time) error {
+	key := ApiKeyFromContext(ctx)
+	if key == nil || !key.ReplayProtection {
+		return nil
+	}
+	header := RequestHeaderFromContext(ctx)
+	timestamp, err := strconv.ParseInt(header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return replayRejected(fmt.Sprintf("missing or invalid %s header", TimestampHeader))
+	}
+	if skew := now.Sub(time.Unix(timestamp, 0)); skew > s.ReplayWindow || skew < -s.ReplayWindow {
+		return replayRejected(fmt.Sprintf("request timestamp more than %s from server time", s.ReplayWindow))
+	}
+	nonce := header.Get(NonceHeader)
+	if nonce == "" || len(nonce) > maxNonceLength {
+		return replayRejected(fmt.Sprintf("missing or invalid %s header", NonceHeader))
+	}
+
+	used := false
+	err = s.States.Update(ctx, fmt.Sprintf("replay:%d:%s", key.ID, nonce), 2*s.ReplayWindow, func(value []byte) ([]byte, error) {
+		used = value != nil
+		return []byte(strconv.FormatInt(timestamp, 10)), nil
+	})
+	if err != nil {
+		logger.S().Errorf("replay nonce store error: %v", err)
+		return err
+	}
+	if used {
+		return replayRejected("request nonce already used")
+	}
+	return nil
+}
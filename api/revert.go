@@ -0,0 +1,105 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"github.com/ququzone/verifying-paymaster-service/contracts"
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+)
+
+var entryPointABI, _ = abi.JSON(strings.NewReader(contracts.EntryPointABI))
+
+// RevertReason is the decoded form of a contract revert, returned in the
+// data field of JSON-RPC errors.
+type RevertReason struct {
+	Error   string         `json:"error"`
+	Reason  string         `json:"reason,omitempty"`
+	OpIndex *int           `json:"opIndex,omitempty"`
+	Args    map[string]any `json:"args,omitempty"`
+	Data    string         `json:"data"`
+}
+
+// revertData extracts the hex encoded revert data carried by err.
+func revertData(err error) ([]byte, bool) {
+	dataErr, ok := err.(rpc.DataError)
+	if !ok {
+		return nil, false
+	}
+	data, ok := dataErr.ErrorData().(string)
+	if !ok {
+		return nil, false
+	}
+	decoded, err := hexutil.Decode(data)
+	if err != nil || len(decoded) < 4 {
+		return nil, false
+	}
+	return decoded, true
+}
+
+// DecodeRevert decodes EntryPoint custom errors and Error(string) reverts.
+func DecodeRevert(data []byte) (*RevertReason, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("revert data too short: %d bytes", len(data))
+	}
+	result := &RevertReason{Data: hexutil.Encode(data)}
+
+	if reason, err := abi.UnpackRevert(data); err == nil {
+		result.Error = "Error"
+		result.Reason = reason
+		return result, nil
+	}
+
+	for name, abiErr := range entryPointABI.Errors {
+		if !bytes.Equal(abiErr.ID[:4], data[:4]) {
+			continue
+		}
+		values, err := abiErr.Inputs.Unpack(data[4:])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		result.Error = name
+		result.Args = make(map[string]any, len(values))
+		for i, value := range values {
+			result.Args[abiErr.Inputs[i].Name] = value
+		}
+		if name == "FailedOp" {
+			opIndex := int(values[0].(*big.Int).Int64())
+			result.OpIndex = &opIndex
+			result.Reason = values[1].(string)
+			result.Args = nil
+		}
+		return result, nil
+	}
+
+	return nil, fmt.Errorf("unknown revert selector %s", hexutil.Encode(data[:4]))
+}
+
+// revertRPCError converts a reverted call into an RPCError carrying the
+// decoded reason. Errors without decodable revert data are returned as is.
+func revertRPCError(err error) error {
+	data, ok := revertData(err)
+	if !ok {
+		return err
+	}
+	reason, decodeErr := DecodeRevert(data)
+	if decodeErr != nil {
+		return rpcerrors.NewRPCError(rpcerrors.REJECTED_BY_TYPE, err.Error(), &RevertReason{Data: hexutil.Encode(data)})
+	}
+	switch reason.Error {
+	case "FailedOp":
+		return rpcerrors.NewRPCError(rpcerrors.FailedOpCode(reason.Reason), reason.Reason, reason)
+	case "SignatureValidationFailed":
+		return rpcerrors.NewRPCError(rpcerrors.INVALID_SIGNATURE, "signature validation failed", reason)
+	case "Error":
+		return rpcerrors.NewRPCError(rpcerrors.REJECTED_BY_TYPE, reason.Reason, reason)
+	default:
+		return rpcerrors.NewRPCError(rpcerrors.REJECTED_BY_TYPE, reason.Error, reason)
+	}
+}
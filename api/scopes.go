@@ -0,0 +1,47 @@
+package api
+
+import "github.com/ququzone/verifying-paymaster-service/models"
+
+// methodScopes is the api key scope needed to call each method.
+var methodScopes = map[string]string{
+	"pm_sponsorUserOperation": models.ScopeSponsor,
+	"pm_getPaymasterData":     models.ScopeSponsor,
+	"pm_createSession":        models.ScopeSponsor,
+	"pm_claimCredits":         models.ScopeSponsor,
+	"pm_appealScreening":      models.ScopeSponsor,
+	"eth_sendUserOperation":   models.ScopeSponsor,
+
+	"pm_requestGas":       models.ScopeRequestGas,
+	"pm_allocateQuota":    models.ScopeRequestGas,
+	"pm_passkeyChallenge": models.ScopeRequestGas,
+	"pm_registerPasskey":  models.ScopeRequestGas,
+	"pm_linkIdentity":     models.ScopeRequestGas,
+
+	"pm_config":                    models.ScopeReadOnly,
+	"pm_paymasterStatus":           models.ScopeReadOnly,
+	"eth_supportedEntryPoints":     models.ScopeReadOnly,
+	"pm_gasRemain":                 models.ScopeReadOnly,
+	"pm_getSession":                models.ScopeReadOnly,
+	"pm_getUserOperationStatus":    models.ScopeReadOnly,
+	"pm_sponsorshipReceipt":        models.ScopeReadOnly,
+	"pm_checkSponsorship":          models.ScopeReadOnly,
+	"pm_quote":                     models.ScopeReadOnly,
+	"pm_getPaymasterStubData":      models.ScopeReadOnly,
+	"eth_estimateUserOperationGas": models.ScopeReadOnly,
+	"eth_getUserOperationReceipt":  models.ScopeReadOnly,
+
+	"pm_usageStats": models.ScopeAdminReport,
+
+	"pm_createWebhook":       models.ScopeWebhooks,
+	"pm_listWebhooks":        models.ScopeWebhooks,
+	"pm_deleteWebhook":       models.ScopeWebhooks,
+	"pm_rotateWebhookSecret": models.ScopeWebhooks,
+	"pm_testWebhook":         models.ScopeWebhooks,
+	"pm_webhookDeliveries":   models.ScopeWebhooks,
+}
+
+// MethodScope returns the scope needed to call method, empty for methods
+// only keys without scopes may call.
+func MethodScope(method string) string {
+	return methodScopes[method]
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/utils"
+)
+
+type ScreeningAppealResult struct {
+	ID        uint   `json:"id"`
+	Status    string `json:"status"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+// Pm_appealScreening asks operators to review the screening decision
+// blocking address. An address has one pending appeal at a time, appealing
+// again returns it.
+func (s *Signer) Pm_appealScreening(ctx context.Context, addr string, note string) (*ScreeningAppealResult, error) {
+	apiKey := ApiKeyFromContext(ctx)
+	if apiKey == nil {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_API_KEY, "api key required", map[string]any{"reason": rpcerrors.REASON_API_KEY_REQUIRED})
+	}
+	address, err := utils.NormalizeAddress(addr)
+	if err != nil {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, err.Error(), nil)
+	}
+	if len(note) > 2000 {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, "note longer than 2000 characters", nil)
+	}
+	rep := s.Container.GetRepository()
+	decision, err := models.FindScreening(rep, address)
+	if err != nil {
+		logger.S().Errorf("Query screening decision error: %v", err)
+		return nil, err
+	}
+	if decision == nil || decision.Allowed || !decision.Valid(time.Now()) {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, "address is not blocked by screening", nil)
+	}
+	appeal, err := models.PendingAppeal(rep, address)
+	if err != nil {
+		logger.S().Errorf("Query screening appeal error: %v", err)
+		return nil, err
+	}
+	if appeal == nil {
+		appeal = &models.ScreeningAppeal{
+			Address:  address,
+			ApiKeyID: apiKey.ID,
+			Decision: decision.Reason,
+			Note:     note,
+			Status:   models.AppealPending,
+		}
+		if err := rep.Create(appeal).Error; err != nil {
+			logger.S().Errorf("save screening appeal error: %v", err)
+			return nil, err
+		}
+		logger.S().Infof("Screening appeal %d for %s by api key %d", appeal.ID, address, apiKey.ID)
+	}
+	return &ScreeningAppealResult{ID: appeal.ID, Status: appeal.Status, CreatedAt: appeal.CreatedAt.Unix()}, nil
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/policy"
+	"github.com/ququzone/verifying-paymaster-service/utils"
+)
+
+type SessionInfo struct {
+	SessionID  string   `json:"sessionId"`
+	Sender     string   `json:"sender"`
+	Targets    []string `json:"targets"`
+	ValidUntil int64    `json:"validUntil"`
+	MaxGas     string   `json:"maxGas"`
+	UsedGas    string   `json:"usedGas"`
+}
+
+func newSessionInfo(session *models.Session) *SessionInfo {
+	targets := session.TargetList()
+	if targets == nil {
+		targets = []string{}
+	}
+	return &SessionInfo{
+		SessionID:  session.Key,
+		Sender:     session.Sender,
+		Targets:    targets,
+		ValidUntil: session.ExpiresAt.Unix(),
+		MaxGas:     session.MaxGas,
+		UsedGas:    session.UsedGas,
+	}
+}
+
+// Pm_createSession registers a session for a sender with its own gas quota.
+// params holds sender, validUntil (unix seconds), maxGas (wei) and the
+// optional targets the session may call.
+func (s *Signer) Pm_createSession(ctx context.Context, params map[string]any) (*SessionInfo, error) {
+	apiKey := ApiKeyFromContext(ctx)
+	if apiKey == nil {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_API_KEY, "api key required", map[string]any{"reason": rpcerrors.REASON_API_KEY_REQUIRED})
+	}
+	senderParam, _ := params["sender"].(string)
+	sender, err := utils.NormalizeAddress(senderParam)
+	if err != nil {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, "invalid sender: "+err.Error(), nil)
+	}
+	validUntil, _ := params["validUntil"].(float64)
+	expiresAt := time.Unix(int64(validUntil), 0)
+	now := time.Now()
+	if !expiresAt.After(now) || expiresAt.Sub(now) > s.MaxSessionDuration {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, fmt.Sprintf("validUntil must be within %s", s.MaxSessionDuration), nil)
+	}
+	maxGasStr, _ := params["maxGas"].(string)
+	maxGas, ok := new(big.Int).SetString(maxGasStr, 0)
+	if !ok || maxGas.Sign() <= 0 {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, "invalid maxGas", nil)
+	}
+	var targets []string
+	if list, ok := params["targets"].([]any); ok {
+		for _, t := range list {
+			s, _ := t.(string)
+			target, err := utils.NormalizeAddress(s)
+			if err != nil {
+				return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, fmt.Sprintf("invalid target %v", t), nil)
+			}
+			targets = append(targets, target)
+		}
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	session := &models.Session{
+		Key:       hexutil.Encode(key),
+		ApiKeyID:  apiKey.ID,
+		Sender:    sender,
+		Targets:   strings.Join(targets, ","),
+		ExpiresAt: expiresAt,
+		MaxGas:    maxGas.String(),
+		UsedGas:   "0",
+	}
+	if err := s.Container.GetRepository().Create(session).Error; err != nil {
+		logger.S().Errorf("save session error: %v", err)
+		return nil, err
+	}
+	return newSessionInfo(session), nil
+}
+
+// Pm_getSession returns a session of the api key, or null.
+func (s *Signer) Pm_getSession(ctx context.Context, sessionID string) (*SessionInfo, error) {
+	session, err := (&models.Session{}).FindByKey(s.Container.GetRepository(), sessionID)
+	if nil != err {
+		logger.S().Errorf("Query session error: %v", err)
+		return nil, err
+	}
+	apiKey := ApiKeyFromContext(ctx)
+	if session == nil || apiKey == nil || session.ApiKeyID != apiKey.ID {
+		return nil, nil
+	}
+	return newSessionInfo(session), nil
+}
+
+// sessionID returns the session requested in the sponsorship context.
+func sessionID(sponsorContext map[string]any) string {
+	id, _ := sponsorContext["sessionId"].(string)
+	return id
+}
+
+// checkSession validates that the operation may be sponsored by the session.
+func (s *Signer) checkSession(ctx context.Context, req *policy.Request, sp *sponsorship, id string) (*models.Session, error) {
+	session, err := (&models.Session{}).FindByKey(s.Container.GetRepository(), id)
+	if nil != err {
+		logger.S().Errorf("Query session error: %v", err)
+		return nil, err
+	}
+	if session == nil || req.ApiKey == nil || session.ApiKeyID != req.ApiKey.ID {
+		return nil, rpcerrors.RejectedByPaymaster("unknown session", rpcerrors.REASON_SESSION_INVALID)
+	}
+	if session.Sender != sp.sender {
+		return nil, rpcerrors.RejectedByPaymaster("session belongs to another sender", rpcerrors.REASON_SESSION_INVALID)
+	}
+	if !time.Now().Before(session.ExpiresAt) {
+		return nil, rpcerrors.RejectedByPaymaster("session expired", rpcerrors.REASON_SESSION_INVALID)
+	}
+	if sp.quota.Cmp(session.RemainGas()) > 0 {
+		return nil, rpcerrors.RejectedByPaymaster("session quota exceeded", rpcerrors.REASON_SESSION_QUOTA)
+	}
+	if targets := session.TargetList(); len(targets) > 0 {
+		scope := make([]models.PolicyTarget, len(targets))
+		for n, target := range targets {
+			scope[n] = models.PolicyTarget{Address: target}
+		}
+		if err := policy.NewTargetAllowlist(scope, "").Check(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+	return session, nil
+}
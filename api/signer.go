@@ -1,25 +1,32 @@
 package api
 
 import (
+	"context"
 	"crypto/ecdsa"
+	"crypto/x509"
 	"encoding/hex"
-	"errors"
+	"encoding/json"
+	"fmt"
 	"math/big"
-	"strings"
+	"os"
 	"time"
 
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/ethclient"
 
+	"github.com/ququzone/verifying-paymaster-service/bundler"
+	"github.com/ququzone/verifying-paymaster-service/cache"
 	"github.com/ququzone/verifying-paymaster-service/config"
 	"github.com/ququzone/verifying-paymaster-service/container"
-	"github.com/ququzone/verifying-paymaster-service/contracts"
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/faucet"
+	"github.com/ququzone/verifying-paymaster-service/identity"
 	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/metrics"
 	"github.com/ququzone/verifying-paymaster-service/models"
-	"github.com/ququzone/verifying-paymaster-service/types"
+	"github.com/ququzone/verifying-paymaster-service/policy"
+	"github.com/ququzone/verifying-paymaster-service/store"
 	"github.com/ququzone/verifying-paymaster-service/utils"
 )
 
@@ -50,28 +57,117 @@ type GasRemain struct {
 	Remain      string `json:"remain"`
 	LastRequest int64  `json:"last_request"`
 	Used        string `json:"total_used"`
+	// DisabledReason is the reason code of a disabled account.
+	DisabledReason string `json:"disabled_reason,omitempty"`
+	// Unit is the shared quota unit, empty when the quota is in wei.
+	Unit string `json:"unit,omitempty"`
 }
 
 type PaymasterConfig struct {
 	MaxGas      string `json:"max_gas"`
 	VipContract string `json:"vip_contract"`
 	MaxVipGas   string `json:"max_vip_gas"`
+	QuotaUnit   string `json:"quota_unit,omitempty"`
+	// Maintenance are the maintenance windows in force or starting within
+	// the notice period.
+	Maintenance []MaintenanceNotice `json:"maintenance,omitempty"`
+	// Chains are the served chains, the default one first.
+	Chains []ChainMetadata `json:"chains"`
+}
+
+// ChainMetadata is the paymaster deployment of a chain.
+type ChainMetadata struct {
+	ChainID    string `json:"chain_id"`
+	Paymaster  string `json:"paymaster"`
+	EntryPoint string `json:"entry_point"`
+}
+
+// MaintenanceNotice is a scheduled maintenance window, in unix seconds.
+type MaintenanceNotice struct {
+	Start  int64  `json:"start"`
+	End    int64  `json:"end"`
+	Reason string `json:"reason"`
 }
 
 type Signer struct {
-	Container   container.Container
-	Client      *ethclient.Client
-	Contract    common.Address
-	Paymaster   *contracts.VerifyingPaymaster
-	PrivateKey  *ecdsa.PrivateKey
-	CreateGas   *big.Int
-	MaxGas      *big.Int
-	MaxVipGas   *big.Int
-	VipContract *contracts.VipNFT
+	// ChainContext is the default chain.
+	*ChainContext
+	// Chains are the served chains by chain id.
+	Chains     map[uint64]*ChainContext
+	Container  container.Container
+	PrivateKey *ecdsa.PrivateKey
+	// Simulate runs simulateHandleOp before sponsoring and uses its gas limits.
+	Simulate bool
+	// SimulationTTL is how long simulation results are reused at the same
+	// chain head, 0 simulates every time.
+	SimulationTTL time.Duration
+	// MaxSessionDuration bounds the lifetime of sessions.
+	MaxSessionDuration time.Duration
+	// HashType is the sponsorship hash scheme verified by the paymaster
+	// contract, HashEthSign or HashEIP712 with the given domain.
+	HashType      string
+	DomainName    string
+	DomainVersion string
+	// PvgProfiles are the preVerificationGas adjustment profiles by name,
+	// PvgProfile applies to keys without one.
+	PvgProfiles map[string]*bundler.Profile
+	PvgProfile  string
+	// QuotaUnit is the unit account quotas are shared in across chains,
+	// empty when they are kept in wei.
+	QuotaUnit string
+	// CreditPaymentAddress receives on-chain payments for credit packs,
+	// disabled when zero.
+	CreditPaymentAddress common.Address
+	CreditConfirmations  uint64
+	CreditPackValidity   time.Duration
+	// RefreshGrace is how long after the refresh window elapsed a policy can
+	// refresh the quota in the sponsorship path.
+	RefreshGrace time.Duration
+	// DualApprovalAbove is the max gas cost from which held operations need
+	// two operator approvals, nil disables dual approval.
+	DualApprovalAbove *big.Int
+	// MaintenanceNotice is how long ahead pm_config reports maintenance
+	// windows.
+	MaintenanceNotice time.Duration
+	// ApiKeys serves the api keys of rpc requests.
+	ApiKeys *cache.ApiKeys
+	// States keeps the request nonces of replay protection, ReplayWindow is
+	// how far request timestamps may be from the server time.
+	States       store.Store
+	ReplayWindow time.Duration
+	// QuoteTTL is how long pm_quote tokens are honoured.
+	QuoteTTL time.Duration
+	// PasskeyRPID is the WebAuthn relying party of the passkeys bound to
+	// addresses, empty disables them. Ceremonies must run on one of
+	// PasskeyOrigins and answer their challenge within PasskeyChallengeTTL.
+	PasskeyRPID         string
+	PasskeyOrigins      []string
+	PasskeyChallengeTTL time.Duration
+	// PasskeyAttestation is the attestation policy of passkey registrations,
+	// PasskeyAttestationPacked trusting the authenticators certified by
+	// PasskeyAttestationRoots.
+	PasskeyAttestation      string
+	PasskeyAttestationRoots *x509.CertPool
+	// Identities verifies the web2 identities linked to addresses, nil
+	// when no provider is configured.
+	Identities *identity.Verifier
+	// Faucets drip native tokens to the accounts pm_requestGas creates, one
+	// per chain with a faucet.
+	Faucets []*faucet.Faucet
+	// DepositBuffer is the safety buffer in wei the deposit monitor keeps
+	// on top of the outstanding sponsorships, nil for none.
+	DepositBuffer *big.Int
+	// ErrorTemplates replace the catalog messages of the errors returned
+	// to clients.
+	ErrorTemplates *rpcerrors.Templates
+
+	cachedConfig configCache
+	cachedStatus statusCache
+	dedicated    dedicatedChains
+	simulations  simulationCache
 }
 
-func NewSigner(con container.Container) (*Signer, error) {
-	conf := config.Config()
+func NewSigner(conf *config.Values, con container.Container, states store.Store) (*Signer, error) {
 	keyBytes, err := hex.DecodeString(conf.PrivateKey)
 	if err != nil {
 		return nil, err
@@ -80,37 +176,138 @@ func NewSigner(con container.Container) (*Signer, error) {
 	if err != nil {
 		return nil, err
 	}
-	logger.S().Infof("VerifyingPaymaster contract: %s", conf.Contract)
+	if conf.PaymasterHash != HashEthSign && conf.PaymasterHash != HashEIP712 {
+		return nil, fmt.Errorf("unsupported PAYMASTER_HASH %q", conf.PaymasterHash)
+	}
+	if conf.MockChain {
+		logger.S().Warnf("Mock chain mode enabled, chain calls are served in-process")
+	}
 
-	rpc, err := ethclient.Dial(conf.RPC)
+	profiles, err := bundler.LoadProfiles(conf.PvgProfiles)
 	if err != nil {
 		return nil, err
 	}
+	if _, ok := profiles[conf.PvgProfile]; conf.PvgProfile != "" && !ok {
+		return nil, fmt.Errorf("unknown PVG_PROFILE %q", conf.PvgProfile)
+	}
 
-	contract := common.HexToAddress(conf.Contract)
-	paymaster, err := contracts.NewVerifyingPaymaster(contract, rpc)
-	if err != nil {
-		return nil, err
+	var creditPaymentAddress common.Address
+	if conf.CreditPaymentAddress != "" {
+		if !common.IsHexAddress(conf.CreditPaymentAddress) {
+			return nil, fmt.Errorf("invalid CREDIT_PAYMENT_ADDRESS %q", conf.CreditPaymentAddress)
+		}
+		creditPaymentAddress = common.HexToAddress(conf.CreditPaymentAddress)
+	}
+	var dualApprovalAbove *big.Int
+	if conf.DualApprovalAbove != "" {
+		var ok bool
+		if dualApprovalAbove, ok = new(big.Int).SetString(conf.DualApprovalAbove, 10); !ok || dualApprovalAbove.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid DUAL_APPROVAL_ABOVE %q", conf.DualApprovalAbove)
+		}
+		if conf.AdminOperatorTokens == "" {
+			// approvals can only be told apart by their operator token
+			return nil, fmt.Errorf("DUAL_APPROVAL_ABOVE requires ADMIN_OPERATOR_TOKENS")
+		}
 	}
-	createGas, _ := new(big.Int).SetString(conf.CreateGas, 10)
-	maxGas, _ := new(big.Int).SetString(conf.MaxGas, 10)
 
-	vipContract, err := contracts.NewVipNFT(common.HexToAddress(conf.VipContract), rpc)
+	var depositBuffer *big.Int
+	if conf.DepositSafetyBuffer != "" {
+		var ok bool
+		if depositBuffer, ok = new(big.Int).SetString(conf.DepositSafetyBuffer, 10); !ok || depositBuffer.Sign() < 0 {
+			return nil, fmt.Errorf("invalid DEPOSIT_SAFETY_BUFFER %q", conf.DepositSafetyBuffer)
+		}
+	}
+
+	passkeyAttestation := conf.PasskeyAttestation
+	var passkeyAttestationRoots *x509.CertPool
+	switch passkeyAttestation {
+	case "", PasskeyAttestationNone:
+		passkeyAttestation = PasskeyAttestationNone
+	case PasskeyAttestationPacked:
+		if conf.PasskeyAttestationRoots == "" {
+			return nil, fmt.Errorf("PASSKEY_ATTESTATION packed requires PASSKEY_ATTESTATION_ROOTS")
+		}
+		pem, err := os.ReadFile(conf.PasskeyAttestationRoots)
+		if err != nil {
+			return nil, err
+		}
+		passkeyAttestationRoots = x509.NewCertPool()
+		if !passkeyAttestationRoots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in PASSKEY_ATTESTATION_ROOTS %q", conf.PasskeyAttestationRoots)
+		}
+	default:
+		return nil, fmt.Errorf("invalid PASSKEY_ATTESTATION %q", conf.PasskeyAttestation)
+	}
+
+	errorTemplates, err := rpcerrors.ParseTemplates(conf.ErrorTemplates)
 	if err != nil {
 		return nil, err
 	}
-	maxVipGas, _ := new(big.Int).SetString(conf.VipMaxGas, 10)
+
+	var identities *identity.Verifier
+	clients := make(map[string]*identity.Client)
+	if conf.GoogleClientID != "" {
+		clients[identity.Google] = &identity.Client{ID: conf.GoogleClientID, Secret: conf.GoogleClientSecret}
+	}
+	if conf.GitHubClientID != "" {
+		clients[identity.GitHub] = &identity.Client{ID: conf.GitHubClientID, Secret: conf.GitHubClientSecret}
+	}
+	if len(clients) > 0 {
+		if identities, err = identity.New(&identity.Config{RedirectURL: conf.IdentityRedirectURL, Clients: clients}); err != nil {
+			return nil, err
+		}
+	}
+
+	chains := make(map[uint64]*ChainContext, len(conf.Chains))
+	var defaultChain *ChainContext
+	for _, c := range conf.Chains {
+		cc, err := newChainContext(con, c, conf, states)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := chains[cc.ChainID.Uint64()]; ok {
+			return nil, fmt.Errorf("chain %s configured twice", cc.ChainID)
+		}
+		chains[cc.ChainID.Uint64()] = cc
+		if defaultChain == nil {
+			defaultChain = cc
+		}
+	}
 
 	return &Signer{
-		Container:   con,
-		Client:      rpc,
-		Contract:    contract,
-		Paymaster:   paymaster,
-		PrivateKey:  privKey,
-		CreateGas:   createGas,
-		MaxGas:      maxGas,
-		VipContract: vipContract,
-		MaxVipGas:   maxVipGas,
+		ChainContext: defaultChain,
+		Chains:       chains,
+		Container:    con,
+		PrivateKey:   privKey,
+		ApiKeys:      cache.NewApiKeys(con.GetRepository(), apiKeyTTL),
+		Simulate:     conf.Simulate,
+
+		SimulationTTL:      conf.SimulationCacheTTL,
+		MaxSessionDuration: conf.MaxSessionDuration,
+		HashType:           conf.PaymasterHash,
+		DomainName:         conf.EIP712Name,
+		DomainVersion:      conf.EIP712Version,
+		PvgProfiles:        profiles,
+		PvgProfile:         conf.PvgProfile,
+		QuotaUnit:          conf.QuotaUnit,
+
+		CreditPaymentAddress:    creditPaymentAddress,
+		CreditConfirmations:     conf.CreditPaymentConfirmations,
+		CreditPackValidity:      conf.CreditPackValidity,
+		RefreshGrace:            conf.RefreshGrace,
+		DualApprovalAbove:       dualApprovalAbove,
+		MaintenanceNotice:       conf.MaintenanceNotice,
+		States:                  states,
+		ReplayWindow:            conf.ReplayWindow,
+		QuoteTTL:                conf.QuoteTTL,
+		PasskeyRPID:             conf.PasskeyRPID,
+		PasskeyOrigins:          conf.PasskeyOrigins,
+		PasskeyChallengeTTL:     conf.PasskeyChallengeTTL,
+		PasskeyAttestation:      passkeyAttestation,
+		PasskeyAttestationRoots: passkeyAttestationRoots,
+		Identities:              identities,
+		DepositBuffer:           depositBuffer,
+		ErrorTemplates:          errorTemplates,
 	}, nil
 }
 
@@ -121,198 +318,308 @@ type PaymasterResult struct {
 	CallGasLimit         string `json:"callGasLimit"`
 }
 
-func (s *Signer) Pm_sponsorUserOperation(op map[string]any, entryPoint string) (*PaymasterResult, error) {
-	entryPoint = "0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789"
-	userOp, err := types.NewUserOperation(op)
+// Pm_sponsorUserOperation signs op. sponsorContext may carry the sessionId
+// the operation is charged to, its chainId and the requested validUntil.
+func (s *Signer) Pm_sponsorUserOperation(ctx context.Context, op json.RawMessage, entryPoint string, sponsorContext map[string]any) (*PaymasterResult, error) {
+	chain, err := s.sponsorChain(entryPoint, sponsorContext)
+	if err != nil {
+		return nil, err
+	}
+	return s.sponsor(ctx, chain, op, sponsorContext, false)
+}
+
+// sponsor evaluates op on chain, charges its quota and signs it. opGas signs
+// the gas limits of op instead of the service defaults.
+func (s *Signer) sponsor(ctx context.Context, chain *ChainContext, op json.RawMessage, sponsorContext map[string]any, opGas bool) (*PaymasterResult, error) {
+	if err := s.checkReplay(ctx, time.Now()); err != nil {
+		s.recordRejection(ctx, chain, op, err)
+		return nil, err
+	}
+	sp, _, err := s.evaluate(ctx, chain, op, sponsorContext, opGas)
+	if sp != nil {
+		defer sp.release()
+	}
+	if err != nil && sp != nil {
+		s.queueHold(sp, op)
+		return nil, err
+	}
 	if err != nil {
+		s.recordRejection(ctx, chain, op, err)
 		return nil, err
 	}
 
-	account, err := (&models.Account{}).FindByAddress(s.Container.GetRepository(), strings.ToLower(userOp.Sender.String()))
-	if nil != err || account == nil {
-		return nil, errors.New("insufficient gas")
-		// remove auto claim gas
-		// account = &models.Account{
-		// 	Address:     strings.ToLower(strings.ToLower(userOp.Sender.String())),
-		// 	Enable:      true,
-		// 	UsedGas:     "0",
-		// 	RemainGas:   s.MaxGas.String(),
-		// 	LastRequest: time.Now(),
-		// }
-		// err = s.Container.GetRepository().Save(account).Error
-		// if nil != err {
-		// 	logger.S().Errorf("save account error: %v", err)
-		// 	return nil, err
-		// }
-	}
-
-	// tempOp, _ := types.NewUserOperation(op)
-	// preVerificationGas, verificationGas, callGas, err := estimate(
-	// 	s.Client,
-	// 	s.PrivateKey,
-	// 	s.Contract,
-	// 	s.Paymaster,
-	// 	common.HexToAddress(entryPoint),
-	// 	tempOp,
-	// )
-	// if err != nil {
-	// 	return nil, err
-	// }
-
-	preVerificationGas, verificationGas, callGas, err := big.NewInt(52304), big.NewInt(100000), big.NewInt(33100), nil
-
-	remainGas, _ := new(big.Int).SetString(account.RemainGas, 10)
-	totalGas := new(big.Int).Add(preVerificationGas, verificationGas)
-	totalGas = new(big.Int).Add(totalGas, callGas)
-	totalGas = new(big.Int).Mul(totalGas, userOp.MaxFeePerGas)
-	// Auto claim gas
-	// if totalGas.Cmp(remainGas) > 0 {
-	// 	if account.LastRequest.Unix()+86400 < time.Now().Unix() {
-	// 		account.LastRequest = time.Now()
-	// 		account.RemainGas = s.MaxGas.String()
-	// 		err = s.Container.GetRepository().Save(account).Error
-	// 		if nil != err {
-	// 			logger.S().Errorf("save account error: %v", err)
-	// 			return nil, err
-	// 		}
-	// 	}
-	// }
-	// remainGas, _ = new(big.Int).SetString(account.RemainGas, 10)
-	if totalGas.Cmp(remainGas) > 0 {
-		return nil, errors.New("insufficient gas")
-	}
-	usedGas, _ := new(big.Int).SetString(account.UsedGas, 10)
-	account.UsedGas = new(big.Int).Add(usedGas, totalGas).String()
-	account.RemainGas = new(big.Int).Sub(remainGas, totalGas).String()
-	err = s.Container.GetRepository().Save(account).Error
+	if sp.autoRefresh != nil {
+		if err := s.autoRefresh(s.accounts(sp.chain), sp.autoRefresh, sp.sender); err != nil {
+			logger.S().Warnf("auto refresh quota of %s error: %v", sp.sender, err)
+		}
+	}
+	accounts := s.accounts(sp.chain)
+	timed := metrics.Time(ctx, metrics.PhaseDB)
+	_, err = accounts.ReserveGas(sp.sender, sp.quota, sp.overdraft)
+	timed()
+	if err == models.ErrInsufficientGas || err == models.ErrAccountNotFound {
+		err = rpcerrors.RejectedByPaymaster("insufficient gas", rpcerrors.REASON_INSUFFICIENT_GAS)
+		s.recordRejection(ctx, chain, op, err)
+		return nil, err
+	}
 	if nil != err {
-		logger.S().Errorf("save account error: %v", err)
+		logger.S().Errorf("reserve gas error: %v", err)
 		return nil, err
 	}
+	committed := false
+	defer func() {
+		if committed {
+			return
+		}
+		if err := accounts.ReleaseGas(sp.sender, sp.quota); err != nil {
+			logger.S().Errorf("release gas error: %v", err)
+		}
+	}()
+	if sp.session != nil {
+		timed := metrics.Time(ctx, metrics.PhaseDB)
+		err := (&models.Session{}).ChargeGas(s.Container.GetRepository(), sp.session.Key, sp.quota)
+		timed()
+		if err == models.ErrSessionQuota {
+			err = rpcerrors.RejectedByPaymaster("session quota exceeded", rpcerrors.REASON_SESSION_QUOTA)
+			s.recordRejection(ctx, chain, op, err)
+			return nil, err
+		}
+		if nil != err {
+			logger.S().Errorf("charge session error: %v", err)
+			return nil, err
+		}
+		defer func() {
+			if committed {
+				return
+			}
+			refund := new(big.Int).Neg(sp.quota)
+			if err := (&models.Session{}).ChargeGas(s.Container.GetRepository(), sp.session.Key, refund); err != nil {
+				logger.S().Errorf("refund session error: %v", err)
+			}
+		}()
+	}
 
-	// TODO: verify op rules:
-	//  1. normal gas
-	//  2. only for create
-	validAfter := new(big.Int).SetInt64(time.Now().Unix())
-	validUntil := new(big.Int).Add(validAfter, validTimeDelay)
-	timeRangeData, err := timeRangeABI.Pack(validUntil, validAfter)
+	var block uint64
+	if sp.chain.SignRate.PerBlock() {
+		head, err := chain.Client.HeaderByNumber(ctx, nil)
+		if err != nil {
+			logger.S().Errorf("query chain head error: %v", err)
+			return nil, err
+		}
+		block = head.Number.Uint64()
+	}
+	release, err := sp.chain.SignRate.Reserve(ctx, chain.ChainID, block, policy.SignedGas(sp.op), sp.totalGas)
 	if err != nil {
+		s.recordRejection(ctx, chain, op, err)
 		return nil, err
 	}
-	userOp.PaymasterAndData = append(append(s.Contract.Bytes(), timeRangeData...), emptySignature...)
-	userOp.Signature = []byte{}
-
-	hash, err := s.Paymaster.GetHash(nil, contracts.UserOperation{
-		Sender:               userOp.Sender,
-		Nonce:                userOp.Nonce,
-		InitCode:             userOp.InitCode,
-		CallData:             userOp.CallData,
-		CallGasLimit:         callGas,
-		VerificationGasLimit: verificationGas,
-		PreVerificationGas:   preVerificationGas,
-		MaxFeePerGas:         userOp.MaxFeePerGas,
-		MaxPriorityFeePerGas: userOp.MaxPriorityFeePerGas,
-		PaymasterAndData:     userOp.PaymasterAndData,
-		Signature:            userOp.Signature,
-	}, validUntil, validAfter)
+	defer func() {
+		if !committed {
+			release()
+		}
+	}()
+
+	timed = metrics.Time(ctx, metrics.PhaseSigning)
+	result, err := s.sign(sp)
+	timed()
 	if err != nil {
 		return nil, err
 	}
-	signature, err := utils.SignMessage(s.PrivateKey, hash[:])
-	if err != nil {
+	rec := &models.Sponsorship{
+		UserOpHash:       sp.userOpHash.Hex(),
+		Sender:           sp.sender,
+		Nonce:            sp.op.Nonce.String(),
+		PaymasterAndData: result.PaymasterAndData,
+		MaxGasCost:       sp.totalGas.String(),
+		ApiKeyID:         sp.apiKeyID,
+		Target:           sp.target,
+		Value:            sp.value.String(),
+		ValidUntil:       sp.validUntil,
+		Status:           models.SponsorshipSigned,
+		ChainID:          chain.ChainID.Uint64(),
+		SurchargePercent: sp.surcharge,
+		ClientIP:         ClientIPFromContext(ctx),
+		Paymaster:        utils.LowerAddress(sp.chain.Contract),
+		QuotaPaymaster:   sp.chain.QuotaPaymaster,
+	}
+	if len(sp.op.CallData) > 0 {
+		rec.CallDataHash = crypto.Keccak256Hash(sp.op.CallData).Hex()
+	}
+	if chain.QuotaRate != nil || sp.surcharge > 0 {
+		rec.QuotaCost = sp.quota.String()
+	}
+	timed = metrics.Time(ctx, metrics.PhaseDB)
+	defer timed()
+	if err := s.Container.GetRepository().Create(rec).Error; err != nil {
+		logger.S().Errorf("save sponsorship error: %v", err)
+		return nil, err
+	}
+	if err := accounts.CommitGas(sp.sender, sp.quota); err != nil {
+		logger.S().Errorf("commit gas error: %v", err)
 		return nil, err
 	}
+	committed = true
+	if sp.hold != nil {
+		s.useHold(sp, rec.UserOpHash)
+	}
+	metrics.Observe(chain.ChainID, sp.apiKeyID, metrics.OutcomeSigned, sp.totalGas)
 
-	// TODO: set gas
-	return &PaymasterResult{
-		PaymasterAndData:     hexutil.Encode(append(append(s.Contract.Bytes(), timeRangeData...), signature...)),
-		PreVerificationGas:   hexutil.Encode(preVerificationGas.Bytes()),
-		VerificationGasLimit: hexutil.Encode(verificationGas.Bytes()),
-		CallGasLimit:         hexutil.Encode(callGas.Bytes()),
-	}, nil
+	return result, nil
 }
 
-func (s *Signer) Pm_gasRemain(addr string) (*GasRemain, error) {
-	account, err := (&models.Account{}).FindByAddress(s.Container.GetRepository(), strings.ToLower(addr))
+// recordRejection stores and counts a refused sponsorship, internal errors
+// are not recorded.
+func (s *Signer) recordRejection(ctx context.Context, chain *ChainContext, op json.RawMessage, err error) {
+	rpcErr, ok := err.(*rpcerrors.RPCError)
+	if !ok || rpcErr.Code() == rpcerrors.INTERNAL_ERROR {
+		return
+	}
+	entry, _ := rpcErr.Describe()
+	rejection := &models.Rejection{
+		Code:   rpcErr.Code(),
+		Reason: entry.Slug,
+	}
+	if key := ApiKeyFromContext(ctx); key != nil {
+		rejection.ApiKeyID = key.ID
+	}
+	var fields struct {
+		Sender string `json:"sender"`
+	}
+	if json.Unmarshal(op, &fields) == nil && fields.Sender != "" {
+		rejection.Sender, _ = utils.NormalizeAddress(fields.Sender)
+	}
+	metrics.Observe(chain.ChainID, rejection.ApiKeyID, metrics.OutcomeRejected, nil)
+	metrics.ObserveRejection(chain.ChainID, rejection.ApiKeyID, rejection.Reason)
+	if err := s.Container.GetRepository().Create(rejection).Error; err != nil {
+		logger.S().Errorf("save rejection error: %v", err)
+	}
+}
+
+// Pm_gasRemain reports the quota of addr, on the dedicated paymaster of the
+// api key when it has one.
+func (s *Signer) Pm_gasRemain(ctx context.Context, addr string) (*GasRemain, error) {
+	address, err := utils.NormalizeAddress(addr)
+	if err != nil {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, err.Error(), nil)
+	}
+	accounts, err := s.keyAccounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	account, err := accounts.FindByAddress(address)
 	if nil != err {
 		logger.S().Errorf("Query account error: %v", err)
 		return nil, err
 	}
-	if account == nil || !account.Enable {
+	if account == nil {
 		return &GasRemain{
 			Remain:      "0",
 			Used:        "0",
 			LastRequest: 0,
+			Unit:        s.QuotaUnit,
+		}, nil
+	}
+	if !account.Enable {
+		return &GasRemain{
+			Remain:         "0",
+			Used:           "0",
+			LastRequest:    0,
+			DisabledReason: account.DisabledReason,
+			Unit:           s.QuotaUnit,
 		}, nil
 	}
 	return &GasRemain{
 		Remain:      account.RemainGas,
 		Used:        account.UsedGas,
 		LastRequest: account.LastRequest.Unix(),
+		Unit:        s.QuotaUnit,
 	}, nil
 }
 
-func (s *Signer) Pm_config() (*PaymasterConfig, error) {
-	return &PaymasterConfig{
-		MaxGas:      config.Config().MaxGas,
-		VipContract: config.Config().VipContract,
-		MaxVipGas:   config.Config().VipMaxGas,
-	}, nil
-}
-
-func (s *Signer) Pm_requestGas(addr string) (bool, error) {
-	account, err := (&models.Account{}).FindByAddress(s.Container.GetRepository(), strings.ToLower(addr))
-	if nil != err {
-		logger.S().Errorf("Query account error: %v", err)
-		return false, err
-	}
-	var lastVip int64 = -1
-	index, err := s.VipContract.TokenOfOwnerByIndex(nil, common.HexToAddress(addr), big.NewInt(0))
+// Pm_requestGas refreshes the quota of addr once per refresh window. The
+// policy of the api key may roll unused quota over, raise the quota of
+// addresses with a linked identity, or require the assertion of the passkey
+// bound to addr or a linked identity.
+func (s *Signer) Pm_requestGas(ctx context.Context, addr string, assertion map[string]any) (bool, error) {
+	address, err := utils.NormalizeAddress(addr)
 	if err != nil {
-		// mute logs
-		// logger.S().Errorf("Query account vip nft error: %v", err)
-	} else {
-		lastVip = index.Int64()
+		return false, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, err.Error(), nil)
 	}
-
-	gas := s.MaxGas
-	if lastVip != -1 {
-		last, err := (&models.Account{}).FindByVipID(s.Container.GetRepository(), lastVip)
+	var p *models.Policy
+	if key := ApiKeyFromContext(ctx); key != nil {
+		p, err = (&models.Policy{}).FindByApiKey(s.Container.GetRepository(), key.ID)
 		if nil != err {
-			logger.S().Errorf("Query account by vip id error: %v", err)
+			logger.S().Errorf("Query policy error: %v", err)
 			return false, err
 		}
-		if last != nil && account.LastRequest.Unix()+86400 > time.Now().Unix() {
-			return false, errors.New("frequent requests with NFT")
+	}
+	if p != nil && p.RequirePasskey {
+		if err := s.checkPasskey(ctx, address, assertion); err != nil {
+			return false, err
 		}
-		gas = s.MaxVipGas
 	}
-	if account != nil {
-		if !account.Enable {
-			return false, errors.New("account disabled")
+	if err := s.checkIdentity(p, address); err != nil {
+		return false, err
+	}
+	lastVip := s.vipOf(address)
+
+	accounts, err := s.keyAccounts(ctx)
+	if err != nil {
+		return false, err
+	}
+	created := false
+	err = accounts.Transaction(func(tx models.AccountRepository) error {
+		account, err := tx.FindForUpdate(address)
+		if nil != err {
+			logger.S().Errorf("Query account error: %v", err)
+			return err
 		}
-		if account.LastRequest.Unix()+86400 > time.Now().Unix() {
-			return false, errors.New("frequent requests")
+
+		gas, err := s.refreshGas(tx, lastVip)
+		if err != nil {
+			return err
 		}
-	} else {
-		if lastVip == -1 {
-			gas = s.CreateGas
+		if account != nil {
+			if !account.Enable {
+				return rpcerrors.RejectedByPaymaster("account disabled", rpcerrors.REASON_ACCOUNT_DISABLED)
+			}
+			if next := account.LastRequest.Add(refreshWindow); next.After(time.Now()) {
+				return tooFrequent(next)
+			}
+		} else {
+			if lastVip == -1 {
+				gas = s.CreateGas
+			}
+			account = &models.Account{
+				Address:     address,
+				Enable:      true,
+				UsedGas:     "0",
+				ReservedGas: "0",
+			}
+			created = true
 		}
-		account = &models.Account{
-			Address: strings.ToLower(addr),
-			Enable:  true,
-			UsedGas: "0",
+		if gas, err = s.identityGas(p, address, gas, lastVip); err != nil {
+			return err
 		}
-	}
 
-	account.RemainGas = gas.String()
-	account.LastRequest = time.Now()
-	account.VipID = lastVip
-	err = s.Container.GetRepository().Save(account).Error
-	if nil != err {
-		logger.S().Errorf("save account error: %v", err)
+		refresh(p, account, gas, lastVip, time.Now())
+		if err := tx.Save(account); nil != err {
+			logger.S().Errorf("save account error: %v", err)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
 		return false, err
 	}
+	if created {
+		// the drips are best effort, gas is granted either way
+		for _, drips := range s.Faucets {
+			if _, err := drips.Enqueue(address, ClientIPFromContext(ctx), time.Now()); err != nil {
+				logger.S().Errorf("enqueue faucet drip error: %v", err)
+			}
+		}
+	}
 
 	return true, nil
 }
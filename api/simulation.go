@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/metrics"
+	"github.com/ququzone/verifying-paymaster-service/types"
+)
+
+// simulation is the gas estimated for an operation at a chain head and the
+// signature aggregator its account validates with.
+type simulation struct {
+	block              uint64
+	expires            time.Time
+	preVerificationGas *big.Int
+	verificationGas    *big.Int
+	callGas            *big.Int
+	aggregator         common.Address
+}
+
+// copy returns sim with its own gas values, cached simulations are shared.
+func (sim *simulation) copy() *simulation {
+	return &simulation{
+		block:              sim.block,
+		expires:            sim.expires,
+		preVerificationGas: new(big.Int).Set(sim.preVerificationGas),
+		verificationGas:    new(big.Int).Set(sim.verificationGas),
+		callGas:            new(big.Int).Set(sim.callGas),
+		aggregator:         sim.aggregator,
+	}
+}
+
+// simulationCache keeps successful simulations by operation, so checking or
+// quoting an operation and then sponsoring it simulates it once.
+type simulationCache struct {
+	mu      sync.Mutex
+	entries map[common.Hash]*simulation
+}
+
+// simulate estimates the gas of op on chain with simulateHandleOp and finds
+// its aggregator with simulateValidation. The result is reused for
+// SimulationTTL as long as no new block arrived, failures are not kept. The
+// call gas is estimated once per block for the same sender and call data.
+func (s *Signer) simulate(ctx context.Context, chain *ChainContext, op *types.UserOperation) (*simulation, error) {
+	defer metrics.Time(ctx, metrics.PhaseSimulation)()
+	head, err := chain.Client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		logger.S().Warnf("query chain head error: %v", err)
+		callGas := func(op *types.UserOperation) (uint64, error) {
+			return estimateCallGas(ctx, chain.Client, chain.EntryPoint, op)
+		}
+		return s.runSimulation(chain, op, callGas)
+	}
+	block := head.Number.Uint64()
+	callGas := func(op *types.UserOperation) (uint64, error) {
+		return chain.callGas.estimate(ctx, block, op, func() (uint64, error) {
+			return estimateCallGas(ctx, chain.Client, chain.EntryPoint, op)
+		})
+	}
+	if s.SimulationTTL <= 0 {
+		return s.runSimulation(chain, op, callGas)
+	}
+	// the operation as submitted, on the paymaster it is signed for
+	key := crypto.Keccak256Hash(holdHash(chain, op).Bytes(), chain.Contract.Bytes())
+	now := time.Now()
+
+	s.simulations.mu.Lock()
+	cached, ok := s.simulations.entries[key]
+	s.simulations.mu.Unlock()
+	if ok && cached.block == block && now.Before(cached.expires) {
+		return cached.copy(), nil
+	}
+
+	sim, err := s.runSimulation(chain, op, callGas)
+	if err != nil {
+		return nil, err
+	}
+	sim.block = block
+	sim.expires = now.Add(s.SimulationTTL)
+	s.simulations.mu.Lock()
+	defer s.simulations.mu.Unlock()
+	if s.simulations.entries == nil {
+		s.simulations.entries = make(map[common.Hash]*simulation)
+	}
+	for k, e := range s.simulations.entries {
+		if e.block != block || !now.Before(e.expires) {
+			delete(s.simulations.entries, k)
+		}
+	}
+	s.simulations.entries[key] = sim.copy()
+	return sim, nil
+}
+
+// runSimulation estimates the gas of op and, on the operation signed by
+// estimate, checks it against the aggregator of its account.
+func (s *Signer) runSimulation(chain *ChainContext, op *types.UserOperation, callGas func(op *types.UserOperation) (uint64, error)) (*simulation, error) {
+	sign := func(op *types.UserOperation, validUntil, validAfter *big.Int) ([]byte, error) {
+		return s.paymasterSignature(chain, op, validUntil, validAfter)
+	}
+	sim := &simulation{}
+	var err error
+	sim.preVerificationGas, sim.verificationGas, sim.callGas, err = estimate(chain.Client, chain.Contract, sign, callGas, chain.EntryPoint, op)
+	if err != nil {
+		return nil, err
+	}
+	sim.aggregator, err = validationAggregator(chain.Client, chain.EntryPoint, op)
+	if err != nil {
+		return nil, err
+	}
+	if sim.aggregator != (common.Address{}) {
+		if err := checkAggregatedSignature(chain.Client, sim.aggregator, op); err != nil {
+			return nil, err
+		}
+	}
+	return sim, nil
+}
@@ -0,0 +1,439 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/metrics"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/policy"
+	"github.com/ququzone/verifying-paymaster-service/types"
+	"github.com/ququzone/verifying-paymaster-service/utils"
+)
+
+// sponsorship is a user operation that passed all sponsorship checks.
+type sponsorship struct {
+	// chain the operation is sponsored on
+	chain              *ChainContext
+	op                 *types.UserOperation
+	sender             string
+	preVerificationGas *big.Int
+	verificationGas    *big.Int
+	callGas            *big.Int
+	// totalGas is the maximum gas cost in wei.
+	totalGas *big.Int
+	// quota is totalGas in the quota unit with the api key surcharge,
+	// charged against the sender and session quotas.
+	quota *big.Int
+	// surcharge is the percentage of the api key added to the quota
+	surcharge uint64
+	// overdraft is how far the policy lets the sender quota go below zero
+	overdraft *big.Int
+	// aggregator is the signature aggregator of the account, zero without
+	// one or when the operation was not simulated
+	aggregator common.Address
+	// policy is the policy of the api key, or nil
+	policy *models.Policy
+	// autoRefresh is the policy that refreshes the expired sender quota
+	// before it is charged, or nil
+	autoRefresh *models.Policy
+	// value is the native value sent by the inner calls, zero when the call
+	// data is not decoded.
+	value *big.Int
+	// target is the contract of the first inner call, empty when the call
+	// data is not decoded.
+	target string
+	// apiKeyID is the api key the operation is sponsored for
+	apiKeyID uint
+	// session charged in addition to the sender quota, or nil
+	session *models.Session
+	// holdHash identifies the operation in the manual approval queue
+	holdHash common.Hash
+	// held is the reason the policy held the operation, hold the approved
+	// hold it is signed for
+	held string
+	hold *models.HeldOperation
+	// validity is the signature validity window in seconds
+	validity *big.Int
+
+	// set by sign
+	userOpHash common.Hash
+	validUntil time.Time
+}
+
+// SponsorshipCheck reports whether an operation would be sponsored.
+type SponsorshipCheck struct {
+	Sponsored            bool   `json:"sponsored"`
+	Code                 int    `json:"code,omitempty"`
+	Reason               string `json:"reason,omitempty"`
+	Data                 any    `json:"data,omitempty"`
+	RequiredGas          string `json:"requiredGas,omitempty"`
+	RemainGas            string `json:"remainGas,omitempty"`
+	PreVerificationGas   string `json:"preVerificationGas,omitempty"`
+	VerificationGasLimit string `json:"verificationGasLimit,omitempty"`
+	CallGasLimit         string `json:"callGasLimit,omitempty"`
+}
+
+// evaluate runs the sponsorship pipeline on chain without touching the quota.
+// Rejections are returned as RPCErrors, anything else is an internal failure.
+// opGas keeps the gas limits of op instead of the defaults or the simulation.
+// sponsorContext may carry the sessionId and the requested validUntil.
+func (s *Signer) evaluate(ctx context.Context, chain *ChainContext, op json.RawMessage, sponsorContext map[string]any, opGas bool) (*sponsorship, *models.Account, error) {
+	userOp, err := types.DecodeUserOperation(op)
+	if err != nil {
+		return nil, nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, err.Error(), nil)
+	}
+	validUntil, err := requestedValidUntil(sponsorContext)
+	if err != nil {
+		return nil, nil, err
+	}
+	timed := metrics.Time(ctx, metrics.PhaseDB)
+	err = s.checkPaused(ctx)
+	timed()
+	if err != nil {
+		return nil, nil, err
+	}
+	chain, err = s.keyChain(ctx, chain)
+	if err != nil {
+		return nil, nil, err
+	}
+	quote, err := s.quoteTerms(ctx, chain, userOp, sponsorContext, time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sp := &sponsorship{
+		chain:              chain,
+		op:                 userOp,
+		sender:             utils.LowerAddress(userOp.Sender),
+		preVerificationGas: big.NewInt(52304),
+		verificationGas:    big.NewInt(100000),
+		callGas:            big.NewInt(33100),
+		value:              new(big.Int),
+		overdraft:          new(big.Int),
+	}
+	sp.holdHash = holdHash(chain, userOp)
+	switch {
+	case opGas:
+		sp.preVerificationGas = userOp.PreVerificationGas
+		sp.verificationGas = userOp.VerificationGasLimit
+		sp.callGas = userOp.CallGasLimit
+	case quote != nil:
+		sp.preVerificationGas, sp.verificationGas, sp.callGas = quote.gasLimits()
+	case s.Simulate:
+		sim, err := s.simulate(ctx, chain, userOp)
+		if err != nil {
+			logger.S().Debugf("simulate user operation error: %v", err)
+			return nil, nil, err
+		}
+		sp.preVerificationGas, sp.verificationGas, sp.callGas = sim.preVerificationGas, sim.verificationGas, sim.callGas
+		sp.aggregator = sim.aggregator
+	}
+	if !opGas && quote == nil {
+		key := ApiKeyFromContext(ctx)
+		sp.verificationGas = chain.markupGas(key, sp.verificationGas)
+		sp.callGas = chain.markupGas(key, sp.callGas)
+		sp.preVerificationGas = s.adjustPreVerificationGas(key, sp.preVerificationGas)
+	}
+	userOp.PreVerificationGas = sp.preVerificationGas
+	userOp.VerificationGasLimit = sp.verificationGas
+	userOp.CallGasLimit = sp.callGas
+	sp.totalGas = new(big.Int).Add(sp.preVerificationGas, sp.verificationGas)
+	sp.totalGas = new(big.Int).Add(sp.totalGas, sp.callGas)
+	sp.totalGas = new(big.Int).Mul(sp.totalGas, userOp.MaxFeePerGas)
+	sp.quota = chain.quotaCost(sp.totalGas)
+	if key := ApiKeyFromContext(ctx); key != nil && key.SurchargePercent > 0 {
+		sp.surcharge = key.SurchargePercent
+		sp.quota = new(big.Int).Mul(sp.quota, new(big.Int).SetUint64(100+sp.surcharge))
+		sp.quota.Div(sp.quota, big.NewInt(100))
+	}
+	if quote != nil {
+		if err := quote.apply(sp); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	timed = metrics.Time(ctx, metrics.PhaseDB)
+	account, err := s.accounts(chain).FindByAddress(sp.sender)
+	timed()
+	if nil != err {
+		logger.S().Errorf("Query account error: %v", err)
+		return nil, nil, err
+	}
+	req := &policy.Request{
+		ApiKey:     ApiKeyFromContext(ctx),
+		Account:    account,
+		Op:         userOp,
+		ChainID:    chain.ChainID,
+		EntryPoint: chain.EntryPoint,
+		MaxGasCost: sp.totalGas,
+		Aggregator: sp.aggregator,
+		MultiSend:  chain.MultiSend,
+	}
+	if req.ApiKey != nil {
+		sp.apiKeyID = req.ApiKey.ID
+	}
+	if account != nil && !account.Enable {
+		return nil, account, rpcerrors.RejectedByPaymaster("account disabled", rpcerrors.REASON_ACCOUNT_DISABLED)
+	}
+	if err := s.checkHold(sp, policy.Evaluate(ctx, chain.Checks, req)); err != nil {
+		return holdOf(sp), account, err
+	}
+	var p *models.Policy
+	if req.ApiKey != nil {
+		timed = metrics.Time(ctx, metrics.PhaseDB)
+		p, err = (&models.Policy{}).FindByApiKey(s.Container.GetRepository(), req.ApiKey.ID)
+		timed()
+		if nil != err {
+			logger.S().Errorf("Query policy error: %v", err)
+			return nil, account, err
+		}
+	}
+	sp.policy = p
+	sp.validity, err = checkValidity(p, validUntil, time.Now())
+	if err != nil {
+		return nil, account, err
+	}
+	if account == nil {
+		return nil, account, rpcerrors.RejectedByPaymaster("insufficient gas", rpcerrors.REASON_INSUFFICIENT_GAS)
+	}
+	sp.overdraft = chain.overdraft(p, account)
+	remain := models.ParseGas(account.RemainGas)
+	if sp.quota.Cmp(new(big.Int).Add(remain, sp.overdraft)) > 0 && s.autoRefreshDue(p, account, time.Now()) {
+		sp.autoRefresh = p
+		remain, err = s.autoRefreshedGas(p, account)
+		if err != nil {
+			return nil, account, err
+		}
+	}
+	if sp.quota.Cmp(new(big.Int).Add(remain, sp.overdraft)) > 0 {
+		return nil, account, rpcerrors.RejectedByPaymaster("insufficient gas", rpcerrors.REASON_INSUFFICIENT_GAS)
+	}
+	if key := sessionID(sponsorContext); key != "" {
+		sp.session, err = s.checkSession(ctx, req, sp, key)
+		if err != nil {
+			return nil, account, err
+		}
+	}
+
+	if req.ApiKey != nil {
+		checkers, err := policy.Build(s.Container.GetRepository(), p)
+		if nil != err {
+			logger.S().Errorf("Build policy error: %v", err)
+			return nil, account, err
+		}
+		if p != nil {
+			req.Wallet = p.Wallet
+		}
+		if err := s.checkHold(sp, policy.Evaluate(ctx, checkers, req)); err != nil {
+			return holdOf(sp), account, err
+		}
+	}
+	if calls, err := req.Calls(); err == nil {
+		sp.value = policy.TotalValue(calls)
+		if len(calls) > 0 {
+			sp.target = utils.LowerAddress(calls[0].Target)
+		}
+	}
+	return sp, account, nil
+}
+
+// release returns the decoded operation of sp to the pool once the request
+// is done with it.
+func (sp *sponsorship) release() {
+	types.ReleaseUserOperation(sp.op)
+	sp.op = nil
+}
+
+// holdOf returns sp when it was held, evaluate returns no sponsorship for
+// rejected operations.
+func holdOf(sp *sponsorship) *sponsorship {
+	if sp.held == "" {
+		return nil
+	}
+	return sp
+}
+
+// overdraft returns how far the policy p lets the quota of account go below
+// zero, a percentage of the quota the account is refreshed to.
+func (c *ChainContext) overdraft(p *models.Policy, account *models.Account) *big.Int {
+	if p == nil || p.OverdraftPercent == 0 {
+		return new(big.Int)
+	}
+	quota := c.MaxGas
+	if account.VipID != -1 {
+		quota = c.MaxVipGas
+	}
+	overdraft := new(big.Int).Mul(quota, new(big.Int).SetUint64(p.OverdraftPercent))
+	return overdraft.Div(overdraft, big.NewInt(100))
+}
+
+// adjustPreVerificationGas applies the preVerificationGas profile of key.
+func (s *Signer) adjustPreVerificationGas(key *models.ApiKeys, pvg *big.Int) *big.Int {
+	name := s.PvgProfile
+	if key != nil && key.PvgProfile != "" {
+		name = key.PvgProfile
+	}
+	if name == "" {
+		return pvg
+	}
+	profile, ok := s.PvgProfiles[name]
+	if !ok {
+		logger.S().Warnf("unknown preVerificationGas profile %q", name)
+		return pvg
+	}
+	return profile.Adjust(pvg)
+}
+
+// sign produces the paymasterAndData for an evaluated operation.
+func (s *Signer) sign(sp *sponsorship) (*PaymasterResult, error) {
+	// TODO: verify op rules:
+	//  1. normal gas
+	//  2. only for create
+	validAfter := new(big.Int).SetInt64(time.Now().Unix())
+	validUntil := new(big.Int).Add(validAfter, sp.validity)
+	timeRangeData, err := timeRangeABI.Pack(validUntil, validAfter)
+	if err != nil {
+		return nil, err
+	}
+	chain := sp.chain
+	userOp := sp.op
+	userOp.PaymasterAndData = append(append(chain.Contract.Bytes(), timeRangeData...), emptySignature...)
+	userOp.Signature = []byte{}
+
+	signature, err := s.paymasterSignature(chain, userOp, validUntil, validAfter)
+	if err != nil {
+		return nil, err
+	}
+
+	userOp.PaymasterAndData = append(append(chain.Contract.Bytes(), timeRangeData...), signature...)
+	sp.userOpHash = userOp.GetUserOpHash(chain.EntryPoint, chain.ChainID)
+	sp.validUntil = time.Unix(validUntil.Int64(), 0)
+
+	return &PaymasterResult{
+		PaymasterAndData:     hexutil.Encode(userOp.PaymasterAndData),
+		PreVerificationGas:   hexutil.Encode(sp.preVerificationGas.Bytes()),
+		VerificationGasLimit: hexutil.Encode(sp.verificationGas.Bytes()),
+		CallGasLimit:         hexutil.Encode(sp.callGas.Bytes()),
+	}, nil
+}
+
+// Pm_checkSponsorship reports whether op would be sponsored without reserving
+// quota or signing it. sponsorContext may carry the chainId of the operation.
+func (s *Signer) Pm_checkSponsorship(ctx context.Context, op json.RawMessage, entryPoint string, sponsorContext map[string]any) (*SponsorshipCheck, error) {
+	chain, err := s.sponsorChain(entryPoint, sponsorContext)
+	if err != nil {
+		return nil, err
+	}
+	sp, account, err := s.evaluate(ctx, chain, op, sponsorContext, false)
+	if sp != nil {
+		defer sp.release()
+	}
+	result := &SponsorshipCheck{}
+	if account != nil {
+		result.RemainGas = account.RemainGas
+	}
+	if err != nil {
+		rpcErr := rpcerrors.Wrap(err)
+		if rpcErr.Code() == rpcerrors.INTERNAL_ERROR || rpcErr.Code() == rpcerrors.INVALID_PARAMS {
+			return nil, err
+		}
+		entry, payload := rpcErr.Describe()
+		result.Code = rpcErr.Code()
+		result.Reason = s.errorMessage(ctx, entry, payload)
+		result.Data = payload
+		return result, nil
+	}
+
+	result.Sponsored = true
+	result.RequiredGas = sp.quota.String()
+	result.PreVerificationGas = hexutil.Encode(sp.preVerificationGas.Bytes())
+	result.VerificationGasLimit = hexutil.Encode(sp.verificationGas.Bytes())
+	result.CallGasLimit = hexutil.Encode(sp.callGas.Bytes())
+	return result, nil
+}
+
+type UserOperationStatus struct {
+	UserOpHash      string `json:"userOpHash"`
+	Sender          string `json:"sender"`
+	Nonce           string `json:"nonce"`
+	Status          string `json:"status"`
+	ValidUntil      int64  `json:"validUntil"`
+	BlockNumber     uint64 `json:"blockNumber,omitempty"`
+	TransactionHash string `json:"transactionHash,omitempty"`
+	ActualGasCost   string `json:"actualGasCost,omitempty"`
+	// SubmittedAt is when the operation was sent through the bundler proxy.
+	SubmittedAt int64 `json:"submittedAt,omitempty"`
+	// SubmissionStatus is the state of a self bundled operation.
+	SubmissionStatus string `json:"submissionStatus,omitempty"`
+	// HoldReason is why an operation held for approval was held.
+	HoldReason string `json:"holdReason,omitempty"`
+}
+
+// Pm_getUserOperationStatus returns the lifecycle status of a sponsored
+// operation, or null when the hash was not signed by this service. Held
+// operations are found by the hash returned with HELD_FOR_APPROVAL, once
+// signed the status is that of their sponsorship.
+func (s *Signer) Pm_getUserOperationStatus(userOpHash string) (*UserOperationStatus, error) {
+	hash := common.HexToHash(userOpHash).Hex()
+	rec, err := (&models.Sponsorship{}).FindByUserOpHash(s.Container.GetRepository(), hash)
+	if nil != err {
+		logger.S().Errorf("Query sponsorship error: %v", err)
+		return nil, err
+	}
+	if rec == nil {
+		hold, err := models.FindHold(s.Container.GetRepository(), hash)
+		if nil != err {
+			logger.S().Errorf("Query held operation error: %v", err)
+			return nil, err
+		}
+		if hold == nil {
+			return nil, nil
+		}
+		if hold.SponsorshipHash == "" {
+			return &UserOperationStatus{
+				UserOpHash: hold.HoldHash,
+				Sender:     hold.Sender,
+				Nonce:      hold.Nonce,
+				Status:     hold.CurrentStatus(time.Now()),
+				ValidUntil: hold.ExpiresAt.Unix(),
+				HoldReason: hold.Reason,
+			}, nil
+		}
+		rec, err = (&models.Sponsorship{}).FindByUserOpHash(s.Container.GetRepository(), hold.SponsorshipHash)
+		if nil != err {
+			logger.S().Errorf("Query sponsorship error: %v", err)
+			return nil, err
+		}
+		if rec == nil {
+			return nil, nil
+		}
+	}
+	status := &UserOperationStatus{
+		UserOpHash:      rec.UserOpHash,
+		Sender:          rec.Sender,
+		Nonce:           rec.Nonce,
+		Status:          rec.CurrentStatus(time.Now()),
+		ValidUntil:      rec.ValidUntil.Unix(),
+		BlockNumber:     rec.BlockNumber,
+		TransactionHash: rec.TxHash,
+		ActualGasCost:   rec.ActualGasCost,
+	}
+	submission, err := (&models.Submission{}).FindBySponsorship(s.Container.GetRepository(), rec.ID)
+	if nil != err {
+		logger.S().Errorf("Query submission error: %v", err)
+		return nil, err
+	}
+	if submission != nil {
+		status.SubmittedAt = submission.CreatedAt.Unix()
+		status.SubmissionStatus = submission.Status
+	}
+	return status, nil
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+
+	"github.com/ququzone/verifying-paymaster-service/contracts"
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+)
+
+// statusTTL is how long the EntryPoint deposits of pm_paymasterStatus are
+// served from memory, so status pages polling it do not load the RPC.
+const statusTTL = 15 * time.Second
+
+// PaymasterStatus is the state of the paymaster integrators show on status
+// pages.
+type PaymasterStatus struct {
+	// Paused is set while sponsorships of the api key are paused or a
+	// maintenance window is running, Pause then holds the error data a
+	// rejected sponsorship would carry.
+	Paused               bool           `json:"paused"`
+	Pause                any            `json:"pause,omitempty"`
+	SupportedEntryPoints []string       `json:"supportedEntryPoints"`
+	Chains               []*ChainStatus `json:"chains"`
+}
+
+// ChainStatus is the EntryPoint deposit and stake of the paymaster of a
+// chain, amounts in wei.
+type ChainStatus struct {
+	ChainID         string `json:"chainId"`
+	Paymaster       string `json:"paymaster"`
+	EntryPoint      string `json:"entryPoint"`
+	Deposit         string `json:"deposit"`
+	Staked          bool   `json:"staked"`
+	Stake           string `json:"stake"`
+	UnstakeDelaySec uint32 `json:"unstakeDelaySec"`
+	// Unlocked is set once the stake was unlocked, it can be withdrawn
+	// from WithdrawTime on.
+	Unlocked     bool  `json:"unlocked"`
+	WithdrawTime int64 `json:"withdrawTime,omitempty"`
+}
+
+// statusCache keeps the chain part of the pm_paymasterStatus response.
+type statusCache struct {
+	mu      sync.Mutex
+	value   []*ChainStatus
+	expires time.Time
+}
+
+// Pm_paymasterStatus returns the deposit, stake and unlock status of the
+// paymaster on every served chain, the default chain first, its EntryPoints
+// and whether sponsorships of the caller are paused.
+func (s *Signer) Pm_paymasterStatus(ctx context.Context) (*PaymasterStatus, error) {
+	chains, err := s.chainStatuses(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entryPoints, err := s.Eth_supportedEntryPoints()
+	if err != nil {
+		return nil, err
+	}
+	status := &PaymasterStatus{SupportedEntryPoints: entryPoints, Chains: chains}
+
+	err = s.checkPaused(ctx)
+	var rpcErr *rpcerrors.RPCError
+	switch {
+	case err == nil:
+	case errors.As(err, &rpcErr) && rpcErr.Code() == rpcerrors.SPONSORSHIP_PAUSED:
+		_, payload := rpcErr.Describe()
+		status.Paused = true
+		status.Pause = payload
+	default:
+		return nil, err
+	}
+	return status, nil
+}
+
+// chainStatuses reads the EntryPoint deposits of the paymaster, served from
+// memory for statusTTL.
+func (s *Signer) chainStatuses(ctx context.Context) ([]*ChainStatus, error) {
+	s.cachedStatus.mu.Lock()
+	defer s.cachedStatus.mu.Unlock()
+	now := time.Now()
+	if s.cachedStatus.value != nil && now.Before(s.cachedStatus.expires) {
+		return s.cachedStatus.value, nil
+	}
+
+	var chains []*ChainStatus
+	for _, chain := range s.sortedChains() {
+		info, err := chain.DepositInfo(ctx)
+		if err != nil {
+			logger.S().Errorf("Query deposit info of chain %s error: %v", chain.ChainID, err)
+			return nil, err
+		}
+		status := &ChainStatus{
+			ChainID:         chain.ChainID.String(),
+			Paymaster:       chain.Contract.Hex(),
+			EntryPoint:      chain.EntryPoint.Hex(),
+			Deposit:         info.Deposit.String(),
+			Staked:          info.Staked,
+			Stake:           info.Stake.String(),
+			UnstakeDelaySec: info.UnstakeDelaySec,
+			Unlocked:        info.WithdrawTime.Sign() > 0,
+		}
+		if status.Unlocked {
+			status.WithdrawTime = info.WithdrawTime.Int64()
+		}
+		chains = append(chains, status)
+	}
+	s.cachedStatus.value = chains
+	s.cachedStatus.expires = now.Add(statusTTL)
+	return chains, nil
+}
+
+// DepositInfo reads the EntryPoint deposit and stake of the paymaster.
+func (c *ChainContext) DepositInfo(ctx context.Context) (*contracts.IStakeManagerDepositInfo, error) {
+	entryPoint, err := contracts.NewEntryPointCaller(c.EntryPoint, c.Client)
+	if err != nil {
+		return nil, err
+	}
+	info, err := entryPoint.GetDepositInfo(&bind.CallOpts{Context: ctx}, c.Contract)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
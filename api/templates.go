@@ -0,0 +1,33 @@
+package api
+
+import (
+	"context"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// ReloadErrorTemplates reads the error templates operators stored in the
+// database, they override those of the configuration.
+func (s *Signer) ReloadErrorTemplates() {
+	templates, err := models.ErrorTemplates(s.Container.GetRepository())
+	if err != nil {
+		logger.S().Errorf("Query error templates error: %v", err)
+		return
+	}
+	overrides := make(map[string]map[string]string)
+	for _, t := range templates {
+		if overrides[t.Locale] == nil {
+			overrides[t.Locale] = make(map[string]string)
+		}
+		overrides[t.Locale][t.Slug] = t.Message
+	}
+	s.ErrorTemplates.SetOverrides(overrides)
+}
+
+// errorMessage returns the message of entry for the client of ctx, in its
+// language when there is a template for it.
+func (s *Signer) errorMessage(ctx context.Context, entry *rpcerrors.Entry, payload *rpcerrors.Payload) string {
+	return s.ErrorTemplates.Message(entry, payload, RequestHeaderFromContext(ctx).Get("Accept-Language"))
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+
+	"github.com/ququzone/verifying-paymaster-service/contracts"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/types"
+	"github.com/ququzone/verifying-paymaster-service/utils"
+)
+
+// Sponsorship hash schemes.
+const (
+	HashEthSign = "eth_sign"
+	HashEIP712  = "eip712"
+)
+
+var sponsorshipTypes = apitypes.Types{
+	"EIP712Domain": {
+		{Name: "name", Type: "string"},
+		{Name: "version", Type: "string"},
+		{Name: "chainId", Type: "uint256"},
+		{Name: "verifyingContract", Type: "address"},
+	},
+	"SponsorUserOperation": {
+		{Name: "sender", Type: "address"},
+		{Name: "nonce", Type: "uint256"},
+		{Name: "initCode", Type: "bytes"},
+		{Name: "callData", Type: "bytes"},
+		{Name: "callGasLimit", Type: "uint256"},
+		{Name: "verificationGasLimit", Type: "uint256"},
+		{Name: "preVerificationGas", Type: "uint256"},
+		{Name: "maxFeePerGas", Type: "uint256"},
+		{Name: "maxPriorityFeePerGas", Type: "uint256"},
+		{Name: "validUntil", Type: "uint48"},
+		{Name: "validAfter", Type: "uint48"},
+	},
+}
+
+// typedDataHash returns the EIP-712 digest of the sponsorship, bound to the
+// paymaster contract and chain through the domain separator.
+func (s *Signer) typedDataHash(chain *ChainContext, op *types.UserOperation, validUntil, validAfter *big.Int) ([]byte, error) {
+	hash, _, err := apitypes.TypedDataAndHash(apitypes.TypedData{
+		Types:       sponsorshipTypes,
+		PrimaryType: "SponsorUserOperation",
+		Domain: apitypes.TypedDataDomain{
+			Name:              s.DomainName,
+			Version:           s.DomainVersion,
+			ChainId:           (*math.HexOrDecimal256)(chain.ChainID),
+			VerifyingContract: chain.Contract.Hex(),
+		},
+		Message: apitypes.TypedDataMessage{
+			"sender":               op.Sender.Hex(),
+			"nonce":                op.Nonce.String(),
+			"initCode":             hexutil.Encode(op.InitCode),
+			"callData":             hexutil.Encode(op.CallData),
+			"callGasLimit":         op.CallGasLimit.String(),
+			"verificationGasLimit": op.VerificationGasLimit.String(),
+			"preVerificationGas":   op.PreVerificationGas.String(),
+			"maxFeePerGas":         op.MaxFeePerGas.String(),
+			"maxPriorityFeePerGas": op.MaxPriorityFeePerGas.String(),
+			"validUntil":           validUntil.String(),
+			"validAfter":           validAfter.String(),
+		},
+	})
+	return hash, err
+}
+
+// paymasterSignature signs op for the paymaster of chain with the configured
+// hash scheme. The gas fields of op must hold the limits being signed.
+func (s *Signer) paymasterSignature(chain *ChainContext, op *types.UserOperation, validUntil, validAfter *big.Int) ([]byte, error) {
+	if s.HashType == HashEIP712 {
+		hash, err := s.typedDataHash(chain, op, validUntil, validAfter)
+		if err != nil {
+			return nil, err
+		}
+		return s.signTypedData(hash)
+	}
+	hash, err := chain.Paymaster.GetHash(nil, contracts.UserOperation{
+		Sender:               op.Sender,
+		Nonce:                op.Nonce,
+		InitCode:             op.InitCode,
+		CallData:             op.CallData,
+		CallGasLimit:         op.CallGasLimit,
+		VerificationGasLimit: op.VerificationGasLimit,
+		PreVerificationGas:   op.PreVerificationGas,
+		MaxFeePerGas:         op.MaxFeePerGas,
+		MaxPriorityFeePerGas: op.MaxPriorityFeePerGas,
+		PaymasterAndData:     op.PaymasterAndData,
+		Signature:            op.Signature,
+	}, validUntil, validAfter)
+	if err != nil {
+		logger.S().Errorf("get paymaster hash error: %v", err)
+		return nil, revertRPCError(err)
+	}
+	return utils.SignMessage(s.PrivateKey, hash[:])
+}
+
+// signTypedData signs an EIP-712 digest without the eth_sign prefix.
+func (s *Signer) signTypedData(hash []byte) ([]byte, error) {
+	signature, err := crypto.Sign(hash, s.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	signature[crypto.RecoveryIDOffset] += 27
+	return signature, nil
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+const (
+	// defaultUsageRange is the range of pm_usageStats without from.
+	defaultUsageRange = 30 * 24 * time.Hour
+	// maxUsageRange bounds the range of one pm_usageStats call.
+	maxUsageRange = 366 * 24 * time.Hour
+)
+
+// Pm_usageStats returns the daily or weekly sponsorships of the api key in
+// [from, to), unix seconds. to defaults to now and from to 30 days before.
+func (s *Signer) Pm_usageStats(ctx context.Context, period string, from, to int64) ([]models.PeriodStats, error) {
+	apiKey := ApiKeyFromContext(ctx)
+	if apiKey == nil {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_API_KEY, "api key required", map[string]any{"reason": rpcerrors.REASON_API_KEY_REQUIRED})
+	}
+	if period == "" {
+		period = "day"
+	}
+	if period != "day" && period != "week" {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, "period must be day or week", nil)
+	}
+	end := time.Now()
+	if to != 0 {
+		end = time.Unix(to, 0)
+	}
+	start := end.Add(-defaultUsageRange)
+	if from != 0 {
+		start = time.Unix(from, 0)
+	}
+	if !start.Before(end) || end.Sub(start) > maxUsageRange {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, "from must be before to and within a year of it", nil)
+	}
+
+	stats, err := models.SponsorshipStats(s.Container.GetRepository(), period, start, end, apiKey.ID)
+	if err != nil {
+		logger.S().Errorf("Query usage stats error: %v", err)
+		return nil, err
+	}
+	if stats == nil {
+		stats = []models.PeriodStats{}
+	}
+	return stats, nil
+}
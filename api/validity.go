@@ -0,0 +1,46 @@
+package api
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// requestedValidUntil returns the validUntil (unix seconds) sponsorContext
+// asks the signature to be valid until, zero for the default window.
+func requestedValidUntil(sponsorContext map[string]any) (int64, error) {
+	switch v := sponsorContext["validUntil"].(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		if v <= 0 || v != float64(int64(v)) {
+			return 0, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, fmt.Sprintf("invalid validUntil %v", v), nil)
+		}
+		return int64(v), nil
+	default:
+		return 0, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, fmt.Sprintf("invalid validUntil %v", v), nil)
+	}
+}
+
+// checkValidity returns the signature validity window of an operation
+// requesting validUntil at now. Windows up to the default are open to every
+// key, longer ones need a policy whose MaxValidity covers them.
+func checkValidity(p *models.Policy, validUntil int64, now time.Time) (*big.Int, error) {
+	if validUntil == 0 {
+		return validTimeDelay, nil
+	}
+	window := validUntil - now.Unix()
+	if window <= 0 {
+		return nil, rpcerrors.NewRPCError(rpcerrors.SHORT_DEADLINE, "validUntil already passed", nil)
+	}
+	if window > validTimeDelay.Int64() && (p == nil || window > p.MaxValidity) {
+		return nil, rpcerrors.RejectedByPaymaster(
+			"validUntil exceeds the validity window of the api key",
+			rpcerrors.REASON_VALIDITY_NOT_ALLOWED,
+		)
+	}
+	return big.NewInt(window), nil
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// maxDeliveries bounds the deliveries of one pm_webhookDeliveries call.
+const maxDeliveries = 100
+
+type WebhookInfo struct {
+	ID     uint     `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	// Secret signs the deliveries, only returned when it is created.
+	Secret    string `json:"secret,omitempty"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+func webhookInfo(w *models.Webhook, secret bool) *WebhookInfo {
+	info := &WebhookInfo{ID: w.ID, URL: w.URL, Events: []string{}, CreatedAt: w.CreatedAt.Unix()}
+	if w.Events != "" {
+		info.Events = strings.Split(w.Events, ",")
+	}
+	if secret {
+		info.Secret = w.Secret
+	}
+	return info
+}
+
+func requireApiKey(ctx context.Context) (*models.ApiKeys, error) {
+	apiKey := ApiKeyFromContext(ctx)
+	if apiKey == nil {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_API_KEY, "api key required", map[string]any{"reason": rpcerrors.REASON_API_KEY_REQUIRED})
+	}
+	return apiKey, nil
+}
+
+// findWebhook returns webhook id of the api key of ctx.
+func (s *Signer) findWebhook(ctx context.Context, id uint64) (*models.Webhook, error) {
+	apiKey, err := requireApiKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	webhook, err := models.FindWebhook(s.Container.GetRepository(), apiKey.ID, uint(id))
+	if err != nil {
+		logger.S().Errorf("Query webhook error: %v", err)
+		return nil, err
+	}
+	if webhook == nil {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, fmt.Sprintf("unknown webhook %d", id), nil)
+	}
+	return webhook, nil
+}
+
+// Pm_createWebhook registers rawURL to receive the events of the api key,
+// every event when events is empty. The returned secret signs the
+// deliveries and is not shown again.
+func (s *Signer) Pm_createWebhook(ctx context.Context, rawURL string, events []any) (*WebhookInfo, error) {
+	apiKey, err := requireApiKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || len(rawURL) > 512 {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, "url must be an absolute http(s) url of at most 512 characters", nil)
+	}
+	var names []string
+	for _, e := range events {
+		name, _ := e.(string)
+		if !models.ValidWebhookEvent(name) {
+			return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, fmt.Sprintf("unknown event %v, events are %s", e, strings.Join(models.WebhookEvents, ", ")), nil)
+		}
+		names = append(names, name)
+	}
+	secret, err := models.NewWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	rep := s.Container.GetRepository()
+	webhooks, err := models.Webhooks(rep, apiKey.ID)
+	if err != nil {
+		logger.S().Errorf("Query webhooks error: %v", err)
+		return nil, err
+	}
+	if len(webhooks) >= models.MaxWebhooksPerKey {
+		return nil, rpcerrors.NewRPCError(rpcerrors.INVALID_PARAMS, fmt.Sprintf("at most %d webhooks per api key", models.MaxWebhooksPerKey), nil)
+	}
+	webhook := &models.Webhook{ApiKeyID: apiKey.ID, URL: rawURL, Events: strings.Join(names, ","), Secret: secret}
+	if err := rep.Create(webhook).Error; err != nil {
+		logger.S().Errorf("Create webhook error: %v", err)
+		return nil, err
+	}
+	return webhookInfo(webhook, true), nil
+}
+
+// Pm_listWebhooks returns the webhooks of the api key, without secrets.
+func (s *Signer) Pm_listWebhooks(ctx context.Context) ([]*WebhookInfo, error) {
+	apiKey, err := requireApiKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	webhooks, err := models.Webhooks(s.Container.GetRepository(), apiKey.ID)
+	if err != nil {
+		logger.S().Errorf("Query webhooks error: %v", err)
+		return nil, err
+	}
+	infos := []*WebhookInfo{}
+	for i := range webhooks {
+		infos = append(infos, webhookInfo(&webhooks[i], false))
+	}
+	return infos, nil
+}
+
+// Pm_deleteWebhook removes webhook id, its pending deliveries are dropped.
+func (s *Signer) Pm_deleteWebhook(ctx context.Context, id uint64) (bool, error) {
+	webhook, err := s.findWebhook(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if err := s.Container.GetRepository().Delete(webhook).Error; err != nil {
+		logger.S().Errorf("Delete webhook error: %v", err)
+		return false, err
+	}
+	return true, nil
+}
+
+// Pm_rotateWebhookSecret replaces the secret of webhook id, deliveries are
+// signed with the new one from now on.
+func (s *Signer) Pm_rotateWebhookSecret(ctx context.Context, id uint64) (*WebhookInfo, error) {
+	webhook, err := s.findWebhook(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if webhook.Secret, err = models.NewWebhookSecret(); err != nil {
+		return nil, err
+	}
+	err = s.Container.GetRepository().Model(webhook).Update("secret", webhook.Secret).Error
+	if err != nil {
+		logger.S().Errorf("Rotate webhook secret error: %v", err)
+		return nil, err
+	}
+	return webhookInfo(webhook, true), nil
+}
+
+// Pm_testWebhook queues a webhook.test event for webhook id.
+func (s *Signer) Pm_testWebhook(ctx context.Context, id uint64) (bool, error) {
+	webhook, err := s.findWebhook(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if err := models.EnqueueWebhookTest(s.Container.GetRepository(), webhook); err != nil {
+		logger.S().Errorf("Queue webhook test error: %v", err)
+		return false, err
+	}
+	return true, nil
+}
+
+type WebhookDeliveryInfo struct {
+	ID             uint   `json:"id"`
+	Event          string `json:"event"`
+	Status         string `json:"status"`
+	Attempts       int    `json:"attempts"`
+	LastStatusCode int    `json:"lastStatusCode"`
+	LastError      string `json:"lastError"`
+	CreatedAt      int64  `json:"createdAt"`
+	// NextAttemptAt is set for pending deliveries, DeliveredAt for
+	// delivered ones.
+	NextAttemptAt int64 `json:"nextAttemptAt,omitempty"`
+	DeliveredAt   int64 `json:"deliveredAt,omitempty"`
+}
+
+// Pm_webhookDeliveries returns the latest limit deliveries of webhook id,
+// newest first.
+func (s *Signer) Pm_webhookDeliveries(ctx context.Context, id uint64, limit int) ([]*WebhookDeliveryInfo, error) {
+	webhook, err := s.findWebhook(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > maxDeliveries {
+		limit = maxDeliveries
+	}
+	deliveries, err := models.WebhookDeliveries(s.Container.GetRepository(), webhook.ID, limit)
+	if err != nil {
+		logger.S().Errorf("Query webhook deliveries error: %v", err)
+		return nil, err
+	}
+	infos := []*WebhookDeliveryInfo{}
+	for _, d := range deliveries {
+		info := &WebhookDeliveryInfo{
+			ID:             d.ID,
+			Event:          d.Event,
+			Status:         d.Status,
+			Attempts:       d.Attempts,
+			LastStatusCode: d.LastStatusCode,
+			LastError:      d.LastError,
+			CreatedAt:      d.CreatedAt.Unix(),
+		}
+		if d.Status == models.DeliveryPending {
+			info.NextAttemptAt = d.NextAttemptAt.Unix()
+		}
+		if d.DeliveredAt != nil {
+			info.DeliveredAt = d.DeliveredAt.Unix()
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
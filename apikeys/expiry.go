@@ -0,0 +1,195 @@
+// Package apikeys reminds project owners of expiring api keys and rotates
+// the keys set to rotate automatically.
+package apikeys
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm/clause"
+
+	"github.com/ququzone/verifying-paymaster-service/cache"
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// Operator is the audit log operator of automatic rotations.
+const Operator = "key-rotation"
+
+// Notice is the payload posted to the project alert webhook ahead of an api
+// key expiry.
+type Notice struct {
+	Event    string `json:"event"`
+	ApiKeyID uint   `json:"apiKeyId"`
+	// ExpiresAt is when the current key stops working.
+	ExpiresAt int64 `json:"expiresAt"`
+	// Key replaces an automatically rotated key, it expires at KeyExpiresAt
+	// or never when zero.
+	Key          string `json:"key,omitempty"`
+	KeyExpiresAt int64  `json:"keyExpiresAt,omitempty"`
+}
+
+type Config struct {
+	// Notice is how long before a key expires its owner is reminded.
+	Notice time.Duration
+	// Grace is how long a key rotated by an operator stays valid.
+	Grace time.Duration
+	// Lifetime is the validity of rotated keys, zero keeps them until
+	// disabled.
+	Lifetime time.Duration
+	Interval time.Duration
+}
+
+// Notifier posts a notice to the alert webhook of the project once, Notice
+// before one of its api keys expires. Keys with AutoRotate are rotated then,
+// the replaced key staying valid until the planned expiry.
+type Notifier struct {
+	conf   *Config
+	rep    db.Repository
+	bus    *cache.Bus
+	client *http.Client
+}
+
+func NewNotifier(conf *Config, rep db.Repository, bus *cache.Bus) *Notifier {
+	if conf.Interval == 0 {
+		conf.Interval = time.Hour
+	}
+	return &Notifier{
+		conf:   conf,
+		rep:    rep,
+		bus:    bus,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Run sends the due notices every interval until ctx is cancelled.
+func (n *Notifier) Run(ctx context.Context) {
+	for {
+		if err := n.check(ctx, time.Now()); err != nil {
+			logger.S().Errorf("api key expiry notifier error: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(n.conf.Interval):
+		}
+	}
+}
+
+func (n *Notifier) check(ctx context.Context, now time.Time) error {
+	keys, err := models.ExpiringApiKeys(n.rep, now, now.Add(n.conf.Notice))
+	if err != nil {
+		return err
+	}
+	for i := range keys {
+		notice, err := n.claim(keys[i].ID, keys[i].User.AlertWebhook != "", now)
+		if err != nil {
+			return err
+		}
+		if notice == nil {
+			continue
+		}
+		if notice.Key != "" {
+			n.bus.Publish(ctx, cache.ApiKey, strconv.FormatUint(uint64(notice.ApiKeyID), 10))
+		}
+		logger.S().Infof("Api key %d of project %d expires at %s", notice.ApiKeyID, keys[i].UserID, keys[i].ExpiresAt)
+		if keys[i].User.AlertWebhook == "" {
+			continue
+		}
+		// the notice is not sent again
+		if err := n.post(ctx, keys[i].User.AlertWebhook, notice); err != nil {
+			logger.S().Warnf("api key expiry notice for project %d error: %v", keys[i].UserID, err)
+		}
+	}
+	return nil
+}
+
+// claim marks the expiry of the key notified, rotating it when it rotates
+// automatically and the owner can receive the new key. It returns nil when
+// another check claimed it first.
+func (n *Notifier) claim(id uint, deliverable bool, now time.Time) (*Notice, error) {
+	var notice *Notice
+	err := n.rep.Transaction(func(tx db.Repository) error {
+		var key models.ApiKeys
+		if err := tx.Model(&models.ApiKeys{}).Clauses(clause.Locking{Strength: "UPDATE"}).First(&key, id).Error; err != nil {
+			return err
+		}
+		if key.ExpiryNotifiedAt != nil || key.ExpiresAt == nil {
+			return nil
+		}
+		expiresAt := *key.ExpiresAt
+		notice = &Notice{Event: "api_key_expiry", ApiKeyID: key.ID, ExpiresAt: expiresAt.Unix()}
+		// the key webhooks could be registered with a leaked key, they are
+		// not sent the new key
+		rotates := key.AutoRotate && deliverable
+		err := models.EnqueueWebhookEvent(tx, key.ID, models.EventApiKeyExpiring, map[string]any{
+			"apiKeyId":  key.ID,
+			"expiresAt": expiresAt.Unix(),
+			"rotated":   rotates,
+		})
+		if err != nil {
+			return err
+		}
+		if !rotates {
+			key.ExpiryNotifiedAt = &now
+			return tx.Save(&key).Error
+		}
+
+		before := NewRotationState(&key)
+		if err := key.Rotate(now, expiresAt.Sub(now), n.conf.Lifetime); err != nil {
+			return err
+		}
+		notice.Key = key.Key
+		if key.ExpiresAt != nil {
+			notice.KeyExpiresAt = key.ExpiresAt.Unix()
+		}
+		if err := tx.Save(&key).Error; err != nil {
+			return err
+		}
+		return models.Audit(tx, &models.AuditLog{
+			Operator: Operator,
+			Action:   "api_key_rotate",
+			Subject:  fmt.Sprintf("api_key:%d", key.ID),
+			Reason:   "auto_rotate",
+		}, before, NewRotationState(&key))
+	})
+	return notice, err
+}
+
+func (n *Notifier) post(ctx context.Context, url string, notice *Notice) error {
+	body, err := json.Marshal(notice)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// RotationState is the audited validity of a key, without the keys
+// themselves.
+type RotationState struct {
+	ExpiresAt            *time.Time `json:"expiresAt"`
+	PreviousKeyExpiresAt *time.Time `json:"previousKeyExpiresAt,omitempty"`
+}
+
+func NewRotationState(key *models.ApiKeys) *RotationState {
+	return &RotationState{ExpiresAt: key.ExpiresAt, PreviousKeyExpiresAt: key.PreviousKeyExpiresAt}
+}
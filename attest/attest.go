@@ -0,0 +1,115 @@
+package attest
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+
+	"github.com/ququzone/verifying-paymaster-service/utils"
+)
+
+// Response headers carrying the attestation.
+const (
+	SignatureHeader = "X-Paymaster-Attestation"
+	SignerHeader    = "X-Paymaster-Attestation-Signer"
+)
+
+// Attester signs JSON-RPC responses with a dedicated key so clients can
+// verify they were produced by the service. The signature covers both the
+// request and the response body.
+type Attester struct {
+	key    *ecdsa.PrivateKey
+	signer common.Address
+}
+
+func NewAttester(hexKey string) (*Attester, error) {
+	key, err := crypto.HexToECDSA(hexKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Attester{key: key, signer: crypto.PubkeyToAddress(key.PublicKey)}, nil
+}
+
+func (a *Attester) Signer() common.Address {
+	return a.signer
+}
+
+// Digest is the attested message: keccak256(keccak256(request) ++
+// keccak256(response)), signed with the eth_sign prefix.
+func Digest(request, response []byte) []byte {
+	return crypto.Keccak256(crypto.Keccak256(request), crypto.Keccak256(response))
+}
+
+// Verify returns the address that attested response to request.
+func Verify(request, response []byte, signature string) (common.Address, error) {
+	sig, err := hexutil.Decode(signature)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if len(sig) != crypto.SignatureLength {
+		return common.Address{}, errors.New("invalid attestation length")
+	}
+	sig = common.CopyBytes(sig)
+	sig[crypto.RecoveryIDOffset] -= 27
+	pub, err := crypto.SigToPub(textHash(Digest(request, response)), sig)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
+
+func textHash(message []byte) []byte {
+	prefix := []byte("\x19Ethereum Signed Message:\n32")
+	return crypto.Keccak256(prefix, message)
+}
+
+type bufferedWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+// Middleware buffers the response and adds the attestation headers. It must
+// run before jsonrpc.Process since it restores the request body after
+// reading it.
+func (a *Attester) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request []byte
+		if c.Request.Body != nil {
+			body, err := io.ReadAll(c.Request.Body)
+			if err != nil {
+				c.AbortWithStatus(http.StatusBadRequest)
+				return
+			}
+			request = body
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		writer := &bufferedWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		response := writer.body.Bytes()
+		signature, err := utils.SignMessage(a.key, Digest(request, response))
+		if err == nil {
+			c.Header(SignatureHeader, hexutil.Encode(signature))
+			c.Header(SignerHeader, a.signer.Hex())
+		}
+		_, _ = c.Writer.Write(response)
+	}
+}
@@ -0,0 +1,148 @@
+package billing
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+type Config struct {
+	// MeterEvent is the event name of the Stripe meter billing the overage.
+	MeterEvent string
+	// UnitWei is the gas cost of one metered unit.
+	UnitWei  *big.Int
+	Interval time.Duration
+}
+
+// Reporter creates a Stripe customer for every project with a billing email
+// and reports the monthly billed gas cost beyond the free quota as metered
+// usage. Prepaid credit packs cover usage before the free quota does. Only
+// whole units are reported, the remainder is carried over to the next
+// report.
+type Reporter struct {
+	conf   *Config
+	stripe *Stripe
+	rep    db.Repository
+}
+
+func NewReporter(conf *Config, stripe *Stripe, rep db.Repository) *Reporter {
+	if conf.Interval == 0 {
+		conf.Interval = time.Hour
+	}
+	if conf.UnitWei == nil || conf.UnitWei.Sign() <= 0 {
+		conf.UnitWei = big.NewInt(1000000000)
+	}
+	return &Reporter{
+		conf:   conf,
+		stripe: stripe,
+		rep:    rep,
+	}
+}
+
+// Run reports usage every interval until ctx is cancelled.
+func (r *Reporter) Run(ctx context.Context) {
+	for {
+		if err := r.report(ctx, time.Now()); err != nil {
+			logger.S().Errorf("billing reporter error: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.conf.Interval):
+		}
+	}
+}
+
+func (r *Reporter) report(ctx context.Context, now time.Time) error {
+	users, err := (&models.User{}).FindBillable(r.rep)
+	if err != nil {
+		return err
+	}
+	for n := range users {
+		if err := r.reportUser(ctx, &users[n], now); err != nil {
+			// retried on the next report
+			logger.S().Warnf("billing report for project %d error: %v", users[n].ID, err)
+		}
+	}
+	return nil
+}
+
+func (r *Reporter) reportUser(ctx context.Context, user *models.User, now time.Time) error {
+	if user.StripeCustomerID == "" {
+		id, err := r.stripe.CreateCustomer(ctx, user.ID, user.BillingEmail)
+		if err != nil {
+			return err
+		}
+		user.StripeCustomerID = id
+		if err := r.rep.Model(user).Update("stripe_customer_id", id).Error; err != nil {
+			return err
+		}
+		logger.S().Infof("Created Stripe customer %s for project %d", id, user.ID)
+	}
+
+	billed, err := user.MonthlyBilled(r.rep, now)
+	if err != nil {
+		return err
+	}
+	month := models.BudgetMonth(now)
+	usage, err := (&models.BillingUsage{}).FindByMonth(r.rep, user.ID, month)
+	if err != nil {
+		return err
+	}
+	if usage == nil {
+		usage = &models.BillingUsage{UserID: user.ID, Month: month, Reported: "0", Covered: "0"}
+	}
+	reported := models.ParseGas(usage.Reported)
+
+	credits, err := models.MonthlyCredits(r.rep, user.ID, month)
+	if err != nil {
+		return err
+	}
+	covered := models.ParseGas(usage.Covered)
+	if usage.Covered == "" {
+		// usage of a month reported before Covered was kept was entirely
+		// covered by credits, the free quota or overage at the last report
+		covered.Add(credits, models.ParseGas(user.FreeQuota))
+		covered.Add(covered, reported)
+		if covered.Cmp(billed) > 0 {
+			covered.Set(billed)
+		}
+	}
+	// credits are drawn for the gross usage since the last report only, the
+	// usage before it was already covered by credits, free quota or overage
+	if uncovered := new(big.Int).Sub(billed, covered); uncovered.Sign() > 0 {
+		err := r.rep.Transaction(func(tx db.Repository) error {
+			consumed, err := models.ConsumeCredits(tx, user.ID, month, uncovered, now)
+			if err != nil {
+				return err
+			}
+			credits.Add(credits, consumed)
+			usage.Covered = billed.String()
+			return tx.Save(usage).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+	overage := new(big.Int).Sub(billed, credits)
+	overage.Sub(overage, models.ParseGas(user.FreeQuota))
+	if overage.Sign() <= 0 {
+		return nil
+	}
+	units := new(big.Int).Div(new(big.Int).Sub(overage, reported), r.conf.UnitWei)
+	if units.Sign() <= 0 {
+		return nil
+	}
+	reported.Add(reported, new(big.Int).Mul(units, r.conf.UnitWei))
+	identifier := fmt.Sprintf("project-%d-%s-%s", user.ID, month, reported)
+	if err := r.stripe.MeterEvent(ctx, r.conf.MeterEvent, user.StripeCustomerID, units.Int64(), identifier, now); err != nil {
+		return err
+	}
+	usage.Reported = reported.String()
+	return r.rep.Save(usage).Error
+}
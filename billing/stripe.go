@@ -0,0 +1,89 @@
+package billing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const stripeAPI = "https://api.stripe.com"
+
+// Stripe is a minimal client of the Stripe REST API.
+type Stripe struct {
+	key     string
+	baseURL string
+	client  *http.Client
+}
+
+func NewStripe(key string) *Stripe {
+	return &Stripe{
+		key:     key,
+		baseURL: stripeAPI,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type stripeError struct {
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// post sends a form encoded request, idempotencyKey makes retries safe.
+func (s *Stripe) post(ctx context.Context, path string, form url.Values, idempotencyKey string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.baseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.key, "")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var e stripeError
+		_ = json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("stripe %s status %d: %s", path, resp.StatusCode, e.Error.Message)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// CreateCustomer creates the customer of a project and returns its id.
+func (s *Stripe) CreateCustomer(ctx context.Context, projectID uint, email string) (string, error) {
+	var customer struct {
+		ID string `json:"id"`
+	}
+	form := url.Values{
+		"email":                {email},
+		"metadata[project_id]": {strconv.FormatUint(uint64(projectID), 10)},
+	}
+	err := s.post(ctx, "/v1/customers", form, fmt.Sprintf("customer-%d", projectID), &customer)
+	return customer.ID, err
+}
+
+// MeterEvent reports value units of usage for customerID. identifier
+// deduplicates the event on the Stripe side.
+func (s *Stripe) MeterEvent(ctx context.Context, eventName, customerID string, value int64, identifier string, at time.Time) error {
+	form := url.Values{
+		"event_name":                  {eventName},
+		"payload[stripe_customer_id]": {customerID},
+		"payload[value]":              {strconv.FormatInt(value, 10)},
+		"identifier":                  {identifier},
+		"timestamp":                   {strconv.FormatInt(at.Unix(), 10)},
+	}
+	return s.post(ctx, "/v1/billing/meter_events", form, identifier, nil)
+}
@@ -0,0 +1,171 @@
+package billing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// signatureTolerance bounds the age of a webhook signature.
+const signatureTolerance = 5 * time.Minute
+
+type event struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object struct {
+			ID            string            `json:"id"`
+			Customer      string            `json:"customer"`
+			PaymentStatus string            `json:"payment_status"`
+			Metadata      map[string]string `json:"metadata"`
+		} `json:"object"`
+	} `json:"data"`
+}
+
+// Webhook handles the Stripe events that hold and release sponsoring of a
+// project: a failed invoice payment holds it until an invoice is paid. A
+// completed checkout with credit_wei metadata grants a credit pack valid
+// for credit_days, packValidity when not given.
+type Webhook struct {
+	rep          db.Repository
+	secret       string
+	packValidity time.Duration
+}
+
+func NewWebhook(rep db.Repository, secret string, packValidity time.Duration) *Webhook {
+	return &Webhook{rep: rep, secret: secret, packValidity: packValidity}
+}
+
+func (w *Webhook) Handle(c *gin.Context) {
+	payload, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	if !w.verify(payload, c.GetHeader("Stripe-Signature"), time.Now()) {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	var e event
+	if err := json.Unmarshal(payload, &e); err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	var apply func(tx db.Repository, user *models.User) error
+	switch e.Type {
+	case "invoice.payment_failed", "invoice.paid":
+		failed := e.Type == "invoice.payment_failed"
+		apply = func(tx db.Repository, user *models.User) error {
+			return tx.Model(user).Update("payment_failed", failed).Error
+		}
+	case "checkout.session.completed":
+		if e.Data.Object.Metadata["credit_wei"] == "" || e.Data.Object.PaymentStatus != "paid" {
+			c.Status(http.StatusOK)
+			return
+		}
+		pack, err := w.creditPack(&e)
+		if err != nil {
+			logger.S().Warnf("stripe webhook %s: %v", e.ID, err)
+			c.Status(http.StatusBadRequest)
+			return
+		}
+		apply = func(tx db.Repository, user *models.User) error {
+			pack.UserID = user.ID
+			_, err := models.GrantCredits(tx, pack)
+			return err
+		}
+	default:
+		c.Status(http.StatusOK)
+		return
+	}
+	err = w.rep.Transaction(func(tx db.Repository) error {
+		seen, err := (&models.StripeEvent{}).Seen(tx, e.ID, e.Type)
+		if err != nil || seen {
+			return err
+		}
+		user, err := (&models.User{}).FindByStripeCustomer(tx, e.Data.Object.Customer)
+		if err != nil || user == nil {
+			return err
+		}
+		logger.S().Infof("Stripe %s for project %d", e.Type, user.ID)
+		return apply(tx, user)
+	})
+	if err != nil {
+		logger.S().Errorf("stripe webhook %s error: %v", e.ID, err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// creditPack builds the pack bought by a checkout session.
+func (w *Webhook) creditPack(e *event) (*models.CreditEntry, error) {
+	metadata := e.Data.Object.Metadata
+	amount, ok := new(big.Int).SetString(metadata["credit_wei"], 10)
+	if !ok || amount.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid credit_wei %q", metadata["credit_wei"])
+	}
+	validity := w.packValidity
+	if days := metadata["credit_days"]; days != "" {
+		n, err := strconv.ParseUint(days, 10, 16)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("invalid credit_days %q", days)
+		}
+		validity = time.Duration(n) * 24 * time.Hour
+	}
+	return &models.CreditEntry{
+		Amount:    amount.String(),
+		Source:    models.CreditSourceStripe,
+		Reference: "stripe:" + e.Data.Object.ID,
+		ExpiresAt: time.Now().Add(validity),
+	}, nil
+}
+
+// verify checks the Stripe-Signature header: an HMAC-SHA256 of the
+// timestamp and payload with the endpoint secret.
+func (w *Webhook) verify(payload []byte, header string, now time.Time) bool {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		k, v, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		switch k {
+		case "t":
+			timestamp = v
+		case "v1":
+			signatures = append(signatures, v)
+		}
+	}
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || now.Sub(time.Unix(t, 0)).Abs() > signatureTolerance {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(w.secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+	for _, sig := range signatures {
+		decoded, err := hex.DecodeString(sig)
+		if err == nil && hmac.Equal(decoded, expected) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+// Package bots serves the claims of community bots, which let the members
+// of a Discord guild or Telegram group claim gas quota from a campaign.
+package bots
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/utils"
+)
+
+type Config struct {
+	// ProofMaxAge bounds the age of the member proofs.
+	ProofMaxAge time.Duration
+}
+
+// Handler credits the claims bots make for their members.
+type Handler struct {
+	conf *Config
+	rep  db.Repository
+}
+
+func NewHandler(conf *Config, rep db.Repository) *Handler {
+	if conf.ProofMaxAge == 0 {
+		conf.ProofMaxAge = 15 * time.Minute
+	}
+	return &Handler{conf: conf, rep: rep}
+}
+
+// NewToken returns a bot token and the hash it is stored as.
+func NewToken() (string, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(raw)
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the hash a bot token is stored as.
+func HashToken(token string) string {
+	return crypto.Keccak256Hash([]byte(token)).Hex()
+}
+
+// claimRequest is a member claim. Discord bots forward the interaction the
+// member triggered with its X-Signature-Ed25519 and X-Signature-Timestamp
+// headers, Telegram bots the init data of their Web App.
+type claimRequest struct {
+	Address     string `json:"address"`
+	Interaction string `json:"interaction"`
+	Signature   string `json:"signature"`
+	Timestamp   string `json:"timestamp"`
+	InitData    string `json:"initData"`
+}
+
+type claimResponse struct {
+	CampaignID uint   `json:"campaignId"`
+	Address    string `json:"address"`
+	Gas        string `json:"gas"`
+}
+
+// Claim credits the campaign quota of the bot to the address of a member
+// whose platform proof checks out.
+func (h *Handler) Claim(c *gin.Context) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "bot token required"})
+		return
+	}
+	campaign, err := models.FindBotCampaignByToken(h.rep, HashToken(token))
+	if err != nil {
+		logger.S().Errorf("Query bot campaign error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if campaign == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unknown bot token"})
+		return
+	}
+	var req claimRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	address, err := utils.NormalizeAddress(req.Address)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	now := time.Now()
+	var guildID, userID string
+	switch campaign.Platform {
+	case models.BotDiscord:
+		guildID, userID, err = h.discordMember(campaign, &req, now)
+	case models.BotTelegram:
+		guildID, userID, err = h.telegramMember(campaign, &req, now)
+	default:
+		err = fmt.Errorf("unsupported platform %q", campaign.Platform)
+	}
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if guildID != campaign.GuildID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "member of another guild"})
+		return
+	}
+
+	claim, err := models.ClaimBotQuota(h.rep, campaign.ID, userID, address, now)
+	switch err {
+	case nil:
+		c.JSON(http.StatusOK, &claimResponse{CampaignID: campaign.ID, Address: claim.Address, Gas: claim.Gas})
+	case models.ErrAlreadyClaimed:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case models.ErrCampaignClosed, models.ErrCampaignBudget:
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	case models.ErrAccountDisabled:
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		logger.S().Errorf("claim bot quota error: %v", err)
+		c.Status(http.StatusInternalServerError)
+	}
+}
+
+// fresh reports whether a proof issued at the unix time issued is recent.
+func (h *Handler) fresh(issued string, now time.Time) bool {
+	sec, err := strconv.ParseInt(issued, 10, 64)
+	if err != nil {
+		return false
+	}
+	age := now.Sub(time.Unix(sec, 0))
+	return age < h.conf.ProofMaxAge && age > -time.Minute
+}
+
+// discordMember verifies the Discord signature of the interaction and
+// returns its guild and member.
+func (h *Handler) discordMember(campaign *models.BotCampaign, req *claimRequest, now time.Time) (string, string, error) {
+	key, err := hex.DecodeString(campaign.VerifyKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return "", "", fmt.Errorf("campaign without discord public key")
+	}
+	sig, err := hex.DecodeString(req.Signature)
+	if err != nil || !ed25519.Verify(key, []byte(req.Timestamp+req.Interaction), sig) {
+		return "", "", fmt.Errorf("invalid interaction signature")
+	}
+	if !h.fresh(req.Timestamp, now) {
+		return "", "", fmt.Errorf("interaction expired")
+	}
+	var interaction struct {
+		GuildID string `json:"guild_id"`
+		Member  struct {
+			User struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		} `json:"member"`
+	}
+	if err := json.Unmarshal([]byte(req.Interaction), &interaction); err != nil {
+		return "", "", fmt.Errorf("invalid interaction: %v", err)
+	}
+	if interaction.GuildID == "" || interaction.Member.User.ID == "" {
+		return "", "", fmt.Errorf("interaction outside a guild")
+	}
+	return interaction.GuildID, interaction.Member.User.ID, nil
+}
+
+// telegramMember verifies the init data hash of a Telegram Web App with the
+// bot token and returns its chat and user.
+func (h *Handler) telegramMember(campaign *models.BotCampaign, req *claimRequest, now time.Time) (string, string, error) {
+	values, err := url.ParseQuery(req.InitData)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid init data: %v", err)
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		if k != "hash" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	lines := make([]string, len(keys))
+	for i, k := range keys {
+		lines[i] = k + "=" + values.Get(k)
+	}
+	secret := hmac.New(sha256.New, []byte("WebAppData"))
+	secret.Write([]byte(campaign.VerifyKey))
+	mac := hmac.New(sha256.New, secret.Sum(nil))
+	mac.Write([]byte(strings.Join(lines, "\n")))
+	hash, err := hex.DecodeString(values.Get("hash"))
+	if err != nil || !hmac.Equal(hash, mac.Sum(nil)) {
+		return "", "", fmt.Errorf("invalid init data hash")
+	}
+	if !h.fresh(values.Get("auth_date"), now) {
+		return "", "", fmt.Errorf("init data expired")
+	}
+	var user, chat struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal([]byte(values.Get("user")), &user); err != nil || user.ID == 0 {
+		return "", "", fmt.Errorf("init data without user")
+	}
+	if err := json.Unmarshal([]byte(values.Get("chat")), &chat); err != nil || chat.ID == 0 {
+		return "", "", fmt.Errorf("init data without chat")
+	}
+	return strconv.FormatInt(chat.ID, 10), strconv.FormatInt(user.ID, 10), nil
+}
@@ -0,0 +1,139 @@
+package budget
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// Alert is the payload posted to the project alert webhook.
+type Alert struct {
+	ProjectID uint   `json:"projectId"`
+	Month     string `json:"month"`
+	Threshold int    `json:"threshold"`
+	Spend     string `json:"spend"`
+	Budget    string `json:"budget"`
+	Paused    bool   `json:"paused"`
+}
+
+// Monitor compares the monthly spend of every project with a budget against
+// its thresholds and notifies each crossed threshold once per month.
+type Monitor struct {
+	rep      db.Repository
+	client   *http.Client
+	interval time.Duration
+}
+
+func NewMonitor(rep db.Repository, interval time.Duration) *Monitor {
+	if interval == 0 {
+		interval = 5 * time.Minute
+	}
+	return &Monitor{
+		rep:      rep,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		interval: interval,
+	}
+}
+
+// Run checks the budgets every interval until ctx is cancelled.
+func (m *Monitor) Run(ctx context.Context) {
+	for {
+		if err := m.check(ctx, time.Now()); err != nil {
+			logger.S().Errorf("budget monitor error: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(m.interval):
+		}
+	}
+}
+
+func (m *Monitor) check(ctx context.Context, now time.Time) error {
+	users, err := (&models.User{}).FindWithBudget(m.rep)
+	if err != nil {
+		return err
+	}
+	month := models.BudgetMonth(now)
+	for n := range users {
+		user := &users[n]
+		budget, ok := new(big.Int).SetString(user.MonthlyBudget, 10)
+		if !ok || budget.Sign() <= 0 {
+			continue
+		}
+		spend, err := user.MonthlySpend(m.rep, now)
+		if err != nil {
+			return err
+		}
+		alerts, err := (&models.BudgetAlert{}).FindByMonth(m.rep, user.ID, month)
+		if err != nil {
+			return err
+		}
+		fired := make(map[int]bool, len(alerts))
+		for _, a := range alerts {
+			fired[a.Threshold] = true
+		}
+		for _, threshold := range models.BudgetThresholds {
+			limit := new(big.Int).Div(new(big.Int).Mul(budget, big.NewInt(int64(threshold))), big.NewInt(100))
+			if fired[threshold] || spend.Cmp(limit) < 0 {
+				continue
+			}
+			alert := &Alert{
+				ProjectID: user.ID,
+				Month:     month,
+				Threshold: threshold,
+				Spend:     spend.String(),
+				Budget:    budget.String(),
+				Paused:    threshold >= 100 && user.AutoPause,
+			}
+			if err := m.notify(ctx, user, alert); err != nil {
+				// retried on the next check
+				logger.S().Warnf("budget alert webhook for project %d error: %v", user.ID, err)
+				continue
+			}
+			err := m.rep.Create(&models.BudgetAlert{
+				UserID:    user.ID,
+				Month:     month,
+				Threshold: threshold,
+				Spend:     spend.String(),
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Monitor) notify(ctx context.Context, user *models.User, alert *Alert) error {
+	logger.S().Warnf("Project %d spent %s of %s wei budget in %s (%d%%)", alert.ProjectID, alert.Spend, alert.Budget, alert.Month, alert.Threshold)
+	if user.AlertWebhook == "" {
+		return nil
+	}
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, user.AlertWebhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package bundler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+)
+
+const defaultTimeout = 10 * time.Second
+
+type request struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      uint64 `json:"id"`
+	Method  string `json:"method"`
+	Params  []any  `json:"params"`
+}
+
+type response struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int             `json:"code"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	} `json:"error"`
+}
+
+// Client calls the ERC-4337 JSON-RPC methods of a bundler.
+type Client struct {
+	URL    string
+	client *http.Client
+	id     uint64
+}
+
+func NewClient(url string) *Client {
+	return &Client{
+		URL:    url,
+		client: &http.Client{Timeout: defaultTimeout},
+	}
+}
+
+// Call invokes method and returns the raw result. Errors answered by the
+// bundler are returned as RPCErrors so they reach the client unchanged.
+func (c *Client) Call(ctx context.Context, method string, params ...any) (json.RawMessage, error) {
+	body, err := json.Marshal(&request{
+		JSONRPC: "2.0",
+		ID:      atomic.AddUint64(&c.id, 1),
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var result response
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("bundler %s status %d: %v", method, resp.StatusCode, err)
+	}
+	if result.Error != nil {
+		var data any
+		if len(result.Error.Data) > 0 {
+			data = result.Error.Data
+		}
+		return nil, rpcerrors.NewRPCError(result.Error.Code, result.Error.Message, data)
+	}
+	return result.Result, nil
+}
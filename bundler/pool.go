@@ -0,0 +1,118 @@
+package bundler
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+)
+
+// Pool spreads calls over several bundlers of a chain. Bundlers are tried
+// in configured order, skipping unhealthy ones, and a bundler that cannot be
+// reached is marked unhealthy until the next successful health check.
+type Pool struct {
+	clients []*Client
+	mu      sync.RWMutex
+	healthy map[*Client]bool
+}
+
+func NewPool(urls []string) *Pool {
+	p := &Pool{healthy: make(map[*Client]bool, len(urls))}
+	for _, url := range urls {
+		client := NewClient(url)
+		p.clients = append(p.clients, client)
+		p.healthy[client] = true
+	}
+	return p
+}
+
+// URLs returns the bundler endpoints of the pool.
+func (p *Pool) URLs() []string {
+	urls := make([]string, len(p.clients))
+	for n, client := range p.clients {
+		urls[n] = client.URL
+	}
+	return urls
+}
+
+// Healthy reports the health of each bundler by URL.
+func (p *Pool) Healthy() map[string]bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	health := make(map[string]bool, len(p.clients))
+	for _, client := range p.clients {
+		health[client.URL] = p.healthy[client]
+	}
+	return health
+}
+
+func (p *Pool) setHealthy(client *Client, healthy bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.healthy[client] != healthy {
+		logger.S().Infof("Bundler %s healthy: %v", client.URL, healthy)
+	}
+	p.healthy[client] = healthy
+}
+
+// candidates returns the healthy bundlers followed by the unhealthy ones, so
+// a call is still attempted when every health check failed.
+func (p *Pool) candidates() []*Client {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	healthy := make([]*Client, 0, len(p.clients))
+	var unhealthy []*Client
+	for _, client := range p.clients {
+		if p.healthy[client] {
+			healthy = append(healthy, client)
+		} else {
+			unhealthy = append(unhealthy, client)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// Call invokes method on the first bundler that answers and returns its URL
+// with the result. Errors answered by a bundler are not retried elsewhere.
+func (p *Pool) Call(ctx context.Context, method string, params ...any) (json.RawMessage, string, error) {
+	var err error
+	for _, client := range p.candidates() {
+		var result json.RawMessage
+		result, err = client.Call(ctx, method, params...)
+		if _, ok := err.(*rpcerrors.RPCError); err == nil || ok {
+			return result, client.URL, err
+		}
+		logger.S().Warnf("bundler %s %s error: %v", client.URL, method, err)
+		p.setHealthy(client, false)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, "", err
+}
+
+// Run checks every bundler each interval until ctx is cancelled.
+func (p *Pool) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.check(ctx)
+		}
+	}
+}
+
+func (p *Pool) check(ctx context.Context) {
+	for _, client := range p.clients {
+		checkCtx, cancel := context.WithTimeout(ctx, defaultTimeout)
+		_, err := client.Call(checkCtx, "eth_supportedEntryPoints")
+		cancel()
+		p.setHealthy(client, err == nil)
+	}
+}
@@ -0,0 +1,51 @@
+package bundler
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// Profile raises the preVerificationGas of sponsored operations to what a
+// bundler implementation accepts: by Percent, then by Overhead gas.
+type Profile struct {
+	Percent  int64 `json:"percent"`
+	Overhead int64 `json:"overhead"`
+}
+
+// DefaultProfiles are the built in adjustment profiles by bundler.
+var DefaultProfiles = map[string]*Profile{
+	"stackup": {Percent: 5},
+	"pimlico": {Percent: 10, Overhead: 1000},
+	"alchemy": {Percent: 10, Overhead: 5000},
+}
+
+// LoadProfiles returns the built in profiles overridden and extended by the
+// JSON object overrides, keyed by profile name.
+func LoadProfiles(overrides string) (map[string]*Profile, error) {
+	profiles := make(map[string]*Profile, len(DefaultProfiles))
+	for name, p := range DefaultProfiles {
+		profiles[name] = p
+	}
+	if overrides == "" {
+		return profiles, nil
+	}
+	var custom map[string]*Profile
+	if err := json.Unmarshal([]byte(overrides), &custom); err != nil {
+		return nil, fmt.Errorf("parse preVerificationGas profiles: %v", err)
+	}
+	for name, p := range custom {
+		if p == nil || p.Percent < 0 || p.Overhead < 0 {
+			return nil, fmt.Errorf("invalid preVerificationGas profile %q", name)
+		}
+		profiles[name] = p
+	}
+	return profiles, nil
+}
+
+// Adjust returns the preVerificationGas to sign for pvg.
+func (p *Profile) Adjust(pvg *big.Int) *big.Int {
+	adjusted := new(big.Int).Mul(pvg, big.NewInt(100+p.Percent))
+	adjusted.Div(adjusted, big.NewInt(100))
+	return adjusted.Add(adjusted, big.NewInt(p.Overhead))
+}
@@ -0,0 +1,343 @@
+package bundler
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/ququzone/verifying-paymaster-service/chain"
+	"github.com/ququzone/verifying-paymaster-service/contracts"
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/types"
+)
+
+type SelfConfig struct {
+	// Key pays for the handleOps transactions.
+	Key        *ecdsa.PrivateKey
+	ChainID    *big.Int
+	EntryPoint common.Address
+	// Beneficiary receives the operation fees, the Key address when zero.
+	Beneficiary common.Address
+	// MaxBatch is the maximum number of operations per transaction.
+	MaxBatch int
+	Interval time.Duration
+	// ResendAfter replaces a transaction that was not mined in time with
+	// higher fees.
+	ResendAfter time.Duration
+}
+
+// batch is the handleOps transaction in flight.
+type batch struct {
+	ids    []uint
+	ops    []contracts.UserOperation
+	tx     *ethtypes.Transaction
+	sentAt time.Time
+	// replaced are the earlier transactions of the batch, any of them may
+	// still be mined instead of tx
+	replaced []common.Hash
+}
+
+// SelfBundler submits queued sponsored operations to the EntryPoint with
+// handleOps from its own EOA, one transaction in flight at a time. Each
+// operation is estimated alone first so an invalid one fails by itself
+// instead of reverting the batch.
+type SelfBundler struct {
+	conf       *SelfConfig
+	client     chain.Client
+	rep        db.Repository
+	entryPoint *contracts.EntryPointTransactor
+	from       common.Address
+	pending    *batch
+}
+
+func NewSelfBundler(conf *SelfConfig, client chain.Client, rep db.Repository) (*SelfBundler, error) {
+	entryPoint, err := contracts.NewEntryPointTransactor(conf.EntryPoint, client)
+	if err != nil {
+		return nil, err
+	}
+	from := crypto.PubkeyToAddress(conf.Key.PublicKey)
+	if conf.Beneficiary == (common.Address{}) {
+		conf.Beneficiary = from
+	}
+	if conf.MaxBatch <= 0 {
+		conf.MaxBatch = 10
+	}
+	if conf.Interval == 0 {
+		conf.Interval = 5 * time.Second
+	}
+	if conf.ResendAfter == 0 {
+		conf.ResendAfter = 2 * time.Minute
+	}
+	return &SelfBundler{
+		conf:       conf,
+		client:     client,
+		rep:        rep,
+		entryPoint: entryPoint,
+		from:       from,
+	}, nil
+}
+
+// Address returns the EOA sending the transactions.
+func (b *SelfBundler) Address() common.Address {
+	return b.from
+}
+
+// Run bundles queued operations every interval until ctx is cancelled.
+func (b *SelfBundler) Run(ctx context.Context) {
+	logger.S().Infof("Self bundler %s started for chain %s", b.from, b.conf.ChainID)
+	for {
+		if err := b.step(ctx, time.Now()); err != nil {
+			logger.S().Errorf("self bundler error: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(b.conf.Interval):
+		}
+	}
+}
+
+func (b *SelfBundler) step(ctx context.Context, now time.Time) error {
+	sent, err := (&models.Submission{}).FindSent(b.rep, b.conf.ChainID.Uint64())
+	if err != nil {
+		return err
+	}
+	if len(sent) > 0 {
+		return b.track(ctx, sent, now)
+	}
+	b.pending = nil
+	return b.send(ctx, now)
+}
+
+// track settles the submissions of mined transactions and replaces stale
+// ones.
+func (b *SelfBundler) track(ctx context.Context, sent []models.Submission, now time.Time) error {
+	byTx := make(map[string][]uint)
+	sentAt := make(map[string]time.Time)
+	var order []string
+	for _, rec := range sent {
+		if _, ok := byTx[rec.TxHash]; !ok {
+			order = append(order, rec.TxHash)
+			sentAt[rec.TxHash] = rec.UpdatedAt
+		}
+		byTx[rec.TxHash] = append(byTx[rec.TxHash], rec.ID)
+	}
+	for _, hash := range order {
+		ids := byTx[hash]
+		inFlight := b.pending != nil && b.pending.tx.Hash().Hex() == hash
+		hashes := []common.Hash{common.HexToHash(hash)}
+		if inFlight {
+			hashes = append(hashes, b.pending.replaced...)
+		}
+		receipt, err := b.receipt(ctx, hashes)
+		if err != nil {
+			return err
+		}
+		if receipt != nil {
+			status := models.SubmissionIncluded
+			if receipt.Status != ethtypes.ReceiptStatusSuccessful {
+				status = models.SubmissionFailed
+			}
+			logger.S().Infof("Self bundled transaction %s mined with status %d", receipt.TxHash.Hex(), receipt.Status)
+			err := b.update(ids, map[string]any{"status": status, "tx_hash": receipt.TxHash.Hex()})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if inFlight {
+			if now.Sub(b.pending.sentAt) < b.conf.ResendAfter {
+				continue
+			}
+			if err := b.replace(ctx, now); err != nil {
+				return err
+			}
+			continue
+		}
+		// sent before a restart, requeue once it is stale
+		if now.Sub(sentAt[hash]) >= b.conf.ResendAfter {
+			logger.S().Warnf("Self bundled transaction %s not mined, requeueing %d operations", hash, len(ids))
+			if err := b.update(ids, map[string]any{"status": models.SubmissionQueued, "tx_hash": ""}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// send estimates the queued operations one by one and submits the valid
+// ones in a single handleOps transaction.
+func (b *SelfBundler) send(ctx context.Context, now time.Time) error {
+	queued, err := (&models.Submission{}).FindQueued(b.rep, b.conf.ChainID.Uint64(), b.conf.MaxBatch)
+	if err != nil || len(queued) == 0 {
+		return err
+	}
+	nonce, err := b.client.PendingNonceAt(ctx, b.from)
+	if err != nil {
+		return err
+	}
+	opts, err := b.transactOpts(ctx, nonce, nil)
+	if err != nil {
+		return err
+	}
+
+	next := &batch{}
+	var gas uint64
+	for _, rec := range queued {
+		op, err := decodeOp(rec.Op)
+		if err == nil {
+			opts.NoSend = true
+			var tx *ethtypes.Transaction
+			tx, err = b.entryPoint.HandleOps(opts, []contracts.UserOperation{op}, b.conf.Beneficiary)
+			if err == nil {
+				gas += tx.Gas()
+				next.ids = append(next.ids, rec.ID)
+				next.ops = append(next.ops, op)
+				continue
+			}
+		}
+		logger.S().Warnf("Self bundler dropped user operation %s: %v", rec.UserOpHash, err)
+		if err := b.update([]uint{rec.ID}, map[string]any{"status": models.SubmissionFailed, "error": err.Error()}); err != nil {
+			return err
+		}
+	}
+	if len(next.ops) == 0 {
+		return nil
+	}
+
+	opts.NoSend = false
+	opts.GasLimit = gas
+	next.tx, err = b.entryPoint.HandleOps(opts, next.ops, b.conf.Beneficiary)
+	if err != nil {
+		return err
+	}
+	next.sentAt = now
+	b.pending = next
+	logger.S().Infof("Self bundler sent %d user operations in %s", len(next.ops), next.tx.Hash().Hex())
+	return b.update(next.ids, map[string]any{"status": models.SubmissionSent, "tx_hash": next.tx.Hash().Hex()})
+}
+
+// replace resends the pending batch with the same nonce and bumped fees.
+func (b *SelfBundler) replace(ctx context.Context, now time.Time) error {
+	prev := b.pending.tx
+	opts, err := b.transactOpts(ctx, prev.Nonce(), prev)
+	if err != nil {
+		return err
+	}
+	opts.GasLimit = prev.Gas()
+	tx, err := b.entryPoint.HandleOps(opts, b.pending.ops, b.conf.Beneficiary)
+	if err != nil {
+		return err
+	}
+	logger.S().Warnf("Self bundler replaced %s with %s", prev.Hash().Hex(), tx.Hash().Hex())
+	b.pending.replaced = append(b.pending.replaced, prev.Hash())
+	b.pending.tx = tx
+	b.pending.sentAt = now
+	return b.update(b.pending.ids, map[string]any{"tx_hash": tx.Hash().Hex()})
+}
+
+// transactOpts prices a transaction at the current network fees, at least
+// 25% above the fees of prev when replacing it.
+func (b *SelfBundler) transactOpts(ctx context.Context, nonce uint64, prev *ethtypes.Transaction) (*bind.TransactOpts, error) {
+	opts, err := bind.NewKeyedTransactorWithChainID(b.conf.Key, b.conf.ChainID)
+	if err != nil {
+		return nil, err
+	}
+	opts.Context = ctx
+	opts.Nonce = new(big.Int).SetUint64(nonce)
+
+	head, err := b.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if head.BaseFee == nil {
+		opts.GasPrice, err = b.client.SuggestGasPrice(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if prev != nil {
+			opts.GasPrice = maxBig(opts.GasPrice, bump(prev.GasPrice()))
+		}
+		return opts, nil
+	}
+	opts.GasTipCap, err = b.client.SuggestGasTipCap(ctx)
+	if err != nil {
+		return nil, err
+	}
+	opts.GasFeeCap = new(big.Int).Add(new(big.Int).Mul(head.BaseFee, big.NewInt(2)), opts.GasTipCap)
+	if prev != nil {
+		opts.GasTipCap = maxBig(opts.GasTipCap, bump(prev.GasTipCap()))
+		opts.GasFeeCap = maxBig(opts.GasFeeCap, bump(prev.GasFeeCap()))
+	}
+	return opts, nil
+}
+
+// receipt returns the receipt of the first mined of hashes, nil when none is
+// mined yet.
+func (b *SelfBundler) receipt(ctx context.Context, hashes []common.Hash) (*ethtypes.Receipt, error) {
+	for _, hash := range hashes {
+		receipt, err := b.client.TransactionReceipt(ctx, hash)
+		if err == ethereum.NotFound {
+			continue
+		}
+		return receipt, err
+	}
+	return nil, nil
+}
+
+func (b *SelfBundler) update(ids []uint, values map[string]any) error {
+	return b.rep.Model(&models.Submission{}).Where(`"id" IN ?`, ids).Updates(values).Error
+}
+
+func bump(fee *big.Int) *big.Int {
+	bumped := new(big.Int).Mul(fee, big.NewInt(125))
+	return bumped.Div(bumped, big.NewInt(100))
+}
+
+func maxBig(a, b *big.Int) *big.Int {
+	if a.Cmp(b) >= 0 {
+		return a
+	}
+	return b
+}
+
+// EncodeOp serializes op for the submission queue.
+func EncodeOp(op *types.UserOperation) (string, error) {
+	data, err := op.MarshalJSON()
+	return string(data), err
+}
+
+func decodeOp(data string) (contracts.UserOperation, error) {
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(data), &fields); err != nil {
+		return contracts.UserOperation{}, fmt.Errorf("decode queued operation: %v", err)
+	}
+	op, err := types.NewUserOperation(fields)
+	if err != nil {
+		return contracts.UserOperation{}, err
+	}
+	return contracts.UserOperation{
+		Sender:               op.Sender,
+		Nonce:                op.Nonce,
+		InitCode:             op.InitCode,
+		CallData:             op.CallData,
+		CallGasLimit:         op.CallGasLimit,
+		VerificationGasLimit: op.VerificationGasLimit,
+		PreVerificationGas:   op.PreVerificationGas,
+		MaxFeePerGas:         op.MaxFeePerGas,
+		MaxPriorityFeePerGas: op.MaxPriorityFeePerGas,
+		PaymasterAndData:     op.PaymasterAndData,
+		Signature:            op.Signature,
+	}, nil
+}
@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+type apiKeyEntry struct {
+	key     *models.ApiKeys
+	expires time.Time
+}
+
+// ApiKeys serves api keys by key from memory for a TTL. Unknown keys are
+// not kept, so new keys work at once.
+type ApiKeys struct {
+	rep     db.Repository
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]*apiKeyEntry
+}
+
+func NewApiKeys(rep db.Repository, ttl time.Duration) *ApiKeys {
+	return &ApiKeys{rep: rep, ttl: ttl, entries: make(map[string]*apiKeyEntry)}
+}
+
+// Find returns the api key of key, nil when there is none.
+func (c *ApiKeys) Find(key string) (*models.ApiKeys, error) {
+	now := time.Now()
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.key, nil
+	}
+
+	apiKey, err := (&models.ApiKeys{}).FindByKey(c.rep, key)
+	if err != nil || apiKey == nil {
+		return apiKey, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = &apiKeyEntry{key: apiKey, expires: now.Add(c.ttl)}
+	return apiKey, nil
+}
+
+// Drop removes the api key with id, subject of an ApiKey invalidation.
+func (c *ApiKeys) Drop(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, e := range c.entries {
+		if strconv.FormatUint(uint64(e.key.ID), 10) == id {
+			delete(c.entries, k)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Package cache keeps hot database rows in memory and broadcasts their
+// invalidation to every replica.
+package cache
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/store"
+)
+
+// channel is the store channel invalidations are published on.
+const channel = "cache:invalidate"
+
+// Kinds of invalidated entries, the subject identifies the entry within the
+// kind.
+const (
+	// Config is the pm_config response, subject is empty.
+	Config = "config"
+	// ApiKey is an api key, subject is its id.
+	ApiKey = "api_key"
+	// Throttle is a client fingerprint throttle, subject is the
+	// fingerprint.
+	Throttle = "throttle"
+	// ErrorTemplates are the error message templates, subject is the
+	// slug.
+	ErrorTemplates = "error_templates"
+)
+
+// Bus delivers invalidations published on any replica to the handlers of
+// this one.
+type Bus struct {
+	store    store.Store
+	mu       sync.Mutex
+	handlers map[string][]func(subject string)
+}
+
+func NewBus(st store.Store) *Bus {
+	return &Bus{store: st, handlers: make(map[string][]func(string))}
+}
+
+// On calls fn with the subject of every invalidation of kind.
+func (b *Bus) On(kind string, fn func(subject string)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[kind] = append(b.handlers[kind], fn)
+}
+
+// Publish invalidates subject of kind on every replica. Errors are logged,
+// the entries then expire with their TTL.
+func (b *Bus) Publish(ctx context.Context, kind, subject string) {
+	if err := b.store.Publish(ctx, channel, kind+":"+subject); err != nil {
+		logger.S().Errorf("publish %s %s invalidation error: %v", kind, subject, err)
+	}
+}
+
+// Run delivers the invalidations until ctx is cancelled.
+func (b *Bus) Run(ctx context.Context) {
+	b.store.Subscribe(ctx, channel, b.dispatch)
+}
+
+func (b *Bus) dispatch(message string) {
+	kind, subject, _ := strings.Cut(message, ":")
+	b.mu.Lock()
+	handlers := b.handlers[kind]
+	b.mu.Unlock()
+	for _, fn := range handlers {
+		fn(subject)
+	}
+}
@@ -0,0 +1,33 @@
+package chain
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/ququzone/verifying-paymaster-service/contracts"
+)
+
+//go:generate mockgen -destination=mocks/chain.go -package=mocks . Client,Paymaster,VipNFT
+
+// Client is the subset of ethclient.Client used by the service.
+type Client interface {
+	bind.ContractBackend
+	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+	ChainID(ctx context.Context) (*big.Int, error)
+	TransactionByHash(ctx context.Context, hash common.Hash) (tx *types.Transaction, isPending bool, err error)
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+}
+
+// Paymaster is the subset of the VerifyingPaymaster binding used by the service.
+type Paymaster interface {
+	GetHash(opts *bind.CallOpts, userOp contracts.UserOperation, validUntil *big.Int, validAfter *big.Int) ([32]byte, error)
+}
+
+// VipNFT is the subset of the VipNFT binding used by the service.
+type VipNFT interface {
+	TokenOfOwnerByIndex(opts *bind.CallOpts, owner common.Address, index *big.Int) (*big.Int, error)
+}
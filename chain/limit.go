@@ -0,0 +1,222 @@
+package chain
+
+import (
+	"context"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/metrics"
+)
+
+// ErrBusy is returned by the calls of a Limited client refused because the
+// rpc node of the chain is saturated.
+var ErrBusy = rpcerrors.NewRPCError(rpcerrors.RATE_LIMITED, "chain node busy", map[string]any{
+	"reason":     rpcerrors.REASON_CHAIN_BUSY,
+	"retryAfter": 1,
+})
+
+// Limited bounds the calls in flight to the rpc node of a chain, so traffic
+// spikes queue in the service instead of hitting the rate limits of the
+// provider. Calls over Concurrency wait for a slot, at most Queue of them
+// for up to Timeout, the others fail with ErrBusy. Log subscriptions are
+// long lived and not limited.
+type Limited struct {
+	Client
+	Concurrency int
+	Queue       int
+	Timeout     time.Duration
+
+	slots   chan struct{}
+	waiting atomic.Int64
+	metrics *metrics.ChainCalls
+}
+
+// NewLimited limits the calls of client to the rpc node of chainID.
+func NewLimited(client Client, chainID *big.Int, concurrency, queue int, timeout time.Duration) *Limited {
+	return &Limited{
+		Client:      client,
+		Concurrency: concurrency,
+		Queue:       queue,
+		Timeout:     timeout,
+		slots:       make(chan struct{}, concurrency),
+		metrics:     metrics.NewChainCalls(chainID),
+	}
+}
+
+// acquire waits for a slot and returns the function releasing it.
+func (l *Limited) acquire(ctx context.Context) (func(), error) {
+	select {
+	case l.slots <- struct{}{}:
+		return l.started(), nil
+	default:
+	}
+
+	if l.waiting.Add(1) > int64(l.Queue) {
+		l.waiting.Add(-1)
+		l.metrics.Reject(metrics.ChainQueueFull)
+		return nil, ErrBusy
+	}
+	l.metrics.Queued.Inc()
+	start := time.Now()
+	defer func() {
+		l.waiting.Add(-1)
+		l.metrics.Queued.Dec()
+		l.metrics.ObserveWait(time.Since(start))
+	}()
+
+	var timeout <-chan time.Time
+	if l.Timeout > 0 {
+		timer := time.NewTimer(l.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return l.started(), nil
+	case <-timeout:
+		l.metrics.Reject(metrics.ChainQueueTimeout)
+		return nil, ErrBusy
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *Limited) started() func() {
+	l.metrics.InFlight.Inc()
+	return func() {
+		l.metrics.InFlight.Dec()
+		<-l.slots
+	}
+}
+
+func (l *Limited) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return l.Client.CodeAt(ctx, contract, blockNumber)
+}
+
+func (l *Limited) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return l.Client.CallContract(ctx, call, blockNumber)
+}
+
+func (l *Limited) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return l.Client.HeaderByNumber(ctx, number)
+}
+
+func (l *Limited) PendingCodeAt(ctx context.Context, account common.Address) ([]byte, error) {
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return l.Client.PendingCodeAt(ctx, account)
+}
+
+func (l *Limited) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	return l.Client.PendingNonceAt(ctx, account)
+}
+
+func (l *Limited) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return l.Client.SuggestGasPrice(ctx)
+}
+
+func (l *Limited) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return l.Client.SuggestGasTipCap(ctx)
+}
+
+func (l *Limited) EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	return l.Client.EstimateGas(ctx, call)
+}
+
+func (l *Limited) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return l.Client.SendTransaction(ctx, tx)
+}
+
+func (l *Limited) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return l.Client.FilterLogs(ctx, query)
+}
+
+func (l *Limited) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return l.Client.BalanceAt(ctx, account, blockNumber)
+}
+
+func (l *Limited) ChainID(ctx context.Context) (*big.Int, error) {
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return l.Client.ChainID(ctx)
+}
+
+func (l *Limited) TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error) {
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer release()
+	return l.Client.TransactionByHash(ctx, hash)
+}
+
+func (l *Limited) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return l.Client.TransactionReceipt(ctx, txHash)
+}
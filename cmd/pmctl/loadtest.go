@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/loadtest"
+)
+
+func runLoadtest(args []string) error {
+	fs := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	url := fs.String("url", "http://localhost:8888", "paymaster service base url")
+	keys := fs.String("keys", "", "comma separated api keys, optionally weighted as key:weight")
+	senders := fs.Int("senders", 1000, "number of distinct sender addresses")
+	distribution := fs.String("distribution", loadtest.DistributionZipf, "sender distribution: uniform or zipf")
+	concurrency := fs.Int("concurrency", 16, "concurrent workers")
+	duration := fs.Duration("duration", 30*time.Second, "test duration")
+	rate := fs.Float64("rate", 0, "max requests per second, 0 for unlimited")
+	readRatio := fs.Float64("read-ratio", 0.2, "share of pm_gasRemain requests")
+	entryPoint := fs.String("entrypoint", "0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789", "EntryPoint address")
+	timeout := fs.Duration("timeout", 10*time.Second, "per request timeout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	report, err := loadtest.Run(context.Background(), &loadtest.Config{
+		URL:          *url,
+		Keys:         strings.Split(*keys, ","),
+		Senders:      *senders,
+		Distribution: *distribution,
+		Concurrency:  *concurrency,
+		Duration:     *duration,
+		Rate:         *rate,
+		ReadRatio:    *readRatio,
+		EntryPoint:   *entryPoint,
+		Timeout:      *timeout,
+	})
+	if err != nil {
+		return err
+	}
+	report.Print(os.Stdout)
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []*command{
+	{name: "loadtest", usage: "fire sponsorship traffic and report latency percentiles", run: runLoadtest},
+	{name: "replay", usage: "re-send captured requests and compare outcomes", run: runReplay},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: pmctl <command> [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.usage)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, cmd := range commands {
+		if cmd.name == os.Args[1] {
+			if err := cmd.run(os.Args[2:]); err != nil {
+				log.Fatalf("%s error: %v", cmd.name, err)
+			}
+			return
+		}
+	}
+	usage()
+	os.Exit(2)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/recorder"
+)
+
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	file := fs.String("file", "capture.jsonl", "capture file written by the service")
+	url := fs.String("url", "http://localhost:8888", "target service base url")
+	keys := fs.String("keys", "", "comma separated label=key mappings, use *=key for all labels")
+	methods := fs.String("methods", "pm_sponsorUserOperation", "comma separated methods to replay, empty for all")
+	rate := fs.Float64("rate", 10, "max requests per second, 0 for unlimited")
+	timeout := fs.Duration("timeout", 10*time.Second, "per request timeout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	mapping := make(map[string]string)
+	for _, item := range strings.Split(*keys, ",") {
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid key mapping: %s", item)
+		}
+		mapping[parts[0]] = parts[1]
+	}
+	if len(mapping) == 0 {
+		return fmt.Errorf("at least one key mapping is required")
+	}
+
+	var methodList []string
+	if *methods != "" {
+		methodList = strings.Split(*methods, ",")
+	}
+	result, err := recorder.Replay(context.Background(), &recorder.ReplayConfig{
+		File:    *file,
+		URL:     *url,
+		Keys:    mapping,
+		Methods: methodList,
+		Rate:    *rate,
+		Timeout: *timeout,
+	})
+	if err != nil {
+		return err
+	}
+	result.Print(os.Stdout)
+	return nil
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/spf13/viper"
+)
+
+// Chain is the configuration of one network.
+type Chain struct {
+	// ChainID must match the RPC, zero takes it from the RPC.
+	ChainID     uint64 `json:"chainId"`
+	RPC         string `json:"rpc"`
+	EntryPoint  string `json:"entryPoint"`
+	Contract    string `json:"contract"`
+	VipContract string `json:"vipContract"`
+	CreateGas   string `json:"createGas"`
+	MaxGas      string `json:"maxGas"`
+	VipMaxGas   string `json:"vipMaxGas"`
+
+	// gas limit bounds, zero disables a bound
+	MinVerificationGas    uint64 `json:"minVerificationGas"`
+	MaxVerificationGas    uint64 `json:"maxVerificationGas"`
+	MinPreVerificationGas uint64 `json:"minPreVerificationGas"`
+	MaxPreVerificationGas uint64 `json:"maxPreVerificationGas"`
+	// MaxPrefund is the per operation prefund ceiling in wei, empty disables it
+	MaxPrefund string `json:"maxPrefund"`
+	// MaxOpCost is the per operation sponsored cost ceiling in wei, empty
+	// disables it
+	MaxOpCost string `json:"maxOpCost"`
+	// MaxSignedCost caps the max gas cost in wei and MaxSignedGas the gas
+	// limits signed on the chain across all api keys per SIGNED_COST_WINDOW,
+	// empty or 0 disables them
+	MaxSignedCost string `json:"maxSignedCost"`
+	MaxSignedGas  uint64 `json:"maxSignedGas"`
+	// MaxBlockSignedGas caps the gas limits signed on the chain across all
+	// api keys per block, 0 disables it
+	MaxBlockSignedGas uint64 `json:"maxBlockSignedGas"`
+	// GasMarkupPercent and GasMarkup raise the estimated verification and
+	// call gas limits before signing.
+	GasMarkupPercent uint64 `json:"gasMarkupPercent"`
+	GasMarkup        uint64 `json:"gasMarkup"`
+	// Bundlers are the bundler endpoints of the chain in order of
+	// preference, none disables the proxy.
+	Bundlers []string `json:"bundlers"`
+	// Bundler is a single endpoint, added in front of Bundlers.
+	Bundler string `json:"bundler"`
+	// SelfBundlerKey is the key of the EOA sending handleOps on the chain,
+	// empty disables self bundling.
+	SelfBundlerKey string `json:"selfBundlerKey"`
+	// QuotaRate converts the gas cost to the shared quota unit, in units
+	// per native token (1e18 wei). Required when QUOTA_UNIT is set.
+	QuotaRate string `json:"quotaRate"`
+	// RPCConcurrency and RPCQueue bound the calls to the rpc node of the
+	// chain, see RPC_CONCURRENCY.
+	RPCConcurrency int `json:"rpcConcurrency"`
+	RPCQueue       int `json:"rpcQueue"`
+
+	// IndexerStartBlock is the first block indexed on the chain.
+	IndexerStartBlock uint64 `json:"indexerStartBlock"`
+	// ReceiptContract is the receipt NFT of the chain, empty disables the
+	// relayer on it, and ReceiptStartBlock the first inclusion receipted.
+	ReceiptContract   string `json:"receiptContract"`
+	ReceiptStartBlock uint64 `json:"receiptStartBlock"`
+}
+
+// Rate parses QuotaRate.
+func (c *Chain) Rate() (*big.Rat, bool) {
+	rate, ok := new(big.Rat).SetString(c.QuotaRate)
+	return rate, ok && rate.Sign() > 0
+}
+
+// loadChains returns the chains of CHAINS_FILE, a JSON array of Chain whose
+// omitted fields default to the top level settings. Without a file the top
+// level settings are the only chain.
+func loadChains(settings *viper.Viper, v *Values) ([]*Chain, error) {
+	base := Chain{
+		ChainID:               settings.GetUint64("CHAIN_ID"),
+		RPC:                   v.RPC,
+		EntryPoint:            v.EntryPoint,
+		Contract:              v.Contract,
+		VipContract:           v.VipContract,
+		CreateGas:             v.CreateGas,
+		MaxGas:                v.MaxGas,
+		VipMaxGas:             v.VipMaxGas,
+		MinVerificationGas:    v.MinVerificationGas,
+		MaxVerificationGas:    v.MaxVerificationGas,
+		MinPreVerificationGas: v.MinPreVerificationGas,
+		MaxPreVerificationGas: v.MaxPreVerificationGas,
+		MaxPrefund:            v.MaxPrefund,
+		MaxOpCost:             v.MaxOpCost,
+		MaxSignedCost:         v.MaxSignedCost,
+		MaxSignedGas:          v.MaxSignedGas,
+		MaxBlockSignedGas:     v.MaxBlockSignedGas,
+		GasMarkupPercent:      v.GasMarkupPercent,
+		GasMarkup:             v.GasMarkup,
+		Bundlers:              v.Bundlers,
+		SelfBundlerKey:        v.SelfBundlerKey,
+		QuotaRate:             v.QuotaRate,
+		RPCConcurrency:        v.RpcConcurrency,
+		RPCQueue:              v.RpcQueue,
+		IndexerStartBlock:     v.IndexerStartBlock,
+		ReceiptContract:       v.ReceiptContract,
+		ReceiptStartBlock:     v.ReceiptStartBlock,
+	}
+	file := settings.GetString("CHAINS_FILE")
+	if file == "" {
+		if _, ok := base.Rate(); v.QuotaUnit != "" && !ok {
+			return nil, fmt.Errorf("QUOTA_RATE required with QUOTA_UNIT")
+		}
+		return []*Chain{&base}, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var entries []json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parse %s: %v", file, err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%s: no chains", file)
+	}
+	seen := make(map[uint64]bool, len(entries))
+	chains := make([]*Chain, len(entries))
+	for n, entry := range entries {
+		chain := base
+		if err := json.Unmarshal(entry, &chain); err != nil {
+			return nil, fmt.Errorf("parse %s: chain %d: %v", file, n, err)
+		}
+		if chain.Bundler != "" {
+			chain.Bundlers = append([]string{chain.Bundler}, chain.Bundlers...)
+			chain.Bundler = ""
+		}
+		if chain.ChainID == 0 {
+			return nil, fmt.Errorf("%s: chain %d: chainId required", file, n)
+		}
+		if seen[chain.ChainID] {
+			return nil, fmt.Errorf("%s: duplicate chainId %d", file, chain.ChainID)
+		}
+		seen[chain.ChainID] = true
+		if _, ok := chain.Rate(); v.QuotaUnit != "" && !ok {
+			return nil, fmt.Errorf("%s: chain %d: quotaRate required with QUOTA_UNIT", file, chain.ChainID)
+		}
+		chains[n] = &chain
+	}
+	return chains, nil
+}
@@ -1,7 +1,11 @@
 package config
 
 import (
+	"fmt"
 	"log"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/spf13/viper"
@@ -9,6 +13,14 @@ import (
 
 var values *Values
 
+// networkName is the form of network names, which prefix their routes.
+var networkName = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// reservedPaths are the route prefixes a network cannot take.
+var reservedPaths = map[string]bool{
+	"rpc": true, "admin": true, "bot": true, "billing": true, "ping": true, "metrics": true,
+}
+
 type Values struct {
 	// database
 	DbHost     string
@@ -16,24 +28,387 @@ type Values struct {
 	DbUser     string
 	DbName     string
 	DbPassword string
+	// DbSlowQuery is the duration statements are logged as slow after, 0
+	// disables the log. DbExplainSlow checks the plan of slow queries for
+	// sequential scans
+	DbSlowQuery   time.Duration
+	DbExplainSlow bool
 
 	PrivateKey  string
 	Port        int
 	GinMode     string
 	RPC         string
 	Contract    string
+	EntryPoint  string
 	MaxGas      string
 	CreateGas   string
 	VipMaxGas   string
 	VipContract string
+	Simulate    bool
+	// simulation results are reused for SimulationCacheTTL while the chain
+	// head stays the same, 0 disables the cache
+	SimulationCacheTTL time.Duration
+	// at most RpcConcurrency calls per chain are sent to its rpc node, the
+	// next RpcQueue wait up to RpcQueueTimeout for a slot and the others
+	// fail, 0 RpcConcurrency disables the limit
+	RpcConcurrency  int
+	RpcQueue        int
+	RpcQueueTimeout time.Duration
+	// Chains are the served networks, the first is the default. The top
+	// level chain settings above are their defaults.
+	Chains []*Chain
+	// sponsorship hash scheme of the paymaster contract, eth_sign or eip712
+	PaymasterHash string
+	EIP712Name    string
+	EIP712Version string
+
+	// gas limit bounds, zero disables a bound
+	MinVerificationGas    uint64
+	MaxVerificationGas    uint64
+	MinPreVerificationGas uint64
+	MaxPreVerificationGas uint64
+	// MaxPrefund is the per operation prefund ceiling in wei, empty disables it
+	MaxPrefund string
+	// MaxOpCost is the per operation sponsored cost ceiling in wei, empty
+	// disables it
+	MaxOpCost string
+	// MaxSignedCost caps the max gas cost in wei and MaxSignedGas the gas
+	// limits signed across all api keys per SignedCostWindow, empty or 0
+	// disables them
+	MaxSignedCost    string
+	MaxSignedGas     uint64
+	SignedCostWindow time.Duration
+	// MaxBlockSignedGas caps the gas limits signed across all api keys per
+	// chain head block, 0 disables it
+	MaxBlockSignedGas uint64
+	// markup added to the estimated verification and call gas limits
+	GasMarkupPercent uint64
+	GasMarkup        uint64
+	// Bundlers are the bundler endpoints the eth_ methods are proxied to,
+	// none disables the proxy
+	Bundlers []string
+	// BundlerHealthInterval is the period of the bundler health checks
+	BundlerHealthInterval time.Duration
+	// SelfBundlerKey sends sponsored operations with handleOps instead of
+	// forwarding them, empty disables self bundling
+	SelfBundlerKey         string
+	SelfBundlerBeneficiary string
+	SelfBundlerMaxBatch    int
+	SelfBundlerInterval    time.Duration
+	SelfBundlerResendAfter time.Duration
+	// PvgProfile is the default preVerificationGas adjustment profile and
+	// PvgProfiles a JSON object of custom profiles
+	PvgProfile  string
+	PvgProfiles string
+	// QuotaUnit names the common unit account quotas are kept in across
+	// chains, empty keeps them in the wei of the chain
+	QuotaUnit string
+	// QuotaRate is the default number of quota units per native token
+	QuotaRate string
+	// only sponsor deployments through verified registered factories
+	FactoryRegistry      bool
+	FactoryCheckInterval time.Duration
+	// refuse operations whose nonce is used or more than NonceMaxGap ahead
+	// of the account, negative disables the check
+	NonceMaxGap int64
+	// comma separated signature aggregators accepted for simulated
+	// operations, none when empty
+	Aggregators string
+	// comma separated Safe MultiSend and MultiSendCallOnly contracts whose
+	// delegated batches are expanded by the policy checks
+	SafeMultiSend string
+	// MaxSessionDuration bounds the lifetime of sponsorship sessions
+	MaxSessionDuration time.Duration
+	// QuotaReclaimInterval is the period of the expired allocation sweep
+	QuotaReclaimInterval time.Duration
+	// BudgetCheckInterval is the period of the project budget monitor
+	BudgetCheckInterval time.Duration
+	// Stripe billing of the usage beyond the free quota
+	StripeSecretKey     string
+	StripeWebhookSecret string
+	StripeMeterEvent    string
+	StripeUnitWei       string
+	BillingInterval     time.Duration
+	// prepaid credit packs, CreditPaymentAddress accepts on-chain payments
+	CreditPackValidity         time.Duration
+	CreditPaymentAddress       string
+	CreditPaymentConfirmations uint64
+	// free tier of projects without billing, FreeTierOps operations or
+	// FreeTierGas wei per key and day before throttling to one operation
+	// per FreeTierThrottle
+	FreeTierOps      uint64
+	FreeTierGas      string
+	FreeTierThrottle time.Duration
+	// RefreshGrace bounds the policy auto refresh of expired quotas
+	RefreshGrace time.Duration
+	// GeoIPDatabase is a MaxMind country database enabling the GeoIP access
+	// policy with the GeoIPAllow and GeoIPDeny country lists
+	GeoIPDatabase string
+	GeoIPAllow    []string
+	GeoIPDeny     []string
+	// TrustedProxies are the CIDRs whose forwarding headers give the client
+	// IP, none uses the connection address
+	TrustedProxies []string
+	// IPRateLimit is the requests per second of a client IP on /rpc, zero
+	// disables the limit
+	IPRateLimit   float64
+	IPRateBurst   int
+	IPKeyFailures int
+	IPKeyBan      time.Duration
+	// FingerprintFactor is the deviation from its baseline that throttles a
+	// client fingerprint, zero disables fingerprint throttling
+	FingerprintFactor      float64
+	FingerprintMinRequests float64
+	FingerprintMinSenders  float64
+	FingerprintWarmup      int
+	FingerprintThrottle    time.Duration
+	// abuse rules, AbuseCheckInterval zero disables them
+	AbuseCheckInterval   time.Duration
+	AbuseWindow          time.Duration
+	AbuseIPSenders       int64
+	AbuseCallDataSenders int64
+	AbuseDrainPercent    int64
+	AbuseDrainWindow     time.Duration
+	AbuseAutoPause       []string
+	// ScreeningURL is the compliance screening provider senders are checked
+	// with, empty disables screening
+	ScreeningURL      string
+	ScreeningToken    string
+	ScreeningTTL      time.Duration
+	ScreeningTimeout  time.Duration
+	ScreeningFailOpen bool
+	// DualApprovalAbove is the max gas cost in wei from which operations are
+	// held until two operators approve them, empty disables it
+	DualApprovalAbove string
+	// maintenance windows are announced MaintenanceNotice before they start
+	// to MaintenanceWebhook and the project alert webhooks
+	MaintenanceNotice  time.Duration
+	MaintenanceWebhook string
+	// sponsorship requests of api keys with replay protection need a
+	// timestamp within ReplayWindow of the server time
+	ReplayWindow time.Duration
+	// pm_quote tokens guarantee their terms for QuoteTTL
+	QuoteTTL time.Duration
+	// passkeys bound to addresses are verified for the WebAuthn relying
+	// party PasskeyRPID from PasskeyOrigins, empty disables them; their
+	// challenges are answered within PasskeyChallengeTTL. Registrations
+	// follow the PasskeyAttestation policy, none or packed, the latter
+	// trusting the PEM root certificates of the PasskeyAttestationRoots file
+	PasskeyRPID             string
+	PasskeyOrigins          []string
+	PasskeyChallengeTTL     time.Duration
+	PasskeyAttestation      string
+	PasskeyAttestationRoots string
+	// web2 identities are verified with the OAuth applications of the
+	// providers that have a client id, for codes issued to
+	// IdentityRedirectURL
+	IdentityRedirectURL string
+	GoogleClientID      string
+	GoogleClientSecret  string
+	GitHubClientID      string
+	GitHubClientSecret  string
+	// community bots claim for members whose platform proof is younger
+	// than BotProofMaxAge
+	BotProofMaxAge time.Duration
+	// api key owners are reminded ApiKeyExpiryNotice before their keys
+	// expire; rotated keys stay valid for ApiKeyRotationGrace and the new
+	// keys expire after ApiKeyLifetime, never when zero
+	ApiKeyExpiryNotice  time.Duration
+	ApiKeyRotationGrace time.Duration
+	ApiKeyLifetime      time.Duration
+	ApiKeyCheckInterval time.Duration
+	// api key webhook deliveries
+	WebhookInterval     time.Duration
+	WebhookMaxAttempts  int
+	WebhookRetention    time.Duration
+	WebhookAllowPrivate bool
+	// anomaly reports
+	AnomalyCheckInterval time.Duration
+	AnomalyWindow        int
+	AnomalyZScore        float64
+	AnomalyWebhook       string
+	// warehouse export, ExportSink is "", "s3" or "bigquery"
+	ExportSink         string
+	ExportInterval     time.Duration
+	ExportBatchSize    int
+	ExportS3Bucket     string
+	ExportS3Prefix     string
+	ExportS3Region     string
+	ExportS3Endpoint   string
+	AWSAccessKeyID     string
+	AWSSecretAccessKey string
+	AWSSessionToken    string
+	ExportBQProject    string
+	ExportBQDataset    string
+	ExportBQTable      string
+	GoogleCredentials  string
+	// MetricsEnabled serves prometheus metrics on /metrics
+	MetricsEnabled bool
+	// latency SLO: SloObjective of the requests to SloMethods, every method
+	// when empty, answer within SloLatency, 0 disables it. SloBurnAlerts are
+	// long/short:rate burn rate alerts, posted to SloAlertWebhook
+	SloLatency       time.Duration
+	SloObjective     float64
+	SloMethods       []string
+	SloBurnAlerts    string
+	SloAlertWebhook  string
+	SloCheckInterval time.Duration
+	// AdminToken is the bearer token of the /admin endpoints, empty disables them
+	AdminToken string
+	// AdminOperatorTokens are comma separated name:token pairs, each the
+	// bearer token of one operator
+	AdminOperatorTokens string
+
+	// offline mode
+	MockChain     bool
+	MockVipOwners []string
+
+	// indexer
+	IndexerEnabled       bool
+	IndexerStartBlock    uint64
+	IndexerBatchSize     uint64
+	IndexerConfirmations uint64
+	IndexerReorgDepth    uint64
+	IndexerPollInterval  time.Duration
+
+	// LeaderElection runs the background jobs on a single replica holding
+	// a Postgres advisory lock, checked every LeaderCheckInterval
+	LeaderElection      bool
+	LeaderCheckInterval time.Duration
+	// RedisURL keeps the rate limit and throttle state of the api in Redis,
+	// shared by the replicas, empty keeps it in memory
+	RedisURL string
+
+	// AttestationKey signs rpc responses when set, hex encoded private key
+	AttestationKey string
+
+	// receipt NFT relayer, enabled when the contract is set
+	ReceiptContract     string
+	ReceiptRelayerKey   string
+	ReceiptStartBlock   uint64
+	ReceiptBatchSize    int
+	ReceiptPollInterval time.Duration
+
+	// testnet faucet, enabled when the key is set: FaucetAmount wei per new
+	// account, at most FaucetDailyCap wei per UTC day and one drip per
+	// client IP every FaucetCooldown, on the FaucetChains testnets only
+	FaucetKey      string
+	FaucetChains   []string
+	FaucetAmount   string
+	FaucetDailyCap string
+	FaucetCooldown time.Duration
+	FaucetInterval time.Duration
+
+	// DepositSafetyBuffer is the wei the deposit monitor keeps on top of the
+	// outstanding sponsorships, checked every DepositCheckInterval
+	DepositSafetyBuffer  string
+	DepositCheckInterval time.Duration
+
+	// ErrorTemplates is a JSON object of error messages by locale and slug
+	// overriding the catalog ones
+	ErrorTemplates string
+
+	// traffic capture
+	CaptureFile       string
+	CaptureSampleRate float64
+
+	// Network names the settings of an additional network, empty for the
+	// top level one
+	Network string
+	// Networks are served next to the top level settings under /<network>
+	// with their own signer, database and state keys
+	Networks []*Values
 }
 
 func InitValues() error {
 	viper.SetDefault("port", 8888)
 	viper.SetDefault("gin_mode", gin.ReleaseMode)
+	viper.SetDefault("DB_SLOW_QUERY", "200ms")
+	viper.SetDefault("DB_EXPLAIN_SLOW", true)
 	viper.SetDefault("CREATE_GAS", "5000000000000000000")
 	viper.SetDefault("MAX_GAS", "2000000000000000000")
 	viper.SetDefault("VIP_MAX_GAS", "10000000000000000000")
+	viper.SetDefault("MAX_VERIFICATION_GAS", 1500000)
+	viper.SetDefault("MAX_PRE_VERIFICATION_GAS", 1000000)
+	viper.SetDefault("CAPTURE_SAMPLE_RATE", 1)
+	viper.SetDefault("INDEXER_ENABLED", true)
+	viper.SetDefault("INDEXER_BATCH_SIZE", 2000)
+	viper.SetDefault("INDEXER_REORG_DEPTH", 64)
+	viper.SetDefault("INDEXER_POLL_INTERVAL", "15s")
+	viper.SetDefault("LEADER_CHECK_INTERVAL", "10s")
+	viper.SetDefault("FACTORY_CHECK_INTERVAL", "10m")
+	viper.SetDefault("PAYMASTER_HASH", "eth_sign")
+	viper.SetDefault("EIP712_NAME", "VerifyingPaymaster")
+	viper.SetDefault("EIP712_VERSION", "1")
+	viper.SetDefault("MAX_SESSION_DURATION", "24h")
+	viper.SetDefault("QUOTA_RECLAIM_INTERVAL", "1m")
+	viper.SetDefault("BUDGET_CHECK_INTERVAL", "5m")
+	viper.SetDefault("ANOMALY_CHECK_INTERVAL", "1h")
+	viper.SetDefault("METRICS_ENABLED", true)
+	viper.SetDefault("SLO_LATENCY", "0s")
+	viper.SetDefault("SLO_OBJECTIVE", 0.99)
+	viper.SetDefault("SLO_BURN_ALERTS", "1h/5m:14.4,6h/30m:6")
+	viper.SetDefault("SLO_CHECK_INTERVAL", "1m")
+	viper.SetDefault("EXPORT_INTERVAL", "1h")
+	viper.SetDefault("EXPORT_BATCH_SIZE", 1000)
+	viper.SetDefault("ANOMALY_WINDOW", 14)
+	viper.SetDefault("ANOMALY_ZSCORE", 3)
+	viper.SetDefault("RECEIPT_BATCH_SIZE", 100)
+	viper.SetDefault("RECEIPT_POLL_INTERVAL", "1m")
+	viper.SetDefault("FAUCET_AMOUNT", "10000000000000000")
+	viper.SetDefault("FAUCET_COOLDOWN", "24h")
+	viper.SetDefault("FAUCET_INTERVAL", "15s")
+	viper.SetDefault("BUNDLER_HEALTH_INTERVAL", "30s")
+	viper.SetDefault("STRIPE_METER_EVENT", "paymaster_gas")
+	viper.SetDefault("STRIPE_UNIT_WEI", "1000000000")
+	viper.SetDefault("BILLING_INTERVAL", "1h")
+	viper.SetDefault("CREDIT_PACK_VALIDITY", "8760h")
+	viper.SetDefault("CREDIT_PAYMENT_CONFIRMATIONS", 12)
+	viper.SetDefault("FREE_TIER_THROTTLE", "1m")
+	viper.SetDefault("SIGNED_COST_WINDOW", "1m")
+	viper.SetDefault("NONCE_MAX_GAP", 10)
+	viper.SetDefault("SAFE_MULTISEND", "0x38869bf66a61cF6bDB996A6aE40D5853Fd43B526,0x9641d764fc13c8B624c04430C7356C1C7C8102e2,"+
+		"0xA238CBeb142c10Ef7Ad8442C6D1f9E89e07e7761,0x40A2aCCbd92BCA938b02010E17A5b8929b49130D,"+
+		"0x998739BFdAAdde7C933B942a68053933098f9EDa,0xA1dabEF33b3B82c7814B6D82A79e50F4AC44102B")
+	viper.SetDefault("SIMULATION_CACHE_TTL", "15s")
+	viper.SetDefault("RPC_CONCURRENCY", 32)
+	viper.SetDefault("RPC_QUEUE", 512)
+	viper.SetDefault("RPC_QUEUE_TIMEOUT", "5s")
+	viper.SetDefault("MAINTENANCE_NOTICE", "24h")
+	viper.SetDefault("REPLAY_WINDOW", "5m")
+	viper.SetDefault("QUOTE_TTL", "60s")
+	viper.SetDefault("PASSKEY_CHALLENGE_TTL", "5m")
+	viper.SetDefault("PASSKEY_ATTESTATION", "none")
+	viper.SetDefault("BOT_PROOF_MAX_AGE", "15m")
+	viper.SetDefault("API_KEY_EXPIRY_NOTICE", "168h")
+	viper.SetDefault("API_KEY_ROTATION_GRACE", "168h")
+	viper.SetDefault("API_KEY_CHECK_INTERVAL", "1h")
+	viper.SetDefault("WEBHOOK_INTERVAL", "5s")
+	viper.SetDefault("WEBHOOK_MAX_ATTEMPTS", 8)
+	viper.SetDefault("WEBHOOK_RETENTION", "720h")
+	viper.SetDefault("REFRESH_GRACE", "1h")
+	viper.SetDefault("IP_RATE_LIMIT", 20)
+	viper.SetDefault("IP_RATE_BURST", 40)
+	viper.SetDefault("IP_KEY_FAILURES", 20)
+	viper.SetDefault("IP_KEY_BAN", "15m")
+	viper.SetDefault("FINGERPRINT_FACTOR", 10)
+	viper.SetDefault("FINGERPRINT_MIN_REQUESTS", 60)
+	viper.SetDefault("FINGERPRINT_MIN_SENDERS", 10)
+	viper.SetDefault("FINGERPRINT_WARMUP", 10)
+	viper.SetDefault("FINGERPRINT_THROTTLE", "15m")
+	viper.SetDefault("ABUSE_CHECK_INTERVAL", "5m")
+	viper.SetDefault("ABUSE_WINDOW", "1h")
+	viper.SetDefault("ABUSE_IP_SENDERS", 50)
+	viper.SetDefault("ABUSE_CALLDATA_SENDERS", 50)
+	viper.SetDefault("ABUSE_DRAIN_PERCENT", 90)
+	viper.SetDefault("ABUSE_DRAIN_WINDOW", "10m")
+	viper.SetDefault("SCREENING_TTL", "24h")
+	viper.SetDefault("SCREENING_TIMEOUT", "3s")
+	viper.SetDefault("SELF_BUNDLER_MAX_BATCH", 10)
+	viper.SetDefault("SELF_BUNDLER_INTERVAL", "5s")
+	viper.SetDefault("SELF_BUNDLER_RESEND_AFTER", "2m")
+	viper.SetDefault("DEPOSIT_CHECK_INTERVAL", "1m")
+	viper.SetDefault("ENTRY_POINT", "0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789")
 
 	viper.SetConfigName(".env")
 	viper.SetConfigType("env")
@@ -49,35 +424,443 @@ func InitValues() error {
 	_ = viper.BindEnv("DB_USER")
 	_ = viper.BindEnv("DB_NAME")
 	_ = viper.BindEnv("DB_PASSWORD")
+	_ = viper.BindEnv("DB_SLOW_QUERY")
+	_ = viper.BindEnv("DB_EXPLAIN_SLOW")
 	_ = viper.BindEnv("PORT")
 	_ = viper.BindEnv("GIN_MODE")
 	_ = viper.BindEnv("PRIVATE_KEY")
 	_ = viper.BindEnv("RPC")
+	_ = viper.BindEnv("CHAIN_ID")
+	_ = viper.BindEnv("CHAINS_FILE")
+	_ = viper.BindEnv("GAS_MARKUP_PERCENT")
+	_ = viper.BindEnv("GAS_MARKUP")
+	_ = viper.BindEnv("QUOTA_UNIT")
+	_ = viper.BindEnv("STRIPE_SECRET_KEY")
+	_ = viper.BindEnv("STRIPE_WEBHOOK_SECRET")
+	_ = viper.BindEnv("STRIPE_METER_EVENT")
+	_ = viper.BindEnv("STRIPE_UNIT_WEI")
+	_ = viper.BindEnv("BILLING_INTERVAL")
+	_ = viper.BindEnv("CREDIT_PACK_VALIDITY")
+	_ = viper.BindEnv("CREDIT_PAYMENT_ADDRESS")
+	_ = viper.BindEnv("CREDIT_PAYMENT_CONFIRMATIONS")
+	_ = viper.BindEnv("FREE_TIER_OPS")
+	_ = viper.BindEnv("FREE_TIER_GAS")
+	_ = viper.BindEnv("FREE_TIER_THROTTLE")
+	_ = viper.BindEnv("REFRESH_GRACE")
+	_ = viper.BindEnv("GEOIP_DATABASE")
+	_ = viper.BindEnv("GEOIP_ALLOW")
+	_ = viper.BindEnv("GEOIP_DENY")
+	_ = viper.BindEnv("TRUSTED_PROXIES")
+	_ = viper.BindEnv("IP_RATE_LIMIT")
+	_ = viper.BindEnv("IP_RATE_BURST")
+	_ = viper.BindEnv("IP_KEY_FAILURES")
+	_ = viper.BindEnv("IP_KEY_BAN")
+	_ = viper.BindEnv("FINGERPRINT_FACTOR")
+	_ = viper.BindEnv("FINGERPRINT_MIN_REQUESTS")
+	_ = viper.BindEnv("FINGERPRINT_MIN_SENDERS")
+	_ = viper.BindEnv("FINGERPRINT_WARMUP")
+	_ = viper.BindEnv("FINGERPRINT_THROTTLE")
+	_ = viper.BindEnv("ABUSE_CHECK_INTERVAL")
+	_ = viper.BindEnv("ABUSE_WINDOW")
+	_ = viper.BindEnv("ABUSE_IP_SENDERS")
+	_ = viper.BindEnv("ABUSE_CALLDATA_SENDERS")
+	_ = viper.BindEnv("ABUSE_DRAIN_PERCENT")
+	_ = viper.BindEnv("ABUSE_DRAIN_WINDOW")
+	_ = viper.BindEnv("ABUSE_AUTO_PAUSE")
+	_ = viper.BindEnv("SCREENING_URL")
+	_ = viper.BindEnv("SCREENING_TOKEN")
+	_ = viper.BindEnv("SCREENING_TTL")
+	_ = viper.BindEnv("SCREENING_TIMEOUT")
+	_ = viper.BindEnv("SCREENING_FAIL_OPEN")
+	_ = viper.BindEnv("DUAL_APPROVAL_ABOVE")
+	_ = viper.BindEnv("MAINTENANCE_NOTICE")
+	_ = viper.BindEnv("MAINTENANCE_WEBHOOK")
+	_ = viper.BindEnv("REPLAY_WINDOW")
+	_ = viper.BindEnv("QUOTE_TTL")
+	_ = viper.BindEnv("PASSKEY_RP_ID")
+	_ = viper.BindEnv("PASSKEY_ORIGINS")
+	_ = viper.BindEnv("PASSKEY_CHALLENGE_TTL")
+	_ = viper.BindEnv("PASSKEY_ATTESTATION")
+	_ = viper.BindEnv("PASSKEY_ATTESTATION_ROOTS")
+	_ = viper.BindEnv("IDENTITY_REDIRECT_URL")
+	_ = viper.BindEnv("GOOGLE_CLIENT_ID")
+	_ = viper.BindEnv("GOOGLE_CLIENT_SECRET")
+	_ = viper.BindEnv("GITHUB_CLIENT_ID")
+	_ = viper.BindEnv("GITHUB_CLIENT_SECRET")
+	_ = viper.BindEnv("BOT_PROOF_MAX_AGE")
+	_ = viper.BindEnv("API_KEY_EXPIRY_NOTICE")
+	_ = viper.BindEnv("API_KEY_ROTATION_GRACE")
+	_ = viper.BindEnv("API_KEY_LIFETIME")
+	_ = viper.BindEnv("API_KEY_CHECK_INTERVAL")
+	_ = viper.BindEnv("WEBHOOK_INTERVAL")
+	_ = viper.BindEnv("WEBHOOK_MAX_ATTEMPTS")
+	_ = viper.BindEnv("WEBHOOK_RETENTION")
+	_ = viper.BindEnv("WEBHOOK_ALLOW_PRIVATE")
+	_ = viper.BindEnv("BUNDLER_URL")
+	_ = viper.BindEnv("BUNDLER_HEALTH_INTERVAL")
+	_ = viper.BindEnv("SELF_BUNDLER_KEY")
+	_ = viper.BindEnv("SELF_BUNDLER_BENEFICIARY")
+	_ = viper.BindEnv("SELF_BUNDLER_MAX_BATCH")
+	_ = viper.BindEnv("SELF_BUNDLER_INTERVAL")
+	_ = viper.BindEnv("SELF_BUNDLER_RESEND_AFTER")
+	_ = viper.BindEnv("PVG_PROFILE")
+	_ = viper.BindEnv("PVG_PROFILES")
+	_ = viper.BindEnv("QUOTA_RATE")
 	_ = viper.BindEnv("CONTRACT")
+	_ = viper.BindEnv("ENTRY_POINT")
 	_ = viper.BindEnv("CREATE_GAS")
 	_ = viper.BindEnv("MAX_GAS")
 	_ = viper.BindEnv("VIP_MAX_GAS")
 	_ = viper.BindEnv("VIP_CONTRACT")
+	_ = viper.BindEnv("SIMULATE")
+	_ = viper.BindEnv("SIMULATION_CACHE_TTL")
+	_ = viper.BindEnv("RPC_CONCURRENCY")
+	_ = viper.BindEnv("RPC_QUEUE")
+	_ = viper.BindEnv("RPC_QUEUE_TIMEOUT")
+	_ = viper.BindEnv("PAYMASTER_HASH")
+	_ = viper.BindEnv("EIP712_NAME")
+	_ = viper.BindEnv("EIP712_VERSION")
+	_ = viper.BindEnv("MIN_VERIFICATION_GAS")
+	_ = viper.BindEnv("MAX_VERIFICATION_GAS")
+	_ = viper.BindEnv("MIN_PRE_VERIFICATION_GAS")
+	_ = viper.BindEnv("MAX_PRE_VERIFICATION_GAS")
+	_ = viper.BindEnv("MAX_PREFUND")
+	_ = viper.BindEnv("MAX_OP_COST")
+	_ = viper.BindEnv("MAX_SIGNED_COST")
+	_ = viper.BindEnv("MAX_SIGNED_GAS")
+	_ = viper.BindEnv("MAX_BLOCK_SIGNED_GAS")
+	_ = viper.BindEnv("SIGNED_COST_WINDOW")
+	_ = viper.BindEnv("FACTORY_REGISTRY")
+	_ = viper.BindEnv("FACTORY_CHECK_INTERVAL")
+	_ = viper.BindEnv("NONCE_MAX_GAP")
+	_ = viper.BindEnv("AGGREGATORS")
+	_ = viper.BindEnv("SAFE_MULTISEND")
+	_ = viper.BindEnv("MAX_SESSION_DURATION")
+	_ = viper.BindEnv("QUOTA_RECLAIM_INTERVAL")
+	_ = viper.BindEnv("BUDGET_CHECK_INTERVAL")
+	_ = viper.BindEnv("ANOMALY_CHECK_INTERVAL")
+	_ = viper.BindEnv("ANOMALY_WINDOW")
+	_ = viper.BindEnv("ANOMALY_ZSCORE")
+	_ = viper.BindEnv("ANOMALY_WEBHOOK")
+	_ = viper.BindEnv("EXPORT_SINK")
+	_ = viper.BindEnv("EXPORT_INTERVAL")
+	_ = viper.BindEnv("EXPORT_BATCH_SIZE")
+	_ = viper.BindEnv("EXPORT_S3_BUCKET")
+	_ = viper.BindEnv("EXPORT_S3_PREFIX")
+	_ = viper.BindEnv("EXPORT_S3_REGION")
+	_ = viper.BindEnv("EXPORT_S3_ENDPOINT")
+	_ = viper.BindEnv("AWS_ACCESS_KEY_ID")
+	_ = viper.BindEnv("AWS_SECRET_ACCESS_KEY")
+	_ = viper.BindEnv("AWS_SESSION_TOKEN")
+	_ = viper.BindEnv("EXPORT_BQ_PROJECT")
+	_ = viper.BindEnv("EXPORT_BQ_DATASET")
+	_ = viper.BindEnv("EXPORT_BQ_TABLE")
+	_ = viper.BindEnv("GOOGLE_APPLICATION_CREDENTIALS")
+	_ = viper.BindEnv("METRICS_ENABLED")
+	_ = viper.BindEnv("SLO_LATENCY")
+	_ = viper.BindEnv("SLO_OBJECTIVE")
+	_ = viper.BindEnv("SLO_METHODS")
+	_ = viper.BindEnv("SLO_BURN_ALERTS")
+	_ = viper.BindEnv("SLO_ALERT_WEBHOOK")
+	_ = viper.BindEnv("SLO_CHECK_INTERVAL")
+	_ = viper.BindEnv("ADMIN_TOKEN")
+	_ = viper.BindEnv("ADMIN_OPERATOR_TOKENS")
+	_ = viper.BindEnv("MOCK_CHAIN")
+	_ = viper.BindEnv("MOCK_VIP_OWNERS")
+	_ = viper.BindEnv("INDEXER_ENABLED")
+	_ = viper.BindEnv("INDEXER_START_BLOCK")
+	_ = viper.BindEnv("INDEXER_BATCH_SIZE")
+	_ = viper.BindEnv("INDEXER_CONFIRMATIONS")
+	_ = viper.BindEnv("INDEXER_REORG_DEPTH")
+	_ = viper.BindEnv("INDEXER_POLL_INTERVAL")
+	_ = viper.BindEnv("LEADER_ELECTION")
+	_ = viper.BindEnv("LEADER_CHECK_INTERVAL")
+	_ = viper.BindEnv("REDIS_URL")
+	_ = viper.BindEnv("ATTESTATION_KEY")
+	_ = viper.BindEnv("RECEIPT_CONTRACT")
+	_ = viper.BindEnv("RECEIPT_RELAYER_KEY")
+	_ = viper.BindEnv("RECEIPT_START_BLOCK")
+	_ = viper.BindEnv("RECEIPT_BATCH_SIZE")
+	_ = viper.BindEnv("RECEIPT_POLL_INTERVAL")
+	_ = viper.BindEnv("FAUCET_KEY")
+	_ = viper.BindEnv("FAUCET_CHAINS")
+	_ = viper.BindEnv("FAUCET_AMOUNT")
+	_ = viper.BindEnv("FAUCET_DAILY_CAP")
+	_ = viper.BindEnv("FAUCET_COOLDOWN")
+	_ = viper.BindEnv("FAUCET_INTERVAL")
+	_ = viper.BindEnv("DEPOSIT_SAFETY_BUFFER")
+	_ = viper.BindEnv("DEPOSIT_CHECK_INTERVAL")
+	_ = viper.BindEnv("ERROR_TEMPLATES")
+	_ = viper.BindEnv("CAPTURE_FILE")
+	_ = viper.BindEnv("CAPTURE_SAMPLE_RATE")
+	_ = viper.BindEnv("NETWORKS")
 
-	values = &Values{
-		DbHost:      viper.GetString("DB_HOST"),
-		DbPort:      viper.GetUint("DB_PORT"),
-		DbUser:      viper.GetString("DB_USER"),
-		DbName:      viper.GetString("DB_NAME"),
-		DbPassword:  viper.GetString("DB_PASSWORD"),
-		PrivateKey:  viper.GetString("PRIVATE_KEY"),
-		Port:        viper.GetInt("PORT"),
-		GinMode:     viper.GetString("GIN_MODE"),
-		RPC:         viper.GetString("RPC"),
-		Contract:    viper.GetString("CONTRACT"),
-		CreateGas:   viper.GetString("CREATE_GAS"),
-		MaxGas:      viper.GetString("MAX_GAS"),
-		VipMaxGas:   viper.GetString("VIP_MAX_GAS"),
-		VipContract: viper.GetString("VIP_CONTRACT"),
+	var err error
+	if values, err = load(viper.GetViper()); err != nil {
+		return err
+	}
+	keys := viper.AllKeys()
+	for _, name := range splitList(viper.GetString("NETWORKS")) {
+		if !networkName.MatchString(name) || reservedPaths[name] {
+			return fmt.Errorf("invalid network name %q", name)
+		}
+		network, err := load(networkViper(name, keys))
+		if err != nil {
+			return fmt.Errorf("network %s: %v", name, err)
+		}
+		prefix := strings.ToUpper(name) + "_"
+		if network.DbHost == values.DbHost && network.DbPort == values.DbPort && network.DbName == values.DbName {
+			return fmt.Errorf("network %s: %sDB_NAME must name its own database", name, prefix)
+		}
+		if network.PrivateKey == values.PrivateKey {
+			return fmt.Errorf("network %s: %sPRIVATE_KEY must be its own signer", name, prefix)
+		}
+		network.Network = name
+		values.Networks = append(values.Networks, network)
 	}
 	return nil
 }
 
+// load reads the settings of v.
+func load(v *viper.Viper) (*Values, error) {
+	values := &Values{
+		DbHost:      v.GetString("DB_HOST"),
+		DbPort:      v.GetUint("DB_PORT"),
+		DbUser:      v.GetString("DB_USER"),
+		DbName:      v.GetString("DB_NAME"),
+		DbPassword:  v.GetString("DB_PASSWORD"),
+		PrivateKey:  v.GetString("PRIVATE_KEY"),
+		Port:        v.GetInt("PORT"),
+		GinMode:     v.GetString("GIN_MODE"),
+		RPC:         v.GetString("RPC"),
+		Contract:    v.GetString("CONTRACT"),
+		EntryPoint:  v.GetString("ENTRY_POINT"),
+		CreateGas:   v.GetString("CREATE_GAS"),
+		MaxGas:      v.GetString("MAX_GAS"),
+		VipMaxGas:   v.GetString("VIP_MAX_GAS"),
+		VipContract: v.GetString("VIP_CONTRACT"),
+		Simulate:    v.GetBool("SIMULATE"),
+
+		DbSlowQuery:   v.GetDuration("DB_SLOW_QUERY"),
+		DbExplainSlow: v.GetBool("DB_EXPLAIN_SLOW"),
+
+		SimulationCacheTTL: v.GetDuration("SIMULATION_CACHE_TTL"),
+
+		RpcConcurrency:  v.GetInt("RPC_CONCURRENCY"),
+		RpcQueue:        v.GetInt("RPC_QUEUE"),
+		RpcQueueTimeout: v.GetDuration("RPC_QUEUE_TIMEOUT"),
+
+		PaymasterHash: v.GetString("PAYMASTER_HASH"),
+		EIP712Name:    v.GetString("EIP712_NAME"),
+		EIP712Version: v.GetString("EIP712_VERSION"),
+
+		MinVerificationGas:     v.GetUint64("MIN_VERIFICATION_GAS"),
+		MaxVerificationGas:     v.GetUint64("MAX_VERIFICATION_GAS"),
+		MinPreVerificationGas:  v.GetUint64("MIN_PRE_VERIFICATION_GAS"),
+		MaxPreVerificationGas:  v.GetUint64("MAX_PRE_VERIFICATION_GAS"),
+		MaxPrefund:             v.GetString("MAX_PREFUND"),
+		MaxOpCost:              v.GetString("MAX_OP_COST"),
+		MaxSignedCost:          v.GetString("MAX_SIGNED_COST"),
+		MaxSignedGas:           v.GetUint64("MAX_SIGNED_GAS"),
+		MaxBlockSignedGas:      v.GetUint64("MAX_BLOCK_SIGNED_GAS"),
+		SignedCostWindow:       v.GetDuration("SIGNED_COST_WINDOW"),
+		GasMarkupPercent:       v.GetUint64("GAS_MARKUP_PERCENT"),
+		GasMarkup:              v.GetUint64("GAS_MARKUP"),
+		Bundlers:               splitList(v.GetString("BUNDLER_URL")),
+		BundlerHealthInterval:  v.GetDuration("BUNDLER_HEALTH_INTERVAL"),
+		SelfBundlerKey:         v.GetString("SELF_BUNDLER_KEY"),
+		SelfBundlerBeneficiary: v.GetString("SELF_BUNDLER_BENEFICIARY"),
+		SelfBundlerMaxBatch:    v.GetInt("SELF_BUNDLER_MAX_BATCH"),
+		SelfBundlerInterval:    v.GetDuration("SELF_BUNDLER_INTERVAL"),
+		SelfBundlerResendAfter: v.GetDuration("SELF_BUNDLER_RESEND_AFTER"),
+		PvgProfile:             v.GetString("PVG_PROFILE"),
+		PvgProfiles:            v.GetString("PVG_PROFILES"),
+		QuotaUnit:              v.GetString("QUOTA_UNIT"),
+		QuotaRate:              v.GetString("QUOTA_RATE"),
+		FactoryRegistry:        v.GetBool("FACTORY_REGISTRY"),
+		FactoryCheckInterval:   v.GetDuration("FACTORY_CHECK_INTERVAL"),
+		NonceMaxGap:            v.GetInt64("NONCE_MAX_GAP"),
+		Aggregators:            v.GetString("AGGREGATORS"),
+		SafeMultiSend:          v.GetString("SAFE_MULTISEND"),
+		MaxSessionDuration:     v.GetDuration("MAX_SESSION_DURATION"),
+		QuotaReclaimInterval:   v.GetDuration("QUOTA_RECLAIM_INTERVAL"),
+		BudgetCheckInterval:    v.GetDuration("BUDGET_CHECK_INTERVAL"),
+		StripeSecretKey:        v.GetString("STRIPE_SECRET_KEY"),
+		StripeWebhookSecret:    v.GetString("STRIPE_WEBHOOK_SECRET"),
+		StripeMeterEvent:       v.GetString("STRIPE_METER_EVENT"),
+		StripeUnitWei:          v.GetString("STRIPE_UNIT_WEI"),
+		BillingInterval:        v.GetDuration("BILLING_INTERVAL"),
+		AnomalyCheckInterval:   v.GetDuration("ANOMALY_CHECK_INTERVAL"),
+		AnomalyWindow:          v.GetInt("ANOMALY_WINDOW"),
+		AnomalyZScore:          v.GetFloat64("ANOMALY_ZSCORE"),
+		AnomalyWebhook:         v.GetString("ANOMALY_WEBHOOK"),
+		ExportSink:             v.GetString("EXPORT_SINK"),
+		ExportInterval:         v.GetDuration("EXPORT_INTERVAL"),
+		ExportBatchSize:        v.GetInt("EXPORT_BATCH_SIZE"),
+		ExportS3Bucket:         v.GetString("EXPORT_S3_BUCKET"),
+		ExportS3Prefix:         v.GetString("EXPORT_S3_PREFIX"),
+		ExportS3Region:         v.GetString("EXPORT_S3_REGION"),
+		ExportS3Endpoint:       v.GetString("EXPORT_S3_ENDPOINT"),
+		AWSAccessKeyID:         v.GetString("AWS_ACCESS_KEY_ID"),
+		AWSSecretAccessKey:     v.GetString("AWS_SECRET_ACCESS_KEY"),
+		AWSSessionToken:        v.GetString("AWS_SESSION_TOKEN"),
+		ExportBQProject:        v.GetString("EXPORT_BQ_PROJECT"),
+		ExportBQDataset:        v.GetString("EXPORT_BQ_DATASET"),
+		ExportBQTable:          v.GetString("EXPORT_BQ_TABLE"),
+		GoogleCredentials:      v.GetString("GOOGLE_APPLICATION_CREDENTIALS"),
+		MetricsEnabled:         v.GetBool("METRICS_ENABLED"),
+		SloLatency:             v.GetDuration("SLO_LATENCY"),
+		SloObjective:           v.GetFloat64("SLO_OBJECTIVE"),
+		SloMethods:             splitList(v.GetString("SLO_METHODS")),
+		SloBurnAlerts:          v.GetString("SLO_BURN_ALERTS"),
+		SloAlertWebhook:        v.GetString("SLO_ALERT_WEBHOOK"),
+		SloCheckInterval:       v.GetDuration("SLO_CHECK_INTERVAL"),
+		AdminToken:             v.GetString("ADMIN_TOKEN"),
+		AdminOperatorTokens:    v.GetString("ADMIN_OPERATOR_TOKENS"),
+
+		CreditPackValidity:         v.GetDuration("CREDIT_PACK_VALIDITY"),
+		CreditPaymentAddress:       v.GetString("CREDIT_PAYMENT_ADDRESS"),
+		CreditPaymentConfirmations: v.GetUint64("CREDIT_PAYMENT_CONFIRMATIONS"),
+
+		FreeTierOps:      v.GetUint64("FREE_TIER_OPS"),
+		FreeTierGas:      v.GetString("FREE_TIER_GAS"),
+		FreeTierThrottle: v.GetDuration("FREE_TIER_THROTTLE"),
+		RefreshGrace:     v.GetDuration("REFRESH_GRACE"),
+
+		GeoIPDatabase: v.GetString("GEOIP_DATABASE"),
+		GeoIPAllow:    splitList(v.GetString("GEOIP_ALLOW")),
+		GeoIPDeny:     splitList(v.GetString("GEOIP_DENY")),
+
+		TrustedProxies: splitList(v.GetString("TRUSTED_PROXIES")),
+		IPRateLimit:    v.GetFloat64("IP_RATE_LIMIT"),
+		IPRateBurst:    v.GetInt("IP_RATE_BURST"),
+		IPKeyFailures:  v.GetInt("IP_KEY_FAILURES"),
+		IPKeyBan:       v.GetDuration("IP_KEY_BAN"),
+
+		FingerprintFactor:      v.GetFloat64("FINGERPRINT_FACTOR"),
+		FingerprintMinRequests: v.GetFloat64("FINGERPRINT_MIN_REQUESTS"),
+		FingerprintMinSenders:  v.GetFloat64("FINGERPRINT_MIN_SENDERS"),
+		FingerprintWarmup:      v.GetInt("FINGERPRINT_WARMUP"),
+		FingerprintThrottle:    v.GetDuration("FINGERPRINT_THROTTLE"),
+
+		AbuseCheckInterval:   v.GetDuration("ABUSE_CHECK_INTERVAL"),
+		AbuseWindow:          v.GetDuration("ABUSE_WINDOW"),
+		AbuseIPSenders:       v.GetInt64("ABUSE_IP_SENDERS"),
+		AbuseCallDataSenders: v.GetInt64("ABUSE_CALLDATA_SENDERS"),
+		AbuseDrainPercent:    v.GetInt64("ABUSE_DRAIN_PERCENT"),
+		AbuseDrainWindow:     v.GetDuration("ABUSE_DRAIN_WINDOW"),
+		AbuseAutoPause:       splitList(v.GetString("ABUSE_AUTO_PAUSE")),
+
+		ScreeningURL:      v.GetString("SCREENING_URL"),
+		ScreeningToken:    v.GetString("SCREENING_TOKEN"),
+		ScreeningTTL:      v.GetDuration("SCREENING_TTL"),
+		ScreeningTimeout:  v.GetDuration("SCREENING_TIMEOUT"),
+		ScreeningFailOpen: v.GetBool("SCREENING_FAIL_OPEN"),
+
+		DualApprovalAbove: v.GetString("DUAL_APPROVAL_ABOVE"),
+
+		MaintenanceNotice:  v.GetDuration("MAINTENANCE_NOTICE"),
+		MaintenanceWebhook: v.GetString("MAINTENANCE_WEBHOOK"),
+		ReplayWindow:       v.GetDuration("REPLAY_WINDOW"),
+		QuoteTTL:           v.GetDuration("QUOTE_TTL"),
+
+		PasskeyRPID:             v.GetString("PASSKEY_RP_ID"),
+		PasskeyOrigins:          splitList(v.GetString("PASSKEY_ORIGINS")),
+		PasskeyChallengeTTL:     v.GetDuration("PASSKEY_CHALLENGE_TTL"),
+		PasskeyAttestation:      v.GetString("PASSKEY_ATTESTATION"),
+		PasskeyAttestationRoots: v.GetString("PASSKEY_ATTESTATION_ROOTS"),
+
+		IdentityRedirectURL: v.GetString("IDENTITY_REDIRECT_URL"),
+		GoogleClientID:      v.GetString("GOOGLE_CLIENT_ID"),
+		GoogleClientSecret:  v.GetString("GOOGLE_CLIENT_SECRET"),
+		GitHubClientID:      v.GetString("GITHUB_CLIENT_ID"),
+		GitHubClientSecret:  v.GetString("GITHUB_CLIENT_SECRET"),
+		BotProofMaxAge:      v.GetDuration("BOT_PROOF_MAX_AGE"),
+
+		ApiKeyExpiryNotice:  v.GetDuration("API_KEY_EXPIRY_NOTICE"),
+		ApiKeyRotationGrace: v.GetDuration("API_KEY_ROTATION_GRACE"),
+		ApiKeyLifetime:      v.GetDuration("API_KEY_LIFETIME"),
+		ApiKeyCheckInterval: v.GetDuration("API_KEY_CHECK_INTERVAL"),
+
+		WebhookInterval:     v.GetDuration("WEBHOOK_INTERVAL"),
+		WebhookMaxAttempts:  v.GetInt("WEBHOOK_MAX_ATTEMPTS"),
+		WebhookRetention:    v.GetDuration("WEBHOOK_RETENTION"),
+		WebhookAllowPrivate: v.GetBool("WEBHOOK_ALLOW_PRIVATE"),
+
+		MockChain:     v.GetBool("MOCK_CHAIN"),
+		MockVipOwners: strings.Split(v.GetString("MOCK_VIP_OWNERS"), ","),
+
+		IndexerEnabled:       v.GetBool("INDEXER_ENABLED"),
+		IndexerStartBlock:    v.GetUint64("INDEXER_START_BLOCK"),
+		IndexerBatchSize:     v.GetUint64("INDEXER_BATCH_SIZE"),
+		IndexerConfirmations: v.GetUint64("INDEXER_CONFIRMATIONS"),
+		IndexerReorgDepth:    v.GetUint64("INDEXER_REORG_DEPTH"),
+		IndexerPollInterval:  v.GetDuration("INDEXER_POLL_INTERVAL"),
+
+		LeaderElection:      v.GetBool("LEADER_ELECTION"),
+		LeaderCheckInterval: v.GetDuration("LEADER_CHECK_INTERVAL"),
+		RedisURL:            v.GetString("REDIS_URL"),
+
+		AttestationKey: v.GetString("ATTESTATION_KEY"),
+
+		ReceiptContract:     v.GetString("RECEIPT_CONTRACT"),
+		ReceiptRelayerKey:   v.GetString("RECEIPT_RELAYER_KEY"),
+		ReceiptStartBlock:   v.GetUint64("RECEIPT_START_BLOCK"),
+		ReceiptBatchSize:    v.GetInt("RECEIPT_BATCH_SIZE"),
+		ReceiptPollInterval: v.GetDuration("RECEIPT_POLL_INTERVAL"),
+
+		FaucetKey:      v.GetString("FAUCET_KEY"),
+		FaucetChains:   splitList(v.GetString("FAUCET_CHAINS")),
+		FaucetAmount:   v.GetString("FAUCET_AMOUNT"),
+		FaucetDailyCap: v.GetString("FAUCET_DAILY_CAP"),
+		FaucetCooldown: v.GetDuration("FAUCET_COOLDOWN"),
+		FaucetInterval: v.GetDuration("FAUCET_INTERVAL"),
+
+		DepositSafetyBuffer:  v.GetString("DEPOSIT_SAFETY_BUFFER"),
+		DepositCheckInterval: v.GetDuration("DEPOSIT_CHECK_INTERVAL"),
+
+		ErrorTemplates: v.GetString("ERROR_TEMPLATES"),
+
+		CaptureFile:       v.GetString("CAPTURE_FILE"),
+		CaptureSampleRate: v.GetFloat64("CAPTURE_SAMPLE_RATE"),
+	}
+	chains, err := loadChains(v, values)
+	if err != nil {
+		return nil, err
+	}
+	values.Chains = chains
+	return values, nil
+}
+
+// networkViper returns the settings of network name: each of keys is read
+// from its name prefixed with the upper case network name, TESTNET_DB_NAME
+// for DB_NAME, and defaults to the top level setting.
+func networkViper(name string, keys []string) *viper.Viper {
+	v := viper.New()
+	prefix := strings.ToUpper(name) + "_"
+	for _, key := range keys {
+		override := prefix + strings.ToUpper(key)
+		_ = viper.BindEnv(override)
+		if viper.IsSet(override) {
+			v.Set(key, viper.Get(override))
+		} else {
+			v.Set(key, viper.Get(key))
+		}
+	}
+	return v
+}
+
+// splitList splits a comma separated setting, dropping empty entries.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func Config() *Values {
 	if values == nil {
 		log.Fatal("config not initial")
@@ -2,22 +2,30 @@ package container
 
 import (
 	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/models"
 )
 
 type Container interface {
 	GetRepository() db.Repository
+	GetAccounts() models.AccountRepository
 }
 
 func NewContainer(rep db.Repository) Container {
 	return &container{
-		rep: rep,
+		rep:      rep,
+		accounts: models.NewAccountRepository(rep),
 	}
 }
 
 type container struct {
-	rep db.Repository
+	rep      db.Repository
+	accounts models.AccountRepository
 }
 
 func (c *container) GetRepository() db.Repository {
 	return c.rep
 }
+
+func (c *container) GetAccounts() models.AccountRepository {
+	return c.accounts
+}
@@ -0,0 +1,169 @@
+package db
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/metrics"
+)
+
+const (
+	startKey = "instrument:start"
+	// explainEvery is how often the plan of the same slow statement is
+	// checked again.
+	explainEvery = 10 * time.Minute
+)
+
+// literal matches the SQL string literals written in statements, redacted
+// in the log like the bound parameters.
+var literal = regexp.MustCompile(`'(?:[^']|'')*'`)
+
+// Instrumentation is a GORM plugin timing every statement. Statements slower
+// than SlowQuery are logged without their parameters and, for queries, their
+// plan is checked for sequential scans hinting at a missing index.
+type Instrumentation struct {
+	// Database labels the metrics, it tells the networks apart.
+	Database  string
+	SlowQuery time.Duration
+	// Explain checks the plan of slow queries with EXPLAIN, without running
+	// them again.
+	Explain bool
+
+	mu        sync.Mutex
+	explained map[string]time.Time
+}
+
+func (i *Instrumentation) Name() string {
+	return "instrumentation"
+}
+
+// Initialize registers the callbacks timing the statements of db.
+func (i *Instrumentation) Initialize(db *gorm.DB) error {
+	i.explained = make(map[string]time.Time)
+	callbacks := db.Callback()
+	for _, err := range []error{
+		callbacks.Create().Before("gorm:create").Register("instrument:before_create", i.before),
+		callbacks.Create().After("gorm:create").Register("instrument:after_create", i.after("create")),
+		callbacks.Query().Before("gorm:query").Register("instrument:before_query", i.before),
+		callbacks.Query().After("gorm:query").Register("instrument:after_query", i.after("query")),
+		callbacks.Update().Before("gorm:update").Register("instrument:before_update", i.before),
+		callbacks.Update().After("gorm:update").Register("instrument:after_update", i.after("update")),
+		callbacks.Delete().Before("gorm:delete").Register("instrument:before_delete", i.before),
+		callbacks.Delete().After("gorm:delete").Register("instrument:after_delete", i.after("delete")),
+		callbacks.Row().Before("gorm:row").Register("instrument:before_row", i.before),
+		callbacks.Row().After("gorm:row").Register("instrument:after_row", i.after("row")),
+		callbacks.Raw().Before("gorm:raw").Register("instrument:before_raw", i.before),
+		callbacks.Raw().After("gorm:raw").Register("instrument:after_raw", i.after("raw")),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *Instrumentation) before(db *gorm.DB) {
+	db.InstanceSet(startKey, time.Now())
+}
+
+func (i *Instrumentation) after(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(startKey)
+		if !ok {
+			return
+		}
+		elapsed := time.Since(value.(time.Time))
+		table := db.Statement.Table
+		if table == "" {
+			table = "raw"
+		}
+		slow := i.SlowQuery > 0 && elapsed > i.SlowQuery
+		metrics.ObserveQuery(i.Database, table, operation, elapsed, slow)
+		if !slow {
+			return
+		}
+
+		sql := db.Statement.SQL.String()
+		logger.S().Warnf("Slow %s on %s took %s: %s [%d params redacted]", operation, table, elapsed.Round(time.Millisecond), redact(sql), len(db.Statement.Vars))
+		// rows of row statements are still being read, the connection is busy
+		if i.Explain && operation == "query" && db.Error == nil && isSelect(sql) && i.explainDue(sql) {
+			i.explain(db, sql)
+		}
+	}
+}
+
+// explainDue reports whether the plan of sql was not checked for
+// explainEvery.
+func (i *Instrumentation) explainDue(sql string) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	now := time.Now()
+	if last, ok := i.explained[sql]; ok && now.Sub(last) < explainEvery {
+		return false
+	}
+	for s, last := range i.explained {
+		if now.Sub(last) >= explainEvery {
+			delete(i.explained, s)
+		}
+	}
+	i.explained[sql] = now
+	return true
+}
+
+// planNode is a node of a postgres EXPLAIN (FORMAT JSON) plan.
+type planNode struct {
+	NodeType     string     `json:"Node Type"`
+	RelationName string     `json:"Relation Name"`
+	Filter       string     `json:"Filter"`
+	Plans        []planNode `json:"Plans"`
+}
+
+// explain plans the query of db on its connection, bypassing the callbacks,
+// and flags the filtered sequential scans.
+func (i *Instrumentation) explain(db *gorm.DB, sql string) {
+	rows, err := db.Statement.ConnPool.QueryContext(db.Statement.Context, "EXPLAIN (FORMAT JSON) "+sql, db.Statement.Vars...)
+	if err != nil {
+		logger.S().Debugf("explain slow query error: %v", err)
+		return
+	}
+	defer rows.Close()
+	var raw []byte
+	if !rows.Next() || rows.Scan(&raw) != nil {
+		return
+	}
+	var plans []struct {
+		Plan planNode `json:"Plan"`
+	}
+	if err := json.Unmarshal(raw, &plans); err != nil {
+		return
+	}
+	for _, p := range plans {
+		i.flagSeqScans(&p.Plan, sql)
+	}
+}
+
+func (i *Instrumentation) flagSeqScans(node *planNode, sql string) {
+	if node.NodeType == "Seq Scan" && node.Filter != "" {
+		metrics.ObserveSeqScan(i.Database, node.RelationName)
+		logger.S().Warnf("Possible missing index: slow query scans %s sequentially filtering %s: %s", node.RelationName, redact(node.Filter), redact(sql))
+	}
+	for n := range node.Plans {
+		i.flagSeqScans(&node.Plans[n], sql)
+	}
+}
+
+// redact replaces the string literals of sql, bound parameters are never
+// part of it.
+func redact(sql string) string {
+	return literal.ReplaceAllString(sql, "'?'")
+}
+
+func isSelect(sql string) bool {
+	return strings.HasPrefix(strings.ToUpper(strings.TrimSpace(sql)), "SELECT")
+}
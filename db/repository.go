@@ -39,26 +39,40 @@ type repository struct {
 }
 
 func NewRepository() Repository {
+	return Open(config.Config())
+}
+
+// Open connects to the database of conf.
+func Open(conf *config.Values) Repository {
 	logger.S().Infof("Try database connection...")
-	db, err := connectDatabase()
+	db, err := connectDatabase(conf)
 	if err != nil {
 		logger.S().Errorf("Failure database connection")
 		os.Exit(1)
 	}
-	logger.S().Infof("Success database connection, %s:%d", config.Config().DbHost, config.Config().DbPort)
+	logger.S().Infof("Success database connection, %s:%d/%s", conf.DbHost, conf.DbPort, conf.DbName)
 	return &repository{db: db}
 }
 
-func connectDatabase() (*gorm.DB, error) {
+func connectDatabase(conf *config.Values) (*gorm.DB, error) {
 	dsn := fmt.Sprintf(
 		"host=%s port=%d user=%s dbname=%s password=%s",
-		config.Config().DbHost,
-		config.Config().DbPort,
-		config.Config().DbUser,
-		config.Config().DbName,
-		config.Config().DbPassword,
+		conf.DbHost,
+		conf.DbPort,
+		conf.DbUser,
+		conf.DbName,
+		conf.DbPassword,
 	)
-	return gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Use(&Instrumentation{
+		Database:  conf.DbName,
+		SlowQuery: conf.DbSlowQuery,
+		Explain:   conf.DbExplainSlow,
+	})
+	return db, err
 }
 
 // Model specify the model you would like to run db operations
@@ -150,8 +164,12 @@ func (rep *repository) AutoMigrate(values ...interface{}) error {
 // Transaction start a transaction as a block.
 // If it is failed, will rollback and return error.
 // If it is sccuessed, will commit.
+// Called inside a transaction, fc joins the outer transaction.
 // ref: https://github.com/jinzhu/gorm/blob/master/main.go#L533
 func (rep *repository) Transaction(fc func(tx Repository) error) (err error) {
+	if _, ok := rep.db.Statement.ConnPool.(gorm.TxCommitter); ok {
+		return fc(rep)
+	}
 	panicked := true
 	tx := rep.db.Begin()
 	defer func() {
@@ -171,3 +189,8 @@ func (rep *repository) Transaction(fc func(tx Repository) error) (err error) {
 	panicked = false
 	return
 }
+
+// Wrap returns the repository of an open gorm connection.
+func Wrap(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
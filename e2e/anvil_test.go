@@ -0,0 +1,57 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+type anvil struct {
+	cmd    *exec.Cmd
+	url    string
+	client *ethclient.Client
+}
+
+func startAnvil(ctx context.Context, path string, port int) (*anvil, error) {
+	cmd := exec.CommandContext(ctx, path, "--port", fmt.Sprintf("%d", port), "--silent")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start anvil: %w", err)
+	}
+
+	url := fmt.Sprintf("http://127.0.0.1:%d", port)
+	node := &anvil{cmd: cmd, url: url}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		client, err := ethclient.DialContext(ctx, url)
+		if err == nil {
+			if _, err = client.ChainID(ctx); err == nil {
+				node.client = client
+				return node, nil
+			}
+			client.Close()
+		}
+		if time.Now().After(deadline) {
+			node.stop()
+			return nil, fmt.Errorf("anvil not ready: %w", err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func (a *anvil) stop() {
+	if a.client != nil {
+		a.client.Close()
+	}
+	if a.cmd.Process != nil {
+		_ = a.cmd.Process.Kill()
+		_ = a.cmd.Wait()
+	}
+}
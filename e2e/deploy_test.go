@@ -0,0 +1,125 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// artifact is the subset of a foundry (out/<Name>.sol/<Name>.json) or
+// hardhat (<Name>.json) compilation artifact used by the harness.
+type artifact struct {
+	ABI      json.RawMessage `json:"abi"`
+	Bytecode json.RawMessage `json:"bytecode"`
+}
+
+func loadArtifact(dir string, name string) (abi.ABI, []byte, error) {
+	candidates := []string{
+		filepath.Join(dir, name+".sol", name+".json"),
+		filepath.Join(dir, name+".json"),
+	}
+	var raw []byte
+	var err error
+	for _, path := range candidates {
+		raw, err = os.ReadFile(path)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return abi.ABI{}, nil, fmt.Errorf("artifact %s not found in %s", name, dir)
+	}
+
+	var art artifact
+	if err := json.Unmarshal(raw, &art); err != nil {
+		return abi.ABI{}, nil, fmt.Errorf("artifact %s: %w", name, err)
+	}
+	parsed, err := abi.JSON(strings.NewReader(string(art.ABI)))
+	if err != nil {
+		return abi.ABI{}, nil, fmt.Errorf("artifact %s abi: %w", name, err)
+	}
+
+	// hardhat stores the bytecode as a string, foundry as {"object": "0x..."}
+	var code string
+	if err := json.Unmarshal(art.Bytecode, &code); err != nil {
+		var obj struct {
+			Object string `json:"object"`
+		}
+		if err := json.Unmarshal(art.Bytecode, &obj); err != nil {
+			return abi.ABI{}, nil, fmt.Errorf("artifact %s bytecode: %w", name, err)
+		}
+		code = obj.Object
+	}
+	if !strings.HasPrefix(code, "0x") {
+		code = "0x" + code
+	}
+	bytecode, err := hexutil.Decode(code)
+	if err != nil {
+		return abi.ABI{}, nil, fmt.Errorf("artifact %s bytecode: %w", name, err)
+	}
+	return parsed, bytecode, nil
+}
+
+func deploy(
+	ctx context.Context,
+	client *ethclient.Client,
+	auth *bind.TransactOpts,
+	dir string,
+	name string,
+	params ...interface{},
+) (common.Address, abi.ABI, error) {
+	parsed, bytecode, err := loadArtifact(dir, name)
+	if err != nil {
+		return common.Address{}, abi.ABI{}, err
+	}
+	addr, tx, _, err := bind.DeployContract(auth, parsed, bytecode, client, params...)
+	if err != nil {
+		return common.Address{}, abi.ABI{}, fmt.Errorf("deploy %s: %w", name, err)
+	}
+	if _, err := bind.WaitDeployed(ctx, client, tx); err != nil {
+		return common.Address{}, abi.ABI{}, fmt.Errorf("deploy %s: %w", name, err)
+	}
+	return addr, parsed, nil
+}
+
+func transact(ctx context.Context, client *ethclient.Client, auth *bind.TransactOpts, to common.Address, parsed abi.ABI, method string, params ...interface{}) error {
+	contract := bind.NewBoundContract(to, parsed, client, client, client)
+	tx, err := contract.Transact(auth, method, params...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	receipt, err := bind.WaitMined(ctx, client, tx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	if receipt.Status != 1 {
+		return fmt.Errorf("%s: transaction %s reverted", method, tx.Hash())
+	}
+	return nil
+}
+
+func call(ctx context.Context, client *ethclient.Client, to common.Address, parsed abi.ABI, method string, params ...interface{}) ([]interface{}, error) {
+	contract := bind.NewBoundContract(to, parsed, client, client, client)
+	var out []interface{}
+	err := contract.Call(&bind.CallOpts{Context: ctx}, &out, method, params...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", method, err)
+	}
+	return out, nil
+}
+
+func ether(n int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(n), big.NewInt(1e18))
+}
@@ -0,0 +1,6 @@
+// Package e2e is the end-to-end suite: it runs the service against anvil and
+// EntryPoint, VerifyingPaymaster, VipNFT and SimpleAccountFactory deployed
+// from compiled artifacts. Its tests build with the e2e tag:
+//
+//	E2E_ARTIFACTS=$PWD/../account-abstraction/out go test -tags e2e ./e2e
+package e2e
@@ -0,0 +1,390 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/ququzone/verifying-paymaster-service/api"
+	"github.com/ququzone/verifying-paymaster-service/config"
+	"github.com/ququzone/verifying-paymaster-service/contracts"
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/jsonrpc"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/utils"
+)
+
+const (
+	// well known anvil development accounts
+	deployerKey = "ac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcd7e7ae784d4c6e5"
+	signerKey   = "59c6995e998f97a5a0044966f0945389dc9e86dae88c7a8412f4603b6b78690d"
+)
+
+// settings of the suite, read from the environment
+var (
+	anvilPath   = env("E2E_ANVIL", "anvil")
+	anvilPort   = envInt("E2E_ANVIL_PORT", 18545)
+	artifacts   = env("E2E_ARTIFACTS", "../out")
+	servicePort = envInt("E2E_SERVICE_PORT", 18888)
+)
+
+func env(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func envInt(key string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return n
+	}
+	return fallback
+}
+
+type environment struct {
+	node       *anvil
+	chainID    *big.Int
+	deployer   *bind.TransactOpts
+	entryPoint common.Address
+	factory    common.Address
+	paymaster  common.Address
+	vip        common.Address
+}
+
+// TestSponsorAndExecute spins up anvil, deploys the contracts, starts the
+// service against them and sends a sponsored user operation through
+// EntryPoint.handleOps. It is skipped without anvil, the compiled contracts
+// or the database.
+func TestSponsorAndExecute(t *testing.T) {
+	if _, err := exec.LookPath(anvilPath); err != nil {
+		t.Skipf("anvil not found: %v", err)
+	}
+	for _, name := range []string{"EntryPoint", "VerifyingPaymaster", "VipNFT", "SimpleAccountFactory", "SimpleAccount"} {
+		if _, _, err := loadArtifact(artifacts, name); err != nil {
+			t.Skip(err)
+		}
+	}
+	rep := openRepository(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	node, err := startAnvil(ctx, anvilPath, anvilPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.stop()
+
+	env, err := setup(ctx, node, artifacts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("Deployed EntryPoint %s, VerifyingPaymaster %s, VipNFT %s", env.entryPoint, env.paymaster, env.vip)
+
+	service, err := startService(ctx, t, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = service.Process.Kill()
+		_ = service.Wait()
+	}()
+
+	key, err := seedApiKey(rep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := fmt.Sprintf("http://127.0.0.1:%d/rpc/%s", servicePort, key)
+	if err := sponsorAndExecute(ctx, t, env, url); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// openRepository connects to the database of the service, configured in the
+// environment or the .env of the repository, and skips the suite when it is
+// unreachable.
+func openRepository(t *testing.T) db.Repository {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(".."); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+	if err := logger.InitLogger(); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.InitValues(); err != nil {
+		t.Fatal(err)
+	}
+	conf := config.Config()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(conf.DbHost, fmt.Sprint(conf.DbPort)), 3*time.Second)
+	if err != nil {
+		t.Skipf("database not reachable: %v", err)
+	}
+	conn.Close()
+	return db.Open(conf)
+}
+
+func setup(ctx context.Context, node *anvil, artifacts string) (*environment, error) {
+	chainID, err := node.client.ChainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key, _ := crypto.HexToECDSA(deployerKey)
+	auth, err := bind.NewKeyedTransactorWithChainID(key, chainID)
+	if err != nil {
+		return nil, err
+	}
+	auth.Context = ctx
+	env := &environment{node: node, chainID: chainID, deployer: auth}
+
+	env.entryPoint, _, err = deploy(ctx, node.client, auth, artifacts, "EntryPoint")
+	if err != nil {
+		return nil, err
+	}
+	env.factory, _, err = deploy(ctx, node.client, auth, artifacts, "SimpleAccountFactory", env.entryPoint)
+	if err != nil {
+		return nil, err
+	}
+	signer, _ := crypto.HexToECDSA(signerKey)
+	env.paymaster, _, err = deploy(ctx, node.client, auth, artifacts, "VerifyingPaymaster", env.entryPoint, crypto.PubkeyToAddress(signer.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+	env.vip, _, err = deploy(ctx, node.client, auth, artifacts, "VipNFT", big.NewInt(0), big.NewInt(100), big.NewInt(0))
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := contracts.VerifyingPaymasterMetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	deposit := *auth
+	deposit.Value = ether(10)
+	if err := transact(ctx, node.client, &deposit, env.paymaster, *parsed, "deposit"); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// startService builds the service and runs it from the repository root
+// against the anvil node.
+func startService(ctx context.Context, t *testing.T, env *environment) (*exec.Cmd, error) {
+	binary := filepath.Join(t.TempDir(), "paymaster")
+	build := exec.CommandContext(ctx, "go", "build", "-o", binary, ".")
+	build.Dir = ".."
+	if out, err := build.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("build service: %w: %s", err, out)
+	}
+
+	cmd := exec.CommandContext(ctx, binary)
+	cmd.Dir = ".."
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("PORT=%d", servicePort),
+		"RPC="+env.node.url,
+		"CONTRACT="+env.paymaster.Hex(),
+		"ENTRY_POINT="+env.entryPoint.Hex(),
+		"VIP_CONTRACT="+env.vip.Hex(),
+		"PRIVATE_KEY="+signerKey,
+		"MOCK_CHAIN=false",
+	)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start service: %w", err)
+	}
+
+	ping := fmt.Sprintf("http://127.0.0.1:%d/ping", servicePort)
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := http.Get(ping)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return cmd, nil
+			}
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	_ = cmd.Process.Kill()
+	_ = cmd.Wait()
+	return nil, errors.New("service not ready")
+}
+
+func seedApiKey(rep db.Repository) (string, error) {
+	if err := models.Migrate(rep); err != nil {
+		return "", err
+	}
+	user := &models.User{Address: common.Address{}.Hex()}
+	if err := rep.Create(user).Error; err != nil {
+		return "", err
+	}
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	key := &models.ApiKeys{
+		UserID:      user.ID,
+		Key:         hex.EncodeToString(raw),
+		Enable:      true,
+		Description: "e2e harness",
+	}
+	if err := rep.Create(key).Error; err != nil {
+		return "", err
+	}
+	return key.Key, nil
+}
+
+func sponsorAndExecute(ctx context.Context, t *testing.T, env *environment, url string) error {
+	client := env.node.client
+	factoryABI, _, err := loadArtifact(artifacts, "SimpleAccountFactory")
+	if err != nil {
+		return err
+	}
+	accountABI, _, err := loadArtifact(artifacts, "SimpleAccount")
+	if err != nil {
+		return err
+	}
+
+	owner, err := crypto.GenerateKey()
+	if err != nil {
+		return err
+	}
+	ownerAddr := crypto.PubkeyToAddress(owner.PublicKey)
+	if err := transact(ctx, client, env.deployer, env.factory, factoryABI, "createAccount", ownerAddr, big.NewInt(0)); err != nil {
+		return err
+	}
+	out, err := call(ctx, client, env.factory, factoryABI, "getAddress", ownerAddr, big.NewInt(0))
+	if err != nil {
+		return err
+	}
+	sender := out[0].(common.Address)
+	t.Logf("Smart account %s deployed for owner %s", sender, ownerAddr)
+
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	var granted bool
+	if err := jsonrpc.Call(ctx, httpClient, url, "pm_requestGas", []any{sender.Hex()}, &granted); err != nil {
+		return fmt.Errorf("pm_requestGas: %w", err)
+	}
+	if !granted {
+		return errors.New("pm_requestGas: gas not granted")
+	}
+
+	callData, err := accountABI.Pack("execute", ownerAddr, big.NewInt(0), []byte{})
+	if err != nil {
+		return err
+	}
+	head, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return err
+	}
+	tip := big.NewInt(1e9)
+	maxFee := new(big.Int).Add(new(big.Int).Mul(head.BaseFee, big.NewInt(2)), tip)
+	op := map[string]any{
+		"sender":               sender.Hex(),
+		"nonce":                "0x0",
+		"initCode":             "0x",
+		"callData":             hexutil.Encode(callData),
+		"callGasLimit":         "0x0",
+		"verificationGasLimit": "0x0",
+		"preVerificationGas":   "0x0",
+		"maxFeePerGas":         hexutil.EncodeBig(maxFee),
+		"maxPriorityFeePerGas": hexutil.EncodeBig(tip),
+		"paymasterAndData":     "0x",
+		"signature":            "0x",
+	}
+	var result api.PaymasterResult
+	if err := jsonrpc.Call(ctx, httpClient, url, "pm_sponsorUserOperation", []any{op, env.entryPoint.Hex()}, &result); err != nil {
+		return fmt.Errorf("pm_sponsorUserOperation: %w", err)
+	}
+
+	userOp := contracts.UserOperation{
+		Sender:               sender,
+		Nonce:                big.NewInt(0),
+		InitCode:             []byte{},
+		CallData:             callData,
+		CallGasLimit:         parseQuantity(result.CallGasLimit),
+		VerificationGasLimit: parseQuantity(result.VerificationGasLimit),
+		PreVerificationGas:   parseQuantity(result.PreVerificationGas),
+		MaxFeePerGas:         maxFee,
+		MaxPriorityFeePerGas: tip,
+		PaymasterAndData:     hexutil.MustDecode(result.PaymasterAndData),
+		Signature:            []byte{},
+	}
+	return execute(ctx, t, env, owner, userOp)
+}
+
+func execute(ctx context.Context, t *testing.T, env *environment, owner *ecdsa.PrivateKey, userOp contracts.UserOperation) error {
+	client := env.node.client
+	entryPoint, err := contracts.NewEntryPoint(env.entryPoint, client)
+	if err != nil {
+		return err
+	}
+	hash, err := entryPoint.GetUserOpHash(&bind.CallOpts{Context: ctx}, userOp)
+	if err != nil {
+		return err
+	}
+	userOp.Signature, err = utils.SignMessage(owner, hash[:])
+	if err != nil {
+		return err
+	}
+
+	tx, err := entryPoint.HandleOps(env.deployer, []contracts.UserOperation{userOp}, env.deployer.From)
+	if err != nil {
+		return fmt.Errorf("handleOps: %w", err)
+	}
+	receipt, err := bind.WaitMined(ctx, client, tx)
+	if err != nil {
+		return err
+	}
+	if receipt.Status != 1 {
+		return fmt.Errorf("handleOps: transaction %s reverted", tx.Hash())
+	}
+	for _, log := range receipt.Logs {
+		event, err := entryPoint.ParseUserOperationEvent(*log)
+		if err != nil {
+			continue
+		}
+		if event.Paymaster != env.paymaster {
+			return fmt.Errorf("userOp paid by %s, expected %s", event.Paymaster, env.paymaster)
+		}
+		if !event.Success {
+			return fmt.Errorf("userOp %s execution failed", common.Hash(event.UserOpHash))
+		}
+		t.Logf("Sponsored userOp %s included, actual gas cost %s", common.Hash(event.UserOpHash), event.ActualGasCost)
+		return nil
+	}
+	return errors.New("handleOps: no UserOperationEvent emitted")
+}
+
+func parseQuantity(s string) *big.Int {
+	n, ok := new(big.Int).SetString(strings.TrimPrefix(s, "0x"), 16)
+	if !ok {
+		return big.NewInt(0)
+	}
+	return n
+}
@@ -0,0 +1,200 @@
+package errors
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// Slugs of the errors without a paymaster reason, the other slugs are the
+// REASON_ values.
+const (
+	REASON_PARSE_ERROR            = "parse_error"
+	REASON_INVALID_REQUEST        = "invalid_request"
+	REASON_METHOD_NOT_FOUND       = "method_not_found"
+	REASON_INVALID_PARAMS         = "invalid_params"
+	REASON_INTERNAL_ERROR         = "internal_error"
+	REASON_REJECTED_BY_ENTRYPOINT = "rejected_by_entrypoint"
+	REASON_REJECTED_BY_PAYMASTER  = "rejected_by_paymaster"
+	REASON_VALIDITY_EXPIRED       = "validity_expired"
+	REASON_INVALID_AGGREGATOR     = "invalid_aggregator"
+	REASON_INVALID_SIGNATURE      = "invalid_signature"
+	REASON_INVALID_API_KEY        = "invalid_api_key"
+	REASON_API_KEY_REQUIRED       = "api_key_required"
+	REASON_RATE_LIMITED           = "rate_limited"
+	REASON_REQUEST_TOO_FREQUENT   = "request_too_frequent"
+	REASON_FINGERPRINT_THROTTLED  = "fingerprint_throttled"
+	REASON_CHAIN_BUSY             = "chain_busy"
+	REASON_ACCESS_DENIED          = "access_denied"
+	REASON_GEO_BLOCKED            = "geo_blocked"
+	REASON_HELD_FOR_APPROVAL      = "held_for_approval"
+	REASON_UPSTREAM_ERROR         = "upstream_error"
+)
+
+// Entry describes an error of the catalog. Slugs are stable, SDKs branch on
+// them instead of on messages.
+type Entry struct {
+	Slug string `json:"slug"`
+	// Code is the JSON-RPC code the error is returned with.
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	// Retryable is set when the same request may succeed later unchanged.
+	Retryable bool `json:"retryable"`
+}
+
+// Payload is the data of every error response.
+type Payload struct {
+	Slug string `json:"slug"`
+	// Reason repeats Slug for clients reading data.reason.
+	Reason    string `json:"reason"`
+	Retryable bool   `json:"retryable"`
+	// Details holds what is specific to the occurrence, such as retryAfter
+	// or the decoded revert, and the specific message in detail.
+	Details map[string]any `json:"details,omitempty"`
+}
+
+var catalog = map[string]*Entry{}
+
+// codeSlugs are the slugs of errors returned without a known reason.
+var codeSlugs = map[int]string{
+	PARSE_ERROR:           REASON_PARSE_ERROR,
+	INVALID_REQUEST:       REASON_INVALID_REQUEST,
+	METHOD_NOT_FOUND:      REASON_METHOD_NOT_FOUND,
+	INVALID_PARAMS:        REASON_INVALID_PARAMS,
+	INTERNAL_ERROR:        REASON_INTERNAL_ERROR,
+	REJECTED_BY_TYPE:      REASON_REJECTED_BY_ENTRYPOINT,
+	REJECTED_BY_PAYMASTER: REASON_REJECTED_BY_PAYMASTER,
+	SHORT_DEADLINE:        REASON_VALIDITY_EXPIRED,
+	INVALID_AGGREGATOR:    REASON_INVALID_AGGREGATOR,
+	INVALID_SIGNATURE:     REASON_INVALID_SIGNATURE,
+	INVALID_API_KEY:       REASON_INVALID_API_KEY,
+	RATE_LIMITED:          REASON_RATE_LIMITED,
+	ACCESS_DENIED:         REASON_ACCESS_DENIED,
+	HELD_FOR_APPROVAL:     REASON_HELD_FOR_APPROVAL,
+	SPONSORSHIP_PAUSED:    REASON_SPONSORSHIP_PAUSED,
+}
+
+func define(code int, slug, message string, retryable bool) {
+	catalog[slug] = &Entry{Slug: slug, Code: code, Message: message, Retryable: retryable}
+}
+
+func init() {
+	define(PARSE_ERROR, REASON_PARSE_ERROR, "The request is not valid JSON.", false)
+	define(INVALID_REQUEST, REASON_INVALID_REQUEST, "The request is not a valid JSON-RPC request.", false)
+	define(METHOD_NOT_FOUND, REASON_METHOD_NOT_FOUND, "The method is not available.", false)
+	define(INVALID_PARAMS, REASON_INVALID_PARAMS, "The request parameters are invalid.", false)
+	define(INTERNAL_ERROR, REASON_INTERNAL_ERROR, "The paymaster failed to process the request.", true)
+	define(-32000, REASON_UPSTREAM_ERROR, "An upstream service rejected the request.", false)
+
+	define(REJECTED_BY_TYPE, REASON_REJECTED_BY_ENTRYPOINT, "The EntryPoint or the account rejected the operation.", false)
+	define(SHORT_DEADLINE, REASON_VALIDITY_EXPIRED, "The validity window of the operation is too short or over.", false)
+	define(INVALID_AGGREGATOR, REASON_INVALID_AGGREGATOR, "The signature aggregator is not supported.", false)
+	define(INVALID_SIGNATURE, REASON_INVALID_SIGNATURE, "The account signature is invalid.", false)
+
+	define(INVALID_API_KEY, REASON_INVALID_API_KEY, "The api key is missing, unknown, disabled or expired.", false)
+	define(INVALID_API_KEY, REASON_API_KEY_REQUIRED, "The method needs an api key.", false)
+	define(RATE_LIMITED, REASON_RATE_LIMITED, "Too many requests, retry later.", true)
+	define(RATE_LIMITED, REASON_REQUEST_TOO_FREQUENT, "Gas was requested too recently, retry later.", true)
+	define(RATE_LIMITED, REASON_FINGERPRINT_THROTTLED, "The client is temporarily throttled.", true)
+	define(RATE_LIMITED, REASON_CHAIN_BUSY, "The chain node of the paymaster is saturated, retry later.", true)
+	define(RATE_LIMITED, REASON_SIGN_RATE_LIMITED, "The sponsorship rate of the paymaster is exceeded, retry later.", true)
+	define(RATE_LIMITED, REASON_FREE_TIER_THROTTLED, "The free tier is used up, retry later.", true)
+	define(ACCESS_DENIED, REASON_ACCESS_DENIED, "Access is not permitted.", false)
+	define(ACCESS_DENIED, REASON_GEO_BLOCKED, "Access from your region is not permitted.", false)
+	define(ACCESS_DENIED, REASON_SCOPE_DENIED, "The method is not allowed for this api key.", false)
+	define(HELD_FOR_APPROVAL, REASON_HELD_FOR_APPROVAL, "The operation is held for approval.", false)
+	define(SPONSORSHIP_PAUSED, REASON_SPONSORSHIP_PAUSED, "Sponsorships are paused.", true)
+	define(SPONSORSHIP_PAUSED, REASON_MAINTENANCE, "The paymaster is in scheduled maintenance.", true)
+
+	define(REJECTED_BY_PAYMASTER, REASON_REJECTED_BY_PAYMASTER, "The paymaster rejected the operation.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_INSUFFICIENT_GAS, "The sender has not enough gas quota left.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_ACCOUNT_DISABLED, "The account is disabled.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_POLICY_REJECTED, "The sponsorship policy rejected the operation.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_POLICY_TIMEOUT, "The sponsorship policy did not answer in time.", true)
+	define(REJECTED_BY_PAYMASTER, REASON_GAS_LIMIT, "A gas limit of the operation is out of bounds.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_PREFUND_LIMIT, "The prefund of the operation is too high.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_OP_COST_LIMIT, "The operation costs too much to sponsor.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_TARGET_NOT_ALLOWED, "The operation calls a contract that is not sponsored.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_SELECTOR_NOT_ALLOWED, "The operation calls a function that is not sponsored.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_DELEGATECALL, "The operation delegates a call to a contract that is not trusted.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_SENDER_MISMATCH, "The sender does not match its initCode.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_UNKNOWN_FACTORY, "The account factory is not supported.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_VALUE_LIMIT, "The operation sends too much value.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_SESSION_INVALID, "The session is unknown, expired or of another sender.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_SESSION_QUOTA, "The session quota is used up.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_INSUFFICIENT_BUDGET, "The api key budget is too low.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_BUDGET_EXHAUSTED, "The monthly budget of the project is spent.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_PAYMENT_FAILED, "The project payment failed.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_OUTSIDE_WINDOW, "Sponsorships are not available at this time.", true)
+	define(REJECTED_BY_PAYMASTER, REASON_SCREENING_BLOCKED, "The sender is blocked by compliance screening.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_SCREENING_UNAVAILABLE, "Compliance screening is unavailable.", true)
+	define(REJECTED_BY_PAYMASTER, REASON_HOLD_REJECTED, "An operator rejected the operation.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_REPLAYED_REQUEST, "The request was already processed or is too old.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_VALIDITY_NOT_ALLOWED, "The validity window is longer than allowed.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_QUOTE_INVALID, "The quote is invalid, expired or exceeded.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_NONCE_GAP, "The nonce is used or too far ahead.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_AGGREGATOR_NOT_ALLOWED, "The signature aggregator is not allowed.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_PASSKEY_REQUIRED, "A passkey assertion is required.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_PASSKEY_INVALID, "The passkey assertion is invalid.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_IDENTITY_REQUIRED, "A linked identity is required.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_IDENTITY_INVALID, "The identity could not be verified.", false)
+	define(REJECTED_BY_PAYMASTER, REASON_IDENTITY_IN_USE, "The identity is linked to another address.", false)
+}
+
+// Lookup returns the catalog entry of slug, nil when there is none.
+func Lookup(slug string) *Entry {
+	return catalog[slug]
+}
+
+// Catalog returns every entry by slug.
+func Catalog() []*Entry {
+	entries := make([]*Entry, 0, len(catalog))
+	for _, entry := range catalog {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Slug < entries[j].Slug })
+	return entries
+}
+
+// Describe returns the catalog entry of e and the payload of its response.
+// The slug is the reason of e when the catalog has it, else the one of its
+// code. The data of e becomes the details, with the specific message of e,
+// or its data when it is a string, in detail.
+func (e *RPCError) Describe() (*Entry, *Payload) {
+	entry := catalog[e.Reason()]
+	if entry == nil {
+		entry = catalog[codeSlugs[e.code]]
+	}
+	if entry == nil {
+		entry = catalog[REASON_UPSTREAM_ERROR]
+	}
+
+	details := map[string]any{}
+	switch data := e.data.(type) {
+	case nil:
+	case map[string]any:
+		for k, v := range data {
+			if k != "reason" || v != entry.Slug {
+				details[k] = v
+			}
+		}
+	case string:
+		details["detail"] = data
+	default:
+		// structured data, e.g. a decoded revert
+		if raw, err := json.Marshal(data); err == nil {
+			var fields map[string]any
+			if json.Unmarshal(raw, &fields) == nil {
+				details = fields
+			} else {
+				details["data"] = data
+			}
+		}
+	}
+	if _, ok := details["detail"]; !ok && e.message != "" && e.message != entry.Message {
+		details["detail"] = e.message
+	}
+	if len(details) == 0 {
+		details = nil
+	}
+	return entry, &Payload{Slug: entry.Slug, Reason: entry.Slug, Retryable: entry.Retryable, Details: details}
+}
@@ -1,7 +1,80 @@
 package errors
 
+import "strings"
+
+// JSON-RPC 2.0 error codes.
 var (
-	REJECTED_BY_TYPE = -32500
+	PARSE_ERROR      = -32700
+	INVALID_REQUEST  = -32600
+	METHOD_NOT_FOUND = -32601
+	INVALID_PARAMS   = -32602
+	INTERNAL_ERROR   = -32603
+)
+
+// ERC-4337 error codes, see https://eips.ethereum.org/EIPS/eip-4337#rpc-methods-eth-namespace.
+var (
+	REJECTED_BY_TYPE              = -32500
+	REJECTED_BY_PAYMASTER         = -32501
+	BANNED_OPCODE                 = -32502
+	SHORT_DEADLINE                = -32503
+	BANNED_OR_THROTTLED_PAYMASTER = -32504
+	INVALID_PAYMASTER_STAKE       = -32505
+	INVALID_AGGREGATOR            = -32506
+	INVALID_SIGNATURE             = -32507
+)
+
+// Paymaster service error codes.
+var (
+	INVALID_API_KEY = -32001
+	RATE_LIMITED    = -32005
+	ACCESS_DENIED   = -32006
+	// HELD_FOR_APPROVAL is returned for operations queued for manual
+	// approval, data.userOpHash is the hash to poll.
+	HELD_FOR_APPROVAL = -32007
+	// SPONSORSHIP_PAUSED is returned while an operator paused sponsorships
+	// for maintenance, read methods keep working.
+	SPONSORSHIP_PAUSED = -32008
+)
+
+// Reasons reported in the data field of REJECTED_BY_PAYMASTER errors.
+const (
+	REASON_INSUFFICIENT_GAS       = "insufficient_gas"
+	REASON_ACCOUNT_DISABLED       = "account_disabled"
+	REASON_POLICY_REJECTED        = "policy_rejected"
+	REASON_POLICY_TIMEOUT         = "policy_timeout"
+	REASON_GAS_LIMIT              = "gas_limit"
+	REASON_PREFUND_LIMIT          = "prefund_limit"
+	REASON_OP_COST_LIMIT          = "op_cost_limit"
+	REASON_SIGN_RATE_LIMITED      = "sign_rate_limited"
+	REASON_TARGET_NOT_ALLOWED     = "target_not_allowed"
+	REASON_DELEGATECALL           = "delegatecall_not_allowed"
+	REASON_SELECTOR_NOT_ALLOWED   = "selector_not_allowed"
+	REASON_SENDER_MISMATCH        = "sender_mismatch"
+	REASON_UNKNOWN_FACTORY        = "unknown_factory"
+	REASON_VALUE_LIMIT            = "value_limit"
+	REASON_SESSION_INVALID        = "session_invalid"
+	REASON_SESSION_QUOTA          = "session_quota"
+	REASON_INSUFFICIENT_BUDGET    = "insufficient_budget"
+	REASON_BUDGET_EXHAUSTED       = "budget_exhausted"
+	REASON_PAYMENT_FAILED         = "payment_failed"
+	REASON_FREE_TIER_THROTTLED    = "free_tier_throttled"
+	REASON_OUTSIDE_WINDOW         = "outside_window"
+	REASON_SCREENING_BLOCKED      = "screening_blocked"
+	REASON_SCREENING_UNAVAILABLE  = "screening_unavailable"
+	REASON_HOLD_REJECTED          = "hold_rejected"
+	REASON_SPONSORSHIP_PAUSED     = "sponsorship_paused"
+	REASON_MAINTENANCE            = "maintenance"
+	REASON_REPLAYED_REQUEST       = "replayed_request"
+	REASON_SCOPE_DENIED           = "scope_denied"
+	REASON_VALIDITY_NOT_ALLOWED   = "validity_not_allowed"
+	REASON_QUOTE_INVALID          = "quote_invalid"
+	REASON_NONCE_GAP              = "nonce_gap"
+	REASON_AGGREGATOR_NOT_ALLOWED = "aggregator_not_allowed"
+	REASON_PASSKEY_REQUIRED       = "passkey_required"
+	REASON_PASSKEY_INVALID        = "passkey_invalid"
+	REASON_IDENTITY_REQUIRED      = "identity_required"
+	REASON_IDENTITY_INVALID       = "identity_invalid"
+	REASON_IDENTITY_IN_USE        = "identity_in_use"
 )
 
 type RPCError struct {
@@ -14,6 +87,37 @@ func NewRPCError(code int, message string, data any) error {
 	return &RPCError{code, message, data}
 }
 
+// RejectedByPaymaster returns a REJECTED_BY_PAYMASTER error whose data carries
+// a machine readable reason.
+func RejectedByPaymaster(message string, reason string) error {
+	return &RPCError{REJECTED_BY_PAYMASTER, message, map[string]any{"reason": reason}}
+}
+
+// FailedOpCode maps an EntryPoint FailedOp reason (AAxx) to an ERC-4337 error code.
+func FailedOpCode(reason string) int {
+	switch {
+	case strings.HasPrefix(reason, "AA22"), strings.HasPrefix(reason, "AA32"):
+		return SHORT_DEADLINE
+	case strings.HasPrefix(reason, "AA3"):
+		return REJECTED_BY_PAYMASTER
+	case strings.HasPrefix(reason, "AA24"):
+		return INVALID_SIGNATURE
+	case strings.HasPrefix(reason, "AA96"):
+		return INVALID_AGGREGATOR
+	default:
+		return REJECTED_BY_TYPE
+	}
+}
+
+// Wrap returns err unchanged when it is already an RPCError, otherwise it is
+// reported as an internal error.
+func Wrap(err error) *RPCError {
+	if rpcErr, ok := err.(*RPCError); ok {
+		return rpcErr
+	}
+	return &RPCError{INTERNAL_ERROR, err.Error(), nil}
+}
+
 func (e *RPCError) Error() string {
 	return e.message
 }
@@ -25,3 +129,13 @@ func (e *RPCError) Data() any {
 func (e *RPCError) Code() int {
 	return e.code
 }
+
+// Reason returns the data reason of REJECTED_BY_PAYMASTER errors, or "".
+func (e *RPCError) Reason() string {
+	data, ok := e.data.(map[string]any)
+	if !ok {
+		return ""
+	}
+	reason, _ := data["reason"].(string)
+	return reason
+}
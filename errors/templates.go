@@ -0,0 +1,134 @@
+package errors
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/language"
+)
+
+// placeholder is a reference to a detail of the error in a template.
+var placeholder = regexp.MustCompile(`\{([A-Za-z][A-Za-z0-9_]*)\}`)
+
+// Templates replace the catalog messages of errors by slug, per locale. The
+// empty locale applies to every client. Templates are safe for concurrent
+// use.
+type Templates struct {
+	mu sync.RWMutex
+	// base are the templates of the configuration, overrides those of the
+	// database, which win over base.
+	base      map[string]map[string]string
+	overrides map[string]map[string]string
+	merged    map[string]map[string]string
+}
+
+// ParseTemplates returns the templates of the JSON object config, messages
+// by locale and slug. Every slug must be in the catalog.
+func ParseTemplates(config string) (*Templates, error) {
+	t := &Templates{}
+	if config == "" {
+		return t, nil
+	}
+	var raw map[string]map[string]string
+	if err := json.Unmarshal([]byte(config), &raw); err != nil {
+		return nil, fmt.Errorf("parse error templates: %v", err)
+	}
+	base := make(map[string]map[string]string, len(raw))
+	for locale, messages := range raw {
+		locale = NormalizeLocale(locale)
+		for slug, message := range messages {
+			if catalog[slug] == nil {
+				return nil, fmt.Errorf("error template of unknown slug %q", slug)
+			}
+			if base[locale] == nil {
+				base[locale] = make(map[string]string)
+			}
+			base[locale][slug] = message
+		}
+	}
+	t.base = base
+	t.merge()
+	return t, nil
+}
+
+// NormalizeLocale returns locale as templates are keyed, lower case with
+// dashes. "default" is the empty locale.
+func NormalizeLocale(locale string) string {
+	locale = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+	if locale == "default" || locale == "*" {
+		return ""
+	}
+	return locale
+}
+
+// SetOverrides replaces the templates taking precedence over those of the
+// configuration, messages by locale and slug.
+func (t *Templates) SetOverrides(overrides map[string]map[string]string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.overrides = overrides
+	t.merge()
+}
+
+// merge combines the base and override templates, t.mu held.
+func (t *Templates) merge() {
+	merged := make(map[string]map[string]string)
+	for _, layer := range []map[string]map[string]string{t.base, t.overrides} {
+		for locale, messages := range layer {
+			if merged[locale] == nil {
+				merged[locale] = make(map[string]string)
+			}
+			for slug, message := range messages {
+				merged[locale][slug] = message
+			}
+		}
+	}
+	t.merged = merged
+}
+
+// Message returns the message of the error of entry and payload for a
+// client sending the Accept-Language header acceptLanguage. The template of
+// the first accepted locale having one is used, a region falling back to
+// its language, then the template of the empty locale and finally the
+// catalog message. {name} in a template is replaced by the detail name.
+func (t *Templates) Message(entry *Entry, payload *Payload, acceptLanguage string) string {
+	if t == nil {
+		return entry.Message
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if len(t.merged) == 0 {
+		return entry.Message
+	}
+
+	var locales []string
+	if tags, _, err := language.ParseAcceptLanguage(acceptLanguage); err == nil {
+		for _, tag := range tags {
+			locales = append(locales, NormalizeLocale(tag.String()))
+			if base, confidence := tag.Base(); confidence != language.No {
+				locales = append(locales, base.String())
+			}
+		}
+	}
+	locales = append(locales, "")
+	for _, locale := range locales {
+		if message, ok := t.merged[locale][entry.Slug]; ok {
+			return render(message, payload.Details)
+		}
+	}
+	return entry.Message
+}
+
+// render replaces the placeholders of message by the details they name,
+// leaving those of missing details as they are.
+func render(message string, details map[string]any) string {
+	return placeholder.ReplaceAllStringFunc(message, func(ref string) string {
+		if value, ok := details[ref[1:len(ref)-1]]; ok {
+			return fmt.Sprint(value)
+		}
+		return ref
+	})
+}
@@ -0,0 +1,131 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const bigQueryAudience = "https://bigquery.googleapis.com/"
+
+// BigQuerySink streams batches into a BigQuery table with insertAll. Rows
+// carry an insert id so retried batches are deduplicated.
+type BigQuerySink struct {
+	Project string
+	Dataset string
+	Table   string
+
+	email  string
+	keyID  string
+	key    any
+	client *http.Client
+}
+
+// NewBigQuerySink authenticates with the service account key file at
+// credentials.
+func NewBigQuerySink(project, dataset, table, credentials string) (*BigQuerySink, error) {
+	data, err := os.ReadFile(credentials)
+	if err != nil {
+		return nil, err
+	}
+	var account struct {
+		ClientEmail  string `json:"client_email"`
+		PrivateKeyID string `json:"private_key_id"`
+		PrivateKey   string `json:"private_key"`
+	}
+	if err := json.Unmarshal(data, &account); err != nil {
+		return nil, err
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(account.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+	return &BigQuerySink{
+		Project: project,
+		Dataset: dataset,
+		Table:   table,
+		email:   account.ClientEmail,
+		keyID:   account.PrivateKeyID,
+		key:     key,
+		client:  &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+func (b *BigQuerySink) Name() string {
+	return "bigquery"
+}
+
+func (b *BigQuerySink) Write(ctx context.Context, records []*Record) error {
+	type row struct {
+		InsertID string  `json:"insertId"`
+		JSON     *Record `json:"json"`
+	}
+	rows := make([]row, len(records))
+	for n, rec := range records {
+		rows[n] = row{
+			InsertID: rec.UserOpHash + ":" + strconv.FormatInt(rec.UpdatedAt.UnixMicro(), 10),
+			JSON:     rec,
+		}
+	}
+	body, err := json.Marshal(map[string]any{"rows": rows})
+	if err != nil {
+		return err
+	}
+	token, err := b.token(time.Now())
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("https://bigquery.googleapis.com/bigquery/v2/projects/%s/datasets/%s/tables/%s/insertAll",
+		b.Project, b.Dataset, b.Table)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("insertAll: status %d: %s", resp.StatusCode, data)
+	}
+	var result struct {
+		InsertErrors []json.RawMessage `json:"insertErrors"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return err
+	}
+	if len(result.InsertErrors) > 0 {
+		return fmt.Errorf("insertAll: %d rows failed, first: %s", len(result.InsertErrors), result.InsertErrors[0])
+	}
+	return nil
+}
+
+// token returns a self-signed service account JWT, accepted by Google APIs
+// as access token for their audience.
+func (b *BigQuerySink) token(now time.Time) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.RegisteredClaims{
+		Issuer:    b.email,
+		Subject:   b.email,
+		Audience:  jwt.ClaimStrings{bigQueryAudience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+	})
+	token.Header["kid"] = b.keyID
+	return token.SignedString(b.key)
+}
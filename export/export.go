@@ -0,0 +1,172 @@
+// Package export ships sponsorship and settlement records to a data
+// warehouse on a schedule.
+package export
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// Record is an exported sponsorship version. A sponsorship is exported when
+// signed and again when settled or reorged, the latest updatedAt wins.
+type Record struct {
+	ChainID       string    `json:"chainId"`
+	UserOpHash    string    `json:"userOpHash"`
+	Sender        string    `json:"sender"`
+	Nonce         string    `json:"nonce"`
+	ApiKeyID      uint      `json:"apiKeyId"`
+	Target        string    `json:"target"`
+	Value         string    `json:"value"`
+	Status        string    `json:"status"`
+	MaxGasCost    string    `json:"maxGasCost"`
+	ActualGasCost string    `json:"actualGasCost"`
+	ValidUntil    time.Time `json:"validUntil"`
+	BlockNumber   uint64    `json:"blockNumber"`
+	BlockHash     string    `json:"blockHash"`
+	TxHash        string    `json:"txHash"`
+	LogIndex      uint      `json:"logIndex"`
+	ReceiptTxHash string    `json:"receiptTxHash"`
+	// SurchargePercent is the fee billed on top of the gas cost.
+	SurchargePercent uint64    `json:"surchargePercent"`
+	CreatedAt        time.Time `json:"createdAt"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+}
+
+// NewRecord converts a sponsorship, reporting its status at now. chainID is
+// used for sponsorships that do not record their chain.
+func NewRecord(chainID *big.Int, rec *models.Sponsorship, now time.Time) *Record {
+	if rec.ChainID != 0 {
+		chainID = new(big.Int).SetUint64(rec.ChainID)
+	}
+	return &Record{
+		ChainID:          chainID.String(),
+		UserOpHash:       rec.UserOpHash,
+		Sender:           rec.Sender,
+		Nonce:            rec.Nonce,
+		ApiKeyID:         rec.ApiKeyID,
+		Target:           rec.Target,
+		Value:            rec.Value,
+		Status:           rec.CurrentStatus(now),
+		MaxGasCost:       rec.MaxGasCost,
+		ActualGasCost:    rec.ActualGasCost,
+		ValidUntil:       rec.ValidUntil,
+		BlockNumber:      rec.BlockNumber,
+		BlockHash:        rec.BlockHash,
+		TxHash:           rec.TxHash,
+		LogIndex:         rec.LogIndex,
+		ReceiptTxHash:    rec.ReceiptTxHash,
+		SurchargePercent: rec.SurchargePercent,
+		CreatedAt:        rec.CreatedAt,
+		UpdatedAt:        rec.UpdatedAt,
+	}
+}
+
+// Sink stores batches of records. Write may be retried with the same batch
+// after a failure, sinks should overwrite or deduplicate.
+type Sink interface {
+	// Name identifies the export cursor of the sink.
+	Name() string
+	Write(ctx context.Context, records []*Record) error
+}
+
+type Config struct {
+	ChainID   *big.Int
+	BatchSize int
+	Interval  time.Duration
+}
+
+// Exporter ships the sponsorships of its chain created or updated since its
+// cursor to the sink every interval.
+type Exporter struct {
+	conf *Config
+	sink Sink
+	rep  db.Repository
+}
+
+func NewExporter(conf *Config, sink Sink, rep db.Repository) *Exporter {
+	if conf.BatchSize == 0 {
+		conf.BatchSize = 1000
+	}
+	if conf.Interval == 0 {
+		conf.Interval = time.Hour
+	}
+	return &Exporter{
+		conf: conf,
+		sink: sink,
+		rep:  rep,
+	}
+}
+
+// Run exports until ctx is cancelled. Full batches are shipped back to back,
+// otherwise it waits for the next interval.
+func (e *Exporter) Run(ctx context.Context) {
+	logger.S().Infof("Exporting chain %s sponsorships to %s", e.conf.ChainID, e.sink.Name())
+	for {
+		n, err := e.step(ctx)
+		if err != nil {
+			logger.S().Errorf("export to %s error: %v", e.sink.Name(), err)
+		}
+		if err == nil && n == e.conf.BatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(e.conf.Interval):
+		}
+	}
+}
+
+// step exports the next batch and returns its size.
+func (e *Exporter) step(ctx context.Context) (int, error) {
+	cursor, err := e.cursor()
+	if err != nil {
+		return 0, err
+	}
+	recs, err := (&models.Sponsorship{}).FindUpdatedAfter(e.rep, cursor.LastUpdatedAt, cursor.LastID, e.conf.ChainID.Uint64(), "", e.conf.BatchSize)
+	if err != nil || len(recs) == 0 {
+		return 0, err
+	}
+
+	now := time.Now()
+	records := make([]*Record, len(recs))
+	for n := range recs {
+		records[n] = NewRecord(e.conf.ChainID, &recs[n], now)
+	}
+	if err := e.sink.Write(ctx, records); err != nil {
+		return 0, err
+	}
+
+	last := recs[len(recs)-1]
+	cursor.LastUpdatedAt = last.UpdatedAt
+	cursor.LastID = last.ID
+	if err := e.rep.Save(cursor).Error; err != nil {
+		return 0, err
+	}
+	return len(recs), nil
+}
+
+// cursor returns the cursor of the sink on the chain. A new cursor starts
+// where the cursor the sink kept for all chains stopped.
+func (e *Exporter) cursor() (*models.ExportCursor, error) {
+	name := models.ChainCheckpoint(e.sink.Name(), e.conf.ChainID.Uint64())
+	cursor, err := (&models.ExportCursor{}).FindBySink(e.rep, name)
+	if err != nil || cursor != nil {
+		return cursor, err
+	}
+	cursor = &models.ExportCursor{Sink: name}
+	shared, err := (&models.ExportCursor{}).FindBySink(e.rep, e.sink.Name())
+	if err != nil {
+		return nil, err
+	}
+	if shared != nil {
+		cursor.LastUpdatedAt = shared.LastUpdatedAt
+		cursor.LastID = shared.LastID
+	}
+	return cursor, nil
+}
@@ -0,0 +1,147 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// S3Sink writes every batch as a JSON lines object to an S3 compatible
+// bucket, named after its first record so retries overwrite the object.
+type S3Sink struct {
+	Bucket string
+	// Prefix is prepended to the object keys.
+	Prefix string
+	Region string
+	// Endpoint overrides the AWS endpoint, e.g. for MinIO or R2.
+	Endpoint        string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	client *http.Client
+}
+
+func (s *S3Sink) Name() string {
+	return "s3"
+}
+
+func (s *S3Sink) Write(ctx context.Context, records []*Record) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	first := records[0]
+	key := fmt.Sprintf("sponsorships/chain=%s/dt=%s/%d-%d.jsonl",
+		first.ChainID, first.UpdatedAt.UTC().Format("2006-01-02"), first.UpdatedAt.UnixMicro(), len(records))
+	if s.Prefix != "" {
+		key = strings.TrimSuffix(s.Prefix, "/") + "/" + key
+	}
+	return s.put(ctx, key, body.Bytes())
+}
+
+func (s *S3Sink) put(ctx context.Context, key string, body []byte) error {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", s.Region)
+	}
+	path := "/" + s.Bucket + "/" + uriEncode(key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, strings.TrimSuffix(endpoint, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	s.sign(req, path, body, time.Now().UTC())
+
+	if s.client == nil {
+		s.client = &http.Client{Timeout: time.Minute}
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("put %s: status %d: %s", key, resp.StatusCode, msg)
+	}
+	return nil
+}
+
+// sign adds an AWS signature version 4 authorization to req.
+func (s *S3Sink) sign(req *http.Request, path string, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	headers := []string{"content-type", "host", "x-amz-content-sha256", "x-amz-date"}
+	if s.SessionToken != "" {
+		headers = append(headers, "x-amz-security-token")
+	}
+	var canonicalHeaders strings.Builder
+	for _, h := range headers {
+		value := req.Header.Get(h)
+		if h == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(h + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, "", canonicalHeaders.String(), signedHeaders, payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// uriEncode escapes everything but unreserved characters and slashes, as
+// required for the canonical request.
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
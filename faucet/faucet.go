@@ -0,0 +1,206 @@
+// Package faucet drips native tokens from a funded EOA to brand-new
+// accounts on testnets, next to the sponsorship quota they are granted.
+package faucet
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/ququzone/verifying-paymaster-service/chain"
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// maxAttempts is how often a drip is sent before it is given up.
+const maxAttempts = 5
+
+type Config struct {
+	// Key is the funded EOA the drips are sent from.
+	Key     *ecdsa.PrivateKey
+	ChainID *big.Int
+	// Testnets are the chains the faucet may run on, it refuses any other.
+	Testnets map[uint64]bool
+	// Amount is the native value in wei dripped to each new account.
+	Amount *big.Int
+	// DailyCap bounds the value dripped per UTC day, nil disables the cap.
+	DailyCap *big.Int
+	// Cooldown is how long a client IP waits between drips.
+	Cooldown time.Duration
+	// BatchSize is the maximum number of drips sent per PollInterval.
+	BatchSize    int
+	PollInterval time.Duration
+}
+
+// Faucet queues a drip for every new account on its chain and sends the
+// queue in order from a single EOA, so its nonces never collide.
+type Faucet struct {
+	conf   *Config
+	client chain.Client
+	rep    db.Repository
+	from   common.Address
+}
+
+func New(conf *Config, client chain.Client, rep db.Repository) (*Faucet, error) {
+	if !conf.Testnets[conf.ChainID.Uint64()] {
+		return nil, fmt.Errorf("faucet refuses to run on chain %s, not a listed testnet", conf.ChainID)
+	}
+	if conf.Amount == nil || conf.Amount.Sign() <= 0 {
+		return nil, fmt.Errorf("faucet amount must be positive")
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = 20
+	}
+	if conf.PollInterval == 0 {
+		conf.PollInterval = 15 * time.Second
+	}
+	return &Faucet{
+		conf:   conf,
+		client: client,
+		rep:    rep,
+		from:   crypto.PubkeyToAddress(conf.Key.PublicKey),
+	}, nil
+}
+
+// Enqueue queues a drip to the new account address requested from
+// clientIP. It reports false when the address already had one, the IP is
+// cooling down or the daily cap is reached.
+func (f *Faucet) Enqueue(address, clientIP string, now time.Time) (bool, error) {
+	chainID := f.conf.ChainID.Uint64()
+	queued := false
+	err := f.rep.Transaction(func(tx db.Repository) error {
+		// drips are counted against the cap and cooldown of the chain one at
+		// a time
+		if err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext(?))`, fmt.Sprintf("faucet:%d", chainID)).Error; err != nil {
+			return err
+		}
+		var count int64
+		err := tx.Model(&models.FaucetDrip{}).Where(`"chain_id" = ? AND "address" = ?`, chainID, address).Count(&count).Error
+		if err != nil || count > 0 {
+			return err
+		}
+		if clientIP != "" && f.conf.Cooldown > 0 {
+			err := tx.Model(&models.FaucetDrip{}).
+				Where(`"chain_id" = ? AND "client_ip" = ? AND "created_at" > ?`, chainID, clientIP, now.Add(-f.conf.Cooldown)).
+				Count(&count).Error
+			if err != nil || count > 0 {
+				return err
+			}
+		}
+		if f.conf.DailyCap != nil {
+			dripped, err := models.DrippedSince(tx, chainID, models.FaucetDay(now))
+			if err != nil {
+				return err
+			}
+			if new(big.Int).Add(dripped, f.conf.Amount).Cmp(f.conf.DailyCap) > 0 {
+				return nil
+			}
+		}
+		queued = true
+		return tx.Create(&models.FaucetDrip{
+			ChainID:  chainID,
+			Address:  address,
+			ClientIP: clientIP,
+			Amount:   f.conf.Amount.String(),
+			Status:   models.DripQueued,
+		}).Error
+	})
+	return queued, err
+}
+
+// Run sends queued drips until ctx is cancelled.
+func (f *Faucet) Run(ctx context.Context) {
+	logger.S().Infof("Faucet started on chain %s from %s", f.conf.ChainID, f.from.Hex())
+	for {
+		sent, err := f.step(ctx)
+		if err != nil {
+			logger.S().Errorf("faucet error: %v", err)
+		}
+		if err == nil && sent == f.conf.BatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(f.conf.PollInterval):
+		}
+	}
+}
+
+// step sends the oldest queued drips and returns how many were sent. A
+// drip that cannot be sent stays queued until maxAttempts.
+func (f *Faucet) step(ctx context.Context) (int, error) {
+	var drips []models.FaucetDrip
+	err := f.rep.Where(`"chain_id" = ? AND "status" = ?`, f.conf.ChainID.Uint64(), models.DripQueued).Order("id").Limit(f.conf.BatchSize).Find(&drips).Error
+	if err != nil || len(drips) == 0 {
+		return 0, err
+	}
+	balance, err := f.client.BalanceAt(ctx, f.from, nil)
+	if err != nil {
+		return 0, err
+	}
+	nonce, err := f.client.PendingNonceAt(ctx, f.from)
+	if err != nil {
+		return 0, err
+	}
+	gasPrice, err := f.client.SuggestGasPrice(ctx)
+	if err != nil {
+		return 0, err
+	}
+	signer := types.LatestSignerForChainID(f.conf.ChainID)
+
+	sent := 0
+	for i := range drips {
+		drip := &drips[i]
+		to := common.HexToAddress(drip.Address)
+		amount := models.ParseGas(drip.Amount)
+		gas, err := f.client.EstimateGas(ctx, ethereum.CallMsg{From: f.from, To: &to, Value: amount})
+		if err == nil {
+			cost := new(big.Int).Add(amount, new(big.Int).Mul(gasPrice, new(big.Int).SetUint64(gas)))
+			if balance.Cmp(cost) < 0 {
+				return sent, fmt.Errorf("faucet %s balance %s too low", f.from.Hex(), balance)
+			}
+			var tx *types.Transaction
+			tx, err = types.SignNewTx(f.conf.Key, signer, &types.LegacyTx{
+				Nonce:    nonce,
+				To:       &to,
+				Value:    amount,
+				Gas:      gas,
+				GasPrice: gasPrice,
+			})
+			if err == nil {
+				err = f.client.SendTransaction(ctx, tx)
+			}
+			if err == nil {
+				balance.Sub(balance, cost)
+				nonce++
+				sent++
+				now := time.Now()
+				drip.Status = models.DripSent
+				drip.TxHash = tx.Hash().Hex()
+				drip.SentAt = &now
+				logger.S().Infof("Faucet sent %s wei to %s in %s", drip.Amount, drip.Address, drip.TxHash)
+			}
+		}
+		if err != nil {
+			drip.Attempts++
+			drip.Error = err.Error()
+			if drip.Attempts >= maxAttempts {
+				drip.Status = models.DripFailed
+			}
+			logger.S().Warnf("faucet drip to %s error: %v", drip.Address, err)
+		}
+		if err := f.rep.Save(drip).Error; err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
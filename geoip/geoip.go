@@ -0,0 +1,111 @@
+// Package geoip restricts the rpc endpoint by the country of the client IP.
+package geoip
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/jsonrpc"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// Unknown is the country of addresses the database has no country for.
+const Unknown = "ZZ"
+
+// GeoIP refuses requests from denied countries, or from countries not
+// allowed when there is an allow list. Api keys with their own lists use
+// them instead of the deployment lists.
+type GeoIP struct {
+	reader *Reader
+	allow  map[string]bool
+	deny   map[string]bool
+	rep    db.Repository
+}
+
+func New(path string, allow, deny []string, rep db.Repository) (*GeoIP, error) {
+	reader, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &GeoIP{reader: reader, allow: countries(allow), deny: countries(deny), rep: rep}, nil
+}
+
+func countries(list []string) map[string]bool {
+	set := make(map[string]bool, len(list))
+	for _, c := range list {
+		if c = strings.ToUpper(strings.TrimSpace(c)); c != "" {
+			set[c] = true
+		}
+	}
+	return set
+}
+
+// Country returns the ISO 3166-1 code of ip, Unknown when not found.
+func (g *GeoIP) Country(ip net.IP) string {
+	record, err := g.reader.Lookup(ip)
+	if err != nil {
+		logger.S().Warnf("geoip lookup %s error: %v", ip, err)
+		return Unknown
+	}
+	for _, field := range []string{"country", "registered_country"} {
+		if c, ok := lookup(record, field, "iso_code").(string); ok && c != "" {
+			return c
+		}
+	}
+	return Unknown
+}
+
+func lookup(record any, path ...string) any {
+	for _, key := range path {
+		m, ok := record.(map[string]any)
+		if !ok {
+			return nil
+		}
+		record = m[key]
+	}
+	return record
+}
+
+func permitted(country string, allow, deny map[string]bool) bool {
+	if deny[country] {
+		return false
+	}
+	return len(allow) == 0 || allow[country]
+}
+
+// Middleware refuses requests of the /rpc/:key route from countries the
+// policy does not permit and records them.
+func (g *GeoIP) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ip := net.ParseIP(c.ClientIP())
+		country := g.Country(ip)
+		allow, deny := g.allow, g.deny
+		key, err := (&models.ApiKeys{}).FindByKey(g.rep, c.Param("key"))
+		if err != nil {
+			logger.S().Errorf("Query api key error: %v", err)
+		}
+		if key != nil && (key.GeoAllow != "" || key.GeoDeny != "") {
+			allow = countries(strings.Split(key.GeoAllow, ","))
+			deny = countries(strings.Split(key.GeoDeny, ","))
+		}
+		if permitted(country, allow, deny) {
+			c.Next()
+			return
+		}
+
+		block := &models.GeoBlock{IP: ip.String(), Country: country}
+		if key != nil {
+			block.ApiKeyID = key.ID
+		}
+		logger.S().Infof("GeoIP refused request of api key %d from %s (%s)", block.ApiKeyID, block.IP, country)
+		if err := g.rep.Create(block).Error; err != nil {
+			logger.S().Errorf("save geoip block error: %v", err)
+		}
+		jsonrpc.Abort(c, errors.ACCESS_DENIED, "access from your region is not permitted", map[string]any{"reason": errors.REASON_GEO_BLOCKED, "country": country})
+	}
+}
@@ -0,0 +1,278 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+// metadataMarker starts the metadata section at the end of a MaxMind DB.
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// Reader looks up records in a MaxMind DB file, see
+// https://maxmind.github.io/MaxMind-DB/. The file is read into memory.
+type Reader struct {
+	buf        []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	// data is the data section
+	data []byte
+	// ipv4Start is the node of ::/96 in an IPv6 tree
+	ipv4Start uint
+}
+
+func Open(path string) (*Reader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	start := bytes.LastIndex(buf, metadataMarker)
+	if start == -1 {
+		return nil, errors.New("mmdb: metadata not found")
+	}
+	d := &decoder{buf: buf[start+len(metadataMarker):]}
+	value, _, err := d.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("mmdb: metadata: %w", err)
+	}
+	metadata, ok := value.(map[string]any)
+	if !ok {
+		return nil, errors.New("mmdb: invalid metadata")
+	}
+	r := &Reader{
+		buf:        buf,
+		nodeCount:  uintOf(metadata["node_count"]),
+		recordSize: uintOf(metadata["record_size"]),
+		ipVersion:  uintOf(metadata["ip_version"]),
+	}
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("mmdb: unsupported record size %d", r.recordSize)
+	}
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+16 > uint(start) {
+		return nil, errors.New("mmdb: invalid search tree size")
+	}
+	r.data = buf[treeSize+16 : start]
+	if r.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.nodeCount; i++ {
+			node = r.record(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+func uintOf(v any) uint {
+	n, _ := v.(uint64)
+	return uint(n)
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node.
+func (r *Reader) record(node uint, bit uint) uint {
+	switch r.recordSize {
+	case 24:
+		b := r.buf[node*6+bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := r.buf[node*7:]
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(r.buf[node*8+bit*4:]))
+	}
+}
+
+// Lookup returns the record of ip, nil when the database has none.
+func (r *Reader) Lookup(ip net.IP) (any, error) {
+	node := uint(0)
+	bits := net.IP(nil)
+	if v4 := ip.To4(); v4 != nil {
+		bits = v4
+		if r.ipVersion == 6 {
+			node = r.ipv4Start
+		}
+	} else if r.ipVersion == 6 {
+		bits = ip.To16()
+	}
+	if bits == nil {
+		return nil, nil
+	}
+	for i := 0; i < len(bits)*8 && node < r.nodeCount; i++ {
+		bit := uint(bits[i/8]>>(7-uint(i%8))) & 1
+		node = r.record(node, bit)
+	}
+	if node == r.nodeCount {
+		return nil, nil
+	}
+	if node < r.nodeCount {
+		return nil, errors.New("mmdb: invalid search tree")
+	}
+	offset := node - r.nodeCount - 16
+	if offset >= uint(len(r.data)) {
+		return nil, errors.New("mmdb: invalid data pointer")
+	}
+	value, _, err := (&decoder{buf: r.data}).decode(offset)
+	return value, err
+}
+
+// decoder reads values of the data section format.
+type decoder struct {
+	buf []byte
+}
+
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+var errTruncated = errors.New("mmdb: truncated data")
+
+func (d *decoder) bytes(offset, n uint) ([]byte, error) {
+	if offset+n > uint(len(d.buf)) {
+		return nil, errTruncated
+	}
+	return d.buf[offset : offset+n], nil
+}
+
+func (d *decoder) uint(offset, n uint) (uint64, error) {
+	b, err := d.bytes(offset, n)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+// decode returns the value at offset and the offset after it.
+func (d *decoder) decode(offset uint) (any, uint, error) {
+	ctrl, err := d.bytes(offset, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset++
+	kind := uint(ctrl[0] >> 5)
+	if kind == typePointer {
+		ss := uint(ctrl[0]>>3) & 0x3
+		vvv := uint64(ctrl[0] & 0x7)
+		p, err := d.uint(offset, ss+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		switch ss {
+		case 0:
+			p |= vvv << 8
+		case 1:
+			p = (p | vvv<<16) + 2048
+		case 2:
+			p = (p | vvv<<24) + 526336
+		}
+		value, _, err := d.decode(uint(p))
+		return value, offset + ss + 1, err
+	}
+	if kind == typeExtended {
+		ext, err := d.bytes(offset, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		kind = 7 + uint(ext[0])
+		offset++
+	}
+	size := uint(ctrl[0] & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		extra, err := d.uint(offset, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += n
+		switch n {
+		case 1:
+			size = 29 + uint(extra)
+		case 2:
+			size = 285 + uint(extra)
+		default:
+			size = 65821 + uint(extra)
+		}
+	}
+
+	switch kind {
+	case typeString:
+		b, err := d.bytes(offset, size)
+		return string(b), offset + size, err
+	case typeBytes:
+		b, err := d.bytes(offset, size)
+		return b, offset + size, err
+	case typeDouble:
+		v, err := d.uint(offset, 8)
+		return math.Float64frombits(v), offset + 8, err
+	case typeFloat:
+		v, err := d.uint(offset, 4)
+		return float64(math.Float32frombits(uint32(v))), offset + 4, err
+	case typeUint16, typeUint32, typeUint64:
+		v, err := d.uint(offset, size)
+		return v, offset + size, err
+	case typeInt32:
+		v, err := d.uint(offset, size)
+		return int64(int32(uint32(v))), offset + size, err
+	case typeUint128:
+		b, err := d.bytes(offset, size)
+		return b, offset + size, err
+	case typeBool:
+		return size != 0, offset, nil
+	case typeMap:
+		m := make(map[string]any, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("mmdb: map key is not a string")
+			}
+			m[k], offset, err = d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]any, 0, size)
+		for i := uint(0); i < size; i++ {
+			var v any
+			v, offset, err = d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+		}
+		return a, offset, nil
+	default:
+		return nil, 0, fmt.Errorf("mmdb: unsupported data type %d", kind)
+	}
+}
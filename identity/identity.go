@@ -0,0 +1,195 @@
+// Package identity verifies web2 identities with the OAuth providers users
+// sign in to, so they can be linked to addresses.
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported providers.
+const (
+	Google = "google"
+	GitHub = "github"
+)
+
+// Client is the OAuth application registered with a provider.
+type Client struct {
+	ID     string
+	Secret string
+}
+
+type Config struct {
+	// RedirectURL is the redirect URI the authorization codes were issued
+	// for.
+	RedirectURL string
+	// Clients are the OAuth applications by provider, providers without
+	// one are disabled.
+	Clients map[string]*Client
+	Timeout time.Duration
+}
+
+// Verified is an identity whose provider vouched for it and its email.
+type Verified struct {
+	Provider string
+	// Subject is the stable user id at the provider.
+	Subject string
+	Email   string
+}
+
+// provider is the OAuth endpoints of a supported provider and how its user
+// is read.
+type provider struct {
+	tokenURL string
+	user     func(ctx context.Context, v *Verifier, token string) (*Verified, error)
+}
+
+var providers = map[string]*provider{
+	Google: {tokenURL: "https://oauth2.googleapis.com/token", user: googleUser},
+	GitHub: {tokenURL: "https://github.com/login/oauth/access_token", user: githubUser},
+}
+
+// Verifier exchanges authorization codes for the identities they were
+// issued to.
+type Verifier struct {
+	conf   *Config
+	client *http.Client
+}
+
+func New(conf *Config) (*Verifier, error) {
+	for name := range conf.Clients {
+		if _, ok := providers[name]; !ok {
+			return nil, fmt.Errorf("unsupported identity provider %q", name)
+		}
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = 10 * time.Second
+	}
+	return &Verifier{conf: conf, client: &http.Client{Timeout: conf.Timeout}}, nil
+}
+
+// Providers returns the enabled providers.
+func (v *Verifier) Providers() []string {
+	names := make([]string, 0, len(v.conf.Clients))
+	for name := range v.conf.Clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Verify exchanges the authorization code of name for the identity it was
+// issued to. Identities without a verified email are refused.
+func (v *Verifier) Verify(ctx context.Context, name, code string) (*Verified, error) {
+	p, ok := providers[name]
+	client, enabled := v.conf.Clients[name]
+	if !ok || !enabled {
+		return nil, fmt.Errorf("identity provider %q not enabled", name)
+	}
+	token, err := v.exchange(ctx, p, client, code)
+	if err != nil {
+		return nil, err
+	}
+	verified, err := p.user(ctx, v, token)
+	if err != nil {
+		return nil, err
+	}
+	verified.Provider = name
+	return verified, nil
+}
+
+// exchange redeems code for an access token.
+func (v *Verifier) exchange(ctx context.Context, p *provider, client *Client, code string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"client_id":     {client.ID},
+		"client_secret": {client.Secret},
+		"redirect_uri":  {v.conf.RedirectURL},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	var result struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if err := v.do(req, &result); err != nil {
+		return "", err
+	}
+	if result.AccessToken == "" {
+		return "", fmt.Errorf("authorization code refused: %s", result.Error)
+	}
+	return result.AccessToken, nil
+}
+
+// get reads the JSON resource at url with the access token.
+func (v *Verifier) get(ctx context.Context, url, token string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	return v.do(req, out)
+}
+
+func (v *Verifier) do(req *http.Request, out any) error {
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s status %d", req.URL.Host, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func googleUser(ctx context.Context, v *Verifier, token string) (*Verified, error) {
+	var user struct {
+		Sub           string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	if err := v.get(ctx, "https://openidconnect.googleapis.com/v1/userinfo", token, &user); err != nil {
+		return nil, err
+	}
+	if user.Sub == "" || user.Email == "" || !user.EmailVerified {
+		return nil, fmt.Errorf("google account without verified email")
+	}
+	return &Verified{Subject: user.Sub, Email: user.Email}, nil
+}
+
+func githubUser(ctx context.Context, v *Verifier, token string) (*Verified, error) {
+	var user struct {
+		ID int64 `json:"id"`
+	}
+	if err := v.get(ctx, "https://api.github.com/user", token, &user); err != nil {
+		return nil, err
+	}
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := v.get(ctx, "https://api.github.com/user/emails", token, &emails); err != nil {
+		return nil, err
+	}
+	for _, e := range emails {
+		if e.Primary && e.Verified && user.ID != 0 {
+			return &Verified{Subject: strconv.FormatInt(user.ID, 10), Email: e.Email}, nil
+		}
+	}
+	return nil, fmt.Errorf("github account without verified primary email")
+}
@@ -0,0 +1,279 @@
+package indexer
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/ququzone/verifying-paymaster-service/chain"
+	"github.com/ququzone/verifying-paymaster-service/contracts"
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/metrics"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// CheckpointName is the checkpoint of the last indexed block, kept per
+// chain under Checkpoint.
+const CheckpointName = "user_operation_event"
+
+// Checkpoint returns the checkpoint of the last block indexed on chainID.
+func Checkpoint(chainID *big.Int) string {
+	return models.ChainCheckpoint(CheckpointName, chainID.Uint64())
+}
+
+type Config struct {
+	// ChainID labels the settlement metrics and selects the dedicated
+	// paymasters of api keys.
+	ChainID    *big.Int
+	EntryPoint common.Address
+	Paymaster  common.Address
+	// StartBlock is used when no checkpoint has been stored yet.
+	StartBlock uint64
+	// BatchSize is the maximum number of blocks fetched per log query.
+	BatchSize uint64
+	// Confirmations is the number of blocks to stay behind the chain head.
+	Confirmations uint64
+	// ReorgDepth is the number of recent block hashes kept to detect reorgs.
+	ReorgDepth   uint64
+	PollInterval time.Duration
+}
+
+// Indexer follows UserOperationEvent logs emitted for the paymasters and
+// settles the matching sponsorships. Progress is checkpointed in the
+// database together with the settlements so restarts resume where the
+// previous run stopped. Hashes of recent blocks are kept to roll back
+// settlements from orphaned blocks.
+type Indexer struct {
+	conf     *Config
+	client   chain.Client
+	rep      db.Repository
+	filterer *contracts.EntryPointFilterer
+}
+
+func NewIndexer(conf *Config, client chain.Client, rep db.Repository) (*Indexer, error) {
+	filterer, err := contracts.NewEntryPointFilterer(conf.EntryPoint, client)
+	if err != nil {
+		return nil, err
+	}
+	if conf.BatchSize == 0 {
+		conf.BatchSize = 2000
+	}
+	if conf.ReorgDepth == 0 {
+		conf.ReorgDepth = 64
+	}
+	if conf.PollInterval == 0 {
+		conf.PollInterval = 15 * time.Second
+	}
+	return &Indexer{
+		conf:     conf,
+		client:   client,
+		rep:      rep,
+		filterer: filterer,
+	}, nil
+}
+
+// Run indexes until ctx is cancelled. While more than one batch behind the
+// head it fetches batches back to back, otherwise it polls.
+func (i *Indexer) Run(ctx context.Context) {
+	logger.S().Infof("Indexer started for chain %s paymaster %s", i.conf.ChainID, i.conf.Paymaster)
+	for {
+		caughtUp, err := i.step(ctx)
+		if err != nil {
+			logger.S().Errorf("indexer error: %v", err)
+		}
+		if err == nil && !caughtUp {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(i.conf.PollInterval):
+		}
+	}
+}
+
+// step indexes the next batch and reports whether the indexer reached the
+// confirmed head.
+func (i *Indexer) step(ctx context.Context) (bool, error) {
+	if err := i.checkReorg(ctx); err != nil {
+		return false, err
+	}
+	from, err := i.nextBlock()
+	if err != nil {
+		return false, err
+	}
+	header, err := i.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	head := header.Number.Uint64()
+	if head < i.conf.Confirmations {
+		return true, nil
+	}
+	target := head - i.conf.Confirmations
+	if from > target {
+		return true, nil
+	}
+	to := from + i.conf.BatchSize - 1
+	if to >= target {
+		to = target
+	} else {
+		logger.S().Infof("Indexer catching up, block %d of %d", to, target)
+	}
+
+	events, err := i.fetch(ctx, from, to)
+	if err != nil {
+		return false, err
+	}
+	blocks := make(map[uint64]string)
+	var settled []*models.Sponsorship
+	err = i.rep.Transaction(func(tx db.Repository) error {
+		settled = settled[:0]
+		for _, event := range events {
+			rec, err := settle(tx, event)
+			if err != nil {
+				return err
+			}
+			if rec != nil {
+				settled = append(settled, rec)
+			}
+			blocks[event.Raw.BlockNumber] = event.Raw.BlockHash.Hex()
+		}
+		if err := i.recordBlocks(ctx, tx, blocks, to); err != nil {
+			return err
+		}
+		return i.saveCheckpoint(tx, to)
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, rec := range settled {
+		metrics.Observe(i.conf.ChainID, rec.ApiKeyID, rec.Status, models.ParseGas(rec.ActualGasCost))
+	}
+	return to == target, nil
+}
+
+func (i *Indexer) nextBlock() (uint64, error) {
+	checkpoint, err := (&models.Checkpoint{}).FindByName(i.rep, Checkpoint(i.conf.ChainID))
+	if err != nil {
+		return 0, err
+	}
+	if checkpoint == nil {
+		return i.conf.StartBlock, nil
+	}
+	return checkpoint.BlockNumber + 1, nil
+}
+
+// paymasters returns the shared paymaster and the dedicated paymasters of
+// api keys on the chain.
+func (i *Indexer) paymasters() ([]common.Address, error) {
+	dedicated, err := models.KeyPaymasterContracts(i.rep, i.conf.ChainID.Uint64())
+	if err != nil {
+		return nil, err
+	}
+	paymasters := []common.Address{i.conf.Paymaster}
+	for _, contract := range dedicated {
+		if address := common.HexToAddress(contract); address != i.conf.Paymaster {
+			paymasters = append(paymasters, address)
+		}
+	}
+	return paymasters, nil
+}
+
+func (i *Indexer) fetch(ctx context.Context, from, to uint64) ([]*contracts.EntryPointUserOperationEvent, error) {
+	paymasters, err := i.paymasters()
+	if err != nil {
+		return nil, err
+	}
+	it, err := i.filterer.FilterUserOperationEvent(&bind.FilterOpts{
+		Start:   from,
+		End:     &to,
+		Context: ctx,
+	}, nil, nil, paymasters)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	var events []*contracts.EntryPointUserOperationEvent
+	for it.Next() {
+		events = append(events, it.Event)
+	}
+	return events, it.Error()
+}
+
+// SettledEvent is the webhook payload of a settled sponsorship.
+type SettledEvent struct {
+	UserOpHash    string `json:"userOpHash"`
+	Sender        string `json:"sender"`
+	ChainID       uint64 `json:"chainId"`
+	TxHash        string `json:"txHash"`
+	BlockNumber   uint64 `json:"blockNumber"`
+	ActualGasCost string `json:"actualGasCost"`
+}
+
+// settle records the inclusion of a sponsored operation and refunds the
+// unused part of the charged gas. It returns the settled sponsorship, nil
+// when the event was unknown or already settled.
+func settle(tx db.Repository, event *contracts.EntryPointUserOperationEvent) (*models.Sponsorship, error) {
+	hash := common.Hash(event.UserOpHash).Hex()
+	rec, err := (&models.Sponsorship{}).FindByUserOpHash(tx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		logger.S().Warnf("Indexer found unknown user operation %s", hash)
+		return nil, nil
+	}
+	if rec.Status == models.SponsorshipIncluded || rec.Status == models.SponsorshipReverted {
+		return nil, nil
+	}
+
+	rec.Status = models.SponsorshipIncluded
+	if !event.Success {
+		rec.Status = models.SponsorshipReverted
+	}
+	rec.BlockNumber = event.Raw.BlockNumber
+	rec.BlockHash = event.Raw.BlockHash.Hex()
+	rec.TxHash = event.Raw.TxHash.Hex()
+	rec.LogIndex = event.Raw.Index
+	rec.ActualGasCost = event.ActualGasCost.String()
+	if err := tx.Save(rec).Error; err != nil {
+		return nil, err
+	}
+	webhookEvent := models.EventSponsorshipIncluded
+	if !event.Success {
+		webhookEvent = models.EventSponsorshipReverted
+	}
+	err = models.EnqueueWebhookEvent(tx, rec.ApiKeyID, webhookEvent, &SettledEvent{
+		UserOpHash:    rec.UserOpHash,
+		Sender:        rec.Sender,
+		ChainID:       rec.ChainID,
+		TxHash:        rec.TxHash,
+		BlockNumber:   rec.BlockNumber,
+		ActualGasCost: rec.ActualGasCost,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	charged, used := rec.QuotaCharge(event.ActualGasCost)
+	return rec, models.NewAccountRepository(tx).ForPaymaster(rec.QuotaPaymaster).SettleGas(rec.Sender, charged, used)
+}
+
+func (i *Indexer) saveCheckpoint(tx db.Repository, block uint64) error {
+	name := Checkpoint(i.conf.ChainID)
+	checkpoint, err := (&models.Checkpoint{}).FindByName(tx, name)
+	if err != nil {
+		return err
+	}
+	if checkpoint == nil {
+		checkpoint = &models.Checkpoint{Name: name}
+	}
+	checkpoint.BlockNumber = block
+	return tx.Save(checkpoint).Error
+}
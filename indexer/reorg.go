@@ -0,0 +1,103 @@
+package indexer
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// checkReorg compares the stored block hashes with the canonical chain and
+// rewinds the indexer to the last common block when they diverge.
+func (i *Indexer) checkReorg(ctx context.Context) error {
+	blocks, err := models.LatestIndexedBlocks(i.rep, i.conf.ChainID.Uint64(), int(i.conf.ReorgDepth))
+	if err != nil {
+		return err
+	}
+	if len(blocks) == 0 {
+		return nil
+	}
+
+	ancestor := int64(blocks[len(blocks)-1].Number) - 1
+	for n, block := range blocks {
+		header, err := i.client.HeaderByNumber(ctx, new(big.Int).SetUint64(block.Number))
+		if err != nil {
+			return err
+		}
+		if header.Hash().Hex() == block.Hash {
+			if n == 0 {
+				return nil
+			}
+			ancestor = int64(block.Number)
+			break
+		}
+	}
+	if ancestor < 0 {
+		ancestor = 0
+	}
+
+	logger.S().Warnf("Indexer detected reorg, rewinding from block %d to %d", blocks[0].Number, ancestor)
+	return i.rep.Transaction(func(tx db.Repository) error {
+		if err := rewind(tx, i.conf.ChainID.Uint64(), uint64(ancestor)); err != nil {
+			return err
+		}
+		return i.saveCheckpoint(tx, uint64(ancestor))
+	})
+}
+
+// rewind reverts the settlements of sponsorships included on chainID after
+// block and forgets the orphaned block hashes. The operations go back to
+// pending as they are usually re-included on the new chain.
+func rewind(tx db.Repository, chainID, block uint64) error {
+	var recs []models.Sponsorship
+	err := tx.Where(`"chain_id" = ? AND "block_number" > ? AND "status" IN ?`, chainID, block, []string{
+		models.SponsorshipIncluded,
+		models.SponsorshipReverted,
+	}).Find(&recs).Error
+	if err != nil {
+		return err
+	}
+
+	accounts := models.NewAccountRepository(tx)
+	for n := range recs {
+		rec := &recs[n]
+		charged, used := rec.QuotaCharge(models.ParseGas(rec.ActualGasCost))
+		err := accounts.ForPaymaster(rec.QuotaPaymaster).UnsettleGas(rec.Sender, charged, used)
+		if err != nil {
+			return err
+		}
+		rec.Status = models.SponsorshipPending
+		rec.BlockNumber = 0
+		rec.BlockHash = ""
+		rec.TxHash = ""
+		rec.LogIndex = 0
+		rec.ActualGasCost = ""
+		if err := tx.Save(rec).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Where(`"chain_id" = ? AND "number" > ?`, chainID, block).Delete(&models.IndexedBlock{}).Error
+}
+
+// recordBlocks stores the hashes of the given blocks and prunes the ones
+// that left the reorg window.
+func (i *Indexer) recordBlocks(ctx context.Context, tx db.Repository, blocks map[uint64]string, to uint64) error {
+	if _, ok := blocks[to]; !ok {
+		header, err := i.client.HeaderByNumber(ctx, new(big.Int).SetUint64(to))
+		if err != nil {
+			return err
+		}
+		blocks[to] = header.Hash().Hex()
+	}
+	for number, hash := range blocks {
+		if err := tx.Save(&models.IndexedBlock{ChainID: i.conf.ChainID.Uint64(), Number: number, Hash: hash}).Error; err != nil {
+			return err
+		}
+	}
+	if to <= i.conf.ReorgDepth {
+		return nil
+	}
+	return tx.Where(`"chain_id" = ? AND "number" < ?`, i.conf.ChainID.Uint64(), to-i.conf.ReorgDepth).Delete(&models.IndexedBlock{}).Error
+}
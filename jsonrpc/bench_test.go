@@ -0,0 +1,170 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+
+	"github.com/ququzone/verifying-paymaster-service/api"
+	"github.com/ququzone/verifying-paymaster-service/config"
+	"github.com/ququzone/verifying-paymaster-service/container"
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/store"
+)
+
+const (
+	benchKey        = "benchkey"
+	benchSender     = "0x00000000000000000000000000000000000b3c4d"
+	benchEntryPoint = "0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789"
+)
+
+// benchTables are an enabled api key without policy and a sender with a
+// quota large enough for every sponsorship of a run.
+var benchTables = []fakeTable{
+	{
+		match:   `FROM "api_keys"`,
+		columns: []string{"id", "key", "enable", "user_id"},
+		rows:    [][]driver.Value{{int64(1), benchKey, true, int64(1)}},
+	},
+	{
+		match:   `FROM "users"`,
+		columns: []string{"id"},
+		rows:    [][]driver.Value{{int64(1)}},
+	},
+	{
+		match:   `FROM "accounts"`,
+		columns: []string{"id", "address", "enable", "vip_id", "remain_gas", "used_gas", "reserved_gas"},
+		rows:    [][]driver.Value{{int64(1), benchSender, true, int64(-1), "1000000000000000000000000", "0", "0"}},
+	},
+}
+
+var benchRouter struct {
+	once sync.Once
+	r    *gin.Engine
+	err  error
+}
+
+// newBenchRouter serves the rpc handler of a signer on the mock chain and
+// the fake database, so the benchmarks measure the service alone.
+func newBenchRouter(b *testing.B) *gin.Engine {
+	benchRouter.once.Do(func() {
+		for key, value := range map[string]string{
+			"MOCK_CHAIN":  "true",
+			"PRIVATE_KEY": "ac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcae784d7bf4f2ff80",
+			"ENTRY_POINT": benchEntryPoint,
+			"CONTRACT":    "0x0000000000000000000000000000000000000def",
+		} {
+			b.Setenv(key, value)
+		}
+		if benchRouter.err = logger.InitLogger(); benchRouter.err != nil {
+			return
+		}
+		if benchRouter.err = config.InitValues(); benchRouter.err != nil {
+			return
+		}
+		conn := openFakeDB("bench", &fakeDriver{tables: benchTables})
+		var gdb *gorm.DB
+		gdb, benchRouter.err = gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{Logger: gormlogger.Discard})
+		if benchRouter.err != nil {
+			return
+		}
+		var signer *api.Signer
+		signer, benchRouter.err = api.NewSigner(config.Config(), container.NewContainer(db.Wrap(gdb)), store.NewMemory())
+		if benchRouter.err != nil {
+			return
+		}
+		gin.SetMode(gin.ReleaseMode)
+		benchRouter.r = gin.New()
+		benchRouter.r.POST("/rpc/:key", Process(signer))
+	})
+	if benchRouter.err != nil {
+		b.Fatal(benchRouter.err)
+	}
+	return benchRouter.r
+}
+
+// benchBody returns the request body of method, with the nonce of the user
+// operation in params replaced by nonce so every sponsorship is new.
+func benchBody(buf []byte, method, params string, nonce uint64) []byte {
+	buf = append(buf[:0], `{"jsonrpc":"2.0","id":1,"method":"`...)
+	buf = append(buf, method...)
+	buf = append(buf, `","params":`...)
+	if i := strings.Index(params, "NONCE"); i >= 0 {
+		buf = append(buf, params[:i]...)
+		buf = strconv.AppendUint(buf, nonce, 16)
+		buf = append(buf, params[i+len("NONCE"):]...)
+	} else {
+		buf = append(buf, params...)
+	}
+	return append(buf, '}')
+}
+
+const benchOp = `{"sender":"` + benchSender + `","nonce":"0xNONCE","initCode":"0x",` +
+	`"callData":"0xb61d27f6000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000",` +
+	`"callGasLimit":"0x0","verificationGasLimit":"0x0","preVerificationGas":"0x0",` +
+	`"maxFeePerGas":"0x77359400","maxPriorityFeePerGas":"0x3b9aca00","paymasterAndData":"0x",` +
+	`"signature":"0x` + zeroSignature + `"}`
+
+const zeroSignature = "0000000000000000000000000000000000000000000000000000000000000000" +
+	"0000000000000000000000000000000000000000000000000000000000000000" + "00"
+
+func serveBench(b *testing.B, r http.Handler, body []byte) {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/rpc/"+benchKey, bytes.NewReader(body)))
+	if w.Code != http.StatusOK || bytes.Contains(w.Body.Bytes(), []byte(`"error":{`)) {
+		b.Fatalf("request failed: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func benchMethod(b *testing.B, method, params string) {
+	r := newBenchRouter(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	var buf []byte
+	for i := 0; i < b.N; i++ {
+		buf = benchBody(buf, method, params, uint64(i))
+		serveBench(b, r, buf)
+	}
+}
+
+func benchMethodParallel(b *testing.B, method, params string) {
+	r := newBenchRouter(b)
+	var nonce uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var buf []byte
+		for pb.Next() {
+			buf = benchBody(buf, method, params, atomic.AddUint64(&nonce, 1))
+			serveBench(b, r, buf)
+		}
+	})
+}
+
+func BenchmarkConfig(b *testing.B) {
+	benchMethod(b, "pm_config", `[]`)
+}
+
+func BenchmarkGasRemain(b *testing.B) {
+	benchMethod(b, "pm_gasRemain", `["`+benchSender+`"]`)
+}
+
+func BenchmarkSponsorUserOperation(b *testing.B) {
+	benchMethod(b, "pm_sponsorUserOperation", `[`+benchOp+`,"`+benchEntryPoint+`"]`)
+}
+
+func BenchmarkSponsorUserOperationParallel(b *testing.B) {
+	benchMethodParallel(b, "pm_sponsorUserOperation", `[`+benchOp+`,"`+benchEntryPoint+`"]`)
+}
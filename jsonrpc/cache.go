@@ -0,0 +1,87 @@
+package jsonrpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ququzone/verifying-paymaster-service/api"
+	"github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/metrics"
+)
+
+// etag is the strong entity tag of a method result, the same on every
+// replica serving it.
+func etag(result any) (string, error) {
+	body, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// matchETag reports whether the If-None-Match header value lists tag.
+func matchETag(header, tag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == tag || t == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Get serves the cacheable methods of service at GET /rpc/:key/:method with
+// Cache-Control and ETag headers, answering If-None-Match requests for an
+// unchanged result with 304 Not Modified.
+func Get(service interface{}) gin.HandlerFunc {
+	methods := newMethodTable(service)
+	return func(c *gin.Context) {
+		start := time.Now()
+		phases := metrics.NewPhases()
+		method := c.Param("method")
+		ttl, ok := api.CacheableMethods[method]
+		m := methods.lookup(method)
+		if !ok || m == nil {
+			jsonrpcError(c, errors.METHOD_NOT_FOUND, "Method not found", "Method not found", nil)
+			return
+		}
+		apiKey, ok := findKey(c, service, phases)
+		if !ok || !allowed(c, apiKey, method, nil) {
+			return
+		}
+		defer func() { metrics.ObserveRPC(method, time.Since(start), phases) }()
+
+		var ctx context.Context
+		if m.withContext {
+			ctx = requestContext(c, apiKey, phases)
+		}
+		value, err := m.call(ctx, nil)
+		if err != nil {
+			rpcErr := errors.Wrap(err)
+			jsonrpcError(c, rpcErr.Code(), rpcErr.Error(), rpcErr.Data(), nil)
+			return
+		}
+
+		tag, err := etag(value)
+		if err != nil {
+			jsonrpcError(c, errors.INTERNAL_ERROR, "Internal error", err.Error(), nil)
+			return
+		}
+		c.Header("ETag", tag)
+		c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(ttl.Seconds())))
+		if matchETag(c.GetHeader("If-None-Match"), tag) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+		respond(c, value, nil)
+	}
+}
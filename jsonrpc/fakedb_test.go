@@ -0,0 +1,100 @@
+package jsonrpc
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+)
+
+// fakeTable answers the queries containing match with rows.
+type fakeTable struct {
+	match   string
+	columns []string
+	rows    [][]driver.Value
+}
+
+// fakeDriver is a database/sql driver serving the queries of the
+// benchmarked requests from fixed rows, so the benchmarks measure the
+// service rather than a database. Writes succeed without effect.
+type fakeDriver struct {
+	tables []fakeTable
+}
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) { return &fakeConn{d: d}, nil }
+
+func (d *fakeDriver) table(query string) *fakeTable {
+	for i := range d.tables {
+		if strings.Contains(query, d.tables[i].match) {
+			return &d.tables[i]
+		}
+	}
+	return nil
+}
+
+type fakeConn struct{ d *fakeDriver }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{c: c, query: query}, nil
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *fakeConn) Commit() error             { return nil }
+func (c *fakeConn) Rollback() error           { return nil }
+
+func (c *fakeConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	t := c.d.table(query)
+	if t == nil {
+		return &fakeRows{}, nil
+	}
+	return &fakeRows{columns: t.columns, rows: t.rows}, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return driver.RowsAffected(1), nil
+}
+
+type fakeStmt struct {
+	c     *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.c.ExecContext(context.Background(), s.query, nil)
+}
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.c.QueryContext(context.Background(), s.query, nil)
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+	next    int
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.next])
+	r.next++
+	return nil
+}
+
+var fakeDrivers sync.Map
+
+func openFakeDB(name string, d *fakeDriver) *sql.DB {
+	if _, loaded := fakeDrivers.LoadOrStore(name, d); !loaded {
+		sql.Register(name, d)
+	}
+	db, _ := sql.Open(name, "")
+	return db
+}
@@ -1,344 +1,251 @@
 package jsonrpc
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
-	"io"
 	"net/http"
 	"reflect"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"golang.org/x/text/cases"
-	"golang.org/x/text/language"
 
 	"github.com/ququzone/verifying-paymaster-service/api"
 	"github.com/ququzone/verifying-paymaster-service/errors"
 	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/metrics"
 	"github.com/ququzone/verifying-paymaster-service/models"
 )
 
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// KeyRejected is set on the gin context of requests with an unknown or
+// disabled api key.
+const KeyRejected = "jsonrpc-key-rejected"
+
+// errorTemplates is the gin context key of the error templates of the
+// network serving the request.
+const errorTemplates = "jsonrpc-error-templates"
+
+// Localize makes the error responses of the following handlers use the
+// message templates of templates.
+func Localize(templates *errors.Templates) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(errorTemplates, templates)
+		c.Next()
+	}
+}
+
+// jsonrpcError answers with the error of the catalog matching code and the
+// reason in data, its message from the templates set by Localize. message
+// and data are reported in its details.
 func jsonrpcError(c *gin.Context, code int, message string, data any, id *float64) {
+	entry, payload := errors.Wrap(errors.NewRPCError(code, message, data)).Describe()
+	templates, _ := c.Value(errorTemplates).(*errors.Templates)
 	c.JSON(http.StatusOK, map[string]interface{}{
 		"result":  nil,
 		"jsonrpc": "2.0",
 		"error": map[string]interface{}{
 			"code":    code,
-			"message": message,
-			"data":    data,
+			"message": templates.Message(entry, payload, c.GetHeader("Accept-Language")),
+			"data":    payload,
 		},
 		"id": id,
 	})
 }
 
+// Abort answers with a JSON-RPC error before the request reaches the service.
+func Abort(c *gin.Context, code int, message string, data any) {
+	jsonrpcError(c, code, message, data, nil)
+	c.Abort()
+}
+
+// findKey returns the enabled api key of the request, answering with an
+// error when there is none. The lookup is timed in phases.
+func findKey(c *gin.Context, service interface{}, phases *metrics.Phases) (*models.ApiKeys, bool) {
+	defer func(start time.Time) { phases.Add(metrics.PhaseKeyLookup, time.Since(start)) }(time.Now())
+	key := c.Param("key")
+	if key == "" {
+		jsonrpcError(c, errors.INVALID_API_KEY, "Key error", "No key", nil)
+		return nil, false
+	}
+	apiKey, err := service.(*api.Signer).ApiKeys.Find(key)
+	if nil != err {
+		logger.S().Errorf("Query api error: %v", err)
+		jsonrpcError(c, errors.INTERNAL_ERROR, "Database error", "Query apikey error", nil)
+		return nil, false
+	}
+	if apiKey == nil || !apiKey.Valid(key, time.Now()) {
+		c.Set(KeyRejected, true)
+		jsonrpcError(c, errors.INVALID_API_KEY, "Key error", "Apikey error", nil)
+		return nil, false
+	}
+	return apiKey, true
+}
+
+// requestContext carries the api key, client IP and headers of the request
+// to the methods taking a context, and the phases they are timed in.
+func requestContext(c *gin.Context, apiKey *models.ApiKeys, phases *metrics.Phases) context.Context {
+	ctx := api.WithRequestHeader(api.WithClientIP(api.WithApiKey(c.Request.Context(), apiKey), c.ClientIP()), c.Request.Header)
+	return metrics.WithPhases(ctx, phases)
+}
+
+// allowed reports whether the scopes of apiKey cover method, answering with
+// an error when they do not.
+func allowed(c *gin.Context, apiKey *models.ApiKeys, method string, id *float64) bool {
+	scope := api.MethodScope(method)
+	if apiKey.Scopes == "" || (scope != "" && apiKey.HasScope(scope)) {
+		return true
+	}
+	jsonrpcError(c, errors.ACCESS_DENIED, "method not allowed for this api key", map[string]any{
+		"reason": errors.REASON_SCOPE_DENIED,
+		"scope":  scope,
+	}, id)
+	return false
+}
+
+// jsonString decodes raw when it is a JSON string.
+func jsonString(raw json.RawMessage) (string, bool) {
+	if len(raw) == 0 || raw[0] != '"' {
+		return "", false
+	}
+	var s string
+	return s, json.Unmarshal(raw, &s) == nil
+}
+
+// bodies are the buffers the request bodies are read into, the raw params
+// are copied out of them.
+var bodies = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// maxPooledBody caps the buffers kept in bodies and responses so a single
+// large request does not pin its memory.
+const maxPooledBody = 64 << 10
+
+func putBuffer(pool *sync.Pool, buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBody {
+		buf.Reset()
+		pool.Put(buf)
+	}
+}
+
+// response is a successful JSON-RPC response, its fields in the order
+// encoding a map would give them.
+type response struct {
+	ID      *float64 `json:"id"`
+	Jsonrpc string   `json:"jsonrpc"`
+	Result  any      `json:"result"`
+}
+
+// responses are the buffers the responses are encoded into.
+var responses = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// respond answers with result, encoded in a pooled buffer.
+func respond(c *gin.Context, result any, id *float64) {
+	buf := responses.Get().(*bytes.Buffer)
+	defer putBuffer(&responses, buf)
+	if err := json.NewEncoder(buf).Encode(response{ID: id, Jsonrpc: "2.0", Result: result}); err != nil {
+		jsonrpcError(c, errors.INTERNAL_ERROR, "Internal error", err.Error(), id)
+		return
+	}
+	// Encode ends the value with a newline Marshal does not write
+	c.Data(http.StatusOK, "application/json; charset=utf-8", bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+}
+
+// Process serves the JSON-RPC methods of service. Its methods are resolved
+// once here, the most requested signatures being called without reflection.
 func Process(service interface{}) gin.HandlerFunc {
+	methods := newMethodTable(service)
 	return func(c *gin.Context) {
+		start := time.Now()
+		phases := metrics.NewPhases()
 		if c.Request.Method != "POST" {
-			jsonrpcError(c, -32700, "Parse error", "POST method excepted", nil)
+			jsonrpcError(c, errors.PARSE_ERROR, "Parse error", "POST method excepted", nil)
 			return
 		}
 
 		if nil == c.Request.Body {
-			jsonrpcError(c, -32700, "Parse error", "No POST data", nil)
+			jsonrpcError(c, errors.PARSE_ERROR, "Parse error", "No POST data", nil)
 			return
 		}
 
-		key := c.Param("key")
-		if key == "" {
-			jsonrpcError(c, -32700, "Key error", "No key", nil)
-			return
-		}
-		apiKey := &models.ApiKeys{}
-		apiKey, err := apiKey.FindByKey(service.(*api.Signer).Container.GetRepository(), key)
-		if nil != err {
-			logger.S().Errorf("Query api error: %v", err)
-			jsonrpcError(c, -32700, "Database error", "Query apikey error", nil)
-			return
-		}
-		if apiKey == nil || !apiKey.Enable {
-			jsonrpcError(c, -32700, "Key error", "Apikey error", nil)
+		apiKey, ok := findKey(c, service, phases)
+		if !ok {
 			return
 		}
 
 		// reading POST data
-		body, err := io.ReadAll(c.Request.Body)
-		if nil != err {
-			jsonrpcError(c, -32700, "Parse error", "Error while reading request body", nil)
+		body := bodies.Get().(*bytes.Buffer)
+		defer putBuffer(&bodies, body)
+		if _, err := body.ReadFrom(c.Request.Body); nil != err {
+			jsonrpcError(c, errors.PARSE_ERROR, "Parse error", "Error while reading request body", nil)
 			return
 		}
 
-		// try to decode JSON
-		data := make(map[string]interface{})
-		err = json.Unmarshal(body, &data)
+		// try to decode JSON, the params are decoded once the method is
+		// known, user operations straight into their struct
+		var data map[string]json.RawMessage
+		err := json.Unmarshal(body.Bytes(), &data)
 		if nil != err {
-			jsonrpcError(c, -32700, "Parse error", "Error parsing json request", nil)
+			jsonrpcError(c, errors.PARSE_ERROR, "Parse error", "Error parsing json request", nil)
 			return
 		}
 
-		id, ok := data["id"].(float64)
-		if !ok {
-			jsonrpcError(c, -32600, "Invalid Request", "No or invalid 'id' in request", nil)
+		id, err := strconv.ParseFloat(string(data["id"]), 64)
+		if err != nil {
+			jsonrpcError(c, errors.INVALID_REQUEST, "Invalid Request", "No or invalid 'id' in request", nil)
 			return
 		}
 
-		if data["jsonrpc"] != "2.0" {
-			jsonrpcError(c, -32600, "Invalid Request", "Version of jsonrpc is not 2.0", &id)
+		if version, _ := jsonString(data["jsonrpc"]); version != "2.0" {
+			jsonrpcError(c, errors.INVALID_REQUEST, "Invalid Request", "Version of jsonrpc is not 2.0", &id)
 			return
 		}
 
-		method, ok := data["method"].(string)
+		name, ok := jsonString(data["method"])
 		if !ok {
-			jsonrpcError(c, -32600, "Invalid Request", "No or invalid 'method' in request", &id)
+			jsonrpcError(c, errors.INVALID_REQUEST, "Invalid Request", "No or invalid 'method' in request", &id)
 			return
 		}
 
-		params, ok := data["params"].([]interface{})
-		if !ok {
-			jsonrpcError(c, -32602, "Invalid params", "No or invalid 'params' in request", &id)
+		var params []json.RawMessage
+		if raw := data["params"]; len(raw) == 0 || raw[0] != '[' || json.Unmarshal(raw, &params) != nil {
+			jsonrpcError(c, errors.INVALID_PARAMS, "Invalid params", "No or invalid 'params' in request", &id)
 			return
 		}
 
-		call := reflect.ValueOf(service).MethodByName(cases.Title(language.Und, cases.NoLower).String(method))
-		if !call.IsValid() {
-			jsonrpcError(c, -32601, "Method not found", "Method not found", &id)
+		m := methods.lookup(name)
+		if m == nil {
+			jsonrpcError(c, errors.METHOD_NOT_FOUND, "Method not found", "Method not found", &id)
 			return
 		}
-
-		// validating and converting params
-		// if call.Type().NumIn() != len(params) {
-		// 	jsonrpcError(c, -32602, "Invalid params", "Invalid number of params", &id)
-		// 	return
-		// }
-
-		args := make([]reflect.Value, len(params))
-		for i, arg := range params {
-
-			switch call.Type().In(i).Kind() {
-			case reflect.Float32:
-				val, ok := arg.(float32)
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.Float64:
-				val, ok := arg.(float64)
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.Int:
-				val, ok := arg.(int)
-				if !ok {
-					var fval float64
-					fval, ok = arg.(float64)
-					if ok {
-						val = int(fval)
-					}
-				}
-
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.Int8:
-				val, ok := arg.(int8)
-				if !ok {
-					var fval float64
-					fval, ok = arg.(float64)
-					if ok {
-						val = int8(fval)
-					}
-				}
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.Int16:
-				val, ok := arg.(int16)
-				if !ok {
-					var fval float64
-					fval, ok = arg.(float64)
-					if ok {
-						val = int16(fval)
-					}
-				}
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.Int32:
-				val, ok := arg.(int32)
-				if !ok {
-					var fval float64
-					fval, ok = arg.(float64)
-					if ok {
-						val = int32(fval)
-					}
-				}
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.Int64:
-				val, ok := arg.(int64)
-				if !ok {
-					var fval float64
-					fval, ok = arg.(float64)
-					if ok {
-						val = int64(fval)
-					}
-				}
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.Interface:
-				args[i] = reflect.ValueOf(arg)
-
-			case reflect.Map:
-				val, ok := arg.(map[string]any)
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.Slice:
-				val, ok := arg.([]interface{})
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.String:
-				val, _ := arg.(string)
-				// if !ok {
-				// 	// jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-				// 	// return
-				// }
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.Uint:
-				val, ok := arg.(uint)
-				if !ok {
-					var fval float64
-					fval, ok = arg.(float64)
-					if ok {
-						val = uint(fval)
-					}
-				}
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.Uint8:
-				val, ok := arg.(uint8)
-				if !ok {
-					var fval float64
-					fval, ok = arg.(float64)
-					if ok {
-						val = uint8(fval)
-					}
-				}
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.Uint16:
-				val, ok := arg.(uint16)
-				if !ok {
-					var fval float64
-					fval, ok = arg.(float64)
-					if ok {
-						val = uint16(fval)
-					}
-				}
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.Uint32:
-				val, ok := arg.(uint32)
-				if !ok {
-					var fval float64
-					fval, ok = arg.(float64)
-					if ok {
-						val = uint32(fval)
-					}
-				}
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.Uint64:
-				val, ok := arg.(uint64)
-				if !ok {
-					var fval float64
-					fval, ok = arg.(float64)
-					if ok {
-						val = uint64(fval)
-					}
-				}
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			default:
-				if !ok {
-					jsonrpcError(c, -32603, "Internal error", "Invalid method defination", &id)
-					return
-				}
-			}
-
+		if !allowed(c, apiKey, name, &id) {
+			return
 		}
+		defer func() { metrics.ObserveRPC(name, time.Since(start), phases) }()
 
-		c.Set("json-rpc-request", data)
-		result := call.Call(args)
+		if len(params) > m.numParams {
+			jsonrpcError(c, errors.INVALID_PARAMS, "Invalid params", "Too many params", &id)
+			return
+		}
 
-		if err, ok := result[len(result)-1].Interface().(error); ok && err != nil {
-			rpcErr, ok := err.(*errors.RPCError)
+		var ctx context.Context
+		if m.withContext {
+			ctx = requestContext(c, apiKey, phases)
+		}
 
-			if ok {
-				jsonrpcError(c, rpcErr.Code(), rpcErr.Error(), rpcErr.Data(), &id)
-			} else {
-				jsonrpcError(c, -32601, err.Error(), err.Error(), &id)
+		result, err := m.call(ctx, params)
+		if err != nil {
+			rpcErr := errors.Wrap(err)
+			jsonrpcError(c, rpcErr.Code(), rpcErr.Error(), rpcErr.Data(), &id)
+			return
+		}
+		if _, ok := api.CacheableMethods[name]; ok {
+			if tag, err := etag(result); err == nil {
+				c.Header("ETag", tag)
 			}
-		} else if len(result) > 0 {
-			c.JSON(http.StatusOK, map[string]interface{}{
-				"result":  result[0].Interface(),
-				"jsonrpc": "2.0",
-				"id":      id,
-			})
-		} else {
-			c.JSON(http.StatusOK, map[string]interface{}{
-				"result":  nil,
-				"jsonrpc": "2.0",
-				"id":      id,
-			})
 		}
+		respond(c, result, &id)
 	}
 }
@@ -0,0 +1,317 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/ququzone/verifying-paymaster-service/api"
+	"github.com/ququzone/verifying-paymaster-service/errors"
+)
+
+var (
+	mapType = reflect.TypeOf(map[string]any(nil))
+	rawType = reflect.TypeOf(json.RawMessage(nil))
+)
+
+// invoker calls a method with the params of a request without reflection.
+type invoker func(ctx context.Context, params []json.RawMessage) (any, error)
+
+// method is an rpc method of the service, resolved once when the handler is
+// built instead of on every request.
+type method struct {
+	value reflect.Value
+	// withContext is set for methods taking a context.Context first, they
+	// receive the request api key and client IP through it
+	withContext bool
+	// numParams is the number of params, the context excluded
+	numParams int
+	// invoke is the fast path of the most requested signatures, nil for the
+	// others which are called through reflection
+	invoke invoker
+}
+
+// methodTable maps the rpc names of the methods of a service to them.
+type methodTable map[string]*method
+
+// newMethodTable resolves the namespaced methods of service, e.g. Pm_config,
+// served as both pm_config and Pm_config.
+func newMethodTable(service interface{}) methodTable {
+	value := reflect.ValueOf(service)
+	table := make(methodTable)
+	for i := 0; i < value.NumMethod(); i++ {
+		name := value.Type().Method(i).Name
+		if !strings.Contains(name, "_") {
+			continue
+		}
+		fn := value.Method(i)
+		m := &method{value: fn, numParams: fn.Type().NumIn(), invoke: fastInvoker(fn.Interface())}
+		if m.numParams > 0 && fn.Type().In(0) == contextType {
+			m.withContext = true
+			m.numParams--
+		}
+		table[name] = m
+		table[strings.ToLower(name[:1])+name[1:]] = m
+	}
+	return table
+}
+
+// lookup returns the method named name, nil when the service has none.
+func (t methodTable) lookup(name string) *method {
+	return t[name]
+}
+
+// call invokes m with params, ctx being passed to methods taking a context.
+// Omitted trailing params are passed as zero values.
+func (m *method) call(ctx context.Context, params []json.RawMessage) (any, error) {
+	if m.invoke != nil {
+		return m.invoke(ctx, params)
+	}
+	args, err := m.args(params)
+	if err != nil {
+		return nil, err
+	}
+	if m.withContext {
+		args = append([]reflect.Value{reflect.ValueOf(ctx)}, args...)
+	}
+	result := m.value.Call(args)
+	if len(result) == 0 {
+		return nil, nil
+	}
+	if err, ok := result[len(result)-1].Interface().(error); ok && err != nil {
+		return nil, err
+	}
+	return result[0].Interface(), nil
+}
+
+func invalidParam(i int, typ reflect.Type) error {
+	return errors.NewRPCError(errors.INVALID_PARAMS, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, typ.String()))
+}
+
+// args converts params to the argument types of m. json.RawMessage
+// arguments receive the param as sent, the others are decoded first.
+func (m *method) args(params []json.RawMessage) ([]reflect.Value, error) {
+	offset := 0
+	if m.withContext {
+		offset = 1
+	}
+	typ := m.value.Type()
+	args := make([]reflect.Value, m.numParams)
+	for i := range args {
+		in := typ.In(i + offset)
+		if i >= len(params) {
+			args[i] = reflect.Zero(in)
+			continue
+		}
+		if in == rawType {
+			args[i] = reflect.ValueOf(params[i])
+			continue
+		}
+		var arg any
+		if err := json.Unmarshal(params[i], &arg); err != nil {
+			return nil, invalidParam(i, in)
+		}
+
+		switch in.Kind() {
+		case reflect.Float32:
+			val, ok := arg.(float32)
+			if !ok {
+				return nil, invalidParam(i, in)
+			}
+			args[i] = reflect.ValueOf(val)
+
+		case reflect.Float64:
+			val, ok := arg.(float64)
+			if !ok {
+				return nil, invalidParam(i, in)
+			}
+			args[i] = reflect.ValueOf(val)
+
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			fval, ok := arg.(float64)
+			if !ok || fval != math.Trunc(fval) || fval < math.MinInt64 || fval >= math.MaxInt64 {
+				return nil, invalidParam(i, in)
+			}
+			args[i] = reflect.New(in).Elem()
+			if args[i].OverflowInt(int64(fval)) {
+				return nil, invalidParam(i, in)
+			}
+			args[i].SetInt(int64(fval))
+
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			// negative, fractional or overflowing numbers are refused
+			// rather than wrapped or truncated
+			fval, ok := arg.(float64)
+			if !ok || fval < 0 || fval != math.Trunc(fval) || fval >= math.MaxUint64 {
+				return nil, invalidParam(i, in)
+			}
+			args[i] = reflect.New(in).Elem()
+			if args[i].OverflowUint(uint64(fval)) {
+				return nil, invalidParam(i, in)
+			}
+			args[i].SetUint(uint64(fval))
+
+		case reflect.Interface:
+			args[i] = reflect.ValueOf(arg)
+
+		case reflect.Map:
+			val, ok := arg.(map[string]any)
+			if !ok {
+				return nil, invalidParam(i, in)
+			}
+			args[i] = reflect.ValueOf(val)
+
+		case reflect.Slice:
+			val, ok := arg.([]interface{})
+			if !ok {
+				return nil, invalidParam(i, in)
+			}
+			args[i] = reflect.ValueOf(val)
+
+		case reflect.String:
+			val, _ := arg.(string)
+			args[i] = reflect.ValueOf(val)
+
+		default:
+			return nil, errors.NewRPCError(errors.INTERNAL_ERROR, "Internal error", "Invalid method defination")
+		}
+	}
+	return args, nil
+}
+
+// objectParam is param i when it is a JSON object, nil when omitted. Like
+// maps it is decoded by the method.
+func objectParam(params []json.RawMessage, i int) (json.RawMessage, error) {
+	if i >= len(params) {
+		return nil, nil
+	}
+	if params[i][0] != '{' {
+		return nil, invalidParam(i, mapType)
+	}
+	return params[i], nil
+}
+
+// mapParam is param i as a map, nil when omitted.
+func mapParam(params []json.RawMessage, i int) (map[string]any, error) {
+	if i >= len(params) {
+		return nil, nil
+	}
+	var val map[string]any
+	if params[i][0] != '{' || json.Unmarshal(params[i], &val) != nil {
+		return nil, invalidParam(i, mapType)
+	}
+	return val, nil
+}
+
+// stringParam is param i as a string, empty when omitted or not a string.
+func stringParam(params []json.RawMessage, i int) string {
+	if i >= len(params) || params[i][0] != '"' {
+		return ""
+	}
+	var val string
+	_ = json.Unmarshal(params[i], &val)
+	return val
+}
+
+// opInvoker calls the methods taking a user operation, its entry point and
+// a sponsor context, like pm_sponsorUserOperation. The operation is passed
+// undecoded.
+func opInvoker[R any](fn func(context.Context, json.RawMessage, string, map[string]any) (R, error)) invoker {
+	return func(ctx context.Context, params []json.RawMessage) (any, error) {
+		op, err := objectParam(params, 0)
+		if err != nil {
+			return nil, err
+		}
+		sponsorContext, err := mapParam(params, 2)
+		if err != nil {
+			return nil, err
+		}
+		return fn(ctx, op, stringParam(params, 1), sponsorContext)
+	}
+}
+
+// estimateInvoker calls the methods taking a user operation as a map.
+func estimateInvoker[R any](fn func(context.Context, map[string]any, string, map[string]any) (R, error)) invoker {
+	return func(ctx context.Context, params []json.RawMessage) (any, error) {
+		op, err := mapParam(params, 0)
+		if err != nil {
+			return nil, err
+		}
+		stateOverride, err := mapParam(params, 2)
+		if err != nil {
+			return nil, err
+		}
+		return fn(ctx, op, stringParam(params, 1), stateOverride)
+	}
+}
+
+// erc7677Invoker calls the ERC-7677 methods, taking the chain id before the
+// sponsor context.
+func erc7677Invoker[R any](fn func(context.Context, json.RawMessage, string, string, map[string]any) (R, error)) invoker {
+	return func(ctx context.Context, params []json.RawMessage) (any, error) {
+		op, err := objectParam(params, 0)
+		if err != nil {
+			return nil, err
+		}
+		sponsorContext, err := mapParam(params, 3)
+		if err != nil {
+			return nil, err
+		}
+		return fn(ctx, op, stringParam(params, 1), stringParam(params, 2), sponsorContext)
+	}
+}
+
+// keyLookupInvoker calls the methods looking up an address for the api key
+// of the request.
+func keyLookupInvoker[R any](fn func(context.Context, string) (R, error)) invoker {
+	return func(ctx context.Context, params []json.RawMessage) (any, error) {
+		return fn(ctx, stringParam(params, 0))
+	}
+}
+
+// lookupInvoker calls the methods looking up an address or hash.
+func lookupInvoker[R any](fn func(string) (R, error)) invoker {
+	return func(_ context.Context, params []json.RawMessage) (any, error) {
+		return fn(stringParam(params, 0))
+	}
+}
+
+// readInvoker calls the methods without params.
+func readInvoker[R any](fn func() (R, error)) invoker {
+	return func(context.Context, []json.RawMessage) (any, error) {
+		return fn()
+	}
+}
+
+// fastInvoker returns the invoker of the signatures of the sponsorship
+// methods, nil for the others.
+func fastInvoker(fn any) invoker {
+	switch fn := fn.(type) {
+	case func(context.Context, json.RawMessage, string, map[string]any) (*api.PaymasterResult, error):
+		return opInvoker(fn)
+	case func(context.Context, json.RawMessage, string, map[string]any) (*api.SponsorshipCheck, error):
+		return opInvoker(fn)
+	case func(context.Context, json.RawMessage, string, map[string]any) (*api.Quote, error):
+		return opInvoker(fn)
+	case func(context.Context, map[string]any, string, map[string]any) (json.RawMessage, error):
+		return estimateInvoker(fn)
+	case func(context.Context, json.RawMessage, string, string, map[string]any) (*api.PaymasterStubData, error):
+		return erc7677Invoker(fn)
+	case func(context.Context, json.RawMessage, string, string, map[string]any) (*api.PaymasterData, error):
+		return erc7677Invoker(fn)
+	case func(context.Context, string) (*api.GasRemain, error):
+		return keyLookupInvoker(fn)
+	case func(string) (*api.SponsorshipReceipt, error):
+		return lookupInvoker(fn)
+	case func(string) (*api.UserOperationStatus, error):
+		return lookupInvoker(fn)
+	case func() (*api.PaymasterConfig, error):
+		return readInvoker(fn)
+	case func() ([]string, error):
+		return readInvoker(fn)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+type numberService struct{}
+
+func (numberService) T_uint(n uint32) (uint32, error) { return n, nil }
+
+func (numberService) T_int(n int8) (int8, error) { return n, nil }
+
+func TestIntegerParams(t *testing.T) {
+	table := newMethodTable(numberService{})
+	for _, tc := range []struct {
+		method string
+		param  string
+		ok     bool
+	}{
+		{"t_uint", "3", true},
+		{"t_uint", "-1", false},
+		{"t_uint", "1.5", false},
+		{"t_uint", "4294967296", false},
+		{"t_uint", "1e30", false},
+		{"t_int", "-128", true},
+		{"t_int", "128", false},
+		{"t_int", "-2.5", false},
+	} {
+		_, err := table.lookup(tc.method).call(context.Background(), []json.RawMessage{json.RawMessage(tc.param)})
+		if (err == nil) != tc.ok {
+			t.Errorf("%s(%s): error %v", tc.method, tc.param, err)
+		}
+	}
+}
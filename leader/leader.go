@@ -0,0 +1,98 @@
+// Package leader elects the replica running the background jobs with a
+// Postgres advisory lock, so several replicas can serve the api while a
+// single one indexes, bills and sends.
+package leader
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+)
+
+// lockKey is the advisory lock held by the leader, it must not be used by
+// other applications sharing the database.
+const lockKey int64 = 4337_0001
+
+// Job is a background job running until its context is cancelled.
+type Job func(ctx context.Context)
+
+// Elector runs the jobs while this replica holds the advisory lock.
+type Elector struct {
+	rep db.Repository
+	// interval is how often the lock is tried and the locked session
+	// checked.
+	interval time.Duration
+}
+
+func NewElector(rep db.Repository, interval time.Duration) *Elector {
+	if interval == 0 {
+		interval = 10 * time.Second
+	}
+	return &Elector{rep: rep, interval: interval}
+}
+
+// Run tries to take the lead every interval until ctx is cancelled. While
+// leading, jobs run with a context cancelled once the lead is lost.
+func (e *Elector) Run(ctx context.Context, jobs []Job) {
+	for {
+		if err := e.rep.Model(nil).Connection(func(conn *gorm.DB) error {
+			return e.lead(ctx, conn, jobs)
+		}); err != nil {
+			logger.S().Errorf("leader election error: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(e.interval):
+		}
+	}
+}
+
+// lead runs jobs if the lock is free. The lock belongs to the session of
+// conn, it is released when the session ends, so conn is pinged to notice
+// a lost connection.
+func (e *Elector) lead(ctx context.Context, conn *gorm.DB, jobs []Job) error {
+	var locked bool
+	if err := conn.Raw("SELECT pg_try_advisory_lock(?)", lockKey).Scan(&locked).Error; err != nil {
+		return err
+	}
+	if !locked {
+		return nil
+	}
+	logger.S().Infof("Elected leader, running %d background jobs", len(jobs))
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Add(1)
+		go func(job Job) {
+			defer wg.Done()
+			job(jobCtx)
+		}(job)
+	}
+	stop := func() {
+		cancel()
+		wg.Wait()
+	}
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			stop()
+			return conn.Exec("SELECT pg_advisory_unlock(?)", lockKey).Error
+		case <-ticker.C:
+			if err := conn.Exec("SELECT 1").Error; err != nil {
+				logger.S().Warnf("Lost leadership: %v", err)
+				stop()
+				return err
+			}
+		}
+	}
+}
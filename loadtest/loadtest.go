@@ -0,0 +1,227 @@
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/ququzone/verifying-paymaster-service/jsonrpc"
+)
+
+const (
+	DistributionUniform = "uniform"
+	DistributionZipf    = "zipf"
+)
+
+type Config struct {
+	// URL is the service base url, e.g. http://localhost:8888
+	URL string
+	// Keys are api keys with optional weights in "key:weight" form.
+	Keys         []string
+	Senders      int
+	Distribution string
+	Concurrency  int
+	Duration     time.Duration
+	// Rate limits the total requests per second, 0 means unlimited.
+	Rate float64
+	// ReadRatio is the share of pm_gasRemain calls mixed into the traffic.
+	ReadRatio  float64
+	EntryPoint string
+	Timeout    time.Duration
+}
+
+type weightedKey struct {
+	key    string
+	weight int
+}
+
+type generator struct {
+	keys        []weightedKey
+	totalWeight int
+	senders     []common.Address
+	zipf        *rand.Zipf
+	rnd         *rand.Rand
+	mu          sync.Mutex
+}
+
+func parseKeys(keys []string) ([]weightedKey, int, error) {
+	var result []weightedKey
+	total := 0
+	for _, item := range keys {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, weight := item, 1
+		if idx := strings.LastIndex(item, ":"); idx > 0 {
+			w, err := strconv.Atoi(item[idx+1:])
+			if err != nil || w <= 0 {
+				return nil, 0, fmt.Errorf("invalid key weight: %s", item)
+			}
+			key, weight = item[:idx], w
+		}
+		result = append(result, weightedKey{key: key, weight: weight})
+		total += weight
+	}
+	if len(result) == 0 {
+		return nil, 0, errors.New("at least one api key is required")
+	}
+	return result, total, nil
+}
+
+func newGenerator(conf *Config) (*generator, error) {
+	keys, total, err := parseKeys(conf.Keys)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Senders <= 0 {
+		return nil, errors.New("senders must be positive")
+	}
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	senders := make([]common.Address, conf.Senders)
+	for i := range senders {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		senders[i] = crypto.PubkeyToAddress(key.PublicKey)
+	}
+
+	gen := &generator{
+		keys:        keys,
+		totalWeight: total,
+		senders:     senders,
+		rnd:         rnd,
+	}
+	switch conf.Distribution {
+	case DistributionUniform, "":
+	case DistributionZipf:
+		if conf.Senders > 1 {
+			gen.zipf = rand.NewZipf(rnd, 1.2, 1, uint64(conf.Senders-1))
+		}
+	default:
+		return nil, fmt.Errorf("unknown sender distribution: %s", conf.Distribution)
+	}
+	return gen, nil
+}
+
+func (g *generator) next() (string, common.Address, float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	pick := g.rnd.Intn(g.totalWeight)
+	key := g.keys[len(g.keys)-1].key
+	for _, k := range g.keys {
+		if pick < k.weight {
+			key = k.key
+			break
+		}
+		pick -= k.weight
+	}
+
+	var sender common.Address
+	if g.zipf != nil {
+		sender = g.senders[g.zipf.Uint64()]
+	} else {
+		sender = g.senders[g.rnd.Intn(len(g.senders))]
+	}
+	return key, sender, g.rnd.Float64()
+}
+
+func userOperation(sender common.Address) map[string]any {
+	callData := append(hexutil.MustDecode("0xb61d27f6"), make([]byte, 96)...)
+	return map[string]any{
+		"sender":               sender.Hex(),
+		"nonce":                "0x0",
+		"initCode":             "0x",
+		"callData":             hexutil.Encode(callData),
+		"callGasLimit":         "0x0",
+		"verificationGasLimit": "0x0",
+		"preVerificationGas":   "0x0",
+		"maxFeePerGas":         hexutil.EncodeBig(big.NewInt(2e9)),
+		"maxPriorityFeePerGas": hexutil.EncodeBig(big.NewInt(1e9)),
+		"paymasterAndData":     "0x",
+		"signature":            hexutil.Encode(make([]byte, 65)),
+	}
+}
+
+// Run fires sponsorship traffic against the service until the duration
+// elapses or ctx is cancelled and returns the collected report.
+func Run(ctx context.Context, conf *Config) (*Report, error) {
+	gen, err := newGenerator(conf)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Concurrency <= 0 {
+		conf.Concurrency = 1
+	}
+	ctx, cancel := context.WithTimeout(ctx, conf.Duration)
+	defer cancel()
+
+	var ticker *time.Ticker
+	if conf.Rate > 0 {
+		ticker = time.NewTicker(time.Duration(float64(time.Second) / conf.Rate))
+		defer ticker.Stop()
+	}
+
+	client := &http.Client{
+		Timeout: conf.Timeout,
+		Transport: &http.Transport{
+			MaxIdleConns:        conf.Concurrency,
+			MaxIdleConnsPerHost: conf.Concurrency,
+		},
+	}
+	collector := newCollector()
+	base := strings.TrimRight(conf.URL, "/")
+
+	var wg sync.WaitGroup
+	for i := 0; i < conf.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if ticker != nil {
+					select {
+					case <-ctx.Done():
+						return
+					case <-ticker.C:
+					}
+				} else if ctx.Err() != nil {
+					return
+				}
+
+				key, sender, roll := gen.next()
+				url := fmt.Sprintf("%s/rpc/%s", base, key)
+				method := "pm_sponsorUserOperation"
+				params := []any{userOperation(sender), conf.EntryPoint}
+				if roll < conf.ReadRatio {
+					method = "pm_gasRemain"
+					params = []any{sender.Hex()}
+				}
+
+				start := time.Now()
+				err := jsonrpc.Call(ctx, client, url, method, params, nil)
+				if ctx.Err() != nil {
+					// requests cut off by the deadline are not representative
+					return
+				}
+				collector.record(method, time.Since(start), err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return collector.report(conf.Duration), nil
+}
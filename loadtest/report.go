@@ -0,0 +1,115 @@
+package loadtest
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/errors"
+)
+
+type collector struct {
+	mu        sync.Mutex
+	latencies map[string][]time.Duration
+	errors    map[string]int
+	failed    map[string]int
+}
+
+func newCollector() *collector {
+	return &collector{
+		latencies: make(map[string][]time.Duration),
+		errors:    make(map[string]int),
+		failed:    make(map[string]int),
+	}
+}
+
+func (c *collector) record(method string, latency time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.latencies[method] = append(c.latencies[method], latency)
+	if err != nil {
+		c.failed[method]++
+		if rpcErr, ok := err.(*errors.RPCError); ok {
+			c.errors[fmt.Sprintf("%d %s", rpcErr.Code(), rpcErr.Error())]++
+		} else {
+			c.errors[err.Error()]++
+		}
+	}
+}
+
+type MethodReport struct {
+	Method   string
+	Requests int
+	Errors   int
+	P50      time.Duration
+	P90      time.Duration
+	P99      time.Duration
+	Max      time.Duration
+}
+
+type Report struct {
+	Duration time.Duration
+	Methods  []MethodReport
+	Errors   map[string]int
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(float64(len(sorted)-1) * p)
+	return sorted[idx]
+}
+
+func (c *collector) report(duration time.Duration) *Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	report := &Report{Duration: duration, Errors: c.errors}
+	for method, latencies := range c.latencies {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		report.Methods = append(report.Methods, MethodReport{
+			Method:   method,
+			Requests: len(latencies),
+			Errors:   c.failed[method],
+			P50:      percentile(latencies, 0.50),
+			P90:      percentile(latencies, 0.90),
+			P99:      percentile(latencies, 0.99),
+			Max:      latencies[len(latencies)-1],
+		})
+	}
+	sort.Slice(report.Methods, func(i, j int) bool { return report.Methods[i].Method < report.Methods[j].Method })
+	return report
+}
+
+// Print writes a human readable summary of the report.
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "%-26s %9s %8s %8s %10s %10s %10s %10s\n", "method", "requests", "rps", "errors", "p50", "p90", "p99", "max")
+	for _, m := range r.Methods {
+		rps := float64(m.Requests) / r.Duration.Seconds()
+		errRate := 0.0
+		if m.Requests > 0 {
+			errRate = float64(m.Errors) * 100 / float64(m.Requests)
+		}
+		fmt.Fprintf(w, "%-26s %9d %8.1f %7.2f%% %10s %10s %10s %10s\n",
+			m.Method, m.Requests, rps, errRate,
+			m.P50.Round(time.Microsecond), m.P90.Round(time.Microsecond),
+			m.P99.Round(time.Microsecond), m.Max.Round(time.Microsecond))
+	}
+	if len(r.Errors) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "\nerrors:\n")
+	messages := make([]string, 0, len(r.Errors))
+	for msg := range r.Errors {
+		messages = append(messages, msg)
+	}
+	sort.Slice(messages, func(i, j int) bool { return r.Errors[messages[i]] > r.Errors[messages[j]] })
+	for _, msg := range messages {
+		fmt.Fprintf(w, "  %8d  %s\n", r.Errors[msg], msg)
+	}
+}
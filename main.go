@@ -1,20 +1,48 @@
 package main
 
 import (
+	"context"
+	"crypto/ecdsa"
 	"fmt"
 	"log"
+	"math/big"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 
+	"github.com/ququzone/verifying-paymaster-service/abuse"
+	"github.com/ququzone/verifying-paymaster-service/admin"
 	"github.com/ququzone/verifying-paymaster-service/api"
+	"github.com/ququzone/verifying-paymaster-service/apikeys"
+	"github.com/ququzone/verifying-paymaster-service/attest"
+	"github.com/ququzone/verifying-paymaster-service/billing"
+	"github.com/ququzone/verifying-paymaster-service/bots"
+	"github.com/ququzone/verifying-paymaster-service/budget"
+	"github.com/ququzone/verifying-paymaster-service/cache"
 	"github.com/ququzone/verifying-paymaster-service/config"
 	"github.com/ququzone/verifying-paymaster-service/container"
 	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/export"
+	"github.com/ququzone/verifying-paymaster-service/faucet"
+	"github.com/ququzone/verifying-paymaster-service/geoip"
+	"github.com/ququzone/verifying-paymaster-service/indexer"
 	"github.com/ququzone/verifying-paymaster-service/jsonrpc"
+	"github.com/ququzone/verifying-paymaster-service/leader"
 	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/maintenance"
+	"github.com/ququzone/verifying-paymaster-service/metrics"
 	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/ratelimit"
+	"github.com/ququzone/verifying-paymaster-service/receipts"
+	"github.com/ququzone/verifying-paymaster-service/recorder"
+	"github.com/ququzone/verifying-paymaster-service/report"
+	"github.com/ququzone/verifying-paymaster-service/store"
+	"github.com/ququzone/verifying-paymaster-service/webhooks"
 )
 
 func main() {
@@ -27,36 +55,342 @@ func main() {
 		log.Fatalf("init config error: %v", err)
 	}
 
-	repository := db.NewRepository()
-	err = repository.AutoMigrate(&models.User{}, &models.ApiKeys{}, &models.Account{})
-	if err != nil {
-		logger.S().Fatalf("database migrate error: %v", err)
-	}
-
-	signerApi, err := api.NewSigner(container.NewContainer(repository))
+	conf := config.Config()
+	states, err := store.New(conf.RedisURL)
 	if err != nil {
-		logger.S().Fatalf("instance signer error: %v", err)
+		logger.S().Fatalf("instance state store error: %v", err)
 	}
 
-	conf := config.Config()
 	gin.SetMode(conf.GinMode)
 	r := gin.New()
-	if err := r.SetTrustedProxies(nil); err != nil {
+	if err := r.SetTrustedProxies(conf.TrustedProxies); err != nil {
 		logger.S().Fatalf("gin set trusted proxies error: %v", err)
 	}
+	// browser clients send the replay protection headers
+	corsConfig := cors.DefaultConfig()
+	corsConfig.AllowAllOrigins = true
+	corsConfig.AddAllowHeaders(api.TimestampHeader, api.NonceHeader)
 	r.Use(
-		cors.Default(),
+		cors.New(corsConfig),
 		gin.Recovery(),
 	)
 	r.GET("/ping", func(g *gin.Context) {
 		g.String(http.StatusOK, "ok")
 	})
-	handlers := []gin.HandlerFunc{
-		jsonrpc.Process(signerApi),
+	if conf.MetricsEnabled {
+		r.GET("/metrics", gin.WrapH(metrics.Handler()))
+	}
+	if conf.SloLatency > 0 {
+		alerts, err := metrics.ParseBurnAlerts(conf.SloBurnAlerts)
+		if err != nil {
+			logger.S().Fatalf("invalid SLO_BURN_ALERTS: %v", err)
+		}
+		if conf.SloObjective <= 0 || conf.SloObjective >= 1 {
+			logger.S().Fatalf("SLO_OBJECTIVE must be between 0 and 1, got %v", conf.SloObjective)
+		}
+		slo := metrics.EnableSLO(&metrics.SLOConfig{
+			Latency:   conf.SloLatency,
+			Objective: conf.SloObjective,
+			Methods:   conf.SloMethods,
+			Alerts:    alerts,
+			Webhook:   conf.SloAlertWebhook,
+			Interval:  conf.SloCheckInterval,
+		})
+		go slo.Run(context.Background())
+	}
+
+	shared := &middleware{}
+	if conf.IPRateLimit > 0 {
+		shared.limiter = ratelimit.NewLimiter(&ratelimit.Config{
+			Rate:           conf.IPRateLimit,
+			Burst:          conf.IPRateBurst,
+			MaxKeyFailures: conf.IPKeyFailures,
+			BanFor:         conf.IPKeyBan,
+		}, states)
+	}
+	if conf.CaptureFile != "" {
+		rec, err := recorder.NewRecorder(conf.CaptureFile, conf.CaptureSampleRate)
+		if err != nil {
+			logger.S().Fatalf("open capture file error: %v", err)
+		}
+		defer rec.Close()
+		logger.S().Infof("Capturing rpc traffic to %s", conf.CaptureFile)
+		shared.recorder = rec
+	}
+	if conf.AttestationKey != "" {
+		attester, err := attest.NewAttester(strings.TrimPrefix(conf.AttestationKey, "0x"))
+		if err != nil {
+			logger.S().Fatalf("load attestation key error: %v", err)
+		}
+		logger.S().Infof("Attesting rpc responses with %s", attester.Signer().Hex())
+		shared.attester = attester
+	}
+
+	runNetwork(conf, states, shared, r)
+	for _, network := range conf.Networks {
+		logger.S().Infof("Serving network %s under /%s", network.Network, network.Network)
+		runNetwork(network, store.WithPrefix(states, network.Network+":"), shared, r.Group("/"+network.Network))
 	}
-	r.POST("/rpc/:key", handlers...)
 
 	if err := r.Run(fmt.Sprintf(":%d", conf.Port)); err != nil {
 		logger.S().Fatalf("gin run error: %v", err)
 	}
 }
+
+// middleware are the rpc handlers shared by all networks, nil when
+// disabled.
+type middleware struct {
+	limiter  *ratelimit.Limiter
+	recorder *recorder.Recorder
+	attester *attest.Attester
+}
+
+// runNetwork starts the signer and background jobs of the network of conf
+// and mounts its endpoints on r. Networks share nothing but the process and
+// the shared middleware: each has its own database, signer key, chains and
+// state keys.
+func runNetwork(conf *config.Values, states store.Store, shared *middleware, r gin.IRouter) {
+	repository := db.Open(conf)
+	err := models.Migrate(repository)
+	if err != nil {
+		logger.S().Fatalf("database migrate error: %v", err)
+	}
+
+	signerApi, err := api.NewSigner(conf, container.NewContainer(repository), states)
+	if err != nil {
+		logger.S().Fatalf("instance signer error: %v", err)
+	}
+	err = models.AssignDefaultChain(repository, signerApi.ChainID.Uint64(), indexer.CheckpointName)
+	if err != nil {
+		logger.S().Fatalf("assign default chain error: %v", err)
+	}
+
+	bus := cache.NewBus(states)
+	bus.On(cache.Config, func(string) { signerApi.InvalidateConfig() })
+	bus.On(cache.ApiKey, signerApi.ApiKeys.Drop)
+	bus.On(cache.ErrorTemplates, func(string) { signerApi.ReloadErrorTemplates() })
+	signerApi.ReloadErrorTemplates()
+	go bus.Run(context.Background())
+
+	keyRotation := &apikeys.Config{
+		Notice:   conf.ApiKeyExpiryNotice,
+		Grace:    conf.ApiKeyRotationGrace,
+		Lifetime: conf.ApiKeyLifetime,
+		Interval: conf.ApiKeyCheckInterval,
+	}
+
+	// jobs run on the elected leader only when several replicas share the
+	// database
+	var jobs []leader.Job
+	jobs = append(jobs,
+		func(ctx context.Context) { signerApi.RunQuotaReclaimer(ctx, conf.QuotaReclaimInterval) },
+		func(ctx context.Context) { signerApi.RunDepositMonitor(ctx, conf.DepositCheckInterval) },
+		budget.NewMonitor(repository, conf.BudgetCheckInterval).Run,
+		maintenance.NewNotifier(&maintenance.Config{
+			Notice:  conf.MaintenanceNotice,
+			Webhook: conf.MaintenanceWebhook,
+		}, repository).Run,
+		apikeys.NewNotifier(keyRotation, repository, bus).Run,
+		webhooks.NewDispatcher(&webhooks.Config{
+			Interval:     conf.WebhookInterval,
+			MaxAttempts:  conf.WebhookMaxAttempts,
+			Retention:    conf.WebhookRetention,
+			AllowPrivate: conf.WebhookAllowPrivate,
+		}, repository).Run,
+		report.NewDetector(&report.Config{
+			Window:   conf.AnomalyWindow,
+			ZScore:   conf.AnomalyZScore,
+			Webhook:  conf.AnomalyWebhook,
+			Interval: conf.AnomalyCheckInterval,
+		}, repository).Run,
+	)
+	if conf.AbuseCheckInterval > 0 {
+		jobs = append(jobs, abuse.NewDetector(&abuse.Config{
+			Interval:        conf.AbuseCheckInterval,
+			Window:          conf.AbuseWindow,
+			IPSenders:       conf.AbuseIPSenders,
+			CallDataSenders: conf.AbuseCallDataSenders,
+			DrainPercent:    conf.AbuseDrainPercent,
+			DrainWindow:     conf.AbuseDrainWindow,
+			AutoPause:       conf.AbuseAutoPause,
+		}, repository, bus).Run)
+	}
+
+	if conf.StripeSecretKey != "" {
+		unitWei, _ := new(big.Int).SetString(conf.StripeUnitWei, 10)
+		jobs = append(jobs, billing.NewReporter(&billing.Config{
+			MeterEvent: conf.StripeMeterEvent,
+			UnitWei:    unitWei,
+			Interval:   conf.BillingInterval,
+		}, billing.NewStripe(conf.StripeSecretKey), repository).Run)
+	}
+
+	var sink export.Sink
+	switch conf.ExportSink {
+	case "":
+	case "s3":
+		sink = &export.S3Sink{
+			Bucket:          conf.ExportS3Bucket,
+			Prefix:          conf.ExportS3Prefix,
+			Region:          conf.ExportS3Region,
+			Endpoint:        conf.ExportS3Endpoint,
+			AccessKeyID:     conf.AWSAccessKeyID,
+			SecretAccessKey: conf.AWSSecretAccessKey,
+			SessionToken:    conf.AWSSessionToken,
+		}
+	case "bigquery":
+		sink, err = export.NewBigQuerySink(conf.ExportBQProject, conf.ExportBQDataset, conf.ExportBQTable, conf.GoogleCredentials)
+		if err != nil {
+			logger.S().Fatalf("instance bigquery sink error: %v", err)
+		}
+	default:
+		logger.S().Fatalf("unsupported EXPORT_SINK %q", conf.ExportSink)
+	}
+
+	var faucetKey *ecdsa.PrivateKey
+	var faucetAmount, faucetDailyCap *big.Int
+	faucetChains := make(map[uint64]bool)
+	if conf.FaucetKey != "" && !conf.MockChain {
+		faucetKey, err = crypto.HexToECDSA(strings.TrimPrefix(conf.FaucetKey, "0x"))
+		if err != nil {
+			logger.S().Fatalf("load faucet key error: %v", err)
+		}
+		if len(conf.FaucetChains) == 0 {
+			logger.S().Fatalf("FAUCET_KEY requires FAUCET_CHAINS")
+		}
+		for _, id := range conf.FaucetChains {
+			chainID, err := strconv.ParseUint(id, 10, 64)
+			if err != nil || signerApi.Chain(chainID) == nil {
+				logger.S().Fatalf("invalid FAUCET_CHAINS chain %q, not a served chain", id)
+			}
+			faucetChains[chainID] = true
+		}
+		faucetAmount, _ = new(big.Int).SetString(conf.FaucetAmount, 10)
+		if conf.FaucetDailyCap != "" {
+			var ok bool
+			if faucetDailyCap, ok = new(big.Int).SetString(conf.FaucetDailyCap, 10); !ok {
+				logger.S().Fatalf("invalid FAUCET_DAILY_CAP %q", conf.FaucetDailyCap)
+			}
+		}
+	}
+
+	for _, chainCtx := range signerApi.Chains {
+		if conf.IndexerEnabled && !conf.MockChain {
+			idx, err := indexer.NewIndexer(&indexer.Config{
+				ChainID:       chainCtx.ChainID,
+				EntryPoint:    chainCtx.EntryPoint,
+				Paymaster:     chainCtx.Contract,
+				StartBlock:    chainCtx.Config.IndexerStartBlock,
+				BatchSize:     conf.IndexerBatchSize,
+				Confirmations: conf.IndexerConfirmations,
+				ReorgDepth:    conf.IndexerReorgDepth,
+				PollInterval:  conf.IndexerPollInterval,
+			}, chainCtx.Client, repository)
+			if err != nil {
+				logger.S().Fatalf("instance chain %s indexer error: %v", chainCtx.ChainID, err)
+			}
+			jobs = append(jobs, idx.Run)
+		}
+		if chainCtx.Config.ReceiptContract != "" && !conf.MockChain {
+			relayerKey, err := crypto.HexToECDSA(strings.TrimPrefix(conf.ReceiptRelayerKey, "0x"))
+			if err != nil {
+				logger.S().Fatalf("load receipt relayer key error: %v", err)
+			}
+			relayer, err := receipts.NewRelayer(&receipts.Config{
+				Contract:     common.HexToAddress(chainCtx.Config.ReceiptContract),
+				RelayerKey:   relayerKey,
+				ChainID:      chainCtx.ChainID,
+				StartBlock:   chainCtx.Config.ReceiptStartBlock,
+				BatchSize:    conf.ReceiptBatchSize,
+				PollInterval: conf.ReceiptPollInterval,
+			}, chainCtx.Client, repository)
+			if err != nil {
+				logger.S().Fatalf("instance chain %s receipt relayer error: %v", chainCtx.ChainID, err)
+			}
+			jobs = append(jobs, relayer.Run)
+		}
+		if faucetChains[chainCtx.ChainID.Uint64()] {
+			drips, err := faucet.New(&faucet.Config{
+				Key:          faucetKey,
+				ChainID:      chainCtx.ChainID,
+				Testnets:     faucetChains,
+				Amount:       faucetAmount,
+				DailyCap:     faucetDailyCap,
+				Cooldown:     conf.FaucetCooldown,
+				PollInterval: conf.FaucetInterval,
+			}, chainCtx.Client, repository)
+			if err != nil {
+				logger.S().Fatalf("instance chain %s faucet error: %v", chainCtx.ChainID, err)
+			}
+			signerApi.Faucets = append(signerApi.Faucets, drips)
+			jobs = append(jobs, drips.Run)
+		}
+		if sink != nil {
+			jobs = append(jobs, export.NewExporter(&export.Config{
+				ChainID:   chainCtx.ChainID,
+				BatchSize: conf.ExportBatchSize,
+				Interval:  conf.ExportInterval,
+			}, sink, repository).Run)
+		}
+		if chainCtx.Factories != nil {
+			go chainCtx.Factories.Run(context.Background(), conf.FactoryCheckInterval)
+		}
+		if chainCtx.Bundler != nil {
+			go chainCtx.Bundler.Run(context.Background(), conf.BundlerHealthInterval)
+		}
+		if chainCtx.SelfBundler != nil {
+			jobs = append(jobs, chainCtx.SelfBundler.Run)
+		}
+	}
+	if conf.LeaderElection {
+		go leader.NewElector(repository, conf.LeaderCheckInterval).Run(context.Background(), jobs)
+	} else {
+		for _, job := range jobs {
+			go job(context.Background())
+		}
+	}
+
+	handlers := []gin.HandlerFunc{jsonrpc.Localize(signerApi.ErrorTemplates)}
+	if shared.limiter != nil {
+		handlers = append(handlers, shared.limiter.Middleware())
+	}
+	if conf.FingerprintFactor > 0 {
+		fingerprints := ratelimit.NewFingerprints(&ratelimit.FingerprintConfig{
+			Factor:      conf.FingerprintFactor,
+			MinRequests: conf.FingerprintMinRequests,
+			MinSenders:  conf.FingerprintMinSenders,
+			Warmup:      conf.FingerprintWarmup,
+			ThrottleFor: conf.FingerprintThrottle,
+		}, repository, states)
+		bus.On(cache.Throttle, fingerprints.Reload)
+		go fingerprints.Run(context.Background())
+		handlers = append(handlers, fingerprints.Middleware())
+	}
+	if shared.recorder != nil {
+		handlers = append(handlers, shared.recorder.Middleware())
+	}
+	if shared.attester != nil {
+		handlers = append(handlers, shared.attester.Middleware())
+	}
+	if conf.GeoIPDatabase != "" {
+		geo, err := geoip.New(conf.GeoIPDatabase, conf.GeoIPAllow, conf.GeoIPDeny, repository)
+		if err != nil {
+			logger.S().Fatalf("open geoip database error: %v", err)
+		}
+		handlers = append(handlers, geo.Middleware())
+	}
+	handlers = handlers[:len(handlers):len(handlers)]
+	r.POST("/rpc/:key", append(handlers, jsonrpc.Process(signerApi))...)
+	r.GET("/rpc/:key/:method", append(handlers, jsonrpc.Get(signerApi))...)
+	r.POST("/bot/claims", bots.NewHandler(&bots.Config{ProofMaxAge: conf.BotProofMaxAge}, repository).Claim)
+	if conf.StripeWebhookSecret != "" {
+		r.POST("/billing/stripe/webhook", billing.NewWebhook(repository, conf.StripeWebhookSecret, conf.CreditPackValidity).Handle)
+	}
+	operators, err := admin.ParseOperators(conf.AdminOperatorTokens)
+	if err != nil {
+		logger.S().Fatalf("load admin operator tokens error: %v", err)
+	}
+	if conf.AdminToken != "" || len(operators) > 0 {
+		admin.NewAdmin(repository, conf.AdminToken, operators, signerApi.ChainID, bus, keyRotation).Register(r)
+	}
+}
@@ -0,0 +1,137 @@
+// Package maintenance announces scheduled maintenance windows.
+package maintenance
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// Notice is the payload posted ahead of a maintenance window.
+type Notice struct {
+	Event  string `json:"event"`
+	ID     uint   `json:"id"`
+	Start  int64  `json:"start"`
+	End    int64  `json:"end"`
+	Reason string `json:"reason"`
+}
+
+type Config struct {
+	// Notice is how long before a window starts it is announced.
+	Notice time.Duration
+	// Webhook receives every notice, empty only notifies the projects.
+	Webhook  string
+	Interval time.Duration
+}
+
+// Notifier posts a notice of each maintenance window to Webhook and to the
+// alert webhook of every project once, Notice before the window starts.
+type Notifier struct {
+	conf   *Config
+	rep    db.Repository
+	client *http.Client
+}
+
+func NewNotifier(conf *Config, rep db.Repository) *Notifier {
+	if conf.Interval == 0 {
+		conf.Interval = time.Minute
+	}
+	return &Notifier{
+		conf:   conf,
+		rep:    rep,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Run sends the due notices every interval until ctx is cancelled.
+func (n *Notifier) Run(ctx context.Context) {
+	for {
+		if err := n.check(ctx, time.Now()); err != nil {
+			logger.S().Errorf("maintenance notifier error: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(n.conf.Interval):
+		}
+	}
+}
+
+func (n *Notifier) check(ctx context.Context, now time.Time) error {
+	windows, err := models.UpcomingMaintenance(n.rep, now, now.Add(n.conf.Notice))
+	if err != nil {
+		return err
+	}
+	for _, window := range windows {
+		if window.NotifiedAt != nil {
+			continue
+		}
+		claimed, err := models.ClaimMaintenanceNotice(n.rep, window.ID, now)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+		notice := &Notice{
+			Event:  "maintenance",
+			ID:     window.ID,
+			Start:  window.Start.Unix(),
+			End:    window.End.Unix(),
+			Reason: window.Reason,
+		}
+		logger.S().Infof("Maintenance window %d from %s to %s announced", window.ID, window.Start, window.End)
+		if err := n.notifyAll(ctx, notice); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyAll posts notice once to every webhook, failures are only logged as
+// the notice is not sent again.
+func (n *Notifier) notifyAll(ctx context.Context, notice *Notice) error {
+	if n.conf.Webhook != "" {
+		if err := n.post(ctx, n.conf.Webhook, notice); err != nil {
+			logger.S().Warnf("maintenance webhook error: %v", err)
+		}
+	}
+	users, err := (&models.User{}).FindWithAlertWebhook(n.rep)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if err := n.post(ctx, user.AlertWebhook, notice); err != nil {
+			logger.S().Warnf("maintenance notice for project %d error: %v", user.ID, err)
+		}
+	}
+	return nil
+}
+
+func (n *Notifier) post(ctx context.Context, url string, notice *Notice) error {
+	body, err := json.Marshal(notice)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	chainCallsInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "paymaster_chain_calls_in_flight",
+		Help: "Calls being served by the rpc node by chain.",
+	}, []string{"chain"})
+	chainCallsQueued = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "paymaster_chain_calls_queued",
+		Help: "Calls waiting for a slot of the rpc node concurrency limit by chain.",
+	}, []string{"chain"})
+	chainCallWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "paymaster_chain_call_wait_seconds",
+		Help:    "Time calls waited for a slot of the rpc node concurrency limit by chain.",
+		Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"chain"})
+	chainCallsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "paymaster_chain_calls_rejected_total",
+		Help: "Calls refused by chain and reason, queue_full or timeout.",
+	}, []string{"chain", "reason"})
+)
+
+// Reasons a chain call is refused.
+const (
+	ChainQueueFull    = "queue_full"
+	ChainQueueTimeout = "timeout"
+)
+
+// ChainCalls are the queue metrics of the calls to the rpc node of a chain.
+type ChainCalls struct {
+	InFlight prometheus.Gauge
+	Queued   prometheus.Gauge
+	wait     prometheus.Observer
+	chain    string
+}
+
+func NewChainCalls(chainID *big.Int) *ChainCalls {
+	chain := chainID.String()
+	return &ChainCalls{
+		InFlight: chainCallsInFlight.WithLabelValues(chain),
+		Queued:   chainCallsQueued.WithLabelValues(chain),
+		wait:     chainCallWait.WithLabelValues(chain),
+		chain:    chain,
+	}
+}
+
+// ObserveWait records the time a call waited for its slot.
+func (c *ChainCalls) ObserveWait(elapsed time.Duration) {
+	c.wait.Observe(elapsed.Seconds())
+}
+
+// Reject counts a call refused for reason.
+func (c *ChainCalls) Reject(reason string) {
+	chainCallsRejected.WithLabelValues(c.chain, reason).Inc()
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "paymaster_db_query_seconds",
+		Help:    "Latency of database statements by database, table and operation.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"database", "table", "operation"})
+	slowQueries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "paymaster_db_slow_queries_total",
+		Help: "Database statements slower than the slow query threshold by database, table and operation.",
+	}, []string{"database", "table", "operation"})
+	seqScans = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "paymaster_db_seq_scans_total",
+		Help: "Slow queries planned as a sequential scan of a large table, a likely missing index, by database and table.",
+	}, []string{"database", "table"})
+)
+
+// ObserveQuery records the latency of a statement on table, counting it as
+// slow when it took longer than the threshold.
+func ObserveQuery(database, table, operation string, elapsed time.Duration, slow bool) {
+	queryDuration.WithLabelValues(database, table, operation).Observe(elapsed.Seconds())
+	if slow {
+		slowQueries.WithLabelValues(database, table, operation).Inc()
+	}
+}
+
+// ObserveSeqScan counts a slow query scanning table sequentially.
+func ObserveSeqScan(database, table string) {
+	seqScans.WithLabelValues(database, table).Inc()
+}
@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Phases of an rpc request timed on their own.
+const (
+	PhaseKeyLookup  = "key_lookup"
+	PhaseDB         = "db"
+	PhaseSimulation = "simulation"
+	PhaseSigning    = "signing"
+)
+
+// latencyBuckets span cached reads to slow simulations, in seconds.
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+var (
+	rpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "paymaster_rpc_duration_seconds",
+		Help:    "Latency of rpc requests by method.",
+		Buckets: latencyBuckets,
+	}, []string{"method"})
+	rpcPhaseDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "paymaster_rpc_phase_seconds",
+		Help:    "Time rpc requests spent in api key lookup, database, simulation and signing by method.",
+		Buckets: latencyBuckets,
+	}, []string{"method", "phase"})
+)
+
+// Phases adds up the time a request spends in each phase. It is safe for
+// concurrent use.
+type Phases struct {
+	mu        sync.Mutex
+	durations map[string]time.Duration
+}
+
+type phasesCtxKey struct{}
+
+func NewPhases() *Phases {
+	return &Phases{durations: make(map[string]time.Duration)}
+}
+
+// Add counts elapsed in phase.
+func (p *Phases) Add(phase string, elapsed time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.durations[phase] += elapsed
+}
+
+// WithPhases returns a copy of ctx whose phases Time adds to p.
+func WithPhases(ctx context.Context, p *Phases) context.Context {
+	return context.WithValue(ctx, phasesCtxKey{}, p)
+}
+
+// Time starts timing phase for the request of ctx and returns the function
+// stopping it. Nothing is timed when ctx has no Phases.
+func Time(ctx context.Context, phase string) func() {
+	p, _ := ctx.Value(phasesCtxKey{}).(*Phases)
+	if p == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() { p.Add(phase, time.Since(start)) }
+}
+
+// ObserveRPC records the latency of a request to method and of the phases it
+// went through, and counts it against the latency SLO when one is enabled.
+func ObserveRPC(method string, elapsed time.Duration, p *Phases) {
+	rpcDuration.WithLabelValues(method).Observe(elapsed.Seconds())
+	p.mu.Lock()
+	for phase, d := range p.durations {
+		rpcPhaseDuration.WithLabelValues(method, phase).Observe(d.Seconds())
+	}
+	p.mu.Unlock()
+	if slo := activeSLO(); slo != nil {
+		slo.record(method, elapsed, time.Now())
+	}
+}
@@ -0,0 +1,90 @@
+// Package metrics exports sponsorship counters in the prometheus format.
+package metrics
+
+import (
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Sponsorship outcomes.
+const (
+	OutcomeSigned   = "signed"
+	OutcomeRejected = "rejected"
+	OutcomeIncluded = "included"
+	OutcomeReverted = "reverted"
+)
+
+var labels = []string{"chain", "api_key", "outcome"}
+
+var (
+	sponsorships = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "paymaster_sponsorships_total",
+		Help: "Sponsorship requests by chain, api key id and outcome.",
+	}, labels)
+	sponsoredGas = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "paymaster_sponsored_gas_wei_total",
+		Help: "Gas cost in wei by chain, api key id and outcome, the maximum cost when signed and the actual cost once settled.",
+	}, labels)
+)
+
+// Observe counts a sponsorship of apiKeyID, 0 when the request had no key.
+// gas may be nil for rejections.
+func Observe(chainID *big.Int, apiKeyID uint, outcome string, gas *big.Int) {
+	values := []string{chainID.String(), strconv.FormatUint(uint64(apiKeyID), 10), outcome}
+	sponsorships.WithLabelValues(values...).Inc()
+	if gas != nil {
+		wei, _ := new(big.Float).SetInt(gas).Float64()
+		sponsoredGas.WithLabelValues(values...).Add(wei)
+	}
+}
+
+var rejections = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "paymaster_rejections_total",
+	Help: "Rejected sponsorship requests by chain, api key id and error slug.",
+}, []string{"chain", "api_key", "reason"})
+
+// ObserveRejection counts a rejected sponsorship of apiKeyID by the slug of
+// its error, on top of the rejected outcome counted by Observe.
+func ObserveRejection(chainID *big.Int, apiKeyID uint, reason string) {
+	rejections.WithLabelValues(chainID.String(), strconv.FormatUint(uint64(apiKeyID), 10), reason).Inc()
+}
+
+// Screening lookup results.
+const (
+	ScreeningHit      = "hit"
+	ScreeningMiss     = "miss"
+	ScreeningOverride = "override"
+	ScreeningError    = "error"
+)
+
+var (
+	screeningLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "paymaster_screening_lookups_total",
+		Help: "Compliance screening lookups by result: cache hit, miss, operator override or provider error.",
+	}, []string{"result"})
+	screeningLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "paymaster_screening_provider_seconds",
+		Help:    "Latency of compliance screening provider calls.",
+		Buckets: prometheus.DefBuckets,
+	})
+)
+
+// ObserveScreening counts a screening lookup, elapsed is the provider call
+// latency of misses and errors.
+func ObserveScreening(result string, elapsed time.Duration) {
+	screeningLookups.WithLabelValues(result).Inc()
+	if result == ScreeningMiss || result == ScreeningError {
+		screeningLatency.Observe(elapsed.Seconds())
+	}
+}
+
+// Handler serves the registered metrics.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
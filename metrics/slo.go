@@ -0,0 +1,277 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/ququzone/verifying-paymaster-service/logger"
+)
+
+// BurnAlert fires when the error budget of the SLO is spent Rate times
+// faster than sustainable over both the Long and the Short window, the short
+// one making it resolve soon after a regression ends.
+type BurnAlert struct {
+	Long  time.Duration
+	Short time.Duration
+	Rate  float64
+}
+
+// ParseBurnAlerts parses a comma separated list of long/short:rate alerts,
+// e.g. 1h/5m:14.4,6h/30m:6.
+func ParseBurnAlerts(value string) ([]BurnAlert, error) {
+	var alerts []BurnAlert
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		windows, rate, ok := strings.Cut(item, ":")
+		long, short, ok2 := strings.Cut(windows, "/")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("invalid burn rate alert %q, expected long/short:rate", item)
+		}
+		var alert BurnAlert
+		var err error
+		if alert.Long, err = time.ParseDuration(long); err != nil {
+			return nil, fmt.Errorf("invalid burn rate alert %q: %v", item, err)
+		}
+		if alert.Short, err = time.ParseDuration(short); err != nil {
+			return nil, fmt.Errorf("invalid burn rate alert %q: %v", item, err)
+		}
+		if alert.Rate, err = strconv.ParseFloat(rate, 64); err != nil {
+			return nil, fmt.Errorf("invalid burn rate alert %q: %v", item, err)
+		}
+		if alert.Short < time.Minute || alert.Long < alert.Short || alert.Rate <= 0 {
+			return nil, fmt.Errorf("invalid burn rate alert %q", item)
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts, nil
+}
+
+// SLOConfig is a latency objective: Objective of the requests to Methods,
+// every method when empty, answer within Latency.
+type SLOConfig struct {
+	Latency   time.Duration
+	Objective float64
+	Methods   []string
+	Alerts    []BurnAlert
+	// Webhook receives the alerts when they fire and resolve, empty only
+	// logs them.
+	Webhook  string
+	Interval time.Duration
+}
+
+// SLOAlert is the payload posted to the SLO webhook.
+type SLOAlert struct {
+	Method string `json:"method"`
+	// Window names the alert, long/short.
+	Window    string  `json:"window"`
+	Threshold float64 `json:"threshold"`
+	// BurnRate is the burn rate over the long window.
+	BurnRate  float64 `json:"burnRate"`
+	Latency   string  `json:"latency"`
+	Objective float64 `json:"objective"`
+	Firing    bool    `json:"firing"`
+}
+
+var sloBurnRate = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "paymaster_slo_burn_rate",
+	Help: "Error budget burn rate of the latency SLO by method and window, 1 spends the budget exactly.",
+}, []string{"method", "window"})
+
+var slo atomic.Value
+
+func activeSLO() *SLO {
+	s, _ := slo.Load().(*SLO)
+	return s
+}
+
+// minuteCounts are the requests of a method in a minute and how many of them
+// missed the latency target.
+type minuteCounts struct {
+	minute int64
+	total  int64
+	slow   int64
+}
+
+// SLO counts the requests of every method per minute against the latency
+// target and alerts when the error budget burns too fast. Counts are those
+// of this replica.
+type SLO struct {
+	conf    *SLOConfig
+	client  *http.Client
+	methods map[string]bool
+	// minutes is the length of the longest alert window
+	minutes int64
+
+	mu     sync.Mutex
+	counts map[string][]minuteCounts
+	firing map[string]bool
+}
+
+// EnableSLO starts counting the requests observed by ObserveRPC against
+// conf.
+func EnableSLO(conf *SLOConfig) *SLO {
+	if conf.Interval == 0 {
+		conf.Interval = time.Minute
+	}
+	s := &SLO{
+		conf:    conf,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		methods: make(map[string]bool, len(conf.Methods)),
+		counts:  make(map[string][]minuteCounts),
+		firing:  make(map[string]bool),
+	}
+	for _, m := range conf.Methods {
+		s.methods[m] = true
+	}
+	for _, alert := range conf.Alerts {
+		if minutes := int64(alert.Long / time.Minute); minutes > s.minutes {
+			s.minutes = minutes
+		}
+	}
+	slo.Store(s)
+	return s
+}
+
+func (s *SLO) record(method string, elapsed time.Duration, now time.Time) {
+	if len(s.methods) > 0 && !s.methods[method] {
+		return
+	}
+	minute := now.Unix() / 60
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ring := s.counts[method]
+	if ring == nil {
+		ring = make([]minuteCounts, s.minutes+1)
+		s.counts[method] = ring
+	}
+	c := &ring[minute%int64(len(ring))]
+	if c.minute != minute {
+		*c = minuteCounts{minute: minute}
+	}
+	c.total++
+	if elapsed > s.conf.Latency {
+		c.slow++
+	}
+}
+
+// burnRate is the share of slow requests to method in the window ending at
+// now over the error budget, 0 without requests.
+func (s *SLO) burnRate(method string, window time.Duration, now time.Time) float64 {
+	last := now.Unix() / 60
+	first := last - int64(window/time.Minute) + 1
+	var total, slow int64
+	for _, c := range s.counts[method] {
+		if c.minute >= first && c.minute <= last {
+			total += c.total
+			slow += c.slow
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(slow) / float64(total) / (1 - s.conf.Objective)
+}
+
+// Run evaluates the alerts every interval until ctx is cancelled.
+func (s *SLO) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.conf.Interval):
+		}
+		for _, alert := range s.check(time.Now()) {
+			if err := s.notify(ctx, alert); err != nil {
+				logger.S().Warnf("SLO alert webhook error: %v", err)
+			}
+		}
+	}
+}
+
+// check updates the burn rate gauges and returns the alerts that started
+// or stopped firing.
+func (s *SLO) check(now time.Time) []*SLOAlert {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var changed []*SLOAlert
+	for method := range s.counts {
+		for _, alert := range s.conf.Alerts {
+			window := shortDuration(alert.Long) + "/" + shortDuration(alert.Short)
+			long, short := s.burnRate(method, alert.Long, now), s.burnRate(method, alert.Short, now)
+			sloBurnRate.WithLabelValues(method, shortDuration(alert.Long)).Set(long)
+			sloBurnRate.WithLabelValues(method, shortDuration(alert.Short)).Set(short)
+
+			firing := long >= alert.Rate && short >= alert.Rate
+			key := method + " " + window
+			if firing == s.firing[key] {
+				continue
+			}
+			s.firing[key] = firing
+			changed = append(changed, &SLOAlert{
+				Method:    method,
+				Window:    window,
+				Threshold: alert.Rate,
+				BurnRate:  long,
+				Latency:   s.conf.Latency.String(),
+				Objective: s.conf.Objective,
+				Firing:    firing,
+			})
+		}
+	}
+	return changed
+}
+
+func (s *SLO) notify(ctx context.Context, alert *SLOAlert) error {
+	if alert.Firing {
+		logger.S().Warnf("Latency SLO of %s burning %.1fx over %s (threshold %.1fx): more than %.2f%% of requests slower than %s", alert.Method, alert.BurnRate, alert.Window, alert.Threshold, (1-alert.Objective)*100, alert.Latency)
+	} else {
+		logger.S().Infof("Latency SLO burn of %s over %s back under %.1fx", alert.Method, alert.Window, alert.Threshold)
+	}
+	if s.conf.Webhook == "" {
+		return nil
+	}
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.conf.Webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// shortDuration formats d without zero units, e.g. 1h instead of 1h0m0s.
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
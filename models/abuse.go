@@ -0,0 +1,134 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// Abuse flag review states.
+const (
+	AbuseOpen      = "open"
+	AbuseDismissed = "dismissed"
+	AbuseConfirmed = "confirmed"
+)
+
+// AbuseFlag is a sender or api key an abuse rule matched, open until an
+// operator reviews it. A subject has one open flag per rule.
+type AbuseFlag struct {
+	gorm.Model
+	Rule string `gorm:"uniqueIndex:idx_abuse_open,where:status = 'open';type:varchar(32)" json:"rule"`
+	// Kind is SubjectSender or SubjectApiKey.
+	Kind    string `gorm:"uniqueIndex:idx_abuse_open,where:status = 'open';type:varchar(16)" json:"kind"`
+	Subject string `gorm:"uniqueIndex:idx_abuse_open,where:status = 'open';type:varchar(42)" json:"subject"`
+	Detail  string `gorm:"type:text" json:"detail"`
+	Status  string `gorm:"index;type:varchar(16)" json:"status"`
+	// Paused is set when the subject was disabled with the flag.
+	Paused bool `json:"paused"`
+}
+
+// RaiseAbuseFlag stores an open flag and reports whether it is new, a
+// subject already flagged by the rule is ignored.
+func RaiseAbuseFlag(rep db.Repository, flag *AbuseFlag) (bool, error) {
+	flag.Status = AbuseOpen
+	res := rep.Model(&AbuseFlag{}).Clauses(clause.OnConflict{DoNothing: true}).Create(flag)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// FindAbuseFlags returns the latest flags with status, all when empty.
+func FindAbuseFlags(rep db.Repository, status string, limit int) ([]AbuseFlag, error) {
+	var flags []AbuseFlag
+	query := rep.Model(&AbuseFlag{})
+	if status != "" {
+		query = query.Where(`"status" = ?`, status)
+	}
+	err := query.Order("id DESC").Limit(limit).Find(&flags).Error
+	return flags, err
+}
+
+// IPSenders counts the distinct senders an api key sponsored from a client
+// IP.
+type IPSenders struct {
+	ClientIP string
+	ApiKeyID uint
+	Senders  int64
+}
+
+// FindIPSenders returns the client IPs with more than min senders since
+// from.
+func FindIPSenders(rep db.Repository, from time.Time, min int64) ([]IPSenders, error) {
+	var stats []IPSenders
+	err := rep.Model(&Sponsorship{}).
+		Select(`"client_ip", "api_key_id", COUNT(DISTINCT "sender") AS "senders"`).
+		Where(`"created_at" >= ? AND "client_ip" <> ''`, from).
+		Group("client_ip, api_key_id").
+		Having(`COUNT(DISTINCT "sender") > ?`, min).
+		Scan(&stats).Error
+	return stats, err
+}
+
+// CallDataSenders are the senders that sponsored the same callData.
+type CallDataSenders struct {
+	CallDataHash string
+	Senders      int64
+}
+
+// FindCallDataSenders returns the callData hashes sponsored for more than
+// min senders since from.
+func FindCallDataSenders(rep db.Repository, from time.Time, min int64) ([]CallDataSenders, error) {
+	var stats []CallDataSenders
+	err := rep.Model(&Sponsorship{}).
+		Select(`"call_data_hash", COUNT(DISTINCT "sender") AS "senders"`).
+		Where(`"created_at" >= ? AND "call_data_hash" <> ''`, from).
+		Group("call_data_hash").
+		Having(`COUNT(DISTINCT "sender") > ?`, min).
+		Scan(&stats).Error
+	return stats, err
+}
+
+// SendersOfCallData returns up to limit senders of a callData hash since
+// from.
+func SendersOfCallData(rep db.Repository, hash string, from time.Time, limit int) ([]string, error) {
+	var senders []string
+	err := rep.Model(&Sponsorship{}).
+		Distinct("sender").
+		Where(`"created_at" >= ? AND "call_data_hash" = ?`, from, hash).
+		Limit(limit).
+		Pluck("sender", &senders).Error
+	return senders, err
+}
+
+// QuotaDrain is the quota an account spent within a window after its last
+// quota claim.
+type QuotaDrain struct {
+	Address   string
+	RemainGas string
+	Spent     string
+	Ops       int64
+}
+
+// FindQuotaDrains returns the accounts that claimed quota since from with
+// what they spent from them within window of the claim.
+func FindQuotaDrains(rep db.Repository, from time.Time, window time.Duration) ([]QuotaDrain, error) {
+	var drains []QuotaDrain
+	err := rep.Model(&Account{}).
+		Select(`"accounts"."address", "accounts"."remain_gas",
+			SUM(COALESCE(NULLIF("sponsorships"."quota_cost", ''), "sponsorships"."max_gas_cost")::numeric)::text AS "spent",
+			COUNT(*) AS "ops"`).
+		Joins(`JOIN "sponsorships" ON "sponsorships"."sender" = "accounts"."address"
+			AND "sponsorships"."quota_paymaster" = "accounts"."paymaster"
+			AND "sponsorships"."created_at" >= "accounts"."last_request"
+			AND "sponsorships"."created_at" < "accounts"."last_request" + CAST(? AS interval)`,
+			fmt.Sprintf("%d seconds", int64(window.Seconds()))).
+		Where(`"accounts"."last_request" >= ?`, from).
+		Group(`"accounts"."id", "accounts"."address", "accounts"."remain_gas"`).
+		Scan(&drains).Error
+	return drains, err
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// Account list sort keys.
+const (
+	AccountSortID          = "id"
+	AccountSortLastRequest = "lastRequest"
+	AccountSortUsedGas     = "usedGas"
+)
+
+var accountSortColumns = map[string]string{
+	AccountSortID:          `"id"`,
+	AccountSortLastRequest: `"last_request"`,
+	AccountSortUsedGas:     `CAST(COALESCE(NULLIF("used_gas", ''), '0') AS NUMERIC)`,
+}
+
+// AccountFilter restricts an account listing, zero fields match everything.
+type AccountFilter struct {
+	Enabled *bool
+	Vip     *bool
+	// MinUsedGas is the least gas in wei an account has used.
+	MinUsedGas      string
+	LastRequestFrom time.Time
+	LastRequestTo   time.Time
+	// Paymaster restricts the listing to the accounts of a dedicated
+	// paymaster, or of the shared one when empty.
+	Paymaster *string
+}
+
+// AccountPage positions a listing after the account with the given sort
+// value and id. After is empty for the first page.
+type AccountPage struct {
+	Sort  string
+	Desc  bool
+	After string
+	ID    uint
+	Limit int
+}
+
+// ValidAccountSort reports whether sort is a supported sort key.
+func ValidAccountSort(sort string) bool {
+	_, ok := accountSortColumns[sort]
+	return ok
+}
+
+// FindAccounts lists the accounts matching filter with keyset pagination on
+// (sort value, id).
+func FindAccounts(rep db.Repository, filter *AccountFilter, page *AccountPage) ([]Account, error) {
+	column := accountSortColumns[page.Sort]
+	query := rep.Model(&Account{})
+	if filter.Enabled != nil {
+		query = query.Where(`"enable" = ?`, *filter.Enabled)
+	}
+	if filter.Vip != nil {
+		if *filter.Vip {
+			query = query.Where(`"vip_id" <> -1`)
+		} else {
+			query = query.Where(`"vip_id" = -1`)
+		}
+	}
+	if filter.MinUsedGas != "" {
+		query = query.Where(accountSortColumns[AccountSortUsedGas]+` >= CAST(? AS NUMERIC)`, filter.MinUsedGas)
+	}
+	if !filter.LastRequestFrom.IsZero() {
+		query = query.Where(`"last_request" >= ?`, filter.LastRequestFrom)
+	}
+	if !filter.LastRequestTo.IsZero() {
+		query = query.Where(`"last_request" < ?`, filter.LastRequestTo)
+	}
+	if filter.Paymaster != nil {
+		query = query.Where(`"paymaster" = ?`, *filter.Paymaster)
+	}
+
+	op, order := ">", " ASC"
+	if page.Desc {
+		op, order = "<", " DESC"
+	}
+	if page.ID != 0 {
+		switch page.Sort {
+		case AccountSortID:
+			query = query.Where(`"id" `+op+` ?`, page.ID)
+		case AccountSortUsedGas:
+			query = query.Where(`(`+column+`, "id") `+op+` (CAST(? AS NUMERIC), ?)`, page.After, page.ID)
+		default:
+			query = query.Where(`(`+column+`, "id") `+op+` (?, ?)`, page.After, page.ID)
+		}
+	}
+	if page.Sort != AccountSortID {
+		query = query.Order(column + order)
+	}
+
+	var recs []Account
+	err := query.Order(`"id"` + order).Limit(page.Limit).Find(&recs).Error
+	return recs, err
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+var (
+	ErrAccountExists = errors.New("destination account exists")
+	ErrVipConflict   = errors.New("both accounts are linked to a vip nft")
+	ErrGasReserved   = errors.New("account has gas reserved by in-flight sponsorships")
+)
+
+// MigratedReason is the disabled reason of an account migrated to another
+// address.
+const MigratedReason = "migrated"
+
+// MigrateAccount moves the quota, vip linkage and history of the from account
+// to the to address and disables from. An existing destination fails with
+// ErrAccountExists unless merge is set, then quotas are added up. It returns
+// the source before the migration and the resulting destination.
+func MigrateAccount(rep db.Repository, from, to string, merge bool) (*Account, *Account, error) {
+	var source, dest *Account
+	err := rep.Transaction(func(tx db.Repository) error {
+		accounts := NewAccountRepository(tx)
+		// lock in address order so concurrent migrations cannot deadlock
+		first, second := from, to
+		if second < first {
+			first, second = second, first
+		}
+		locked := make(map[string]*Account, 2)
+		for _, address := range []string{first, second} {
+			account, err := accounts.FindForUpdate(address)
+			if err != nil {
+				return err
+			}
+			locked[address] = account
+		}
+		current := locked[from]
+		if current == nil {
+			return ErrAccountNotFound
+		}
+		if ParseGas(current.ReservedGas).Sign() != 0 {
+			return ErrGasReserved
+		}
+		copied := *current
+		source = &copied
+
+		dest = locked[to]
+		if dest != nil && !merge {
+			return ErrAccountExists
+		}
+		if dest == nil {
+			dest = &Account{
+				Address:     to,
+				Enable:      current.Enable,
+				VipID:       -1,
+				RemainGas:   "0",
+				UsedGas:     "0",
+				ReservedGas: "0",
+			}
+		}
+		if dest.VipID != -1 && current.VipID != -1 && dest.VipID != current.VipID {
+			return ErrVipConflict
+		}
+		if dest.VipID == -1 {
+			dest.VipID = current.VipID
+		}
+		dest.RemainGas = new(big.Int).Add(ParseGas(dest.RemainGas), ParseGas(current.RemainGas)).String()
+		dest.UsedGas = new(big.Int).Add(ParseGas(dest.UsedGas), ParseGas(current.UsedGas)).String()
+		if current.LastRequest.After(dest.LastRequest) {
+			dest.LastRequest = current.LastRequest
+		}
+		if err := accounts.Save(dest); err != nil {
+			return err
+		}
+
+		current.Enable = false
+		current.DisabledReason = MigratedReason
+		current.VipID = -1
+		current.RemainGas = "0"
+		current.UsedGas = "0"
+		if err := accounts.Save(current); err != nil {
+			return err
+		}
+
+		// pending sponsorships settle against the new address
+		if err := tx.Model(&Sponsorship{}).Where(`"sender" = ?`, from).Update("sender", to).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Session{}).Where(`"sender" = ?`, from).Update("sender", to).Error; err != nil {
+			return err
+		}
+		return tx.Model(&QuotaAllocation{}).Where(`"address" = ?`, from).Update("address", to).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return source, dest, nil
+}
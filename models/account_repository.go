@@ -0,0 +1,186 @@
+package models
+
+import (
+	"errors"
+	"math/big"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+var (
+	ErrAccountNotFound = errors.New("account not found")
+	ErrInsufficientGas = errors.New("insufficient gas")
+)
+
+// AccountRepository defines the account storage operations used by the service.
+type AccountRepository interface {
+	FindByAddress(address string) (*Account, error)
+	FindByVipID(id int64) (*Account, error)
+	// FindForUpdate loads an account and locks its row until the transaction ends.
+	FindForUpdate(address string) (*Account, error)
+	Save(account *Account) error
+	// ReserveGas moves amount from RemainGas to ReservedGas, RemainGas can
+	// go down to -overdraft.
+	ReserveGas(address string, amount *big.Int, overdraft *big.Int) (*Account, error)
+	// CommitGas moves a reserved amount to UsedGas.
+	CommitGas(address string, amount *big.Int) error
+	// ReleaseGas returns a reserved amount to RemainGas.
+	ReleaseGas(address string, amount *big.Int) error
+	// SettleGas refunds the difference between a charged and the actual gas
+	// cost from UsedGas to RemainGas.
+	SettleGas(address string, charged *big.Int, actual *big.Int) error
+	// UnsettleGas reverts a previous SettleGas.
+	UnsettleGas(address string, charged *big.Int, actual *big.Int) error
+	Transaction(fc func(tx AccountRepository) error) error
+	// ForPaymaster returns the repository of the accounts charged by the
+	// dedicated paymaster contract, the shared ones for an empty contract.
+	ForPaymaster(paymaster string) AccountRepository
+}
+
+// accountRepository keeps the accounts of one paymaster.
+type accountRepository struct {
+	rep       db.Repository
+	paymaster string
+}
+
+func NewAccountRepository(rep db.Repository) AccountRepository {
+	return &accountRepository{rep: rep}
+}
+
+// ParseGas parses a decimal gas amount column, treating empty values as zero.
+func ParseGas(value string) *big.Int {
+	n, ok := new(big.Int).SetString(value, 10)
+	if !ok {
+		return new(big.Int)
+	}
+	return n
+}
+
+func (r *accountRepository) FindByAddress(address string) (*Account, error) {
+	var rec Account
+	err := r.rep.Model(&Account{}).First(&rec, `"address" = ? AND "paymaster" = ?`, address, r.paymaster).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (r *accountRepository) FindByVipID(id int64) (*Account, error) {
+	var rec Account
+	err := r.rep.Model(&Account{}).Where(`"vip_id" = ? AND "paymaster" = ?`, id, r.paymaster).Order("last_request desc").First(&rec).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (r *accountRepository) FindForUpdate(address string) (*Account, error) {
+	var rec Account
+	err := r.rep.Model(&Account{}).Clauses(clause.Locking{Strength: "UPDATE"}).First(&rec, `"address" = ? AND "paymaster" = ?`, address, r.paymaster).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (r *accountRepository) Save(account *Account) error {
+	account.Paymaster = r.paymaster
+	return r.rep.Save(account).Error
+}
+
+func (r *accountRepository) update(address string, fc func(account *Account) error) (*Account, error) {
+	var result *Account
+	err := r.Transaction(func(tx AccountRepository) error {
+		account, err := tx.FindForUpdate(address)
+		if err != nil {
+			return err
+		}
+		if account == nil {
+			return ErrAccountNotFound
+		}
+		if err := fc(account); err != nil {
+			return err
+		}
+		result = account
+		return tx.Save(account)
+	})
+	return result, err
+}
+
+func (r *accountRepository) ReserveGas(address string, amount *big.Int, overdraft *big.Int) (*Account, error) {
+	return r.update(address, func(account *Account) error {
+		remain := ParseGas(account.RemainGas)
+		if amount.Cmp(new(big.Int).Add(remain, overdraft)) > 0 {
+			return ErrInsufficientGas
+		}
+		account.RemainGas = new(big.Int).Sub(remain, amount).String()
+		account.ReservedGas = new(big.Int).Add(ParseGas(account.ReservedGas), amount).String()
+		return nil
+	})
+}
+
+func (r *accountRepository) CommitGas(address string, amount *big.Int) error {
+	_, err := r.update(address, func(account *Account) error {
+		account.ReservedGas = new(big.Int).Sub(ParseGas(account.ReservedGas), amount).String()
+		account.UsedGas = new(big.Int).Add(ParseGas(account.UsedGas), amount).String()
+		return nil
+	})
+	return err
+}
+
+func (r *accountRepository) ReleaseGas(address string, amount *big.Int) error {
+	_, err := r.update(address, func(account *Account) error {
+		account.ReservedGas = new(big.Int).Sub(ParseGas(account.ReservedGas), amount).String()
+		account.RemainGas = new(big.Int).Add(ParseGas(account.RemainGas), amount).String()
+		return nil
+	})
+	return err
+}
+
+func (r *accountRepository) SettleGas(address string, charged *big.Int, actual *big.Int) error {
+	refund := new(big.Int).Sub(charged, actual)
+	if refund.Sign() <= 0 {
+		return nil
+	}
+	_, err := r.update(address, func(account *Account) error {
+		account.UsedGas = new(big.Int).Sub(ParseGas(account.UsedGas), refund).String()
+		account.RemainGas = new(big.Int).Add(ParseGas(account.RemainGas), refund).String()
+		return nil
+	})
+	return err
+}
+
+func (r *accountRepository) UnsettleGas(address string, charged *big.Int, actual *big.Int) error {
+	refund := new(big.Int).Sub(charged, actual)
+	if refund.Sign() <= 0 {
+		return nil
+	}
+	_, err := r.update(address, func(account *Account) error {
+		account.RemainGas = new(big.Int).Sub(ParseGas(account.RemainGas), refund).String()
+		account.UsedGas = new(big.Int).Add(ParseGas(account.UsedGas), refund).String()
+		return nil
+	})
+	return err
+}
+
+func (r *accountRepository) Transaction(fc func(tx AccountRepository) error) error {
+	return r.rep.Transaction(func(tx db.Repository) error {
+		return fc(&accountRepository{rep: tx, paymaster: r.paymaster})
+	})
+}
+
+func (r *accountRepository) ForPaymaster(paymaster string) AccountRepository {
+	return &accountRepository{rep: r.rep, paymaster: paymaster}
+}
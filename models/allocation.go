@@ -0,0 +1,146 @@
+package models
+
+import (
+	"errors"
+	"math/big"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+var ErrInsufficientBudget = errors.New("insufficient api key budget")
+
+// QuotaAllocation is gas quota an api key moved from its budget to an
+// account. What the account has not used by ExpiresAt returns to the key.
+type QuotaAllocation struct {
+	gorm.Model
+	ApiKeyID uint   `gorm:"index"`
+	Address  string `gorm:"index;type:varchar(42)"`
+	// Paymaster is the dedicated paymaster of the accounts credited, empty
+	// for the shared one.
+	Paymaster string `gorm:"type:varchar(42);default:''"`
+	Amount    string `gorm:"type:varchar(30)"`
+	ExpiresAt time.Time
+	// Reclaimed is the unused quota returned to the key, empty until the
+	// allocation expired.
+	Reclaimed string `gorm:"type:varchar(30);default:''"`
+}
+
+func findKeyForUpdate(tx db.Repository, id uint) (*ApiKeys, error) {
+	var key ApiKeys
+	err := tx.Model(&ApiKeys{}).Clauses(clause.Locking{Strength: "UPDATE"}).First(&key, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// AllocateQuota moves amount from the budget of the api key to the account
+// of each address for paymaster, creating missing accounts.
+func AllocateQuota(rep db.Repository, apiKeyID uint, paymaster string, addresses []string, amount *big.Int, expiresAt time.Time) (*ApiKeys, error) {
+	var result *ApiKeys
+	err := rep.Transaction(func(tx db.Repository) error {
+		key, err := findKeyForUpdate(tx, apiKeyID)
+		if err != nil {
+			return err
+		}
+		total := new(big.Int).Mul(amount, big.NewInt(int64(len(addresses))))
+		budget := ParseGas(key.Budget)
+		if total.Cmp(budget) > 0 {
+			return ErrInsufficientBudget
+		}
+		key.Budget = new(big.Int).Sub(budget, total).String()
+		if err := tx.Save(key).Error; err != nil {
+			return err
+		}
+
+		accounts := NewAccountRepository(tx).ForPaymaster(paymaster)
+		for _, address := range addresses {
+			account, err := accounts.FindForUpdate(address)
+			if err != nil {
+				return err
+			}
+			if account == nil {
+				account = &Account{
+					Address:     address,
+					Enable:      true,
+					VipID:       -1,
+					RemainGas:   "0",
+					UsedGas:     "0",
+					ReservedGas: "0",
+				}
+			}
+			account.RemainGas = new(big.Int).Add(ParseGas(account.RemainGas), amount).String()
+			if err := accounts.Save(account); err != nil {
+				return err
+			}
+			err = tx.Create(&QuotaAllocation{
+				ApiKeyID:  apiKeyID,
+				Address:   address,
+				Paymaster: paymaster,
+				Amount:    amount.String(),
+				ExpiresAt: expiresAt,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		result = key
+		return nil
+	})
+	return result, err
+}
+
+// ReclaimExpiredQuota returns the unused part of up to limit expired
+// allocations to their api keys and reports how many were processed.
+// Unused is what is left of the allocation in the account remain gas.
+func ReclaimExpiredQuota(rep db.Repository, now time.Time, limit int) (int, error) {
+	var allocations []QuotaAllocation
+	err := rep.Where(`"expires_at" <= ? AND "reclaimed" = ''`, now).Order("expires_at").Limit(limit).Find(&allocations).Error
+	if err != nil {
+		return 0, err
+	}
+	for n := range allocations {
+		allocation := &allocations[n]
+		err := rep.Transaction(func(tx db.Repository) error {
+			key, err := findKeyForUpdate(tx, allocation.ApiKeyID)
+			if err != nil {
+				return err
+			}
+			accounts := NewAccountRepository(tx).ForPaymaster(allocation.Paymaster)
+			account, err := accounts.FindForUpdate(allocation.Address)
+			if err != nil {
+				return err
+			}
+			unused := new(big.Int)
+			if account != nil {
+				remain := ParseGas(account.RemainGas)
+				unused.Set(ParseGas(allocation.Amount))
+				if remain.Cmp(unused) < 0 {
+					unused.Set(remain)
+				}
+				if unused.Sign() < 0 {
+					// overdrawn
+					unused.SetInt64(0)
+				}
+				account.RemainGas = new(big.Int).Sub(remain, unused).String()
+				if err := accounts.Save(account); err != nil {
+					return err
+				}
+			}
+			key.Budget = new(big.Int).Add(ParseGas(key.Budget), unused).String()
+			if err := tx.Save(key).Error; err != nil {
+				return err
+			}
+			allocation.Reclaimed = unused.String()
+			return tx.Save(allocation).Error
+		})
+		if err != nil {
+			return n, err
+		}
+	}
+	return len(allocations), nil
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"encoding/json"
+
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// AuditLog records an operator change with the state before and after it.
+type AuditLog struct {
+	gorm.Model
+	Operator string `gorm:"type:varchar(64)" json:"operator"`
+	Action   string `gorm:"index;type:varchar(32)" json:"action"`
+	Subject  string `gorm:"index;type:varchar(66)" json:"subject"`
+	Reason   string `gorm:"type:varchar(32)" json:"reason"`
+	Note     string `gorm:"type:text" json:"note"`
+	Before   string `gorm:"type:text" json:"before"`
+	After    string `gorm:"type:text" json:"after"`
+}
+
+// Audit stores an audit log entry, before and after are stored as JSON.
+func Audit(rep db.Repository, entry *AuditLog, before, after any) error {
+	b, err := json.Marshal(before)
+	if err != nil {
+		return err
+	}
+	a, err := json.Marshal(after)
+	if err != nil {
+		return err
+	}
+	entry.Before = string(b)
+	entry.After = string(a)
+	return rep.Create(entry).Error
+}
+
+// FindBySubject returns the latest entries of subject, all subjects when
+// empty.
+func (l *AuditLog) FindBySubject(rep db.Repository, subject string, limit int) ([]AuditLog, error) {
+	var recs []AuditLog
+	query := rep.Model(&AuditLog{})
+	if subject != "" {
+		query = query.Where(`"subject" = ?`, subject)
+	}
+	err := query.Order("id DESC").Limit(limit).Find(&recs).Error
+	return recs, err
+}
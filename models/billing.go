@@ -0,0 +1,85 @@
+package models
+
+import (
+	"math/big"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// BillingUsage is the overage of a project reported to Stripe in a month.
+type BillingUsage struct {
+	gorm.Model
+	UserID uint   `gorm:"uniqueIndex:idx_billing_usage"`
+	Month  string `gorm:"uniqueIndex:idx_billing_usage;type:varchar(7)"`
+	// Reported is the billed gas cost in wei already reported.
+	Reported string `gorm:"type:varchar(78)"`
+	// Covered is the gross billed gas cost in wei credits were already
+	// drawn for, whether they covered it or the free quota and overage did.
+	Covered string `gorm:"type:varchar(78)"`
+}
+
+// StripeEvent is a processed Stripe webhook event.
+type StripeEvent struct {
+	gorm.Model
+	EventID string `gorm:"unique;type:varchar(255)"`
+	Type    string `gorm:"type:varchar(64)"`
+}
+
+func (u *User) FindBillable(rep db.Repository) ([]User, error) {
+	var recs []User
+	err := rep.Model(&User{}).Where(`"billing_email" <> ''`).Find(&recs).Error
+	return recs, err
+}
+
+func (u *User) FindByStripeCustomer(rep db.Repository, customerID string) (*User, error) {
+	var rec User
+	err := rep.Model(&User{}).First(&rec, `"stripe_customer_id" = ?`, customerID).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// MonthlyBilled is MonthlySpend with the surcharge of each sponsorship.
+func (u *User) MonthlyBilled(rep db.Repository, now time.Time) (*big.Int, error) {
+	now = now.UTC()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	var billed string
+	err := rep.Model(&Sponsorship{}).
+		Select(`COALESCE(SUM(TRUNC(`+gasCostSQL+` * (100 + "surcharge_percent") / 100)), 0)::text`).
+		Where(`"api_key_id" IN (?) AND "created_at" >= ?`, rep.Model(&ApiKeys{}).Select("id").Where(`"user_id" = ?`, u.ID), start).
+		Where(notExpiredSQL, SponsorshipSigned, now).
+		Scan(&billed).Error
+	if err != nil {
+		return nil, err
+	}
+	return ParseGas(billed), nil
+}
+
+func (b *BillingUsage) FindByMonth(rep db.Repository, userID uint, month string) (*BillingUsage, error) {
+	var rec BillingUsage
+	err := rep.Model(&BillingUsage{}).First(&rec, `"user_id" = ? AND "month" = ?`, userID, month).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// Seen records eventID and reports whether it was processed before.
+func (e *StripeEvent) Seen(rep db.Repository, eventID, eventType string) (bool, error) {
+	res := rep.Model(&StripeEvent{}).Clauses(clause.OnConflict{DoNothing: true}).Create(&StripeEvent{EventID: eventID, Type: eventType})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 0, nil
+}
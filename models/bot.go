@@ -0,0 +1,140 @@
+package models
+
+import (
+	"errors"
+	"math/big"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// Community bot platforms.
+const (
+	BotDiscord  = "discord"
+	BotTelegram = "telegram"
+)
+
+var (
+	ErrCampaignClosed  = errors.New("campaign closed")
+	ErrCampaignBudget  = errors.New("campaign budget exhausted")
+	ErrAlreadyClaimed  = errors.New("already claimed")
+	ErrAccountDisabled = errors.New("account disabled")
+)
+
+// BotCampaign lets the members of a Discord guild or Telegram group claim
+// ClaimGas of quota each through the community bot, up to Budget in total.
+type BotCampaign struct {
+	gorm.Model
+	Name     string `gorm:"type:varchar(255)" json:"name"`
+	Platform string `gorm:"index:idx_bot_campaign_guild;type:varchar(16)" json:"platform"`
+	// GuildID is the Discord guild or Telegram chat the members claim in.
+	GuildID string `gorm:"index:idx_bot_campaign_guild;type:varchar(64)" json:"guildId"`
+	// TokenHash is the keccak hash of the token the bot authenticates with.
+	TokenHash string `gorm:"uniqueIndex;type:varchar(66)" json:"-"`
+	// VerifyKey checks the user proofs: the hex ed25519 public key of the
+	// Discord application or the Telegram bot token.
+	VerifyKey string     `gorm:"type:varchar(128)" json:"-"`
+	ClaimGas  string     `gorm:"type:varchar(30)" json:"claimGas"`
+	Budget    string     `gorm:"type:varchar(30)" json:"budget"`
+	SpentGas  string     `gorm:"type:varchar(30);default:'0'" json:"spentGas"`
+	EndsAt    *time.Time `json:"endsAt,omitempty"`
+	Ended     bool       `gorm:"default:false" json:"ended"`
+}
+
+// BotClaim is the quota a member claimed for an address in a campaign. A
+// member and an address claim once per campaign.
+type BotClaim struct {
+	gorm.Model
+	CampaignID uint   `gorm:"uniqueIndex:idx_bot_claim_user;uniqueIndex:idx_bot_claim_address"`
+	UserID     string `gorm:"uniqueIndex:idx_bot_claim_user;type:varchar(64)"`
+	Address    string `gorm:"uniqueIndex:idx_bot_claim_address;type:varchar(42)"`
+	Gas        string `gorm:"type:varchar(30)"`
+}
+
+// ValidBotPlatform reports whether platform is a supported bot platform.
+func ValidBotPlatform(platform string) bool {
+	return platform == BotDiscord || platform == BotTelegram
+}
+
+// FindBotCampaignByToken returns the campaign of the bot token hash, nil
+// when there is none.
+func FindBotCampaignByToken(rep db.Repository, tokenHash string) (*BotCampaign, error) {
+	var rec BotCampaign
+	err := rep.Model(&BotCampaign{}).First(&rec, `"token_hash" = ?`, tokenHash).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// Open reports whether members can still claim at now.
+func (c *BotCampaign) Open(now time.Time) bool {
+	return !c.Ended && (c.EndsAt == nil || now.Before(*c.EndsAt))
+}
+
+// ClaimBotQuota credits the claim gas of the campaign to address for the
+// member userID, creating a missing account, and charges it to the
+// campaign budget.
+func ClaimBotQuota(rep db.Repository, campaignID uint, userID, address string, now time.Time) (*BotClaim, error) {
+	var claim *BotClaim
+	err := rep.Transaction(func(tx db.Repository) error {
+		var campaign BotCampaign
+		err := tx.Model(&BotCampaign{}).Clauses(clause.Locking{Strength: "UPDATE"}).First(&campaign, campaignID).Error
+		if err != nil {
+			return err
+		}
+		if !campaign.Open(now) {
+			return ErrCampaignClosed
+		}
+		var claimed int64
+		err = tx.Model(&BotClaim{}).
+			Where(`"campaign_id" = ? AND ("user_id" = ? OR "address" = ?)`, campaignID, userID, address).
+			Count(&claimed).Error
+		if err != nil {
+			return err
+		}
+		if claimed > 0 {
+			return ErrAlreadyClaimed
+		}
+		gas := ParseGas(campaign.ClaimGas)
+		spent := new(big.Int).Add(ParseGas(campaign.SpentGas), gas)
+		if spent.Cmp(ParseGas(campaign.Budget)) > 0 {
+			return ErrCampaignBudget
+		}
+		if err := tx.Model(&campaign).Update("spent_gas", spent.String()).Error; err != nil {
+			return err
+		}
+
+		accounts := NewAccountRepository(tx)
+		account, err := accounts.FindForUpdate(address)
+		if err != nil {
+			return err
+		}
+		if account == nil {
+			account = &Account{
+				Address:     address,
+				Enable:      true,
+				VipID:       -1,
+				RemainGas:   "0",
+				UsedGas:     "0",
+				ReservedGas: "0",
+			}
+		}
+		if !account.Enable {
+			return ErrAccountDisabled
+		}
+		account.RemainGas = new(big.Int).Add(ParseGas(account.RemainGas), gas).String()
+		if err := accounts.Save(account); err != nil {
+			return err
+		}
+		claim = &BotClaim{CampaignID: campaignID, UserID: userID, Address: address, Gas: gas.String()}
+		return tx.Create(claim).Error
+	})
+	return claim, err
+}
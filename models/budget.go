@@ -0,0 +1,67 @@
+package models
+
+import (
+	"math/big"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// BudgetThresholds are the budget percentages that raise an alert.
+var BudgetThresholds = []int{50, 80, 100}
+
+// BudgetAlert records a budget threshold a project crossed in a month.
+type BudgetAlert struct {
+	gorm.Model
+	UserID    uint   `gorm:"uniqueIndex:idx_budget_alert"`
+	Month     string `gorm:"uniqueIndex:idx_budget_alert;type:varchar(7)"`
+	Threshold int    `gorm:"uniqueIndex:idx_budget_alert"`
+	Spend     string `gorm:"type:varchar(30)"`
+}
+
+// BudgetMonth returns the calendar month of t in UTC, e.g. 2023-06.
+func BudgetMonth(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+func (u *User) FindWithBudget(rep db.Repository) ([]User, error) {
+	var recs []User
+	err := rep.Model(&User{}).Where(`"monthly_budget" <> ''`).Find(&recs).Error
+	return recs, err
+}
+
+// MonthlySpend sums the gas cost of the sponsorships signed for the api keys
+// of the user since the start of the month of now. Settled operations count
+// with their actual cost, expired signatures are left out.
+func (u *User) MonthlySpend(rep db.Repository, now time.Time) (*big.Int, error) {
+	now = now.UTC()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	var spend string
+	err := rep.Model(&Sponsorship{}).
+		Select(sumGasCostSQL).
+		Where(`"api_key_id" IN (?) AND "created_at" >= ?`, rep.Model(&ApiKeys{}).Select("id").Where(`"user_id" = ?`, u.ID), start).
+		Where(notExpiredSQL, SponsorshipSigned, now).
+		Scan(&spend).Error
+	if err != nil {
+		return nil, err
+	}
+	return ParseGas(spend), nil
+}
+
+func (a *BudgetAlert) FindByMonth(rep db.Repository, userID uint, month string) ([]BudgetAlert, error) {
+	var recs []BudgetAlert
+	err := rep.Model(&BudgetAlert{}).Where(`"user_id" = ? AND "month" = ?`, userID, month).Find(&recs).Error
+	return recs, err
+}
+
+// BudgetExhausted reports whether the user crossed the full budget in the
+// month of now.
+func BudgetExhausted(rep db.Repository, userID uint, now time.Time) (bool, error) {
+	var count int64
+	err := rep.Model(&BudgetAlert{}).
+		Where(`"user_id" = ? AND "month" = ? AND "threshold" >= 100`, userID, BudgetMonth(now)).
+		Count(&count).Error
+	return count > 0, err
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// Checkpoint stores the last block processed by a named background worker.
+type Checkpoint struct {
+	gorm.Model
+	Name        string `gorm:"unique;type:varchar(64)"`
+	BlockNumber uint64
+}
+
+func (c *Checkpoint) FindByName(rep db.Repository, name string) (*Checkpoint, error) {
+	var rec Checkpoint
+	err := rep.Model(&Checkpoint{}).First(&rec, `"name" = ?`, name).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
@@ -0,0 +1,156 @@
+package models
+
+import (
+	"math/big"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+const (
+	CreditGrant   = "grant"
+	CreditConsume = "consume"
+
+	CreditSourceAdmin   = "admin"
+	CreditSourceStripe  = "stripe"
+	CreditSourceOnchain = "onchain"
+)
+
+// CreditEntry is a ledger entry of the prepaid credit of a project. A grant
+// entry is a credit pack usable until ExpiresAt, a consume entry draws
+// Amount from the pack PackID to cover the billed usage of Month.
+type CreditEntry struct {
+	gorm.Model
+	UserID uint   `gorm:"index"`
+	Kind   string `gorm:"type:varchar(16)"`
+	// Amount is gas cost in wei.
+	Amount string `gorm:"type:varchar(78)"`
+
+	// Source is how a pack was bought: admin, stripe or onchain.
+	Source string `gorm:"type:varchar(16);default:''"`
+	// Reference identifies the payment of a pack, it can be granted once.
+	Reference string `gorm:"uniqueIndex:idx_credit_reference,where:reference <> '';type:varchar(255);default:''"`
+	ExpiresAt time.Time
+
+	PackID uint   `gorm:"index"`
+	Month  string `gorm:"type:varchar(7);default:''"`
+}
+
+// CreditPack is a grant with its unused amount.
+type CreditPack struct {
+	*CreditEntry
+	Remaining *big.Int
+}
+
+// GrantCredits records the pack and reports whether it is new, a pack with
+// a known reference is ignored.
+func GrantCredits(rep db.Repository, pack *CreditEntry) (bool, error) {
+	pack.Kind = CreditGrant
+	res := rep.Model(&CreditEntry{}).Clauses(clause.OnConflict{DoNothing: true}).Create(pack)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// FindCreditPacks returns the unexpired packs of the project in the order
+// they are consumed, soonest expiry first.
+func FindCreditPacks(rep db.Repository, userID uint, now time.Time) ([]CreditPack, error) {
+	var grants []CreditEntry
+	err := rep.Model(&CreditEntry{}).
+		Where(`"user_id" = ? AND "kind" = ? AND "expires_at" > ?`, userID, CreditGrant, now).
+		Order("expires_at, id").Find(&grants).Error
+	if err != nil || len(grants) == 0 {
+		return nil, err
+	}
+	ids := make([]uint, len(grants))
+	for n := range grants {
+		ids[n] = grants[n].ID
+	}
+	var used []struct {
+		PackID uint
+		Amount string
+	}
+	err = rep.Model(&CreditEntry{}).
+		Select(`"pack_id", SUM("amount"::numeric)::text AS "amount"`).
+		Where(`"kind" = ? AND "pack_id" IN ?`, CreditConsume, ids).
+		Group("pack_id").Scan(&used).Error
+	if err != nil {
+		return nil, err
+	}
+	usedByPack := make(map[uint]*big.Int, len(used))
+	for _, u := range used {
+		usedByPack[u.PackID] = ParseGas(u.Amount)
+	}
+	packs := make([]CreditPack, 0, len(grants))
+	for n := range grants {
+		remaining := ParseGas(grants[n].Amount)
+		if u, ok := usedByPack[grants[n].ID]; ok {
+			remaining.Sub(remaining, u)
+		}
+		packs = append(packs, CreditPack{CreditEntry: &grants[n], Remaining: remaining})
+	}
+	return packs, nil
+}
+
+// MonthlyCredits returns the credit consumed for the billed usage of month.
+func MonthlyCredits(rep db.Repository, userID uint, month string) (*big.Int, error) {
+	var consumed string
+	err := rep.Model(&CreditEntry{}).
+		Select(`COALESCE(SUM("amount"::numeric), 0)::text`).
+		Where(`"user_id" = ? AND "kind" = ? AND "month" = ?`, userID, CreditConsume, month).
+		Scan(&consumed).Error
+	if err != nil {
+		return nil, err
+	}
+	return ParseGas(consumed), nil
+}
+
+// ConsumeCredits draws up to amount from the unexpired packs of the project
+// for the usage of month and returns the consumed credit.
+func ConsumeCredits(rep db.Repository, userID uint, month string, amount *big.Int, now time.Time) (*big.Int, error) {
+	consumed := new(big.Int)
+	err := rep.Transaction(func(tx db.Repository) error {
+		// serializes consumers of the project
+		var user User
+		err := tx.Model(&User{}).Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error
+		if err != nil {
+			return err
+		}
+		packs, err := FindCreditPacks(tx, userID, now)
+		if err != nil {
+			return err
+		}
+		for _, pack := range packs {
+			need := new(big.Int).Sub(amount, consumed)
+			if need.Sign() <= 0 {
+				break
+			}
+			if pack.Remaining.Sign() <= 0 {
+				continue
+			}
+			if pack.Remaining.Cmp(need) < 0 {
+				need = pack.Remaining
+			}
+			err := tx.Create(&CreditEntry{
+				UserID: userID,
+				Kind:   CreditConsume,
+				Amount: need.String(),
+				PackID: pack.ID,
+				Month:  month,
+			}).Error
+			if err != nil {
+				return err
+			}
+			consumed.Add(consumed, need)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return consumed, nil
+}
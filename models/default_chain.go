@@ -0,0 +1,44 @@
+package models
+
+import (
+	"fmt"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// defaultChainCheckpoint marks the rows of single chain deployments as
+// assigned to the default chain.
+const defaultChainCheckpoint = "default_chain"
+
+// chainTables are the tables whose rows are kept per chain.
+var chainTables = []string{"sponsorships", "indexed_blocks", "faucet_drips"}
+
+// AssignDefaultChain assigns the rows stored before the background jobs ran
+// per chain to chainID, the default chain, and renames the given
+// checkpoints to their name on that chain. It runs once.
+func AssignDefaultChain(rep db.Repository, chainID uint64, checkpoints ...string) error {
+	done, err := (&Checkpoint{}).FindByName(rep, defaultChainCheckpoint)
+	if err != nil || done != nil {
+		return err
+	}
+	return rep.Transaction(func(tx db.Repository) error {
+		for _, table := range chainTables {
+			err := tx.Exec(fmt.Sprintf(`UPDATE %q SET "chain_id" = ? WHERE "chain_id" = 0`, table), chainID).Error
+			if err != nil {
+				return err
+			}
+		}
+		for _, name := range checkpoints {
+			err := tx.Model(&Checkpoint{}).Where(`"name" = ?`, name).Update("name", ChainCheckpoint(name, chainID)).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Create(&Checkpoint{Name: defaultChainCheckpoint}).Error
+	})
+}
+
+// ChainCheckpoint returns the name of the checkpoint name on chainID.
+func ChainCheckpoint(name string, chainID uint64) string {
+	return fmt.Sprintf("%s:%d", name, chainID)
+}
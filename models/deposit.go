@@ -0,0 +1,71 @@
+package models
+
+import (
+	"math/big"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// DepositSnapshot is the EntryPoint deposit of a paymaster on a chain, the
+// shared one or a dedicated one, against what it may still be charged, as
+// last checked by the deposit monitor. Amounts are in wei.
+type DepositSnapshot struct {
+	gorm.Model
+	ChainID    uint64 `gorm:"uniqueIndex:idx_deposit_snapshots_chain_paymaster" json:"chainId"`
+	Paymaster  string `gorm:"type:varchar(42);uniqueIndex:idx_deposit_snapshots_chain_paymaster" json:"paymaster"`
+	EntryPoint string `gorm:"type:varchar(42)" json:"entryPoint"`
+	Deposit    string `gorm:"type:varchar(78)" json:"deposit"`
+	// Outstanding is the maximum gas cost of the sponsorships that can still
+	// be charged to the deposit, signed and unexpired or pending.
+	Outstanding string `gorm:"type:varchar(78)" json:"outstanding"`
+	// Buffer is the safety buffer kept on top of Outstanding.
+	Buffer string `gorm:"type:varchar(78)" json:"buffer"`
+	// Headroom is Deposit less Outstanding and Buffer, negative when the
+	// deposit runs short.
+	Headroom  string    `gorm:"type:varchar(78)" json:"headroom"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// OutstandingGasCost sums the maximum gas cost of the sponsorships of
+// paymaster on chainID that can still be charged at now. legacy also counts
+// sponsorships signed before chains and paymasters were recorded, which
+// belong to the default chain.
+func OutstandingGasCost(rep db.Repository, chainID uint64, paymaster string, legacy bool, now time.Time) (*big.Int, error) {
+	chains := []uint64{chainID}
+	paymasters := []string{paymaster}
+	if legacy {
+		chains = append(chains, 0)
+		paymasters = append(paymasters, "")
+	}
+	var cost string
+	err := rep.Model(&Sponsorship{}).
+		Select(`COALESCE(SUM(CAST("max_gas_cost" AS NUMERIC)), 0)::text`).
+		Where(`"chain_id" IN ? AND "paymaster" IN ?`, chains, paymasters).
+		Where(`("status" = ? OR ("status" = ? AND "valid_until" >= ?))`, SponsorshipPending, SponsorshipSigned, now).
+		Scan(&cost).Error
+	if err != nil {
+		return nil, err
+	}
+	return ParseGas(cost), nil
+}
+
+// SaveDepositSnapshot replaces the snapshot of the chain and paymaster of
+// snapshot.
+func SaveDepositSnapshot(rep db.Repository, snapshot *DepositSnapshot) error {
+	return rep.Model(&DepositSnapshot{}).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "chain_id"}, {Name: "paymaster"}},
+		DoUpdates: clause.AssignmentColumns([]string{"entry_point", "deposit", "outstanding", "buffer", "headroom", "checked_at", "updated_at"}),
+	}).Create(snapshot).Error
+}
+
+// DepositSnapshots returns the snapshots of every paymaster by chain id, in
+// the order they were first checked.
+func DepositSnapshots(rep db.Repository) ([]DepositSnapshot, error) {
+	var snapshots []DepositSnapshot
+	err := rep.Model(&DepositSnapshot{}).Order(`"chain_id", "id"`).Find(&snapshots).Error
+	return snapshots, err
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// ErrorTemplate replaces the message of the errors of a catalog slug for
+// clients asking for Locale, any client when Locale is empty. The message
+// may refer to the details of the error as {name}.
+type ErrorTemplate struct {
+	gorm.Model
+	Slug    string `gorm:"uniqueIndex:idx_error_template;type:varchar(32)" json:"slug"`
+	Locale  string `gorm:"uniqueIndex:idx_error_template;type:varchar(16)" json:"locale"`
+	Message string `gorm:"type:text" json:"message"`
+	// Operator is who last changed the template.
+	Operator string `gorm:"type:varchar(64)" json:"operator"`
+}
+
+// ErrorTemplates returns every template by slug and locale.
+func ErrorTemplates(rep db.Repository) ([]ErrorTemplate, error) {
+	var templates []ErrorTemplate
+	err := rep.Model(&ErrorTemplate{}).Order(`"slug", "locale"`).Find(&templates).Error
+	return templates, err
+}
+
+// SaveErrorTemplate replaces the template of the slug and locale of t.
+func SaveErrorTemplate(rep db.Repository, t *ErrorTemplate) error {
+	return rep.Model(&ErrorTemplate{}).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "slug"}, {Name: "locale"}},
+		DoUpdates: clause.AssignmentColumns([]string{"message", "operator", "updated_at"}),
+	}).Create(t).Error
+}
+
+// DeleteErrorTemplate removes the template of slug and locale, the catalog
+// message applies again.
+func DeleteErrorTemplate(rep db.Repository, slug, locale string) error {
+	return rep.Model(&ErrorTemplate{}).Unscoped().Where(`"slug" = ? AND "locale" = ?`, slug, locale).Delete(&ErrorTemplate{}).Error
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// ExportCursor stores the last sponsorship version shipped to a warehouse
+// sink, ordered by update time and id.
+type ExportCursor struct {
+	gorm.Model
+	Sink          string `gorm:"unique;type:varchar(32)"`
+	LastUpdatedAt time.Time
+	LastID        uint
+}
+
+func (c *ExportCursor) FindBySink(rep db.Repository, sink string) (*ExportCursor, error) {
+	var rec ExportCursor
+	err := rep.Model(&ExportCursor{}).First(&rec, `"sink" = ?`, sink).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// FindUpdatedAfter returns up to limit sponsorships created or updated after
+// the (updatedAt, id) cursor, oldest first. A non zero chainID filters the
+// sponsorships of that chain and a non empty sender those of that account.
+func (s *Sponsorship) FindUpdatedAfter(rep db.Repository, updatedAt time.Time, id uint, chainID uint64, sender string, limit int) ([]Sponsorship, error) {
+	var recs []Sponsorship
+	query := rep.Model(&Sponsorship{})
+	if chainID != 0 {
+		query = query.Where(`"chain_id" = ?`, chainID)
+	}
+	if sender != "" {
+		query = query.Where(`"sender" = ?`, sender)
+	}
+	err := query.
+		Where(`("updated_at", "id") > (?, ?)`, updatedAt, id).
+		Order("updated_at, id").
+		Limit(limit).
+		Find(&recs).Error
+	return recs, err
+}
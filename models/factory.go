@@ -0,0 +1,26 @@
+package models
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// Factory is an audited account factory. Its code and, when set, the code
+// of the account implementation it deploys must match the recorded hashes.
+type Factory struct {
+	gorm.Model
+	Name     string `gorm:"type:varchar(64)"`
+	Address  string `gorm:"uniqueIndex;type:varchar(42)"`
+	CodeHash string `gorm:"type:varchar(66)"`
+	// Implementation is the account implementation behind the factory
+	// proxies, empty when the factory deploys full accounts.
+	Implementation         string `gorm:"type:varchar(42)"`
+	ImplementationCodeHash string `gorm:"type:varchar(66)"`
+}
+
+func (f *Factory) FindAll(rep db.Repository) ([]Factory, error) {
+	var recs []Factory
+	err := rep.Model(&Factory{}).Find(&recs).Error
+	return recs, err
+}
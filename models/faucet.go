@@ -0,0 +1,54 @@
+package models
+
+import (
+	"math/big"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// Faucet drip statuses.
+const (
+	DripQueued = "queued"
+	DripSent   = "sent"
+	DripFailed = "failed"
+)
+
+// FaucetDrip is the native value sent, or queued to be sent, to a new
+// account by the testnet faucet of a chain. An address gets a single drip
+// per chain.
+type FaucetDrip struct {
+	gorm.Model
+	ChainID  uint64 `gorm:"uniqueIndex:idx_faucet_drips_chain_address,priority:1;default:0"`
+	Address  string `gorm:"uniqueIndex:idx_faucet_drips_chain_address,priority:2;type:varchar(42)"`
+	ClientIP string `gorm:"index;type:varchar(45);default:''"`
+	Amount   string `gorm:"type:varchar(78)"`
+	Status   string `gorm:"index;type:varchar(16)"`
+	TxHash   string `gorm:"type:varchar(66);default:''"`
+	Attempts int    `gorm:"default:0"`
+	Error    string `gorm:"type:text;default:''"`
+	SentAt   *time.Time
+}
+
+// FaucetDay returns the start of the UTC day of t, the period of the faucet
+// cap.
+func FaucetDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// DrippedSince sums the value of the drips queued or sent on chainID since
+// start.
+func DrippedSince(rep db.Repository, chainID uint64, start time.Time) (*big.Int, error) {
+	var sum string
+	err := rep.Model(&FaucetDrip{}).
+		Select(`COALESCE(SUM(CAST("amount" AS NUMERIC)), 0)::text`).
+		Where(`"chain_id" = ? AND "created_at" >= ? AND "status" <> ?`, chainID, start, DripFailed).
+		Scan(&sum).Error
+	if err != nil {
+		return nil, err
+	}
+	return ParseGas(sum), nil
+}
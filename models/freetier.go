@@ -0,0 +1,43 @@
+package models
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// KeyUsage is the usage of an api key in a UTC day.
+type KeyUsage struct {
+	Ops int64
+	// Gas is the gas cost in wei, settled operations count with their
+	// actual cost.
+	Gas *big.Int
+	// Last is when the latest operation was sponsored, zero without one.
+	Last time.Time
+}
+
+// DailyUsage returns the usage of the api key since the start of the UTC day
+// of now. Expired signatures are left out.
+func (k *ApiKeys) DailyUsage(rep db.Repository, now time.Time) (*KeyUsage, error) {
+	now = now.UTC()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	var row struct {
+		Ops  int64
+		Gas  string
+		Last *time.Time
+	}
+	err := rep.Model(&Sponsorship{}).
+		Select(`COUNT(*) AS "ops", `+sumGasCostSQL+` AS "gas", MAX("created_at") AS "last"`).
+		Where(`"api_key_id" = ? AND "created_at" >= ?`, k.ID, start).
+		Where(notExpiredSQL, SponsorshipSigned, now).
+		Scan(&row).Error
+	if err != nil {
+		return nil, err
+	}
+	usage := &KeyUsage{Ops: row.Ops, Gas: ParseGas(row.Gas)}
+	if row.Last != nil {
+		usage.Last = *row.Last
+	}
+	return usage, nil
+}
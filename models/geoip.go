@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// GeoBlock is a request refused by the GeoIP access policy, kept for
+// compliance reporting.
+type GeoBlock struct {
+	gorm.Model
+	ApiKeyID uint   `gorm:"index"`
+	IP       string `gorm:"type:varchar(45)"`
+	Country  string `gorm:"index;type:varchar(2)"`
+}
+
+// GeoBlockStat counts the refused requests of an api key from a country.
+type GeoBlockStat struct {
+	Country  string `json:"country"`
+	ApiKeyID uint   `json:"apiKeyId"`
+	Requests int64  `json:"requests"`
+}
+
+// GeoBlockStats counts the requests refused between from and to.
+func GeoBlockStats(rep db.Repository, from, to time.Time) ([]GeoBlockStat, error) {
+	var stats []GeoBlockStat
+	err := rep.Model(&GeoBlock{}).
+		Select(`"country", "api_key_id", COUNT(*) AS "requests"`).
+		Where(`"created_at" >= ? AND "created_at" < ?`, from, to).
+		Group("country, api_key_id").Order("requests DESC").
+		Scan(&stats).Error
+	return stats, err
+}
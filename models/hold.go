@@ -0,0 +1,98 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// Held operation states, HoldExpired is derived from ExpiresAt.
+const (
+	HoldPending  = "held"
+	HoldApproved = "approved"
+	HoldRejected = "rejected"
+	HoldSigned   = "signed"
+	HoldExpired  = "expired"
+)
+
+// HeldOperation is an operation the policy held for manual approval. It is
+// keyed by the hash of the operation as submitted, without paymasterAndData,
+// so the client can poll it and resubmit it once approved.
+type HeldOperation struct {
+	gorm.Model
+	HoldHash  string `gorm:"uniqueIndex;type:varchar(66)" json:"userOpHash"`
+	ChainID   uint64 `json:"chainId"`
+	ApiKeyID  uint   `gorm:"index" json:"apiKeyId"`
+	Sender    string `gorm:"index;type:varchar(42)" json:"sender"`
+	Nonce     string `gorm:"type:varchar(78)" json:"nonce"`
+	Operation string `gorm:"type:text" json:"operation"`
+	// MaxGasCost is the gas cost in wei when held.
+	MaxGasCost string `gorm:"type:varchar(78)" json:"maxGasCost"`
+	Reason     string `gorm:"type:varchar(255)" json:"reason"`
+	Status     string `gorm:"index;type:varchar(16)" json:"status"`
+	// Operator is the operator that decided the operation.
+	Operator string `gorm:"type:varchar(64);default:''" json:"operator"`
+	// RequiredApprovals distinct operators must approve the operation,
+	// Approvals did so far.
+	RequiredApprovals uint `gorm:"default:1" json:"requiredApprovals"`
+	Approvals         uint `gorm:"default:0" json:"approvals"`
+	// ExpiresAt ends the approval window, an approved operation must be
+	// resubmitted before it.
+	ExpiresAt time.Time `json:"expiresAt"`
+	// SponsorshipHash is the signed operation once resubmitted.
+	SponsorshipHash string `gorm:"type:varchar(66);default:''" json:"sponsorshipHash"`
+}
+
+// HoldApproval is the approval of a held operation by an operator, one per
+// operator.
+type HoldApproval struct {
+	gorm.Model
+	HoldID   uint   `gorm:"uniqueIndex:idx_hold_approval" json:"holdId"`
+	Operator string `gorm:"uniqueIndex:idx_hold_approval;type:varchar(64)" json:"operator"`
+}
+
+// HoldApprovals returns the approvals of the held operation in order.
+func HoldApprovals(rep db.Repository, holdID uint) ([]HoldApproval, error) {
+	var approvals []HoldApproval
+	err := rep.Model(&HoldApproval{}).Where(`"hold_id" = ?`, holdID).Order("id").Find(&approvals).Error
+	return approvals, err
+}
+
+// CurrentStatus returns Status, or HoldExpired once an undecided or unused
+// approval window ended.
+func (h *HeldOperation) CurrentStatus(now time.Time) string {
+	if (h.Status == HoldPending || h.Status == HoldApproved) && !now.Before(h.ExpiresAt) {
+		return HoldExpired
+	}
+	return h.Status
+}
+
+// FindHold returns the held operation with hash, nil when there is none.
+func FindHold(rep db.Repository, hash string) (*HeldOperation, error) {
+	var rec HeldOperation
+	err := rep.Model(&HeldOperation{}).First(&rec, `"hold_hash" = ?`, hash).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// FindHolds returns the latest held operations with status, all when empty.
+// Pending ones are only returned while they can be decided.
+func FindHolds(rep db.Repository, status string, now time.Time, limit int) ([]HeldOperation, error) {
+	var holds []HeldOperation
+	query := rep.Model(&HeldOperation{})
+	if status != "" {
+		query = query.Where(`"status" = ?`, status)
+	}
+	if status == HoldPending {
+		query = query.Where(`"expires_at" > ?`, now)
+	}
+	err := query.Order("id DESC").Limit(limit).Find(&holds).Error
+	return holds, err
+}
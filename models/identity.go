@@ -0,0 +1,39 @@
+package models
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// Identity is a web2 account, verified with its OAuth provider, linked to
+// an address. An identity is linked to a single address.
+type Identity struct {
+	gorm.Model
+	Provider string `gorm:"uniqueIndex:idx_identity;type:varchar(16)"`
+	// Subject is the stable user id at the provider.
+	Subject string `gorm:"uniqueIndex:idx_identity;type:varchar(255)"`
+	Email   string `gorm:"type:varchar(320)"`
+	Address string `gorm:"index;type:varchar(42)"`
+}
+
+// FindIdentity returns the identity subject of provider, nil when it was
+// never linked.
+func FindIdentity(rep db.Repository, provider, subject string) (*Identity, error) {
+	var rec Identity
+	err := rep.Model(&Identity{}).First(&rec, `"provider" = ? AND "subject" = ?`, provider, subject).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// HasIdentity reports whether an identity is linked to address.
+func HasIdentity(rep db.Repository, address string) (bool, error) {
+	var count int64
+	err := rep.Model(&Identity{}).Where(`"address" = ?`, address).Count(&count).Error
+	return count > 0, err
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// IndexedBlock is the hash of a block processed by the indexer of a chain,
+// kept for the reorg window to detect orphaned blocks.
+type IndexedBlock struct {
+	ChainID   uint64 `gorm:"primaryKey;autoIncrement:false"`
+	Number    uint64 `gorm:"primaryKey;autoIncrement:false"`
+	Hash      string `gorm:"type:varchar(66)"`
+	CreatedAt time.Time
+}
+
+// LatestIndexedBlocks returns up to limit indexed blocks of chainID, newest
+// first.
+func LatestIndexedBlocks(rep db.Repository, chainID uint64, limit int) ([]IndexedBlock, error) {
+	var recs []IndexedBlock
+	err := rep.Model(&IndexedBlock{}).Where(`"chain_id" = ?`, chainID).Order("number desc").Limit(limit).Find(&recs).Error
+	if err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// KeyPaymaster binds an api key to a dedicated paymaster contract on a
+// chain, so its sponsorships are paid from that contract's deposit instead
+// of the shared one. The contract must trust the signer of the service.
+type KeyPaymaster struct {
+	gorm.Model
+	ApiKeyID uint   `gorm:"uniqueIndex:idx_key_paymaster"`
+	ChainID  uint64 `gorm:"uniqueIndex:idx_key_paymaster"`
+	Contract string `gorm:"index;type:varchar(42)"`
+}
+
+// FindKeyPaymaster returns the dedicated paymaster of apiKeyID on chainID,
+// nil when the key uses the shared one.
+func FindKeyPaymaster(rep db.Repository, apiKeyID uint, chainID uint64) (*KeyPaymaster, error) {
+	var rec KeyPaymaster
+	err := rep.Model(&KeyPaymaster{}).First(&rec, `"api_key_id" = ? AND "chain_id" = ?`, apiKeyID, chainID).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// KeyPaymasterContracts returns the distinct dedicated paymasters on chainID.
+func KeyPaymasterContracts(rep db.Repository, chainID uint64) ([]string, error) {
+	var contracts []string
+	err := rep.Model(&KeyPaymaster{}).Where(`"chain_id" = ?`, chainID).Distinct().Pluck("contract", &contracts).Error
+	return contracts, err
+}
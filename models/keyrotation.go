@@ -0,0 +1,62 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// Valid reports whether key, the current or the previous key of a, can be
+// used at now.
+func (a *ApiKeys) Valid(key string, now time.Time) bool {
+	if !a.Enable || (a.ExpiresAt != nil && !now.Before(*a.ExpiresAt)) {
+		return false
+	}
+	if key == a.Key {
+		return true
+	}
+	return key == a.PreviousKey && a.PreviousKey != "" &&
+		a.PreviousKeyExpiresAt != nil && now.Before(*a.PreviousKeyExpiresAt)
+}
+
+// NewApiKey returns a random key.
+func NewApiKey() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// Rotate replaces the key of a with a new one, keeping the current key valid
+// for grace. The new key expires after lifetime, never when it is zero.
+func (a *ApiKeys) Rotate(now time.Time, grace, lifetime time.Duration) error {
+	key, err := NewApiKey()
+	if err != nil {
+		return err
+	}
+	previousExpiresAt := now.Add(grace)
+	a.PreviousKey = a.Key
+	a.PreviousKeyExpiresAt = &previousExpiresAt
+	a.Key = key
+	a.ExpiresAt = nil
+	if lifetime > 0 {
+		expiresAt := now.Add(lifetime)
+		a.ExpiresAt = &expiresAt
+	}
+	a.ExpiryNotifiedAt = nil
+	return nil
+}
+
+// ExpiringApiKeys returns the enabled keys expiring before until whose owner
+// was not reminded yet, with their user.
+func ExpiringApiKeys(rep db.Repository, now, until time.Time) ([]ApiKeys, error) {
+	var keys []ApiKeys
+	err := rep.Model(&ApiKeys{}).Preload("User").
+		Where(`"enable" = ? AND "expires_at" > ? AND "expires_at" <= ? AND "expiry_notified_at" IS NULL`, true, now, until).
+		Order(`"expires_at"`).
+		Find(&keys).Error
+	return keys, err
+}
@@ -0,0 +1,120 @@
+package models
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// KeyOutcomeStats are the outcomes of the sponsorship requests of an api key
+// in a day or week, api key 0 being the requests without one.
+type KeyOutcomeStats struct {
+	ApiKeyID uint      `json:"apiKeyId"`
+	Period   time.Time `json:"period"`
+	Signed   int64     `json:"signed"`
+	Rejected int64     `json:"rejected"`
+	// Included and Reverted count the signed operations that landed on
+	// chain, Expired those whose signature expired unused and Outstanding
+	// those that may still land.
+	Included    int64 `json:"included"`
+	Reverted    int64 `json:"reverted"`
+	Expired     int64 `json:"expired"`
+	Outstanding int64 `json:"outstanding"`
+	// AcceptanceRate is the share of requests signed and InclusionRate the
+	// share of the signed operations no longer outstanding that landed on
+	// chain, nil when there are none.
+	AcceptanceRate *float64 `json:"acceptanceRate"`
+	InclusionRate  *float64 `json:"inclusionRate"`
+}
+
+// KeyRejectionStats counts the rejections of an api key by code and reason.
+type KeyRejectionStats struct {
+	ApiKeyID uint   `json:"apiKeyId"`
+	Code     int    `json:"code"`
+	Reason   string `json:"reason"`
+	Count    int64  `json:"count"`
+}
+
+// KeyOutcomes groups the sponsorships and rejections in [from, to) by api key
+// and period, "day" or "week", of apiKeyID or every key when nil.
+func KeyOutcomes(rep db.Repository, period string, from, to time.Time, apiKeyID *uint) ([]*KeyOutcomeStats, error) {
+	var signed []*KeyOutcomeStats
+	query := rep.Model(&Sponsorship{}).
+		Select(`"api_key_id", date_trunc(?, "created_at") AS period, COUNT(*) AS signed, `+
+			`COUNT(*) FILTER (WHERE "status" = ?) AS included, `+
+			`COUNT(*) FILTER (WHERE "status" = ?) AS reverted, `+
+			`COUNT(*) FILTER (WHERE "status" = ? AND "valid_until" < ?) AS expired`,
+			period, SponsorshipIncluded, SponsorshipReverted, SponsorshipSigned, time.Now()).
+		Where(`"created_at" >= ? AND "created_at" < ?`, from, to)
+	if apiKeyID != nil {
+		query = query.Where(`"api_key_id" = ?`, *apiKeyID)
+	}
+	if err := query.Group(`"api_key_id", period`).Scan(&signed).Error; err != nil {
+		return nil, err
+	}
+
+	var rejected []*KeyOutcomeStats
+	query = rep.Model(&Rejection{}).
+		Select(`"api_key_id", date_trunc(?, "created_at") AS period, COUNT(*) AS rejected`, period).
+		Where(`"created_at" >= ? AND "created_at" < ?`, from, to)
+	if apiKeyID != nil {
+		query = query.Where(`"api_key_id" = ?`, *apiKeyID)
+	}
+	if err := query.Group(`"api_key_id", period`).Scan(&rejected).Error; err != nil {
+		return nil, err
+	}
+
+	type bucket struct {
+		apiKeyID uint
+		period   int64
+	}
+	merged := make(map[bucket]*KeyOutcomeStats, len(signed))
+	for _, s := range signed {
+		merged[bucket{s.ApiKeyID, s.Period.Unix()}] = s
+	}
+	for _, r := range rejected {
+		if s, ok := merged[bucket{r.ApiKeyID, r.Period.Unix()}]; ok {
+			s.Rejected = r.Rejected
+		} else {
+			merged[bucket{r.ApiKeyID, r.Period.Unix()}] = r
+		}
+	}
+
+	stats := make([]*KeyOutcomeStats, 0, len(merged))
+	for _, s := range merged {
+		s.Outstanding = s.Signed - s.Included - s.Reverted - s.Expired
+		s.AcceptanceRate = rate(s.Signed, s.Signed+s.Rejected)
+		s.InclusionRate = rate(s.Included+s.Reverted, s.Signed-s.Outstanding)
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].ApiKeyID != stats[j].ApiKeyID {
+			return stats[i].ApiKeyID < stats[j].ApiKeyID
+		}
+		return stats[i].Period.Before(stats[j].Period)
+	})
+	return stats, nil
+}
+
+// KeyRejections counts the rejections in [from, to) by api key, code and
+// reason, of apiKeyID or every key when nil.
+func KeyRejections(rep db.Repository, from, to time.Time, apiKeyID *uint) ([]KeyRejectionStats, error) {
+	var stats []KeyRejectionStats
+	query := rep.Model(&Rejection{}).
+		Select(`"api_key_id", "code", "reason", COUNT(*) AS count`).
+		Where(`"created_at" >= ? AND "created_at" < ?`, from, to)
+	if apiKeyID != nil {
+		query = query.Where(`"api_key_id" = ?`, *apiKeyID)
+	}
+	err := query.Group(`"api_key_id", "code", "reason"`).Order(`"api_key_id", count DESC`).Scan(&stats).Error
+	return stats, err
+}
+
+func rate(part, total int64) *float64 {
+	if total == 0 {
+		return nil
+	}
+	r := float64(part) / float64(total)
+	return &r
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// MaintenanceWindow is a scheduled period without new sponsorships.
+type MaintenanceWindow struct {
+	gorm.Model
+	Start    time.Time `gorm:"index" json:"start"`
+	End      time.Time `gorm:"index" json:"end"`
+	Reason   string    `gorm:"type:varchar(32)" json:"reason"`
+	Note     string    `gorm:"type:text" json:"note"`
+	Operator string    `gorm:"type:varchar(64)" json:"operator"`
+	// NotifiedAt is when the advance notice was sent, nil before.
+	NotifiedAt *time.Time `json:"notifiedAt"`
+}
+
+// ActiveMaintenance returns the window in force at now ending last, nil
+// outside maintenance.
+func ActiveMaintenance(rep db.Repository, now time.Time) (*MaintenanceWindow, error) {
+	var window MaintenanceWindow
+	err := rep.Model(&MaintenanceWindow{}).
+		Where(`"start" <= ? AND "end" > ?`, now, now).
+		Order(`"end" DESC`).
+		First(&window).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &window, nil
+}
+
+// UpcomingMaintenance returns the windows not over at now that start before
+// until, earliest first.
+func UpcomingMaintenance(rep db.Repository, now, until time.Time) ([]MaintenanceWindow, error) {
+	var windows []MaintenanceWindow
+	err := rep.Model(&MaintenanceWindow{}).
+		Where(`"end" > ? AND "start" < ?`, now, until).
+		Order(`"start"`).
+		Find(&windows).Error
+	return windows, err
+}
+
+// ScheduledMaintenance returns the windows not over at now, earliest first.
+func ScheduledMaintenance(rep db.Repository, now time.Time) ([]MaintenanceWindow, error) {
+	var windows []MaintenanceWindow
+	err := rep.Model(&MaintenanceWindow{}).Where(`"end" > ?`, now).Order(`"start"`).Find(&windows).Error
+	return windows, err
+}
+
+// ClaimMaintenanceNotice marks the notice of window id sent at now and
+// reports whether this call did, so a single replica sends it.
+func ClaimMaintenanceNotice(rep db.Repository, id uint, now time.Time) (bool, error) {
+	res := rep.Model(&MaintenanceWindow{}).
+		Where(`"id" = ? AND "notified_at" IS NULL`, id).
+		Update("notified_at", now)
+	return res.RowsAffected == 1, res.Error
+}
+
+// FindWithAlertWebhook returns the users with an alert webhook.
+func (u *User) FindWithAlertWebhook(rep db.Repository) ([]User, error) {
+	var recs []User
+	err := rep.Model(&User{}).Where(`"alert_webhook" <> ''`).Find(&recs).Error
+	return recs, err
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// indexes are the keyset pagination indexes gorm tags cannot express.
+var indexes = []string{
+	// cursor scans of the warehouse export and the data api
+	`CREATE INDEX IF NOT EXISTS "idx_sponsorships_updated_at_id" ON "sponsorships" ("updated_at", "id")`,
+	// admin account listing
+	`CREATE INDEX IF NOT EXISTS "idx_accounts_last_request_id" ON "accounts" ("last_request", "id")`,
+	`CREATE INDEX IF NOT EXISTS "idx_accounts_used_gas_id" ON "accounts" ((CAST(COALESCE(NULLIF("used_gas", ''), '0') AS NUMERIC)), "id")`,
+}
+
+// upgrades adapt the tables of earlier versions before auto migration.
+var upgrades = []string{
+	// indexed blocks are kept per chain
+	`DO $$ BEGIN
+		IF EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'indexed_blocks')
+			AND NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'indexed_blocks' AND column_name = 'chain_id') THEN
+			ALTER TABLE "indexed_blocks" ADD COLUMN "chain_id" bigint NOT NULL DEFAULT 0;
+			ALTER TABLE "indexed_blocks" DROP CONSTRAINT "indexed_blocks_pkey";
+			ALTER TABLE "indexed_blocks" ADD PRIMARY KEY ("chain_id", "number");
+		END IF;
+	END $$`,
+	// faucet drips are unique per chain and address
+	`DROP INDEX IF EXISTS "idx_faucet_drips_address"`,
+	// accounts are unique per address and paymaster
+	`ALTER TABLE IF EXISTS "accounts" DROP CONSTRAINT IF EXISTS "accounts_address_key"`,
+	// deposit snapshots are kept per chain and paymaster
+	`DROP INDEX IF EXISTS "idx_deposit_snapshots_chain_id"`,
+}
+
+// Migrate runs auto migration for all service models.
+func Migrate(rep db.Repository) error {
+	for _, upgrade := range upgrades {
+		if err := rep.Exec(upgrade).Error; err != nil {
+			return err
+		}
+	}
+	err := rep.AutoMigrate(&User{}, &ApiKeys{}, &Account{}, &Sponsorship{}, &Checkpoint{}, &IndexedBlock{}, &Policy{}, &PolicyGasCap{}, &PolicyTarget{}, &PolicyTargetSelector{}, &PolicyWindow{}, &Factory{}, &Session{}, &QuotaAllocation{}, &BudgetAlert{}, &Rejection{}, &Anomaly{}, &ExportCursor{}, &AuditLog{}, &Submission{}, &BillingUsage{}, &StripeEvent{}, &CreditEntry{}, &GeoBlock{}, &Throttle{}, &AbuseFlag{}, &ScreeningDecision{}, &ScreeningAppeal{}, &HeldOperation{}, &HoldApproval{}, &SponsorshipPause{}, &MaintenanceWindow{}, &Webhook{}, &WebhookDelivery{}, &KeyPaymaster{}, &Passkey{}, &Identity{}, &BotCampaign{}, &BotClaim{}, &FaucetDrip{}, &DepositSnapshot{}, &ErrorTemplate{})
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if err := rep.Exec(index).Error; err != nil {
+			return err
+		}
+	}
+	return normalizeAddresses(rep)
+}
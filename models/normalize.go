@@ -0,0 +1,119 @@
+package models
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// normalizeCheckpoint marks the address normalization as done.
+const normalizeCheckpoint = "address_normalization"
+
+// addressColumns are the address columns stored in lower case.
+var addressColumns = []struct{ table, column string }{
+	{"users", "address"},
+	{"accounts", "address"},
+	{"sponsorships", "sender"},
+	{"sponsorships", "target"},
+	{"sessions", "sender"},
+	{"quota_allocations", "address"},
+	{"rejections", "sender"},
+	{"factories", "address"},
+	{"factories", "implementation"},
+	{"policy_targets", "address"},
+}
+
+// normalizeAddresses lower cases the addresses stored before they were
+// normalized, merging accounts and dropping factories that only differ in
+// case. It runs once.
+func normalizeAddresses(rep db.Repository) error {
+	done, err := (&Checkpoint{}).FindByName(rep, normalizeCheckpoint)
+	if err != nil || done != nil {
+		return err
+	}
+	return rep.Transaction(func(tx db.Repository) error {
+		if err := dedupeAccounts(tx); err != nil {
+			return err
+		}
+		err := tx.Exec(`DELETE FROM "factories" f USING "factories" g WHERE LOWER(f."address") = LOWER(g."address") AND f."id" > g."id"`).Error
+		if err != nil {
+			return err
+		}
+		for _, c := range addressColumns {
+			err := tx.Exec(fmt.Sprintf(`UPDATE %q SET %q = LOWER(%q) WHERE %q <> LOWER(%q)`, c.table, c.column, c.column, c.column, c.column)).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Create(&Checkpoint{Name: normalizeCheckpoint}).Error
+	})
+}
+
+// dedupeAccounts merges accounts whose addresses only differ in case into
+// one, preferring the lower case row. Quotas are added up and the merged
+// account stays disabled if any variant was.
+func dedupeAccounts(tx db.Repository) error {
+	var groups []string
+	err := tx.Model(&Account{}).Unscoped().
+		Select(`LOWER("address")`).Group(`LOWER("address")`).Having("COUNT(*) > 1").
+		Scan(&groups).Error
+	if err != nil {
+		return err
+	}
+	for _, address := range groups {
+		var recs []Account
+		err := tx.Model(&Account{}).Unscoped().Where(`LOWER("address") = ?`, address).Order("id").Find(&recs).Error
+		if err != nil {
+			return err
+		}
+		keeper := 0
+		for n := range recs {
+			if !recs[n].DeletedAt.Valid && (recs[keeper].DeletedAt.Valid || recs[n].Address == address && recs[keeper].Address != address) {
+				keeper = n
+			}
+		}
+		merged := recs[keeper]
+		var ids []uint
+		for n := range recs {
+			if n == keeper {
+				continue
+			}
+			ids = append(ids, recs[n].ID)
+			rec := &recs[n]
+			if rec.DeletedAt.Valid {
+				continue
+			}
+			merged.RemainGas = new(big.Int).Add(ParseGas(merged.RemainGas), ParseGas(rec.RemainGas)).String()
+			merged.UsedGas = new(big.Int).Add(ParseGas(merged.UsedGas), ParseGas(rec.UsedGas)).String()
+			merged.ReservedGas = new(big.Int).Add(ParseGas(merged.ReservedGas), ParseGas(rec.ReservedGas)).String()
+			if merged.VipID == -1 {
+				merged.VipID = rec.VipID
+			}
+			if rec.LastRequest.After(merged.LastRequest) {
+				merged.LastRequest = rec.LastRequest
+			}
+			if !rec.Enable && merged.Enable {
+				merged.Enable = false
+				merged.DisabledReason = rec.DisabledReason
+			}
+		}
+		if err := tx.Model(&Account{}).Unscoped().Where(`"id" IN ?`, ids).Delete(&Account{}).Error; err != nil {
+			return err
+		}
+		err = tx.Model(&Account{}).Unscoped().Where(`"id" = ?`, merged.ID).Updates(map[string]any{
+			"address":         address,
+			"enable":          merged.Enable,
+			"disabled_reason": merged.DisabledReason,
+			"vip_id":          merged.VipID,
+			"remain_gas":      merged.RemainGas,
+			"used_gas":        merged.UsedGas,
+			"reserved_gas":    merged.ReservedGas,
+			"last_request":    merged.LastRequest,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
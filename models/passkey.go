@@ -0,0 +1,35 @@
+package models
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// Passkey is the WebAuthn credential bound to an address, whose assertions
+// gas claims of policies with RequirePasskey need.
+type Passkey struct {
+	gorm.Model
+	Address string `gorm:"uniqueIndex;type:varchar(42)"`
+	// CredentialID is the base64url credential id of the authenticator.
+	CredentialID string `gorm:"type:varchar(1024)"`
+	// PublicKey is the hex encoded DER SubjectPublicKeyInfo of the P-256
+	// credential key.
+	PublicKey string `gorm:"type:text"`
+	// SignCount is the last signature counter the authenticator reported,
+	// authenticators without counter keep it at 0.
+	SignCount uint32 `gorm:"default:0"`
+}
+
+// FindPasskey returns the passkey bound to address, nil when there is none.
+func FindPasskey(rep db.Repository, address string) (*Passkey, error) {
+	var rec Passkey
+	err := rep.Model(&Passkey{}).First(&rec, `"address" = ?`, address).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
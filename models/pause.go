@@ -0,0 +1,44 @@
+package models
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// PauseAll is the ApiKeyID of the pause covering every api key.
+const PauseAll = 0
+
+// SponsorshipPause stops new sponsorships for an api key, or for every key
+// with ApiKeyID PauseAll, until it is deleted on resume.
+type SponsorshipPause struct {
+	gorm.Model
+	ApiKeyID uint   `gorm:"uniqueIndex" json:"apiKeyId"`
+	Reason   string `gorm:"type:varchar(32)" json:"reason"`
+	Note     string `gorm:"type:text" json:"note"`
+	Operator string `gorm:"type:varchar(64)" json:"operator"`
+}
+
+// Pauses returns the pauses in force, the global one first.
+func Pauses(rep db.Repository) ([]SponsorshipPause, error) {
+	var pauses []SponsorshipPause
+	err := rep.Model(&SponsorshipPause{}).Order(`"api_key_id"`).Find(&pauses).Error
+	return pauses, err
+}
+
+// FindPause returns the pause stopping the sponsorships of apiKeyID, the
+// global one when both are set, nil when none is.
+func FindPause(rep db.Repository, apiKeyID uint) (*SponsorshipPause, error) {
+	var pause SponsorshipPause
+	err := rep.Model(&SponsorshipPause{}).
+		Where(`"api_key_id" IN ?`, []uint{PauseAll, apiKeyID}).
+		Order(`"api_key_id"`).
+		First(&pause).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pause, nil
+}
@@ -0,0 +1,129 @@
+package models
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// Policy holds the sponsorship rules of an api key.
+type Policy struct {
+	gorm.Model
+	ApiKeyID uint `gorm:"uniqueIndex"`
+	// Expression is a CEL expression that must evaluate to true.
+	Expression string `gorm:"type:text"`
+	// WebhookURL is called with every operation before it is signed.
+	WebhookURL string `gorm:"type:varchar(512)"`
+	// WebhookTimeout is the webhook deadline in milliseconds.
+	WebhookTimeout int64
+	// Wallet is the call data format of the sponsored accounts, empty to
+	// detect it per operation.
+	Wallet string `gorm:"type:varchar(16)"`
+	// MaxValuePerOp and MaxValuePerDay limit the native value in wei sent by
+	// the inner calls of one operation and of a sender per 24 hours, empty
+	// disables a limit.
+	MaxValuePerOp  string `gorm:"type:varchar(78)"`
+	MaxValuePerDay string `gorm:"type:varchar(78)"`
+	// OverdraftPercent lets the sender quota go below zero by this share of
+	// the quota the account is refreshed to, the deficit is recovered from
+	// the next refresh.
+	OverdraftPercent uint64 `gorm:"default:0"`
+	// AutoRefresh refreshes a quota that ran out in the sponsorship path
+	// when its refresh window elapsed within REFRESH_GRACE, as pm_requestGas
+	// would.
+	AutoRefresh bool `gorm:"default:false"`
+	// RolloverCap and RolloverVipCap are the unused quota carried into the
+	// next refresh window of regular and VIP accounts, empty resets the
+	// quota.
+	RolloverCap    string `gorm:"type:varchar(30);default:''"`
+	RolloverVipCap string `gorm:"type:varchar(30);default:''"`
+
+	// HoldExpression is a CEL expression holding the operations it matches
+	// for manual approval, HoldAbove holds operations with at least this max
+	// gas cost in wei. Empty disables either.
+	HoldExpression string `gorm:"type:text;default:''"`
+	HoldAbove      string `gorm:"type:varchar(78);default:''"`
+
+	// MaxValidity is the longest signature validity window in seconds the
+	// key may request with validUntil beyond the default day, 0 keeps it
+	// to the default.
+	MaxValidity int64 `gorm:"default:0"`
+
+	// Aggregators are the comma separated signature aggregators accepted
+	// for the key, a subset of AGGREGATORS. Empty accepts all of those.
+	Aggregators string `gorm:"type:text;default:''"`
+
+	// DelegateTargets are the comma separated contracts the account may
+	// delegatecall, running their code as the account, when the policy has
+	// targets or value limits. Empty refuses delegatecalls under those rules.
+	DelegateTargets string `gorm:"type:text;default:''"`
+
+	// RequirePasskey makes pm_requestGas ask for an assertion of the passkey
+	// bound to the address.
+	RequirePasskey bool `gorm:"default:false"`
+
+	// RequireIdentity refuses pm_requestGas for addresses without a linked
+	// web2 identity. IdentityMaxGas is the quota in wei a refresh grants to
+	// addresses with one, when higher than the regular quota.
+	RequireIdentity bool   `gorm:"default:false"`
+	IdentityMaxGas  string `gorm:"type:varchar(30);default:''"`
+
+	// Timezone is the IANA time zone the Windows are in, empty for UTC.
+	Timezone string `gorm:"type:varchar(64);default:''"`
+
+	GasCaps []PolicyGasCap
+	Targets []PolicyTarget
+	Windows []PolicyWindow
+}
+
+// PolicyGasCap limits callGasLimit for operations calling Selector.
+type PolicyGasCap struct {
+	gorm.Model
+	PolicyID   uint   `gorm:"index"`
+	Selector   string `gorm:"type:varchar(10)"`
+	MaxCallGas uint64
+}
+
+// PolicyWindow is a daily time range on the comma separated weekdays Days
+// (mon to sun, every day when empty) from Start to End (HH:MM, End before
+// Start runs over midnight). Operations are only sponsored inside the
+// allowed windows of a policy, when it has any, and never inside a
+// Blackout window.
+type PolicyWindow struct {
+	gorm.Model
+	PolicyID uint   `gorm:"index"`
+	Days     string `gorm:"type:varchar(32);default:''"`
+	Start    string `gorm:"type:varchar(5)"`
+	End      string `gorm:"type:varchar(5)"`
+	Blackout bool   `gorm:"default:false"`
+}
+
+// PolicyTarget allows calls into Address. Without targets any contract can
+// be called.
+type PolicyTarget struct {
+	gorm.Model
+	PolicyID uint   `gorm:"index"`
+	Address  string `gorm:"type:varchar(42)"`
+	// Selectors limits the functions that can be called on Address.
+	Selectors []PolicyTargetSelector
+}
+
+// PolicyTargetSelector allows a 4-byte function selector on a target, "0x"
+// allows plain transfers.
+type PolicyTargetSelector struct {
+	gorm.Model
+	PolicyTargetID uint   `gorm:"index"`
+	Selector       string `gorm:"type:varchar(10)"`
+}
+
+func (p *Policy) FindByApiKey(rep db.Repository, apiKeyID uint) (*Policy, error) {
+	var rec Policy
+	err := rep.Model(&Policy{}).Preload("GasCaps").Preload("Targets.Selectors").Preload("Windows").First(&rec, `"api_key_id" = ?`, apiKeyID).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
@@ -0,0 +1,14 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// Rejection is a sponsorship request the service refused.
+type Rejection struct {
+	gorm.Model
+	ApiKeyID uint   `gorm:"index"`
+	Sender   string `gorm:"type:varchar(42)"`
+	Code     int
+	Reason   string `gorm:"index;type:varchar(32)"`
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// Report subjects, the column sponsorships are grouped by.
+const (
+	SubjectSender = "sender"
+	SubjectApiKey = "api_key"
+)
+
+var subjectColumns = map[string]string{
+	SubjectSender: `"sender"`,
+	SubjectApiKey: `CAST("api_key_id" AS TEXT)`,
+}
+
+// SpendStats is the usage of a sender or api key.
+type SpendStats struct {
+	Subject  string `json:"subject"`
+	Ops      int64  `json:"ops"`
+	TotalGas string `json:"totalGas"`
+}
+
+// DailySpend is the gas cost of a subject in the day Age days before now.
+type DailySpend struct {
+	Subject  string
+	Age      int
+	TotalGas string
+}
+
+// Anomaly records a subject whose daily spend deviated from its trailing
+// average.
+type Anomaly struct {
+	gorm.Model
+	Day     string  `gorm:"uniqueIndex:idx_anomaly;type:varchar(10)" json:"day"`
+	Kind    string  `gorm:"uniqueIndex:idx_anomaly;type:varchar(8)" json:"kind"`
+	Subject string  `gorm:"uniqueIndex:idx_anomaly;type:varchar(42)" json:"subject"`
+	Spend   string  `gorm:"type:varchar(30)" json:"spend"`
+	Mean    string  `gorm:"type:varchar(30)" json:"mean"`
+	ZScore  float64 `json:"zScore"`
+}
+
+// TopSpenders returns the senders or api keys with the highest gas cost in
+// [from, to).
+func TopSpenders(rep db.Repository, kind string, from, to time.Time, limit int) ([]SpendStats, error) {
+	var stats []SpendStats
+	err := rep.Model(&Sponsorship{}).
+		Select(subjectColumns[kind]+` AS subject, COUNT(*) AS ops, `+sumGasCostSQL+` AS total_gas`).
+		Where(`"created_at" >= ? AND "created_at" < ?`, from, to).
+		Where(notExpiredSQL, SponsorshipSigned, time.Now()).
+		Group("subject").Order("SUM(" + gasCostSQL + ") DESC").
+		Limit(limit).
+		Scan(&stats).Error
+	return stats, err
+}
+
+// DailySpends sums the gas cost of every subject in 24 hour buckets counted
+// back from now, the current bucket has age 0.
+func DailySpends(rep db.Repository, kind string, now time.Time, days int) ([]DailySpend, error) {
+	var spends []DailySpend
+	err := rep.Model(&Sponsorship{}).
+		Select(subjectColumns[kind]+` AS subject, FLOOR(EXTRACT(EPOCH FROM (? - "created_at")) / 86400)::int AS age, `+sumGasCostSQL+` AS total_gas`, now).
+		Where(`"created_at" > ? AND "created_at" <= ?`, now.Add(-time.Duration(days)*24*time.Hour), now).
+		Where(notExpiredSQL, SponsorshipSigned, now).
+		Group("subject, age").
+		Scan(&spends).Error
+	return spends, err
+}
+
+func (a *Anomaly) Exists(rep db.Repository, day, kind, subject string) (bool, error) {
+	var count int64
+	err := rep.Model(&Anomaly{}).
+		Where(`"day" = ? AND "kind" = ? AND "subject" = ?`, day, kind, subject).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (a *Anomaly) FindSince(rep db.Repository, since time.Time) ([]Anomaly, error) {
+	var recs []Anomaly
+	err := rep.Model(&Anomaly{}).Where(`"created_at" >= ?`, since).Order("z_score DESC").Find(&recs).Error
+	return recs, err
+}
@@ -0,0 +1,39 @@
+package models
+
+import "strings"
+
+// Api key scopes, the groups of rpc methods a key may call.
+const (
+	ScopeSponsor     = "sponsor"
+	ScopeReadOnly    = "read-only"
+	ScopeRequestGas  = "request-gas"
+	ScopeAdminReport = "admin-report"
+	ScopeWebhooks    = "webhooks"
+)
+
+// Scopes are the known api key scopes.
+var Scopes = []string{ScopeSponsor, ScopeReadOnly, ScopeRequestGas, ScopeAdminReport, ScopeWebhooks}
+
+// ValidScope reports whether scope is a known scope.
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope reports whether the key may call the methods of scope. Keys
+// without scopes may call every method.
+func (a *ApiKeys) HasScope(scope string) bool {
+	if a.Scopes == "" {
+		return true
+	}
+	for _, s := range strings.Split(a.Scopes, ",") {
+		if strings.TrimSpace(s) == scope {
+			return true
+		}
+	}
+	return false
+}
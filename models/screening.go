@@ -0,0 +1,122 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// Screening decision sources.
+const (
+	ScreeningProvider = "provider"
+	ScreeningOverride = "override"
+)
+
+// Screening appeal states.
+const (
+	AppealPending  = "pending"
+	AppealApproved = "approved"
+	AppealRejected = "rejected"
+)
+
+// ScreeningDecision is the compliance screening result of an address. A
+// provider decision is reused until ExpiresAt, an operator override has no
+// expiry and is never replaced by a provider decision.
+type ScreeningDecision struct {
+	gorm.Model
+	Address   string    `gorm:"uniqueIndex;type:varchar(42)" json:"address"`
+	Allowed   bool      `json:"allowed"`
+	Reason    string    `gorm:"type:varchar(255);default:''" json:"reason"`
+	Source    string    `gorm:"type:varchar(16)" json:"source"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Operator  string    `gorm:"type:varchar(64);default:''" json:"operator"`
+}
+
+// Valid reports whether the decision can be used at now.
+func (d *ScreeningDecision) Valid(now time.Time) bool {
+	return d.Source == ScreeningOverride || now.Before(d.ExpiresAt)
+}
+
+// FindScreening returns the decision of address, nil when there is none.
+func FindScreening(rep db.Repository, address string) (*ScreeningDecision, error) {
+	var rec ScreeningDecision
+	err := rep.Model(&ScreeningDecision{}).First(&rec, `"address" = ?`, address).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// SaveScreening stores a provider decision unless the address has an
+// override.
+func SaveScreening(rep db.Repository, d *ScreeningDecision) error {
+	d.Source = ScreeningProvider
+	return rep.Model(&ScreeningDecision{}).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "address"}},
+		DoUpdates: clause.AssignmentColumns([]string{"allowed", "reason", "source", "expires_at", "updated_at"}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Neq{Column: clause.Column{Table: "screening_decisions", Name: "source"}, Value: ScreeningOverride},
+		}},
+	}).Create(d).Error
+}
+
+// OverrideScreening replaces the decision of address with an operator
+// override.
+func OverrideScreening(rep db.Repository, d *ScreeningDecision) error {
+	d.Source = ScreeningOverride
+	d.ExpiresAt = time.Time{}
+	return rep.Model(&ScreeningDecision{}).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "address"}},
+		DoUpdates: clause.AssignmentColumns([]string{"allowed", "reason", "source", "expires_at", "operator", "updated_at"}),
+	}).Create(d).Error
+}
+
+// ClearScreening removes the decision of address, it is screened again on
+// its next operation.
+func ClearScreening(rep db.Repository, address string) error {
+	return rep.Model(&ScreeningDecision{}).Unscoped().Where(`"address" = ?`, address).Delete(&ScreeningDecision{}).Error
+}
+
+// ScreeningAppeal asks operators to review the screening decision blocking
+// an address.
+type ScreeningAppeal struct {
+	gorm.Model
+	Address  string `gorm:"index;type:varchar(42)" json:"address"`
+	ApiKeyID uint   `gorm:"index" json:"apiKeyId"`
+	// Decision is the reason of the appealed decision.
+	Decision string `gorm:"type:varchar(255);default:''" json:"decision"`
+	Note     string `gorm:"type:text" json:"note"`
+	Status   string `gorm:"index;type:varchar(16)" json:"status"`
+	Operator string `gorm:"type:varchar(64);default:''" json:"operator"`
+}
+
+// PendingAppeal returns the pending appeal of address, nil when there is
+// none.
+func PendingAppeal(rep db.Repository, address string) (*ScreeningAppeal, error) {
+	var rec ScreeningAppeal
+	err := rep.Model(&ScreeningAppeal{}).First(&rec, `"address" = ? AND "status" = ?`, address, AppealPending).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// FindAppeals returns the latest appeals with status, all when empty.
+func FindAppeals(rep db.Repository, status string, limit int) ([]ScreeningAppeal, error) {
+	var appeals []ScreeningAppeal
+	query := rep.Model(&ScreeningAppeal{})
+	if status != "" {
+		query = query.Where(`"status" = ?`, status)
+	}
+	err := query.Order("id DESC").Limit(limit).Find(&appeals).Error
+	return appeals, err
+}
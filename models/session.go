@@ -0,0 +1,83 @@
+package models
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+var ErrSessionQuota = errors.New("session quota exceeded")
+
+// Session is a short lived sponsorship grant of an api key for a single
+// sender with its own gas quota.
+type Session struct {
+	gorm.Model
+	Key      string `gorm:"unique;type:varchar(66)"`
+	ApiKeyID uint   `gorm:"index"`
+	Sender   string `gorm:"index;type:varchar(42)"`
+	// Targets is the comma separated list of contracts the session may
+	// call, empty allows any contract.
+	Targets   string `gorm:"type:text"`
+	ExpiresAt time.Time
+	MaxGas    string `gorm:"type:varchar(30)"`
+	UsedGas   string `gorm:"type:varchar(30);default:'0'"`
+}
+
+// TargetList returns the contracts in the session scope.
+func (s *Session) TargetList() []string {
+	var targets []string
+	for _, t := range strings.Split(s.Targets, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			targets = append(targets, t)
+		}
+	}
+	return targets
+}
+
+// RemainGas returns the quota left in the session.
+func (s *Session) RemainGas() *big.Int {
+	remain := new(big.Int).Sub(ParseGas(s.MaxGas), ParseGas(s.UsedGas))
+	if remain.Sign() < 0 {
+		return new(big.Int)
+	}
+	return remain
+}
+
+func (s *Session) FindByKey(rep db.Repository, key string) (*Session, error) {
+	var rec Session
+	err := rep.Model(&Session{}).First(&rec, `"key" = ?`, key).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// ChargeGas adds amount to the used gas of the session, failing with
+// ErrSessionQuota when the quota is exceeded. A negative amount refunds it.
+func (s *Session) ChargeGas(rep db.Repository, key string, amount *big.Int) error {
+	return rep.Transaction(func(tx db.Repository) error {
+		var rec Session
+		err := tx.Model(&Session{}).Clauses(clause.Locking{Strength: "UPDATE"}).First(&rec, `"key" = ?`, key).Error
+		if err != nil {
+			return err
+		}
+		used := new(big.Int).Add(ParseGas(rec.UsedGas), amount)
+		if amount.Sign() > 0 && used.Cmp(ParseGas(rec.MaxGas)) > 0 {
+			return ErrSessionQuota
+		}
+		if used.Sign() < 0 {
+			used = new(big.Int)
+		}
+		rec.UsedGas = used.String()
+		return tx.Save(&rec).Error
+	})
+}
@@ -0,0 +1,124 @@
+package models
+
+import (
+	"math/big"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+const (
+	// gasCostSQL is the gas cost of a sponsorship, the actual cost of settled
+	// operations.
+	gasCostSQL = `CAST(COALESCE(NULLIF("actual_gas_cost", ''), "max_gas_cost") AS NUMERIC)`
+	// sumGasCostSQL sums the gas cost of sponsorships as text.
+	sumGasCostSQL = `COALESCE(SUM(` + gasCostSQL + `), 0)::text`
+	// notExpiredSQL leaves out signatures that expired unused, its args are
+	// SponsorshipSigned and the current time.
+	notExpiredSQL = `NOT ("status" = ? AND "valid_until" < ?)`
+)
+
+// Sponsorship lifecycle statuses.
+const (
+	SponsorshipSigned   = "signed"
+	SponsorshipPending  = "pending"
+	SponsorshipIncluded = "included"
+	SponsorshipReverted = "reverted"
+	SponsorshipExpired  = "expired"
+)
+
+// Sponsorship is a user operation signed by the paymaster.
+type Sponsorship struct {
+	gorm.Model
+	UserOpHash       string `gorm:"unique;type:varchar(66)"`
+	Sender           string `gorm:"index;type:varchar(42)"`
+	Nonce            string `gorm:"type:varchar(78)"`
+	PaymasterAndData string `gorm:"type:text"`
+	MaxGasCost       string `gorm:"type:varchar(30)"`
+	ValidUntil       time.Time
+	Status           string `gorm:"index;type:varchar(16)"`
+	BlockNumber      uint64
+	BlockHash        string `gorm:"type:varchar(66)"`
+	TxHash           string `gorm:"type:varchar(66)"`
+	LogIndex         uint
+	ActualGasCost    string `gorm:"type:varchar(30)"`
+
+	ApiKeyID uint `gorm:"index"`
+	// Target is the contract of the first inner call.
+	Target string `gorm:"index;type:varchar(42)"`
+	// Value is the native value sent by the inner calls in wei.
+	Value string `gorm:"type:varchar(78)"`
+	// ReceiptTxHash is the transaction that minted the receipt NFT.
+	ReceiptTxHash string `gorm:"type:varchar(66);default:''"`
+	// ChainID is the chain the operation was signed for, zero for
+	// sponsorships signed before chains were configurable.
+	ChainID uint64 `gorm:"default:0"`
+	// QuotaCost is the quota charged for MaxGasCost, in the shared quota unit
+	// and with the surcharge, empty when MaxGasCost was charged as is.
+	QuotaCost string `gorm:"type:varchar(78);default:''"`
+	// SurchargePercent is the surcharge of the api key when signed.
+	SurchargePercent uint64 `gorm:"default:0"`
+	// ClientIP is the address the request came from.
+	ClientIP string `gorm:"index;type:varchar(45);default:''"`
+	// CallDataHash is the keccak256 of the callData, empty without callData.
+	CallDataHash string `gorm:"index;type:varchar(66);default:''"`
+	// Paymaster is the contract the operation was signed for, empty for
+	// sponsorships signed before api keys could have their own.
+	Paymaster string `gorm:"index;type:varchar(42);default:''"`
+	// QuotaPaymaster is the dedicated paymaster whose account of the sender
+	// was charged, empty when the shared account was.
+	QuotaPaymaster string `gorm:"type:varchar(42);default:''"`
+}
+
+// QuotaCharge returns the quota charged for the sponsorship and the part of
+// it used by actual, converted at the rate applied when it was signed.
+func (s *Sponsorship) QuotaCharge(actual *big.Int) (*big.Int, *big.Int) {
+	maxGasCost := ParseGas(s.MaxGasCost)
+	if s.QuotaCost == "" || maxGasCost.Sign() == 0 {
+		return maxGasCost, actual
+	}
+	charged := ParseGas(s.QuotaCost)
+	used := new(big.Int).Mul(actual, charged)
+	return charged, used.Div(used, maxGasCost)
+}
+
+// CurrentStatus returns Status, reporting signed sponsorships past their
+// validity window as expired.
+func (s *Sponsorship) CurrentStatus(now time.Time) string {
+	if s.Status == SponsorshipSigned && now.After(s.ValidUntil) {
+		return SponsorshipExpired
+	}
+	return s.Status
+}
+
+func (s *Sponsorship) FindByUserOpHash(rep db.Repository, hash string) (*Sponsorship, error) {
+	var rec Sponsorship
+	err := rep.Model(&Sponsorship{}).First(&rec, `"user_op_hash" = ?`, hash).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// ValueSince sums the native value of the sponsorships of sender signed after
+// since, leaving out expired and reverted ones.
+func (s *Sponsorship) ValueSince(rep db.Repository, sender string, since, now time.Time) (*big.Int, error) {
+	var values []string
+	err := rep.Model(&Sponsorship{}).
+		Where(`"sender" = ? AND "created_at" > ?`, sender, since).
+		Where(`("status" IN ? OR ("status" = ? AND "valid_until" > ?))`, []string{SponsorshipPending, SponsorshipIncluded}, SponsorshipSigned, now).
+		Pluck("value", &values).Error
+	if err != nil {
+		return nil, err
+	}
+	total := new(big.Int)
+	for _, v := range values {
+		total.Add(total, ParseGas(v))
+	}
+	return total, nil
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// PeriodStats aggregates the sponsorships signed in a day or week.
+type PeriodStats struct {
+	Period        time.Time `json:"period"`
+	Ops           int64     `json:"ops"`
+	TotalGas      string    `json:"totalGas"`
+	UniqueSenders int64     `json:"uniqueSenders"`
+}
+
+type TargetStats struct {
+	Target   string `json:"target"`
+	Ops      int64  `json:"ops"`
+	TotalGas string `json:"totalGas"`
+}
+
+type RejectionStats struct {
+	Code   int    `json:"code"`
+	Reason string `json:"reason"`
+	Count  int64  `json:"count"`
+}
+
+// SponsorshipStats groups the sponsorships signed in [from, to) by period,
+// "day" or "week", of apiKeyID or every key when zero. Signatures that
+// expired unused are not counted.
+func SponsorshipStats(rep db.Repository, period string, from, to time.Time, apiKeyID uint) ([]PeriodStats, error) {
+	var stats []PeriodStats
+	query := rep.Model(&Sponsorship{}).
+		Select(`date_trunc(?, "created_at") AS period, COUNT(*) AS ops, `+sumGasCostSQL+` AS total_gas, COUNT(DISTINCT "sender") AS unique_senders`, period).
+		Where(`"created_at" >= ? AND "created_at" < ?`, from, to).
+		Where(notExpiredSQL, SponsorshipSigned, time.Now())
+	if apiKeyID != 0 {
+		query = query.Where(`"api_key_id" = ?`, apiKeyID)
+	}
+	err := query.Group("period").Order("period").Scan(&stats).Error
+	return stats, err
+}
+
+// TopTargets returns the contracts called by the most sponsorships in
+// [from, to).
+func TopTargets(rep db.Repository, from, to time.Time, limit int) ([]TargetStats, error) {
+	var stats []TargetStats
+	err := rep.Model(&Sponsorship{}).
+		Select(`"target", COUNT(*) AS ops, `+sumGasCostSQL+` AS total_gas`).
+		Where(`"created_at" >= ? AND "created_at" < ? AND "target" <> ''`, from, to).
+		Where(notExpiredSQL, SponsorshipSigned, time.Now()).
+		Group("target").Order("ops DESC").Limit(limit).
+		Scan(&stats).Error
+	return stats, err
+}
+
+// RejectionBreakdown counts the rejections in [from, to) by code and reason.
+func RejectionBreakdown(rep db.Repository, from, to time.Time) ([]RejectionStats, error) {
+	var stats []RejectionStats
+	err := rep.Model(&Rejection{}).
+		Select(`"code", "reason", COUNT(*) AS count`).
+		Where(`"created_at" >= ? AND "created_at" < ?`, from, to).
+		Group(`"code", "reason"`).Order("count DESC").
+		Scan(&stats).Error
+	return stats, err
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// SelfBundler is the Bundler of submissions sent by the service itself.
+const SelfBundler = "self"
+
+// Self-bundled submission statuses.
+const (
+	SubmissionQueued   = "queued"
+	SubmissionSent     = "sent"
+	SubmissionIncluded = "included"
+	SubmissionFailed   = "failed"
+)
+
+// Submission is a sponsored operation forwarded to a bundler through the
+// proxy.
+type Submission struct {
+	gorm.Model
+	SponsorshipID uint   `gorm:"uniqueIndex"`
+	UserOpHash    string `gorm:"index;type:varchar(66)"`
+	ApiKeyID      uint   `gorm:"index"`
+	ChainID       uint64
+	Bundler       string `gorm:"type:varchar(255)"`
+
+	// self-bundled submissions keep the operation and their handleOps
+	// transaction
+	Op     string `gorm:"type:text;default:''"`
+	Status string `gorm:"index;type:varchar(16);default:''"`
+	TxHash string `gorm:"type:varchar(66);default:''"`
+	Error  string `gorm:"type:text;default:''"`
+}
+
+// Record stores the first submission of a sponsorship, resubmissions are
+// ignored.
+func (s *Submission) Record(rep db.Repository) error {
+	return rep.Model(&Submission{}).Clauses(clause.OnConflict{DoNothing: true}).Create(s).Error
+}
+
+func (s *Submission) FindBySponsorship(rep db.Repository, sponsorshipID uint) (*Submission, error) {
+	var rec Submission
+	err := rep.Model(&Submission{}).First(&rec, `"sponsorship_id" = ?`, sponsorshipID).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// FindQueued returns the oldest queued self-bundled submissions of chainID.
+func (s *Submission) FindQueued(rep db.Repository, chainID uint64, limit int) ([]Submission, error) {
+	var recs []Submission
+	err := rep.Where(`"bundler" = ? AND "chain_id" = ? AND "status" = ?`, SelfBundler, chainID, SubmissionQueued).
+		Order("id").Limit(limit).Find(&recs).Error
+	return recs, err
+}
+
+// FindSent returns the self-bundled submissions of chainID waiting for their
+// transaction to be mined.
+func (s *Submission) FindSent(rep db.Repository, chainID uint64) ([]Submission, error) {
+	var recs []Submission
+	err := rep.Where(`"bundler" = ? AND "chain_id" = ? AND "status" = ?`, SelfBundler, chainID, SubmissionSent).
+		Order("id").Find(&recs).Error
+	return recs, err
+}
+
+// FindByUserOpHashes returns a sponsorship signed for one of hashes, nil when
+// none was.
+func (s *Sponsorship) FindByUserOpHashes(rep db.Repository, hashes []string) (*Sponsorship, error) {
+	var rec Sponsorship
+	err := rep.Model(&Sponsorship{}).First(&rec, `"user_op_hash" IN ?`, hashes).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
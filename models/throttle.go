@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// Throttle refuses the requests of a client fingerprint until Until.
+type Throttle struct {
+	gorm.Model
+	Fingerprint string `gorm:"index;type:varchar(32)" json:"fingerprint"`
+	IP          string `gorm:"type:varchar(45)" json:"ip"`
+	UserAgent   string `gorm:"type:varchar(255)" json:"userAgent"`
+	ApiKeyID    uint   `gorm:"index" json:"apiKeyId"`
+	// Reason is the deviating metric: requests or senders
+	Reason string `gorm:"type:varchar(16)" json:"reason"`
+	// Observed and Baseline are the per minute values that triggered it
+	Observed float64   `json:"observed"`
+	Baseline float64   `json:"baseline"`
+	Until    time.Time `gorm:"index" json:"until"`
+}
+
+// ActiveThrottles returns the throttles in force at now, latest first.
+func ActiveThrottles(rep db.Repository, now time.Time) ([]Throttle, error) {
+	var throttles []Throttle
+	err := rep.Model(&Throttle{}).Where(`"until" > ?`, now).Order("id DESC").Find(&throttles).Error
+	return throttles, err
+}
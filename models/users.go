@@ -11,6 +11,21 @@ import (
 type User struct {
 	gorm.Model
 	Address string `gorm:"type:varchar(42)"`
+	// MonthlyBudget is the gas cost in wei the project expects to spend per
+	// calendar month, empty disables budget alerts.
+	MonthlyBudget string `gorm:"type:varchar(30)"`
+	// AlertWebhook receives budget threshold notifications.
+	AlertWebhook string `gorm:"type:varchar(512)"`
+	// AutoPause stops sponsoring once the monthly budget is spent.
+	AutoPause bool
+
+	// BillingEmail enables Stripe billing of the usage beyond FreeQuota.
+	BillingEmail     string `gorm:"type:varchar(255);default:''"`
+	StripeCustomerID string `gorm:"index;type:varchar(64);default:''"`
+	// FreeQuota is the gas cost in wei per calendar month that is not billed.
+	FreeQuota string `gorm:"type:varchar(30);default:'0'"`
+	// PaymentFailed holds sponsoring until Stripe reports a paid invoice.
+	PaymentFailed bool `gorm:"default:false"`
 }
 
 type ApiKeys struct {
@@ -20,41 +35,94 @@ type ApiKeys struct {
 	Key         string `gorm:"unique;type:varchar(32)"`
 	Enable      bool
 	Description string
+	// Budget is the gas in wei the key can allocate to accounts.
+	Budget string `gorm:"type:varchar(30);default:'0'"`
+	// PvgProfile selects the preVerificationGas adjustment of the bundler the
+	// key submits to, empty uses PVG_PROFILE.
+	PvgProfile string `gorm:"type:varchar(32);default:''"`
+	// GasMarkupPercent and GasMarkup override the gas limit markup of the
+	// chain, nil keeps it.
+	GasMarkupPercent *uint64
+	GasMarkup        *uint64
+	// SurchargePercent is added to the gas cost charged for the operations
+	// of a monetized key.
+	SurchargePercent uint64 `gorm:"default:0"`
+	// GeoAllow and GeoDeny are comma separated country codes replacing the
+	// deployment GeoIP lists, when either is set.
+	GeoAllow string `gorm:"type:varchar(255);default:''"`
+	GeoDeny  string `gorm:"type:varchar(255);default:''"`
+	// ReplayProtection requires a fresh timestamp and an unused nonce on
+	// the sponsorship requests of the key.
+	ReplayProtection bool `gorm:"default:false"`
+	// ExpiresAt ends the key, nil keeps it until disabled.
+	ExpiresAt *time.Time
+	// AutoRotate replaces the key with a new one when its expiry reminder
+	// is sent.
+	AutoRotate bool `gorm:"default:false"`
+	// PreviousKey is the key replaced by the last rotation, valid until
+	// PreviousKeyExpiresAt.
+	PreviousKey          string `gorm:"index;type:varchar(32);default:''"`
+	PreviousKeyExpiresAt *time.Time
+	// ExpiryNotifiedAt is when the owner was reminded of ExpiresAt.
+	ExpiryNotifiedAt *time.Time
+	// Scopes are the comma separated method groups the key may call, every
+	// group when empty.
+	Scopes string `gorm:"type:varchar(255);default:''"`
 }
 
 func (a *ApiKeys) FindByKey(rep db.Repository, key string) (*ApiKeys, error) {
 	var rec ApiKeys
-	err := rep.Model(&ApiKeys{}).First(&rec, `"key" = ?`, key).Error
+	err := rep.Model(&ApiKeys{}).First(&rec, `"key" = ? OR ("previous_key" = ? AND "previous_key" <> '')`, key, key).Error
 	if err == gorm.ErrRecordNotFound {
 		return nil, nil
 	}
+	if err != nil {
+		return nil, err
+	}
 	return &rec, nil
 }
 
+// Account is the sender quota of an address. Senders have one account for
+// the shared paymaster and one per dedicated paymaster they are sponsored
+// through, so the spend of a dedicated paymaster is accounted apart.
 type Account struct {
 	gorm.Model
-	Address     string `gorm:"unique;type:varchar(42)"`
-	Enable      bool
-	VipID       int64  `gorm:"type:integer DEFAULT -1"`
+	Address string `gorm:"uniqueIndex:idx_accounts_address_paymaster;type:varchar(42)"`
+	// Paymaster is the dedicated paymaster contract the quota is charged
+	// by, empty for the shared paymaster.
+	Paymaster   string `gorm:"uniqueIndex:idx_accounts_address_paymaster;type:varchar(42);default:''"`
+	Enable      bool   `gorm:"index"`
+	VipID       int64  `gorm:"index;type:integer DEFAULT -1"`
 	RemainGas   string `gorm:"type:varchar(30)"`
 	UsedGas     string `gorm:"type:varchar(30)"`
+	ReservedGas string `gorm:"type:varchar(30);default:'0'"`
 	LastRequest time.Time
+
+	// DisabledReason is the operator reason code of a disabled account.
+	DisabledReason string `gorm:"type:varchar(32);default:''"`
 }
 
+// FindByAddress returns the shared paymaster account of address.
 func (a *Account) FindByAddress(rep db.Repository, address string) (*Account, error) {
 	var rec Account
-	err := rep.Model(&Account{}).First(&rec, `"address" = ?`, address).Error
+	err := rep.Model(&Account{}).First(&rec, `"address" = ? AND "paymaster" = ''`, address).Error
 	if err == gorm.ErrRecordNotFound {
 		return nil, nil
 	}
+	if err != nil {
+		return nil, err
+	}
 	return &rec, nil
 }
 
 func (a *Account) FindByVipID(rep db.Repository, id int64) (*Account, error) {
 	var rec Account
-	err := rep.Model(&Account{}).Where(`"vip_id" = ?`, id).Order("last_request desc").First(&rec).Error
+	err := rep.Model(&Account{}).Where(`"vip_id" = ? AND "paymaster" = ''`, id).Order("last_request desc").First(&rec).Error
 	if err == gorm.ErrRecordNotFound {
 		return nil, nil
 	}
+	if err != nil {
+		return nil, err
+	}
 	return &rec, nil
 }
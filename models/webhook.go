@@ -0,0 +1,182 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// Webhook events.
+const (
+	EventSponsorshipIncluded = "sponsorship.included"
+	EventSponsorshipReverted = "sponsorship.reverted"
+	EventApiKeyExpiring      = "api_key.expiring"
+	EventWebhookTest         = "webhook.test"
+)
+
+// WebhookEvents are the events webhooks can subscribe to.
+var WebhookEvents = []string{EventSponsorshipIncluded, EventSponsorshipReverted, EventApiKeyExpiring, EventWebhookTest}
+
+// Webhook delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// MaxWebhooksPerKey bounds the webhooks an api key registers.
+const MaxWebhooksPerKey = 5
+
+// Webhook is an endpoint an api key registered for its events.
+type Webhook struct {
+	gorm.Model
+	ApiKeyID uint   `gorm:"index" json:"-"`
+	URL      string `gorm:"type:varchar(512)" json:"url"`
+	// Events are the comma separated events delivered, every event when
+	// empty.
+	Events string `gorm:"type:varchar(255);default:''" json:"events"`
+	// Secret signs the deliveries.
+	Secret string `gorm:"type:varchar(80)" json:"-"`
+}
+
+// WebhookDelivery is an event queued for a webhook and the outcome of its
+// attempts.
+type WebhookDelivery struct {
+	gorm.Model
+	WebhookID uint    `gorm:"index" json:"webhookId"`
+	Webhook   Webhook `json:"-"`
+	Event     string  `gorm:"type:varchar(64)" json:"event"`
+	Payload   string  `gorm:"type:text" json:"-"`
+	Status    string  `gorm:"index;type:varchar(16)" json:"status"`
+	Attempts  int     `json:"attempts"`
+	// NextAttemptAt is when a pending delivery is tried again.
+	NextAttemptAt  time.Time  `gorm:"index" json:"nextAttemptAt"`
+	LastStatusCode int        `json:"lastStatusCode"`
+	LastError      string     `gorm:"type:varchar(255);default:''" json:"lastError"`
+	DeliveredAt    *time.Time `json:"deliveredAt"`
+}
+
+// ValidWebhookEvent reports whether event is a known event.
+func ValidWebhookEvent(event string) bool {
+	for _, e := range WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscribed reports whether the webhook receives event.
+func (w *Webhook) Subscribed(event string) bool {
+	if w.Events == "" {
+		return true
+	}
+	for _, e := range strings.Split(w.Events, ",") {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// NewWebhookSecret returns a random signing secret.
+func NewWebhookSecret() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(raw), nil
+}
+
+// Webhooks returns the webhooks of apiKeyID, oldest first.
+func Webhooks(rep db.Repository, apiKeyID uint) ([]Webhook, error) {
+	var webhooks []Webhook
+	err := rep.Model(&Webhook{}).Where(`"api_key_id" = ?`, apiKeyID).Order(`"id"`).Find(&webhooks).Error
+	return webhooks, err
+}
+
+// FindWebhook returns webhook id of apiKeyID, nil when the key has none with
+// that id.
+func FindWebhook(rep db.Repository, apiKeyID, id uint) (*Webhook, error) {
+	var webhook Webhook
+	err := rep.Model(&Webhook{}).First(&webhook, `"id" = ? AND "api_key_id" = ?`, id, apiKeyID).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// EnqueueWebhookEvent queues event with payload for the webhooks of
+// apiKeyID subscribed to it, in the transaction of tx so events are only
+// sent for committed changes.
+func EnqueueWebhookEvent(tx db.Repository, apiKeyID uint, event string, payload any) error {
+	webhooks, err := Webhooks(tx, apiKeyID)
+	if err != nil || len(webhooks) == 0 {
+		return err
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for i := range webhooks {
+		if webhooks[i].Subscribed(event) {
+			if err := enqueueDelivery(tx, webhooks[i].ID, event, string(body), now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// EnqueueWebhookTest queues a test event for webhook.
+func EnqueueWebhookTest(rep db.Repository, webhook *Webhook) error {
+	return enqueueDelivery(rep, webhook.ID, EventWebhookTest, `{}`, time.Now())
+}
+
+func enqueueDelivery(rep db.Repository, webhookID uint, event, payload string, now time.Time) error {
+	return rep.Create(&WebhookDelivery{
+		WebhookID:     webhookID,
+		Event:         event,
+		Payload:       payload,
+		Status:        DeliveryPending,
+		NextAttemptAt: now,
+	}).Error
+}
+
+// DueWebhookDeliveries returns up to limit pending deliveries due at now,
+// with their webhook.
+func DueWebhookDeliveries(rep db.Repository, now time.Time, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := rep.Model(&WebhookDelivery{}).Preload("Webhook").
+		Where(`"status" = ? AND "next_attempt_at" <= ?`, DeliveryPending, now).
+		Order(`"next_attempt_at"`).Limit(limit).
+		Find(&deliveries).Error
+	return deliveries, err
+}
+
+// WebhookDeliveries returns the latest deliveries of webhookID.
+func WebhookDeliveries(rep db.Repository, webhookID uint, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := rep.Model(&WebhookDelivery{}).
+		Where(`"webhook_id" = ?`, webhookID).
+		Order(`"id" DESC`).Limit(limit).
+		Find(&deliveries).Error
+	return deliveries, err
+}
+
+// PruneWebhookDeliveries removes the deliveries created before.
+func PruneWebhookDeliveries(rep db.Repository, before time.Time) error {
+	return rep.Model(&WebhookDelivery{}).Unscoped().
+		Where(`"created_at" < ? AND "status" <> ?`, before, DeliveryPending).
+		Delete(&WebhookDelivery{}).Error
+}
@@ -0,0 +1,33 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+)
+
+// AggregatorAllowlist only sponsors operations whose account validates
+// through an allowed signature aggregator. Operations without aggregator
+// pass, the aggregator is only known for simulated operations.
+type AggregatorAllowlist struct {
+	allowed map[common.Address]bool
+}
+
+// NewAggregatorAllowlist allows the comma separated aggregators, none when
+// empty.
+func NewAggregatorAllowlist(aggregators string) *AggregatorAllowlist {
+	return &AggregatorAllowlist{allowed: AddressSet(aggregators)}
+}
+
+func (a *AggregatorAllowlist) Check(ctx context.Context, req *Request) error {
+	if req.Aggregator == (common.Address{}) || a.allowed[req.Aggregator] {
+		return nil
+	}
+	return rpcerrors.RejectedByPaymaster(
+		fmt.Sprintf("aggregator %s not allowed", req.Aggregator.Hex()),
+		rpcerrors.REASON_AGGREGATOR_NOT_ALLOWED,
+	)
+}
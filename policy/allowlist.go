@@ -0,0 +1,88 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// TargetAllowlist only sponsors operations whose inner calls all go to
+// allowed contracts and, where selectors are configured, allowed functions.
+// Delegatecalls run the code of the target as the account, they must also
+// go to one of the delegate targets.
+type TargetAllowlist struct {
+	// allowed selectors by target, nil allows every function
+	targets   map[common.Address]map[string]bool
+	delegates map[common.Address]bool
+}
+
+// NewTargetAllowlist allows calls into targets and delegatecalls into the
+// comma separated delegateTargets, none when empty.
+func NewTargetAllowlist(targets []models.PolicyTarget, delegateTargets string) *TargetAllowlist {
+	a := &TargetAllowlist{
+		targets:   make(map[common.Address]map[string]bool, len(targets)),
+		delegates: AddressSet(delegateTargets),
+	}
+	for _, t := range targets {
+		var selectors map[string]bool
+		if len(t.Selectors) > 0 {
+			selectors = make(map[string]bool, len(t.Selectors))
+			for _, s := range t.Selectors {
+				selectors[strings.ToLower(strings.TrimSpace(s.Selector))] = true
+			}
+		}
+		a.targets[common.HexToAddress(strings.TrimSpace(t.Address))] = selectors
+	}
+	return a
+}
+
+func (a *TargetAllowlist) Check(ctx context.Context, req *Request) error {
+	calls, err := req.Calls()
+	if err != nil {
+		return rpcerrors.RejectedByPaymaster(err.Error(), rpcerrors.REASON_TARGET_NOT_ALLOWED)
+	}
+	for n, call := range calls {
+		if err := checkDelegateCall(n, call, a.delegates); err != nil {
+			return err
+		}
+		selectors, ok := a.targets[call.Target]
+		if !ok {
+			return rpcerrors.RejectedByPaymaster(fmt.Sprintf("call %d: target %s not allowed", n, call.Target.Hex()), rpcerrors.REASON_TARGET_NOT_ALLOWED)
+		}
+		if selectors != nil && !selectors[call.Selector()] {
+			return rpcerrors.RejectedByPaymaster(
+				fmt.Sprintf("call %d: function %s not allowed on %s", n, call.Selector(), call.Target.Hex()),
+				rpcerrors.REASON_SELECTOR_NOT_ALLOWED,
+			)
+		}
+	}
+	return nil
+}
+
+// AddressSet parses comma separated addresses.
+func AddressSet(addresses string) map[common.Address]bool {
+	set := make(map[common.Address]bool)
+	for _, address := range strings.Split(addresses, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			set[common.HexToAddress(address)] = true
+		}
+	}
+	return set
+}
+
+// checkDelegateCall rejects call n when it is a delegatecall into a contract
+// outside delegates.
+func checkDelegateCall(n int, call Call, delegates map[common.Address]bool) error {
+	if !call.DelegateCall || delegates[call.Target] {
+		return nil
+	}
+	return rpcerrors.RejectedByPaymaster(
+		fmt.Sprintf("call %d: delegatecall to %s not allowed", n, call.Target.Hex()),
+		rpcerrors.REASON_DELEGATECALL,
+	)
+}
@@ -0,0 +1,36 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+)
+
+// GasBounds keeps verificationGasLimit and preVerificationGas within a range,
+// so operations cannot make the paymaster prefund absurd limits. Zero
+// bounds are not enforced.
+type GasBounds struct {
+	MinVerificationGas    *big.Int
+	MaxVerificationGas    *big.Int
+	MinPreVerificationGas *big.Int
+	MaxPreVerificationGas *big.Int
+}
+
+func (b *GasBounds) Check(ctx context.Context, req *Request) error {
+	if err := checkBounds("verificationGasLimit", req.Op.VerificationGasLimit, b.MinVerificationGas, b.MaxVerificationGas); err != nil {
+		return err
+	}
+	return checkBounds("preVerificationGas", req.Op.PreVerificationGas, b.MinPreVerificationGas, b.MaxPreVerificationGas)
+}
+
+func checkBounds(name string, value, min, max *big.Int) error {
+	if min != nil && min.Sign() > 0 && value.Cmp(min) < 0 {
+		return rpcerrors.RejectedByPaymaster(fmt.Sprintf("%s %s below minimum %s", name, value, min), rpcerrors.REASON_GAS_LIMIT)
+	}
+	if max != nil && max.Sign() > 0 && value.Cmp(max) > 0 {
+		return rpcerrors.RejectedByPaymaster(fmt.Sprintf("%s %s above maximum %s", name, value, max), rpcerrors.REASON_GAS_LIMIT)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package policy
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// BudgetPause rejects operations of projects with AutoPause whose monthly
+// budget is spent. The budget monitor records when that happens.
+type BudgetPause struct {
+	Rep db.Repository
+}
+
+func (b *BudgetPause) Check(ctx context.Context, req *Request) error {
+	if req.ApiKey == nil {
+		return nil
+	}
+	var user models.User
+	err := b.Rep.Model(&models.User{}).First(&user, req.ApiKey.UserID).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !user.AutoPause || user.MonthlyBudget == "" {
+		return nil
+	}
+	exhausted, err := models.BudgetExhausted(b.Rep, user.ID, time.Now())
+	if err != nil {
+		return err
+	}
+	if exhausted {
+		return rpcerrors.RejectedByPaymaster("monthly budget exhausted", rpcerrors.REASON_BUDGET_EXHAUSTED)
+	}
+	return nil
+}
+
+// PaymentHold rejects operations of projects whose last Stripe invoice
+// payment failed.
+type PaymentHold struct {
+	Rep db.Repository
+}
+
+func (p *PaymentHold) Check(ctx context.Context, req *Request) error {
+	if req.ApiKey == nil {
+		return nil
+	}
+	var user models.User
+	err := p.Rep.Model(&models.User{}).First(&user, req.ApiKey.UserID).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.PaymentFailed {
+		return rpcerrors.RejectedByPaymaster("payment failed", rpcerrors.REASON_PAYMENT_FAILED)
+	}
+	return nil
+}
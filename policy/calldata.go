@@ -0,0 +1,228 @@
+package policy
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// Supported wallet call data formats.
+const (
+	WalletAuto          = ""
+	WalletSimpleAccount = "simple"
+	WalletSafe          = "safe"
+	WalletKernel        = "kernel"
+	WalletBiconomy      = "biconomy"
+)
+
+var (
+	simpleAccountABI = mustABI(`[
+		{"inputs":[{"name":"dest","type":"address"},{"name":"value","type":"uint256"},{"name":"func","type":"bytes"}],"name":"execute","outputs":[],"type":"function"},
+		{"inputs":[{"name":"dest","type":"address[]"},{"name":"func","type":"bytes[]"}],"name":"executeBatch","outputs":[],"type":"function"}
+	]`)
+	safeABI = mustABI(`[
+		{"inputs":[{"name":"to","type":"address"},{"name":"value","type":"uint256"},{"name":"data","type":"bytes"},{"name":"operation","type":"uint8"}],"name":"executeUserOp","outputs":[],"type":"function"},
+		{"inputs":[{"name":"to","type":"address"},{"name":"value","type":"uint256"},{"name":"data","type":"bytes"},{"name":"operation","type":"uint8"}],"name":"executeUserOpWithErrorString","outputs":[],"type":"function"}
+	]`)
+	multiSendABI = mustABI(`[
+		{"inputs":[{"name":"transactions","type":"bytes"}],"name":"multiSend","outputs":[],"type":"function"}
+	]`)
+	kernelABI = mustABI(`[
+		{"inputs":[{"name":"to","type":"address"},{"name":"value","type":"uint256"},{"name":"data","type":"bytes"},{"name":"operation","type":"uint8"}],"name":"execute","outputs":[],"type":"function"},
+		{"inputs":[{"components":[{"name":"to","type":"address"},{"name":"value","type":"uint256"},{"name":"data","type":"bytes"}],"name":"calls","type":"tuple[]"}],"name":"executeBatch","outputs":[],"type":"function"}
+	]`)
+	biconomyABI = mustABI(`[
+		{"inputs":[{"name":"dest","type":"address"},{"name":"value","type":"uint256"},{"name":"func","type":"bytes"}],"name":"execute","outputs":[],"type":"function"},
+		{"inputs":[{"name":"dest","type":"address"},{"name":"value","type":"uint256"},{"name":"func","type":"bytes"}],"name":"execute_ncC","outputs":[],"type":"function"},
+		{"inputs":[{"name":"dest","type":"address"},{"name":"value","type":"uint256"},{"name":"func","type":"bytes"}],"name":"executeCall","outputs":[],"type":"function"},
+		{"inputs":[{"name":"dest","type":"address[]"},{"name":"value","type":"uint256[]"},{"name":"func","type":"bytes[]"}],"name":"executeBatch","outputs":[],"type":"function"},
+		{"inputs":[{"name":"dest","type":"address[]"},{"name":"value","type":"uint256[]"},{"name":"func","type":"bytes[]"}],"name":"executeBatch_y6U","outputs":[],"type":"function"},
+		{"inputs":[{"name":"dest","type":"address[]"},{"name":"value","type":"uint256[]"},{"name":"func","type":"bytes[]"}],"name":"executeBatchCall","outputs":[],"type":"function"}
+	]`)
+
+	// wallet decoders in auto detection order
+	walletDecoders = []struct {
+		name   string
+		decode func(callData []byte) ([]Call, bool, error)
+	}{
+		{WalletSimpleAccount, decodeSimpleAccount},
+		{WalletSafe, decodeSafe},
+		{WalletKernel, decodeKernel},
+		{WalletBiconomy, decodeBiconomy},
+	}
+)
+
+func mustABI(definition string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(definition))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+// Call is an inner call made by the account.
+type Call struct {
+	Target common.Address
+	Value  *big.Int
+	Data   []byte
+	// DelegateCall is set for calls executed in the account context.
+	DelegateCall bool
+}
+
+// Selector returns the hex encoded 4-byte function selector, or "0x" for
+// plain transfers.
+func (c *Call) Selector() string {
+	if len(c.Data) < 4 {
+		return "0x"
+	}
+	return hexutil.Encode(c.Data[:4])
+}
+
+// DecodeCalls extracts the inner calls from the call data of a smart
+// account. wallet selects the account format, WalletAuto tries all of them.
+func DecodeCalls(callData []byte, wallet string) ([]Call, error) {
+	if len(callData) < 4 {
+		return nil, nil
+	}
+	for _, decoder := range walletDecoders {
+		if wallet != WalletAuto && wallet != decoder.name {
+			continue
+		}
+		calls, ok, err := decoder.decode(callData)
+		if ok {
+			return calls, err
+		}
+	}
+	if wallet != WalletAuto {
+		return nil, fmt.Errorf("unsupported %s account call %s", wallet, hexutil.Encode(callData[:4]))
+	}
+	return nil, fmt.Errorf("unsupported account call %s", hexutil.Encode(callData[:4]))
+}
+
+// unpack decodes callData with the matching method of parsed and reports
+// whether the selector belongs to parsed.
+func unpack(parsed abi.ABI, callData []byte) (*abi.Method, []any, bool, error) {
+	method, err := parsed.MethodById(callData[:4])
+	if err != nil {
+		return nil, nil, false, nil
+	}
+	args, err := method.Inputs.Unpack(callData[4:])
+	if err != nil {
+		return method, nil, true, fmt.Errorf("decode %s: %w", method.Name, err)
+	}
+	return method, args, true, nil
+}
+
+func decodeSimpleAccount(callData []byte) ([]Call, bool, error) {
+	method, args, ok, err := unpack(simpleAccountABI, callData)
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+	if method.Name == "execute" {
+		return []Call{{Target: args[0].(common.Address), Value: args[1].(*big.Int), Data: args[2].([]byte)}}, true, nil
+	}
+	targets, datas := args[0].([]common.Address), args[1].([][]byte)
+	if len(targets) != len(datas) {
+		return nil, true, fmt.Errorf("%s: %d targets with %d calls", method.Name, len(targets), len(datas))
+	}
+	calls := make([]Call, len(targets))
+	for i := range targets {
+		calls[i] = Call{Target: targets[i], Value: new(big.Int), Data: datas[i]}
+	}
+	return calls, true, nil
+}
+
+func decodeSafe(callData []byte) ([]Call, bool, error) {
+	_, args, ok, err := unpack(safeABI, callData)
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+	return []Call{{Target: args[0].(common.Address), Value: args[1].(*big.Int), Data: args[2].([]byte), DelegateCall: args[3].(uint8) == 1}}, true, nil
+}
+
+// decodeMultiSend decodes the batch of a delegatecall into a MultiSend or
+// MultiSendCallOnly contract.
+func decodeMultiSend(callData []byte) ([]Call, error) {
+	if len(callData) < 4 {
+		return nil, fmt.Errorf("multiSend: missing call")
+	}
+	_, args, ok, err := unpack(multiSendABI, callData)
+	if !ok {
+		return nil, fmt.Errorf("multiSend: unsupported call %s", hexutil.Encode(callData[:4]))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return splitMultiSend(args[0].([]byte))
+}
+
+// splitMultiSend splits MultiSend packed transactions, each encoded as
+// operation (1 byte), to (20), value (32), data length (32) and data.
+func splitMultiSend(packed []byte) ([]Call, error) {
+	var calls []Call
+	for len(packed) > 0 {
+		if len(packed) < 85 {
+			return nil, fmt.Errorf("multiSend: truncated transaction")
+		}
+		length := new(big.Int).SetBytes(packed[53:85])
+		if !length.IsUint64() || length.Uint64() > uint64(len(packed)-85) {
+			return nil, fmt.Errorf("multiSend: invalid data length")
+		}
+		end := 85 + int(length.Uint64())
+		calls = append(calls, Call{
+			Target:       common.BytesToAddress(packed[1:21]),
+			Value:        new(big.Int).SetBytes(packed[21:53]),
+			Data:         common.CopyBytes(packed[85:end]),
+			DelegateCall: packed[0] == 1,
+		})
+		packed = packed[end:]
+	}
+	return calls, nil
+}
+
+func decodeKernel(callData []byte) ([]Call, bool, error) {
+	method, args, ok, err := unpack(kernelABI, callData)
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+	if method.Name == "execute" {
+		return []Call{{Target: args[0].(common.Address), Value: args[1].(*big.Int), Data: args[2].([]byte), DelegateCall: args[3].(uint8) == 1}}, true, nil
+	}
+	batch := args[0].([]struct {
+		To    common.Address `json:"to"`
+		Value *big.Int       `json:"value"`
+		Data  []byte         `json:"data"`
+	})
+	calls := make([]Call, len(batch))
+	for i, c := range batch {
+		calls[i] = Call{Target: c.To, Value: c.Value, Data: c.Data}
+	}
+	return calls, true, nil
+}
+
+func decodeBiconomy(callData []byte) ([]Call, bool, error) {
+	method, args, ok, err := unpack(biconomyABI, callData)
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+	if len(method.Inputs) == 3 && method.Inputs[0].Type.T == abi.AddressTy {
+		return []Call{{Target: args[0].(common.Address), Value: args[1].(*big.Int), Data: args[2].([]byte)}}, true, nil
+	}
+	targets, values, datas := args[0].([]common.Address), args[1].([]*big.Int), args[2].([][]byte)
+	if len(targets) != len(datas) || (len(values) != 0 && len(values) != len(targets)) {
+		return nil, true, fmt.Errorf("%s: mismatched batch lengths", method.Name)
+	}
+	calls := make([]Call, len(targets))
+	for i := range targets {
+		value := new(big.Int)
+		if len(values) != 0 {
+			value = values[i]
+		}
+		calls[i] = Call{Target: targets[i], Value: value, Data: datas[i]}
+	}
+	return calls, true, nil
+}
@@ -0,0 +1,84 @@
+package policy
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/types"
+)
+
+var (
+	multiSend = common.HexToAddress("0x38869bf66a61cF6bDB996A6aE40D5853Fd43B526")
+	token     = common.HexToAddress("0x9b5BF39E3aF8F2B1f1F3b5F9C2B5D8f3B0f6aC12")
+)
+
+// safeBatch is the call data of a Safe delegating a multiSend of a token
+// transfer to batcher.
+func safeBatch(t *testing.T, batcher common.Address) []byte {
+	transfer := common.FromHex("0xa9059cbb")
+	packed := []byte{0}
+	packed = append(packed, token.Bytes()...)
+	packed = append(packed, common.LeftPadBytes(nil, 32)...)
+	packed = append(packed, common.LeftPadBytes(big.NewInt(int64(len(transfer))).Bytes(), 32)...)
+	packed = append(packed, transfer...)
+	data, err := multiSendABI.Pack("multiSend", packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callData, err := safeABI.Pack("executeUserOp", batcher, new(big.Int), data, uint8(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return callData
+}
+
+func safeRequest(t *testing.T, batcher common.Address) *Request {
+	return &Request{
+		Op:        &types.UserOperation{Sender: common.HexToAddress("0x01"), CallData: safeBatch(t, batcher)},
+		Wallet:    WalletSafe,
+		MultiSend: map[common.Address]bool{multiSend: true},
+	}
+}
+
+func TestSafeMultiSendExpanded(t *testing.T) {
+	req := safeRequest(t, multiSend)
+	calls, err := req.Calls()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 1 || calls[0].Target != token || calls[0].DelegateCall || calls[0].Selector() != "0xa9059cbb" {
+		t.Fatalf("unexpected calls %+v", calls)
+	}
+	allowlist := NewTargetAllowlist([]models.PolicyTarget{{Address: token.Hex()}}, "")
+	if err := allowlist.Check(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSafeDelegateCallToFakeMultiSend(t *testing.T) {
+	fake := common.HexToAddress("0x00000000000000000000000000000000000bad")
+	req := safeRequest(t, fake)
+	calls, err := req.Calls()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 1 || calls[0].Target != fake || !calls[0].DelegateCall {
+		t.Fatalf("delegatecall to %s expanded: %+v", fake.Hex(), calls)
+	}
+
+	allowlist := NewTargetAllowlist([]models.PolicyTarget{{Address: token.Hex()}, {Address: fake.Hex()}}, "")
+	err = allowlist.Check(context.Background(), req)
+	if rpcErr, ok := err.(*rpcerrors.RPCError); !ok || rpcErr.Reason() != rpcerrors.REASON_DELEGATECALL {
+		t.Fatalf("expected %s rejection, got %v", rpcerrors.REASON_DELEGATECALL, err)
+	}
+	values := NewValueLimits(nil, "0", "", "")
+	err = values.Check(context.Background(), req)
+	if rpcErr, ok := err.(*rpcerrors.RPCError); !ok || rpcErr.Reason() != rpcerrors.REASON_DELEGATECALL {
+		t.Fatalf("expected %s rejection, got %v", rpcerrors.REASON_DELEGATECALL, err)
+	}
+}
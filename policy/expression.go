@@ -0,0 +1,196 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/utils"
+)
+
+var (
+	celEnv     *cel.Env
+	celEnvErr  error
+	celEnvOnce sync.Once
+
+	// compiled programs keyed by expression source
+	programs sync.Map
+)
+
+func env() (*cel.Env, error) {
+	celEnvOnce.Do(func() {
+		celEnv, celEnvErr = cel.NewEnv(
+			cel.Variable("op", cel.MapType(cel.StringType, cel.DynType)),
+			cel.Variable("account", cel.MapType(cel.StringType, cel.DynType)),
+			cel.Variable("calls", cel.ListType(cel.MapType(cel.StringType, cel.DynType))),
+			cel.Variable("chainId", cel.IntType),
+			cel.Variable("maxGasCost", cel.DoubleType),
+			cel.Variable("now", cel.TimestampType),
+		)
+	})
+	return celEnv, celEnvErr
+}
+
+// Compile parses and type checks a policy expression, which must evaluate to
+// a bool.
+func Compile(expression string) (cel.Program, error) {
+	if prg, ok := programs.Load(expression); ok {
+		return prg.(cel.Program), nil
+	}
+	e, err := env()
+	if err != nil {
+		return nil, err
+	}
+	ast, iss := e.Compile(expression)
+	if iss != nil && iss.Err() != nil {
+		return nil, iss.Err()
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("policy expression must return bool, got %s", ast.OutputType())
+	}
+	prg, err := e.Program(ast)
+	if err != nil {
+		return nil, err
+	}
+	programs.Store(expression, prg)
+	return prg, nil
+}
+
+// Expression evaluates a CEL expression against the operation and the
+// sender account. The operation is sponsored only when it returns true.
+type Expression struct {
+	Source  string
+	program cel.Program
+}
+
+func NewExpression(source string) (*Expression, error) {
+	prg, err := Compile(source)
+	if err != nil {
+		return nil, err
+	}
+	return &Expression{Source: source, program: prg}, nil
+}
+
+func (e *Expression) Check(ctx context.Context, req *Request) error {
+	out, _, err := e.program.ContextEval(ctx, Activation(req))
+	if err != nil {
+		return rpcerrors.RejectedByPaymaster(fmt.Sprintf("policy expression error: %s", err), rpcerrors.REASON_POLICY_REJECTED)
+	}
+	if approved, ok := out.Value().(bool); !ok || !approved {
+		return rpcerrors.RejectedByPaymaster("rejected by policy expression", rpcerrors.REASON_POLICY_REJECTED)
+	}
+	return nil
+}
+
+// Activation returns the variables available to policy expressions.
+func Activation(req *Request) map[string]any {
+	op := req.Op
+	selector := ""
+	if len(op.CallData) >= 4 {
+		selector = hexutil.Encode(op.CallData[:4])
+	}
+	vars := map[string]any{
+		"op": map[string]any{
+			"sender":               utils.LowerAddress(op.Sender),
+			"nonce":                bigToInt(op.Nonce),
+			"initCode":             op.InitCode,
+			"callData":             op.CallData,
+			"selector":             selector,
+			"factory":              factory(op.InitCode),
+			"callGasLimit":         bigToInt(op.CallGasLimit),
+			"verificationGasLimit": bigToInt(op.VerificationGasLimit),
+			"preVerificationGas":   bigToInt(op.PreVerificationGas),
+			"maxFeePerGas":         bigToInt(op.MaxFeePerGas),
+			"maxPriorityFeePerGas": bigToInt(op.MaxPriorityFeePerGas),
+		},
+		"account":    accountVars(req.Account),
+		"calls":      callVars(req),
+		"chainId":    bigToInt(req.ChainID),
+		"maxGasCost": bigToFloat(req.MaxGasCost),
+		"now":        time.Now(),
+	}
+	return vars
+}
+
+// callVars lazily exposes the decoded inner calls, so call data that cannot
+// be decoded only fails expressions referring to calls.
+func callVars(req *Request) func() ref.Val {
+	return func() ref.Val {
+		calls, err := req.Calls()
+		if err != nil {
+			return types.NewErr(err.Error())
+		}
+		return types.DefaultTypeAdapter.NativeToValue(callList(calls))
+	}
+}
+
+func callList(calls []Call) []any {
+	vars := make([]any, len(calls))
+	for n, call := range calls {
+		vars[n] = map[string]any{
+			"target":       utils.LowerAddress(call.Target),
+			"value":        bigToFloat(call.Value),
+			"selector":     call.Selector(),
+			"data":         call.Data,
+			"delegateCall": call.DelegateCall,
+		}
+	}
+	return vars
+}
+
+func accountVars(account *models.Account) map[string]any {
+	if account == nil {
+		return map[string]any{
+			"address":   "",
+			"vip":       false,
+			"remainGas": float64(0),
+			"usedGas":   float64(0),
+			"createdAt": time.Unix(0, 0),
+		}
+	}
+	return map[string]any{
+		"address":   account.Address,
+		"vip":       account.VipID != -1,
+		"remainGas": bigToFloat(models.ParseGas(account.RemainGas)),
+		"usedGas":   bigToFloat(models.ParseGas(account.UsedGas)),
+		"createdAt": account.CreatedAt,
+	}
+}
+
+func factory(initCode []byte) string {
+	if len(initCode) < 20 {
+		return ""
+	}
+	return hexutil.Encode(initCode[:20])
+}
+
+// bigToInt converts n to int64, saturating values that do not fit.
+func bigToInt(n *big.Int) int64 {
+	if n == nil {
+		return 0
+	}
+	if !n.IsInt64() {
+		if n.Sign() < 0 {
+			return -1 << 63
+		}
+		return 1<<63 - 1
+	}
+	return n.Int64()
+}
+
+func bigToFloat(n *big.Int) float64 {
+	if n == nil {
+		return 0
+	}
+	f, _ := new(big.Float).SetInt(n).Float64()
+	return f
+}
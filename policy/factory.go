@@ -0,0 +1,114 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// FactoryRegistry only sponsors account deployments through registered
+// factories whose on-chain code matches the recorded fingerprints. The
+// fingerprints are verified by Refresh, factories failing verification are
+// refused until they pass again.
+type FactoryRegistry struct {
+	client bind.ContractCaller
+	rep    db.Repository
+
+	mu       sync.RWMutex
+	verified map[common.Address]bool
+}
+
+func NewFactoryRegistry(client bind.ContractCaller, rep db.Repository) *FactoryRegistry {
+	return &FactoryRegistry{
+		client:   client,
+		rep:      rep,
+		verified: make(map[common.Address]bool),
+	}
+}
+
+func (r *FactoryRegistry) Check(ctx context.Context, req *Request) error {
+	if len(req.Op.InitCode) < common.AddressLength {
+		return nil
+	}
+	factory := common.BytesToAddress(req.Op.InitCode[:common.AddressLength])
+	r.mu.RLock()
+	verified, known := r.verified[factory]
+	r.mu.RUnlock()
+	if !known {
+		return rpcerrors.RejectedByPaymaster(fmt.Sprintf("unknown factory %s", factory.Hex()), rpcerrors.REASON_UNKNOWN_FACTORY)
+	}
+	if !verified {
+		return rpcerrors.RejectedByPaymaster(fmt.Sprintf("factory %s failed code verification", factory.Hex()), rpcerrors.REASON_UNKNOWN_FACTORY)
+	}
+	return nil
+}
+
+// Refresh reloads the registered factories and verifies their code hashes.
+func (r *FactoryRegistry) Refresh(ctx context.Context) error {
+	factories, err := (&models.Factory{}).FindAll(r.rep)
+	if err != nil {
+		return err
+	}
+	verified := make(map[common.Address]bool, len(factories))
+	for _, f := range factories {
+		address := common.HexToAddress(strings.TrimSpace(f.Address))
+		ok, err := r.verify(ctx, &f)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			logger.S().Warnf("Factory %s (%s) code does not match its fingerprint", address.Hex(), f.Name)
+		}
+		verified[address] = ok
+	}
+	r.mu.Lock()
+	r.verified = verified
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *FactoryRegistry) verify(ctx context.Context, f *models.Factory) (bool, error) {
+	ok, err := r.matchCode(ctx, f.Address, f.CodeHash)
+	if err != nil || !ok || f.Implementation == "" {
+		return ok, err
+	}
+	return r.matchCode(ctx, f.Implementation, f.ImplementationCodeHash)
+}
+
+func (r *FactoryRegistry) matchCode(ctx context.Context, address, codeHash string) (bool, error) {
+	code, err := r.client.CodeAt(ctx, common.HexToAddress(strings.TrimSpace(address)), nil)
+	if err != nil {
+		return false, err
+	}
+	if len(code) == 0 {
+		return false, nil
+	}
+	return crypto.Keccak256Hash(code) == common.HexToHash(strings.TrimSpace(codeHash)), nil
+}
+
+// Run refreshes the registry every interval until ctx is done.
+func (r *FactoryRegistry) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Refresh(ctx); err != nil {
+				logger.S().Errorf("Refresh factory registry error: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// FreeTier throttles the keys of projects without billing once they used
+// Ops operations or Gas gas cost in a UTC day, zero disables a limit.
+// Instead of failing, a throttled key is served one operation per Throttle
+// for the rest of the day.
+type FreeTier struct {
+	Rep      db.Repository
+	Ops      uint64
+	Gas      *big.Int
+	Throttle time.Duration
+}
+
+func (f *FreeTier) Check(ctx context.Context, req *Request) error {
+	if req.ApiKey == nil {
+		return nil
+	}
+	var user models.User
+	err := f.Rep.Model(&models.User{}).First(&user, req.ApiKey.UserID).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return err
+	}
+	if user.BillingEmail != "" {
+		return nil
+	}
+	now := time.Now()
+	usage, err := req.ApiKey.DailyUsage(f.Rep, now)
+	if err != nil {
+		return err
+	}
+	exceeded := (f.Ops > 0 && uint64(usage.Ops) >= f.Ops) || (f.Gas != nil && f.Gas.Sign() > 0 && usage.Gas.Cmp(f.Gas) >= 0)
+	if !exceeded {
+		return nil
+	}
+	wait := usage.Last.Add(f.Throttle).Sub(now)
+	if wait <= 0 {
+		return nil
+	}
+	retryAfter := int64((wait + time.Second - 1) / time.Second)
+	return rpcerrors.NewRPCError(
+		rpcerrors.RATE_LIMITED,
+		fmt.Sprintf("free tier exceeded, retry in %ds", retryAfter),
+		map[string]any{"reason": rpcerrors.REASON_FREE_TIER_THROTTLED, "retryAfter": retryAfter},
+	)
+}
@@ -0,0 +1,46 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// GasCaps limits callGasLimit by the function selectors the operation calls.
+// With several capped calls the lowest cap applies.
+type GasCaps struct {
+	caps map[string]*big.Int
+}
+
+func NewGasCaps(caps []models.PolicyGasCap) *GasCaps {
+	g := &GasCaps{caps: make(map[string]*big.Int, len(caps))}
+	for _, c := range caps {
+		g.caps[strings.ToLower(c.Selector)] = new(big.Int).SetUint64(c.MaxCallGas)
+	}
+	return g
+}
+
+func (g *GasCaps) Check(ctx context.Context, req *Request) error {
+	calls, err := req.Calls()
+	if err != nil {
+		return rpcerrors.RejectedByPaymaster(err.Error(), rpcerrors.REASON_POLICY_REJECTED)
+	}
+	for n, call := range calls {
+		selector := call.Selector()
+		limit, ok := g.caps[selector]
+		if !ok {
+			continue
+		}
+		if req.Op.CallGasLimit.Cmp(limit) > 0 {
+			return rpcerrors.RejectedByPaymaster(
+				fmt.Sprintf("call %d: callGasLimit %s exceeds cap %s for %s", n, req.Op.CallGasLimit, limit, selector),
+				rpcerrors.REASON_GAS_LIMIT,
+			)
+		}
+	}
+	return nil
+}
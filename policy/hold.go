@@ -0,0 +1,59 @@
+package policy
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/google/cel-go/cel"
+)
+
+// Hold is returned by checkers that want an operator to approve the
+// operation instead of rejecting it. Evaluate reports a hold only when no
+// other checker rejects the operation.
+type Hold struct {
+	Reason string
+}
+
+func (h *Hold) Error() string {
+	return "held for approval: " + h.Reason
+}
+
+// HoldRule holds operations costing at least Above or matching a CEL
+// expression.
+type HoldRule struct {
+	Above   *big.Int
+	Source  string
+	program cel.Program
+}
+
+// NewHoldRule returns a hold rule, an empty expression or nil above disables
+// that condition.
+func NewHoldRule(expression string, above *big.Int) (*HoldRule, error) {
+	rule := &HoldRule{Above: above, Source: expression}
+	if expression != "" {
+		prg, err := Compile(expression)
+		if err != nil {
+			return nil, err
+		}
+		rule.program = prg
+	}
+	return rule, nil
+}
+
+func (h *HoldRule) Check(ctx context.Context, req *Request) error {
+	if h.Above != nil && req.MaxGasCost.Cmp(h.Above) >= 0 {
+		return &Hold{Reason: "max gas cost above hold threshold"}
+	}
+	if h.program == nil {
+		return nil
+	}
+	out, _, err := h.program.ContextEval(ctx, Activation(req))
+	if err != nil {
+		// an operator decides when the expression cannot
+		return &Hold{Reason: "hold expression error: " + err.Error()}
+	}
+	if matched, ok := out.Value().(bool); ok && matched {
+		return &Hold{Reason: "hold expression matched"}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+)
+
+// NonceGap compares the nonce of an operation with EntryPoint getNonce for
+// its key. Operations reusing a nonce, or more than MaxGap ahead of the
+// account, cannot be included and are refused before quota is reserved,
+// with both nonces in the error data.
+type NonceGap struct {
+	Client bind.ContractCaller
+	MaxGap uint64
+}
+
+var seqMask = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 64), big.NewInt(1))
+
+func (n *NonceGap) Check(ctx context.Context, req *Request) error {
+	// the upper 192 bits are the nonce key, the lower 64 its sequence
+	key := new(big.Int).Rsh(req.Op.Nonce, 64)
+	data, err := entryPointABI.Pack("getNonce", req.Op.Sender, key)
+	if err != nil {
+		return err
+	}
+	out, err := n.Client.CallContract(ctx, ethereum.CallMsg{To: &req.EntryPoint, Data: data}, nil)
+	if err != nil {
+		return err
+	}
+	values, err := entryPointABI.Unpack("getNonce", out)
+	if err != nil {
+		return err
+	}
+	current := values[0].(*big.Int)
+	seq := new(big.Int).And(req.Op.Nonce, seqMask)
+	currentSeq := new(big.Int).And(current, seqMask)
+
+	var message string
+	switch gap := new(big.Int).Sub(seq, currentSeq); {
+	case gap.Sign() < 0:
+		message = fmt.Sprintf("nonce %s already used, the account expects %s", req.Op.Nonce, current)
+	case gap.Cmp(new(big.Int).SetUint64(n.MaxGap)) > 0:
+		message = fmt.Sprintf("nonce %s is %s ahead of the account nonce %s, earlier operations are missing", req.Op.Nonce, gap, current)
+	default:
+		return nil
+	}
+	return rpcerrors.NewRPCError(rpcerrors.REJECTED_BY_PAYMASTER, message, map[string]any{
+		"reason":       rpcerrors.REASON_NONCE_GAP,
+		"nonce":        hexutil.EncodeBig(req.Op.Nonce),
+		"accountNonce": hexutil.EncodeBig(current),
+	})
+}
@@ -0,0 +1,29 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+)
+
+// OpCostCap caps the gas cost sponsored for a single operation, so one
+// expensive operation cannot take an account's whole quota or an outsized
+// share of the deposit.
+type OpCostCap struct {
+	Max *big.Int
+}
+
+func (o *OpCostCap) Check(ctx context.Context, req *Request) error {
+	if o.Max == nil || o.Max.Sign() <= 0 {
+		return nil
+	}
+	if req.MaxGasCost.Cmp(o.Max) > 0 {
+		return rpcerrors.RejectedByPaymaster(
+			fmt.Sprintf("max gas cost %s exceeds ceiling %s", req.MaxGasCost, o.Max),
+			rpcerrors.REASON_OP_COST_LIMIT,
+		)
+	}
+	return nil
+}
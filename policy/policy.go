@@ -0,0 +1,166 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/types"
+)
+
+// Request is the operation under evaluation together with its context. Gas
+// fields of Op hold the limits that will be signed.
+type Request struct {
+	ApiKey     *models.ApiKeys
+	Account    *models.Account
+	Op         *types.UserOperation
+	ChainID    *big.Int
+	EntryPoint common.Address
+	// MaxGasCost is the gas cost charged against the sender quota.
+	MaxGasCost *big.Int
+	// Wallet is the account call data format, WalletAuto detects it from the
+	// selector.
+	Wallet string
+	// Aggregator is the signature aggregator the account validates with,
+	// zero without one or when the operation was not simulated.
+	Aggregator common.Address
+	// MultiSend are the trusted MultiSend and MultiSendCallOnly contracts,
+	// delegatecalls into them are expanded into their batch.
+	MultiSend map[common.Address]bool
+
+	calls    []Call
+	callsErr error
+	decoded  bool
+}
+
+// Calls returns the decoded inner calls of the operation. Batches are
+// expanded into their individual calls, including batches the account
+// makes into itself and Safe batches delegated to a MultiSend contract.
+// Delegatecalls into any other contract are kept as one call.
+func (r *Request) Calls() ([]Call, error) {
+	if !r.decoded {
+		r.calls, r.callsErr = r.expand(r.Op.CallData, 0)
+		r.decoded = true
+	}
+	return r.calls, r.callsErr
+}
+
+// maxCallDepth bounds the nesting of self calls expanded by Calls.
+const maxCallDepth = 4
+
+func (r *Request) expand(callData []byte, depth int) ([]Call, error) {
+	if depth > maxCallDepth {
+		return nil, fmt.Errorf("account calls nested deeper than %d", maxCallDepth)
+	}
+	calls, err := DecodeCalls(callData, r.Wallet)
+	if err != nil {
+		return nil, err
+	}
+	var expanded []Call
+	for _, call := range calls {
+		var inner []Call
+		switch {
+		case call.DelegateCall && r.MultiSend[call.Target]:
+			inner, err = decodeMultiSend(call.Data)
+		case call.Target == r.Op.Sender && len(call.Data) >= 4:
+			inner, err = r.expand(call.Data, depth+1)
+		default:
+			expanded = append(expanded, call)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, inner...)
+	}
+	return expanded, nil
+}
+
+// Checker is a single sponsorship rule. Rejections are returned as RPCErrors.
+type Checker interface {
+	Check(ctx context.Context, req *Request) error
+}
+
+// Build returns the checkers configured by p, in evaluation order. Local
+// rules run before the webhook.
+func Build(rep db.Repository, p *models.Policy) ([]Checker, error) {
+	if p == nil {
+		return nil, nil
+	}
+	switch p.Wallet {
+	case WalletAuto, WalletSimpleAccount, WalletSafe, WalletKernel, WalletBiconomy:
+	default:
+		return nil, fmt.Errorf("policy %d: unsupported wallet %q", p.ID, p.Wallet)
+	}
+	var checkers []Checker
+	if p.Expression != "" {
+		expression, err := NewExpression(p.Expression)
+		if err != nil {
+			return nil, fmt.Errorf("policy %d expression: %w", p.ID, err)
+		}
+		checkers = append(checkers, expression)
+	}
+	if len(p.Targets) > 0 {
+		checkers = append(checkers, NewTargetAllowlist(p.Targets, p.DelegateTargets))
+	}
+	if len(p.GasCaps) > 0 {
+		checkers = append(checkers, NewGasCaps(p.GasCaps))
+	}
+	if p.Aggregators != "" {
+		checkers = append(checkers, NewAggregatorAllowlist(p.Aggregators))
+	}
+	if p.MaxValuePerOp != "" || p.MaxValuePerDay != "" {
+		checkers = append(checkers, NewValueLimits(rep, p.MaxValuePerOp, p.MaxValuePerDay, p.DelegateTargets))
+	}
+	if len(p.Windows) > 0 {
+		windows, err := NewTimeWindows(p.Timezone, p.Windows)
+		if err != nil {
+			return nil, fmt.Errorf("policy %d: %w", p.ID, err)
+		}
+		checkers = append(checkers, windows)
+	}
+	if p.HoldExpression != "" || p.HoldAbove != "" {
+		var above *big.Int
+		if p.HoldAbove != "" {
+			var ok bool
+			if above, ok = new(big.Int).SetString(p.HoldAbove, 10); !ok {
+				return nil, fmt.Errorf("policy %d: invalid hold_above %q", p.ID, p.HoldAbove)
+			}
+		}
+		hold, err := NewHoldRule(p.HoldExpression, above)
+		if err != nil {
+			return nil, fmt.Errorf("policy %d hold expression: %w", p.ID, err)
+		}
+		checkers = append(checkers, hold)
+	}
+	if p.WebhookURL != "" {
+		checkers = append(checkers, NewWebhook(p.WebhookURL, p.WebhookTimeout))
+	}
+	return checkers, nil
+}
+
+// Evaluate runs checkers in order and returns the first rejection, or the
+// first *Hold when nothing rejects the operation.
+func Evaluate(ctx context.Context, checkers []Checker, req *Request) error {
+	var hold *Hold
+	for _, checker := range checkers {
+		err := checker.Check(ctx, req)
+		if h, ok := err.(*Hold); ok {
+			if hold == nil {
+				hold = h
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if hold != nil {
+		return hold
+	}
+	return nil
+}
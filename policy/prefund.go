@@ -0,0 +1,29 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+)
+
+// PrefundCeiling caps the prefund a single operation locks from the
+// paymaster deposit, independent of the sender quota.
+type PrefundCeiling struct {
+	Max *big.Int
+}
+
+func (p *PrefundCeiling) Check(ctx context.Context, req *Request) error {
+	if p.Max == nil || p.Max.Sign() <= 0 {
+		return nil
+	}
+	prefund := req.Op.GetRequiredPrefund(true)
+	if prefund.Cmp(p.Max) > 0 {
+		return rpcerrors.RejectedByPaymaster(
+			fmt.Sprintf("required prefund %s exceeds ceiling %s", prefund, p.Max),
+			rpcerrors.REASON_PREFUND_LIMIT,
+		)
+	}
+	return nil
+}
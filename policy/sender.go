@@ -0,0 +1,80 @@
+package policy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"github.com/ququzone/verifying-paymaster-service/contracts"
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+)
+
+var entryPointABI = mustABI(contracts.EntryPointABI)
+
+// CounterfactualSender verifies that operations deploying their account
+// use the sender address the factory will create. The address is obtained
+// from EntryPoint getSenderAddress, which runs the initCode and reverts
+// with the CREATE2 address of the account.
+type CounterfactualSender struct {
+	Client bind.ContractCaller
+}
+
+func (c *CounterfactualSender) Check(ctx context.Context, req *Request) error {
+	initCode := req.Op.InitCode
+	if len(initCode) == 0 {
+		return nil
+	}
+	if len(initCode) < common.AddressLength {
+		return rpcerrors.RejectedByPaymaster("initCode too short", rpcerrors.REASON_SENDER_MISMATCH)
+	}
+	sender, err := c.senderAddress(ctx, req.EntryPoint, initCode)
+	if err != nil {
+		return err
+	}
+	if sender != req.Op.Sender {
+		return rpcerrors.RejectedByPaymaster(
+			fmt.Sprintf("sender %s does not match initCode address %s", req.Op.Sender.Hex(), sender.Hex()),
+			rpcerrors.REASON_SENDER_MISMATCH,
+		)
+	}
+	return nil
+}
+
+func (c *CounterfactualSender) senderAddress(ctx context.Context, entryPoint common.Address, initCode []byte) (common.Address, error) {
+	data, err := entryPointABI.Pack("getSenderAddress", initCode)
+	if err != nil {
+		return common.Address{}, err
+	}
+	_, err = c.Client.CallContract(ctx, ethereum.CallMsg{To: &entryPoint, Data: data}, nil)
+	if err == nil {
+		return common.Address{}, rpcerrors.RejectedByPaymaster("getSenderAddress did not revert", rpcerrors.REASON_SENDER_MISMATCH)
+	}
+	dataErr, ok := err.(rpc.DataError)
+	if !ok {
+		return common.Address{}, err
+	}
+	hexData, _ := dataErr.ErrorData().(string)
+	revert, decodeErr := hexutil.Decode(hexData)
+	if decodeErr != nil {
+		return common.Address{}, err
+	}
+	result := entryPointABI.Errors["SenderAddressResult"]
+	if len(revert) < 4 || !bytes.Equal(revert[:4], result.ID[:4]) {
+		// the factory reverted, the operation cannot be deployed
+		return common.Address{}, rpcerrors.RejectedByPaymaster(
+			fmt.Sprintf("getSenderAddress failed: %s", err),
+			rpcerrors.REASON_SENDER_MISMATCH,
+		)
+	}
+	args, err := result.Inputs.Unpack(revert[4:])
+	if err != nil {
+		return common.Address{}, err
+	}
+	return args[0].(common.Address), nil
+}
@@ -0,0 +1,307 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/store"
+	"github.com/ququzone/verifying-paymaster-service/types"
+)
+
+// signRateSlots is the number of buckets the window of SignRate is kept in.
+const signRateSlots = 60
+
+// SignRate caps what is signed on a chain across all api keys, so a
+// coordinated drain through many keys or senders is slowed down even when
+// each operation passes its own policies. MaxCost caps the max gas cost in
+// wei and MaxGas the gas limits signed per Window, MaxBlockGas the gas
+// limits signed per chain head block. The totals are kept in States, shared
+// by the replicas: Check only reads the window totals, Reserve adds an
+// operation about to be signed to every total atomically.
+type SignRate struct {
+	States      store.Store
+	MaxCost     *big.Int
+	MaxGas      uint64
+	Window      time.Duration
+	MaxBlockGas uint64
+	// Paymaster is the dedicated paymaster the totals are kept for, empty
+	// for the shared one.
+	Paymaster string
+}
+
+// ForPaymaster returns the caps of s with totals of their own for the
+// dedicated paymaster contract.
+func (s *SignRate) ForPaymaster(paymaster string) *SignRate {
+	rate := *s
+	rate.Paymaster = paymaster
+	return &rate
+}
+
+// signBucket is the cost and gas signed in a Window/signRateSlots bucket.
+type signBucket struct {
+	Cost *big.Int `json:"cost"`
+	Gas  uint64   `json:"gas"`
+}
+
+// signBuckets are the buckets of the window by index.
+type signBuckets map[int64]*signBucket
+
+// signBlock is the gas signed at a chain head.
+type signBlock struct {
+	Number uint64 `json:"number"`
+	Gas    uint64 `json:"gas"`
+}
+
+// SignedGas is the gas limits of op counted by SignRate.
+func SignedGas(op *types.UserOperation) uint64 {
+	gas := new(big.Int).Add(op.CallGasLimit, op.VerificationGasLimit)
+	gas.Add(gas, op.PreVerificationGas)
+	if !gas.IsUint64() {
+		return ^uint64(0)
+	}
+	return gas.Uint64()
+}
+
+func (s *SignRate) windowed() bool {
+	return s.Window > 0 && (s.MaxGas > 0 || (s.MaxCost != nil && s.MaxCost.Sign() > 0))
+}
+
+// PerBlock reports whether the gas signed per block is capped, Reserve then
+// needs the chain head.
+func (s *SignRate) PerBlock() bool {
+	return s.MaxBlockGas > 0
+}
+
+func (s *SignRate) key(chainID *big.Int) string {
+	if s.Paymaster != "" {
+		return fmt.Sprintf("signrate:%s:%s:window", chainID, s.Paymaster)
+	}
+	return fmt.Sprintf("signrate:%s:window", chainID)
+}
+
+func (s *SignRate) blockKey(chainID *big.Int) string {
+	if s.Paymaster != "" {
+		return fmt.Sprintf("signrate:%s:%s:block", chainID, s.Paymaster)
+	}
+	return fmt.Sprintf("signrate:%s:block", chainID)
+}
+
+func (s *SignRate) width() int64 {
+	if width := int64(s.Window / signRateSlots); width > 0 {
+		return width
+	}
+	return 1
+}
+
+func (s *SignRate) bucket(t time.Time) int64 {
+	return t.UnixNano() / s.width()
+}
+
+// live decodes the buckets of value still in the window at now and sums
+// them.
+func (s *SignRate) live(value []byte, now time.Time) (signBuckets, *signBucket, error) {
+	buckets := make(signBuckets)
+	if value != nil {
+		if err := json.Unmarshal(value, &buckets); err != nil {
+			return nil, nil, err
+		}
+	}
+	oldest := s.bucket(now) - signRateSlots
+	total := &signBucket{Cost: new(big.Int)}
+	for n, b := range buckets {
+		if n <= oldest {
+			delete(buckets, n)
+			continue
+		}
+		if b.Cost != nil {
+			total.Cost.Add(total.Cost, b.Cost)
+		}
+		total.Gas += b.Gas
+	}
+	return buckets, total, nil
+}
+
+// exceeds reports whether adding gas and cost to total goes over a cap.
+func (s *SignRate) exceeds(total *signBucket, gas uint64, cost *big.Int) bool {
+	if s.MaxCost != nil && s.MaxCost.Sign() > 0 && new(big.Int).Add(total.Cost, cost).Cmp(s.MaxCost) > 0 {
+		return true
+	}
+	return s.MaxGas > 0 && total.Gas+gas > s.MaxGas
+}
+
+func rateLimited(retryAfter int64) error {
+	return rpcerrors.NewRPCError(
+		rpcerrors.RATE_LIMITED,
+		fmt.Sprintf("sponsorship rate exceeded, retry in %ds", retryAfter),
+		map[string]any{"reason": rpcerrors.REASON_SIGN_RATE_LIMITED, "retryAfter": retryAfter},
+	)
+}
+
+// rejected is the rejection of an operation over a window cap, retrying
+// once the oldest bucket leaves the window.
+func (s *SignRate) rejected(buckets signBuckets, now time.Time) error {
+	retryAfter := int64(1)
+	oldest := s.bucket(now)
+	for n := range buckets {
+		if n < oldest {
+			oldest = n
+		}
+	}
+	expires := time.Unix(0, (oldest+signRateSlots+1)*s.width())
+	if wait := expires.Sub(now); wait > time.Second {
+		retryAfter = int64((wait + time.Second - 1) / time.Second)
+	}
+	return rateLimited(retryAfter)
+}
+
+// Check rejects the operation when it would exceed a window cap. The block
+// cap is only enforced by Reserve.
+func (s *SignRate) Check(ctx context.Context, req *Request) error {
+	if !s.windowed() {
+		return nil
+	}
+	now := time.Now()
+	value, err := s.States.Get(ctx, s.key(req.ChainID))
+	if err != nil {
+		return err
+	}
+	buckets, signed, err := s.live(value, now)
+	if err != nil {
+		return err
+	}
+	if !s.exceeds(signed, SignedGas(req.Op), req.MaxGasCost) {
+		return nil
+	}
+	return s.rejected(buckets, now)
+}
+
+// Reserve adds gas and cost to the totals signed on chainID unless one
+// would exceed its cap, block being the chain head when PerBlock. The
+// returned release takes them back when the operation is not signed after
+// all.
+func (s *SignRate) Reserve(ctx context.Context, chainID *big.Int, block, gas uint64, cost *big.Int) (func(), error) {
+	releaseWindow, err := s.reserveWindow(ctx, chainID, gas, cost)
+	if err != nil {
+		return nil, err
+	}
+	releaseBlock, err := s.reserveBlock(ctx, chainID, block, gas)
+	if err != nil {
+		releaseWindow()
+		return nil, err
+	}
+	return func() {
+		releaseBlock()
+		releaseWindow()
+	}, nil
+}
+
+func (s *SignRate) reserveWindow(ctx context.Context, chainID *big.Int, gas uint64, cost *big.Int) (func(), error) {
+	if !s.windowed() {
+		return func() {}, nil
+	}
+	now := time.Now()
+	bucket := s.bucket(now)
+	err := s.States.Update(ctx, s.key(chainID), s.Window, func(value []byte) ([]byte, error) {
+		buckets, signed, err := s.live(value, now)
+		if err != nil {
+			return nil, err
+		}
+		if s.exceeds(signed, gas, cost) {
+			return nil, s.rejected(buckets, now)
+		}
+		b := buckets[bucket]
+		if b == nil {
+			b = &signBucket{Cost: new(big.Int)}
+			buckets[bucket] = b
+		}
+		b.Cost.Add(b.Cost, cost)
+		b.Gas += gas
+		return json.Marshal(buckets)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() { s.releaseWindow(chainID, bucket, gas, cost) }, nil
+}
+
+func (s *SignRate) releaseWindow(chainID *big.Int, bucket int64, gas uint64, cost *big.Int) {
+	err := s.States.Update(context.Background(), s.key(chainID), s.Window, func(value []byte) ([]byte, error) {
+		buckets, _, err := s.live(value, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if b := buckets[bucket]; b != nil {
+			b.Cost.Sub(b.Cost, cost)
+			if b.Gas > gas {
+				b.Gas -= gas
+			} else {
+				b.Gas = 0
+			}
+			if b.Cost.Sign() <= 0 && b.Gas == 0 {
+				delete(buckets, bucket)
+			}
+		}
+		return json.Marshal(buckets)
+	})
+	if err != nil {
+		logger.S().Errorf("release signed cost error: %v", err)
+	}
+}
+
+// blockTTL keeps the gas of a chain head long enough for slow chains, a new
+// head resets it anyway.
+const blockTTL = time.Hour
+
+func (s *SignRate) reserveBlock(ctx context.Context, chainID *big.Int, block, gas uint64) (func(), error) {
+	if !s.PerBlock() {
+		return func() {}, nil
+	}
+	err := s.States.Update(ctx, s.blockKey(chainID), blockTTL, func(value []byte) ([]byte, error) {
+		var signed signBlock
+		if value != nil {
+			if err := json.Unmarshal(value, &signed); err != nil {
+				return nil, err
+			}
+		}
+		if signed.Number < block {
+			// replicas behind the head keep adding to the newest block
+			signed = signBlock{Number: block}
+		}
+		if signed.Gas+gas > s.MaxBlockGas {
+			return nil, rateLimited(1)
+		}
+		signed.Gas += gas
+		return json.Marshal(signed)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() { s.releaseBlock(chainID, block, gas) }, nil
+}
+
+func (s *SignRate) releaseBlock(chainID *big.Int, block, gas uint64) {
+	err := s.States.Update(context.Background(), s.blockKey(chainID), blockTTL, func(value []byte) ([]byte, error) {
+		var signed signBlock
+		if value != nil {
+			if err := json.Unmarshal(value, &signed); err != nil {
+				return nil, err
+			}
+		}
+		if signed.Number == block {
+			if signed.Gas > gas {
+				signed.Gas -= gas
+			} else {
+				signed.Gas = 0
+			}
+		}
+		return json.Marshal(signed)
+	})
+	if err != nil {
+		logger.S().Errorf("release signed block gas error: %v", err)
+	}
+}
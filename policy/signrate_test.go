@@ -0,0 +1,135 @@
+package policy
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/store"
+	"github.com/ququzone/verifying-paymaster-service/types"
+)
+
+func signRateOp(gas int64) *types.UserOperation {
+	return &types.UserOperation{
+		CallGasLimit:         big.NewInt(gas),
+		VerificationGasLimit: new(big.Int),
+		PreVerificationGas:   new(big.Int),
+	}
+}
+
+func isSignRateLimited(err error) bool {
+	rpcErr, ok := err.(*rpcerrors.RPCError)
+	return ok && rpcErr.Reason() == rpcerrors.REASON_SIGN_RATE_LIMITED
+}
+
+func TestSignRateReserveConcurrent(t *testing.T) {
+	rate := &SignRate{States: store.NewMemory(), MaxCost: big.NewInt(10), Window: time.Minute}
+	chainID := big.NewInt(4690)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var releases []func()
+	for n := 0; n < 50; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := rate.Reserve(context.Background(), chainID, 0, 1, big.NewInt(1))
+			if err != nil {
+				if !isSignRateLimited(err) {
+					t.Errorf("unexpected error %v", err)
+				}
+				return
+			}
+			mu.Lock()
+			releases = append(releases, release)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if len(releases) != 10 {
+		t.Fatalf("reserved %d operations, want 10", len(releases))
+	}
+
+	req := &Request{ChainID: chainID, Op: signRateOp(1), MaxGasCost: big.NewInt(1)}
+	if err := rate.Check(context.Background(), req); err == nil {
+		t.Fatal("check passed over the cap")
+	}
+	releases[0]()
+	if err := rate.Check(context.Background(), req); err != nil {
+		t.Fatalf("check after release: %v", err)
+	}
+	if _, err := rate.Reserve(context.Background(), chainID, 0, 1, big.NewInt(1)); err != nil {
+		t.Fatalf("reserve after release: %v", err)
+	}
+}
+
+func TestSignRateGas(t *testing.T) {
+	rate := &SignRate{States: store.NewMemory(), MaxGas: 100000, Window: time.Minute}
+	chainID := big.NewInt(4690)
+	ctx := context.Background()
+
+	release, err := rate.Reserve(ctx, chainID, 0, 60000, big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &Request{ChainID: chainID, Op: signRateOp(50000), MaxGasCost: big.NewInt(1)}
+	if err := rate.Check(ctx, req); !isSignRateLimited(err) {
+		t.Fatalf("check over the gas cap: %v", err)
+	}
+	if _, err := rate.Reserve(ctx, chainID, 0, 50000, big.NewInt(1)); !isSignRateLimited(err) {
+		t.Fatalf("reserve over the gas cap: %v", err)
+	}
+	release()
+	if _, err := rate.Reserve(ctx, chainID, 0, 50000, big.NewInt(1)); err != nil {
+		t.Fatalf("reserve after release: %v", err)
+	}
+}
+
+func TestSignRateBlock(t *testing.T) {
+	rate := &SignRate{States: store.NewMemory(), MaxBlockGas: 100000}
+	chainID := big.NewInt(4690)
+	ctx := context.Background()
+
+	if _, err := rate.Reserve(ctx, chainID, 10, 60000, big.NewInt(1)); err != nil {
+		t.Fatal(err)
+	}
+	release, err := rate.Reserve(ctx, chainID, 10, 40000, big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rate.Reserve(ctx, chainID, 10, 1, big.NewInt(1)); !isSignRateLimited(err) {
+		t.Fatalf("reserve over the block cap: %v", err)
+	}
+	// a replica behind the head counts against the newest block
+	if _, err := rate.Reserve(ctx, chainID, 9, 1, big.NewInt(1)); !isSignRateLimited(err) {
+		t.Fatalf("reserve behind the head: %v", err)
+	}
+	if _, err := rate.Reserve(ctx, chainID, 11, 100000, big.NewInt(1)); err != nil {
+		t.Fatalf("reserve at the next block: %v", err)
+	}
+	// releasing the gas of a past block leaves the new block alone
+	release()
+	if _, err := rate.Reserve(ctx, chainID, 11, 1, big.NewInt(1)); !isSignRateLimited(err) {
+		t.Fatalf("reserve after the release of a past block: %v", err)
+	}
+}
+
+func TestSignRateForPaymaster(t *testing.T) {
+	shared := &SignRate{States: store.NewMemory(), MaxGas: 100000, Window: time.Minute}
+	dedicated := shared.ForPaymaster("0x7a2f000000000000000000000000000000000000")
+	chainID := big.NewInt(4690)
+	ctx := context.Background()
+
+	if _, err := shared.Reserve(ctx, chainID, 0, 100000, big.NewInt(1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dedicated.Reserve(ctx, chainID, 0, 100000, big.NewInt(1)); err != nil {
+		t.Fatalf("dedicated paymaster limited by the shared total: %v", err)
+	}
+	if _, err := dedicated.Reserve(ctx, chainID, 0, 1, big.NewInt(1)); !isSignRateLimited(err) {
+		t.Fatalf("reserve over the dedicated cap: %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/utils"
+)
+
+// ValueLimits caps the native value sent by the inner calls of an operation
+// and the value a sender moves through sponsored operations per day. The
+// value a delegatecall moves is unknown, only delegate targets are trusted
+// with them.
+type ValueLimits struct {
+	PerOp     *big.Int
+	PerDay    *big.Int
+	delegates map[common.Address]bool
+	rep       db.Repository
+}
+
+func NewValueLimits(rep db.Repository, perOp, perDay, delegateTargets string) *ValueLimits {
+	return &ValueLimits{PerOp: parseWei(perOp), PerDay: parseWei(perDay), delegates: AddressSet(delegateTargets), rep: rep}
+}
+
+// parseWei returns nil for empty or invalid amounts.
+func parseWei(value string) *big.Int {
+	n, ok := new(big.Int).SetString(strings.TrimSpace(value), 10)
+	if !ok || n.Sign() < 0 {
+		return nil
+	}
+	return n
+}
+
+// TotalValue sums the native value of calls.
+func TotalValue(calls []Call) *big.Int {
+	total := new(big.Int)
+	for _, call := range calls {
+		if call.Value != nil {
+			total.Add(total, call.Value)
+		}
+	}
+	return total
+}
+
+func (v *ValueLimits) Check(ctx context.Context, req *Request) error {
+	if v.PerOp == nil && v.PerDay == nil {
+		return nil
+	}
+	calls, err := req.Calls()
+	if err != nil {
+		return rpcerrors.RejectedByPaymaster(err.Error(), rpcerrors.REASON_VALUE_LIMIT)
+	}
+	for n, call := range calls {
+		if err := checkDelegateCall(n, call, v.delegates); err != nil {
+			return err
+		}
+	}
+	value := TotalValue(calls)
+	if v.PerOp != nil && value.Cmp(v.PerOp) > 0 {
+		return rpcerrors.RejectedByPaymaster(
+			fmt.Sprintf("value %s exceeds per operation limit %s", value, v.PerOp),
+			rpcerrors.REASON_VALUE_LIMIT,
+		)
+	}
+	if v.PerDay == nil || value.Sign() == 0 {
+		return nil
+	}
+	now := time.Now()
+	spent, err := (&models.Sponsorship{}).ValueSince(v.rep, utils.LowerAddress(req.Op.Sender), now.Add(-24*time.Hour), now)
+	if err != nil {
+		return err
+	}
+	if total := new(big.Int).Add(spent, value); total.Cmp(v.PerDay) > 0 {
+		return rpcerrors.RejectedByPaymaster(
+			fmt.Sprintf("value %s exceeds daily limit %s, %s already sent", value, v.PerDay, spent),
+			rpcerrors.REASON_VALUE_LIMIT,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/types"
+)
+
+const defaultWebhookTimeout = 2 * time.Second
+
+type webhookRequest struct {
+	ApiKeyID      uint                 `json:"apiKeyId"`
+	UserOperation *types.UserOperation `json:"userOperation"`
+	ChainID       string               `json:"chainId"`
+	EntryPoint    string               `json:"entryPoint"`
+	MaxGasCost    string               `json:"maxGasCost"`
+}
+
+type webhookResponse struct {
+	Approved bool `json:"approved"`
+	// Hold asks an operator to approve the operation.
+	Hold   bool   `json:"hold"`
+	Reason string `json:"reason"`
+}
+
+// Webhook asks an external endpoint to approve each operation. Operations
+// are rejected when the endpoint fails or does not answer in time.
+type Webhook struct {
+	URL     string
+	Timeout time.Duration
+	Client  *http.Client
+}
+
+// NewWebhook returns a webhook checker, timeout is in milliseconds.
+func NewWebhook(url string, timeout int64) *Webhook {
+	t := time.Duration(timeout) * time.Millisecond
+	if t <= 0 {
+		t = defaultWebhookTimeout
+	}
+	return &Webhook{
+		URL:     url,
+		Timeout: t,
+		Client:  http.DefaultClient,
+	}
+}
+
+func (w *Webhook) Check(ctx context.Context, req *Request) error {
+	ctx, cancel := context.WithTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	body, err := json.Marshal(&webhookRequest{
+		ApiKeyID:      req.ApiKey.ID,
+		UserOperation: req.Op,
+		ChainID:       req.ChainID.String(),
+		EntryPoint:    req.EntryPoint.Hex(),
+		MaxGasCost:    req.MaxGasCost.String(),
+	})
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.Client.Do(httpReq)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return rpcerrors.RejectedByPaymaster("policy webhook timeout", rpcerrors.REASON_POLICY_TIMEOUT)
+		}
+		logger.S().Warnf("policy webhook %s error: %v", w.URL, err)
+		return rpcerrors.RejectedByPaymaster("policy webhook unavailable", rpcerrors.REASON_POLICY_REJECTED)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		logger.S().Warnf("policy webhook %s status: %s", w.URL, resp.Status)
+		return rpcerrors.RejectedByPaymaster("policy webhook unavailable", rpcerrors.REASON_POLICY_REJECTED)
+	}
+
+	var decision webhookResponse
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return rpcerrors.RejectedByPaymaster("policy webhook timeout", rpcerrors.REASON_POLICY_TIMEOUT)
+		}
+		return rpcerrors.RejectedByPaymaster("invalid policy webhook response", rpcerrors.REASON_POLICY_REJECTED)
+	}
+	if decision.Hold {
+		reason := decision.Reason
+		if reason == "" {
+			reason = "held by policy webhook"
+		}
+		return &Hold{Reason: reason}
+	}
+	if !decision.Approved {
+		message := decision.Reason
+		if message == "" {
+			message = "rejected by policy"
+		}
+		return rpcerrors.RejectedByPaymaster(message, rpcerrors.REASON_POLICY_REJECTED)
+	}
+	return nil
+}
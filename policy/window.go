@@ -0,0 +1,133 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	// the runtime image ships without a zoneinfo database
+	_ "time/tzdata"
+
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// window is a daily time range on some weekdays, in minutes since midnight.
+// A range with end before start runs over midnight into the next day.
+type window struct {
+	days     [7]bool
+	start    int
+	end      int
+	blackout bool
+}
+
+// contains reports whether t, in the time zone of the windows, is inside w.
+func (w *window) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if w.start <= w.end {
+		return w.days[t.Weekday()] && minute >= w.start && minute < w.end
+	}
+	if w.days[t.Weekday()] && minute >= w.start {
+		return true
+	}
+	return w.days[(t.Weekday()+6)%7] && minute < w.end
+}
+
+// TimeWindows limits sponsoring to the allowed windows, any time when there
+// are none, outside of the blackout windows.
+type TimeWindows struct {
+	location  *time.Location
+	allowed   []window
+	blackouts []window
+	now       func() time.Time
+}
+
+func NewTimeWindows(timezone string, windows []models.PolicyWindow) (*TimeWindows, error) {
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("timezone %q: %w", timezone, err)
+	}
+	t := &TimeWindows{location: location, now: time.Now}
+	for _, w := range windows {
+		parsed, err := parseWindow(&w)
+		if err != nil {
+			return nil, fmt.Errorf("window %d: %w", w.ID, err)
+		}
+		if w.Blackout {
+			t.blackouts = append(t.blackouts, *parsed)
+		} else {
+			t.allowed = append(t.allowed, *parsed)
+		}
+	}
+	return t, nil
+}
+
+func parseWindow(w *models.PolicyWindow) (*window, error) {
+	parsed := &window{blackout: w.Blackout}
+	if strings.TrimSpace(w.Days) == "" {
+		parsed.days = [7]bool{true, true, true, true, true, true, true}
+	}
+	for _, day := range strings.Split(w.Days, ",") {
+		day = strings.ToLower(strings.TrimSpace(day))
+		if day == "" {
+			continue
+		}
+		weekday, ok := weekdays[day]
+		if !ok {
+			return nil, fmt.Errorf("invalid day %q", day)
+		}
+		parsed.days[weekday] = true
+	}
+	var err error
+	if parsed.start, err = parseClock(w.Start); err != nil {
+		return nil, err
+	}
+	if parsed.end, err = parseClock(w.End); err != nil {
+		return nil, err
+	}
+	if parsed.start == parsed.end {
+		return nil, fmt.Errorf("empty window %s-%s", w.Start, w.End)
+	}
+	return parsed, nil
+}
+
+// parseClock parses HH:MM into minutes since midnight, 24:00 is the end of
+// the day.
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err == nil {
+		return t.Hour()*60 + t.Minute(), nil
+	}
+	if value == "24:00" {
+		return 24 * 60, nil
+	}
+	return 0, fmt.Errorf("invalid time %q", value)
+}
+
+func (t *TimeWindows) Check(ctx context.Context, req *Request) error {
+	now := t.now().In(t.location)
+	for n := range t.blackouts {
+		if t.blackouts[n].contains(now) {
+			return rpcerrors.RejectedByPaymaster("sponsoring paused in a blackout window", rpcerrors.REASON_OUTSIDE_WINDOW)
+		}
+	}
+	if len(t.allowed) == 0 {
+		return nil
+	}
+	for n := range t.allowed {
+		if t.allowed[n].contains(now) {
+			return nil
+		}
+	}
+	return rpcerrors.RejectedByPaymaster("outside of the sponsoring windows", rpcerrors.REASON_OUTSIDE_WINDOW)
+}
@@ -0,0 +1,357 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/jsonrpc"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/store"
+)
+
+const (
+	// smoothing is the weight of the last minute in the baseline averages.
+	smoothing = 0.1
+	// maxSenders bounds the distinct senders tracked per minute.
+	maxSenders = 1000
+	// syncInterval reloads the active throttles, so lifted throttles and
+	// those of other replicas apply.
+	syncInterval = 30 * time.Second
+)
+
+type FingerprintConfig struct {
+	// Factor is how far above its baseline a fingerprint's requests or
+	// distinct senders per minute must go to be throttled.
+	Factor float64
+	// MinRequests and MinSenders per minute are never throttled.
+	MinRequests float64
+	MinSenders  float64
+	// Warmup is the minutes of history a baseline needs.
+	Warmup int
+	// ThrottleFor is the duration of a throttle.
+	ThrottleFor time.Duration
+}
+
+// profile is the behavior of a fingerprint, counts of the current minute
+// and moving averages of the minutes before.
+type profile struct {
+	Minute      int64     `json:"minute"`
+	Requests    float64   `json:"requests"`
+	Senders     float64   `json:"senders"`
+	AvgRequests float64   `json:"avgRequests"`
+	AvgSenders  float64   `json:"avgSenders"`
+	Minutes     int       `json:"minutes"`
+	Last        time.Time `json:"last"`
+}
+
+// roll folds the counts of the previous minute into the averages when now
+// is in a later minute. Minutes without requests count as zero.
+func (p *profile) roll(now time.Time) {
+	minute := now.Unix() / 60
+	if minute == p.Minute {
+		return
+	}
+	if p.Minute != 0 {
+		p.AvgRequests += smoothing * (p.Requests - p.AvgRequests)
+		p.AvgSenders += smoothing * (p.Senders - p.AvgSenders)
+		gap := minute - p.Minute - 1
+		if gap > 0 {
+			decay := math.Pow(1-smoothing, float64(gap))
+			p.AvgRequests *= decay
+			p.AvgSenders *= decay
+		}
+		p.Minutes += int(minute - p.Minute)
+	}
+	p.Minute = minute
+	p.Requests = 0
+	p.Senders = 0
+}
+
+// Fingerprints tracks clients by IP, user agent and api key and throttles
+// those whose request rate or sender distribution deviates sharply from
+// their own history. Profiles and throttles are kept in the store shared by
+// the replicas.
+type Fingerprints struct {
+	conf  *FingerprintConfig
+	rep   db.Repository
+	store store.Store
+	mu    sync.Mutex
+	// synced are the throttles loaded by the last sync
+	synced map[string]bool
+}
+
+func NewFingerprints(conf *FingerprintConfig, rep db.Repository, st store.Store) *Fingerprints {
+	if conf.Factor <= 1 {
+		conf.Factor = 10
+	}
+	if conf.Warmup <= 0 {
+		conf.Warmup = 10
+	}
+	if conf.ThrottleFor == 0 {
+		conf.ThrottleFor = 15 * time.Minute
+	}
+	return &Fingerprints{
+		conf:   conf,
+		rep:    rep,
+		store:  st,
+		synced: make(map[string]bool),
+	}
+}
+
+// Fingerprint identifies a client by IP, user agent and api key.
+func Fingerprint(ip, userAgent, key string) string {
+	sum := sha256.Sum256([]byte(ip + "\n" + userAgent + "\n" + key))
+	return hex.EncodeToString(sum[:16])
+}
+
+func profileKey(fp string) string {
+	return "fingerprint:profile:" + fp
+}
+
+func sendersKey(fp string, minute int64) string {
+	return fmt.Sprintf("fingerprint:senders:%s:%d", fp, minute)
+}
+
+func throttleKey(fp string) string {
+	return "fingerprint:throttle:" + fp
+}
+
+// Run reloads the active throttles until ctx is cancelled.
+func (f *Fingerprints) Run(ctx context.Context) {
+	for {
+		if err := f.sync(ctx, time.Now()); err != nil {
+			logger.S().Errorf("load throttles error: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(syncInterval):
+		}
+	}
+}
+
+// Reload applies the throttles changed by operators, as announced on the
+// cache bus.
+func (f *Fingerprints) Reload(fingerprint string) {
+	if err := f.sync(context.Background(), time.Now()); err != nil {
+		logger.S().Errorf("reload throttle %s error: %v", fingerprint, err)
+	}
+}
+
+// sync stores the active throttles and removes those lifted since the last
+// sync.
+func (f *Fingerprints) sync(ctx context.Context, now time.Time) error {
+	throttles, err := models.ActiveThrottles(f.rep, now)
+	if err != nil {
+		return err
+	}
+	throttled := make(map[string]time.Time, len(throttles))
+	for _, t := range throttles {
+		if t.Until.After(throttled[t.Fingerprint]) {
+			throttled[t.Fingerprint] = t.Until
+		}
+	}
+	for fp, until := range throttled {
+		if err := f.throttle(ctx, fp, until, now); err != nil {
+			return err
+		}
+	}
+	f.mu.Lock()
+	previous := f.synced
+	f.synced = make(map[string]bool, len(throttled))
+	for fp := range throttled {
+		f.synced[fp] = true
+	}
+	f.mu.Unlock()
+	for fp := range previous {
+		if _, ok := throttled[fp]; ok {
+			continue
+		}
+		if err := f.store.Delete(ctx, throttleKey(fp)); err != nil {
+			return err
+		}
+		// a lifted throttle starts over from the baseline
+		err := f.store.Update(ctx, profileKey(fp), time.Hour, func(value []byte) ([]byte, error) {
+			var p profile
+			if value != nil {
+				if err := json.Unmarshal(value, &p); err != nil {
+					return nil, err
+				}
+			}
+			p.Requests = 0
+			p.Senders = 0
+			return json.Marshal(&p)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Fingerprints) throttle(ctx context.Context, fp string, until, now time.Time) error {
+	if !until.After(now) {
+		return nil
+	}
+	return f.store.Set(ctx, throttleKey(fp), []byte(strconv.FormatInt(until.UnixNano(), 10)), until.Sub(now))
+}
+
+// observe counts a request of the fingerprint and returns a throttle when
+// it deviates from the baseline.
+func (f *Fingerprints) observe(ctx context.Context, fp, sender string, now time.Time) (*models.Throttle, error) {
+	senders := -1
+	if sender != "" {
+		n, err := f.store.AddMember(ctx, sendersKey(fp, now.Unix()/60), sender, maxSenders, 2*time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		senders = n
+	}
+	var p profile
+	// the baseline of an idle fingerprint has decayed to nothing after an
+	// hour
+	err := f.store.Update(ctx, profileKey(fp), time.Hour, func(value []byte) ([]byte, error) {
+		p = profile{}
+		if value != nil {
+			if err := json.Unmarshal(value, &p); err != nil {
+				return nil, err
+			}
+		}
+		p.roll(now)
+		p.Last = now
+		p.Requests++
+		if float64(senders) > p.Senders {
+			p.Senders = float64(senders)
+		}
+		return json.Marshal(&p)
+	})
+	if err != nil || p.Minutes < f.conf.Warmup {
+		return nil, err
+	}
+
+	var t *models.Throttle
+	switch {
+	case p.Requests > math.Max(f.conf.MinRequests, f.conf.Factor*p.AvgRequests):
+		t = &models.Throttle{Reason: "requests", Observed: p.Requests, Baseline: p.AvgRequests}
+	case p.Senders > math.Max(f.conf.MinSenders, f.conf.Factor*p.AvgSenders):
+		t = &models.Throttle{Reason: "senders", Observed: p.Senders, Baseline: p.AvgSenders}
+	default:
+		return nil, nil
+	}
+	t.Fingerprint = fp
+	t.Until = now.Add(f.conf.ThrottleFor)
+	return t, f.throttle(ctx, fp, t.Until, now)
+}
+
+func (f *Fingerprints) until(ctx context.Context, fp string, now time.Time) (time.Time, bool, error) {
+	value, err := f.store.Get(ctx, throttleKey(fp))
+	if err != nil || value == nil {
+		return time.Time{}, false, err
+	}
+	nanos, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	until := time.Unix(0, nanos)
+	return until, now.Before(until), nil
+}
+
+// senderOf returns the sender of the user operation in a JSON-RPC request,
+// empty when the request has none.
+func senderOf(body []byte) string {
+	var req struct {
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || len(req.Params) == 0 {
+		return ""
+	}
+	var op struct {
+		Sender string `json:"sender"`
+	}
+	if err := json.Unmarshal(req.Params[0], &op); err != nil {
+		return ""
+	}
+	return strings.ToLower(op.Sender)
+}
+
+// Middleware refuses requests of throttled fingerprints and throttles
+// fingerprints deviating from their baseline. It must run before
+// jsonrpc.Process since it restores the request body after reading it.
+func (f *Fingerprints) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ip, userAgent, key := c.ClientIP(), c.Request.UserAgent(), c.Param("key")
+		fp := Fingerprint(ip, userAgent, key)
+		ctx := c.Request.Context()
+		now := time.Now()
+		until, ok, err := f.until(ctx, fp, now)
+		if err != nil {
+			logger.S().Errorf("fingerprint store error: %v", err)
+		}
+		if ok {
+			abortThrottled(c, until.Sub(now))
+			return
+		}
+
+		var sender string
+		if c.Request.Body != nil {
+			body, err := io.ReadAll(c.Request.Body)
+			if err != nil {
+				c.Next()
+				return
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+			sender = senderOf(body)
+		}
+		t, err := f.observe(ctx, fp, sender, now)
+		if err != nil {
+			logger.S().Errorf("fingerprint store error: %v", err)
+		}
+		if t == nil {
+			c.Next()
+			return
+		}
+
+		t.IP = ip
+		t.UserAgent = userAgent
+		if len(t.UserAgent) > 255 {
+			t.UserAgent = t.UserAgent[:255]
+		}
+		apiKey, err := (&models.ApiKeys{}).FindByKey(f.rep, key)
+		if err != nil {
+			logger.S().Errorf("Query api key error: %v", err)
+		}
+		if apiKey != nil {
+			t.ApiKeyID = apiKey.ID
+		}
+		logger.S().Warnf("Throttling fingerprint %s of api key %d from %s for %s: %.0f %s per minute, baseline %.1f",
+			fp, t.ApiKeyID, ip, f.conf.ThrottleFor, t.Observed, t.Reason, t.Baseline)
+		if err := f.rep.Create(t).Error; err != nil {
+			logger.S().Errorf("save throttle error: %v", err)
+		}
+		abortThrottled(c, f.conf.ThrottleFor)
+	}
+}
+
+func abortThrottled(c *gin.Context, wait time.Duration) {
+	retryAfter := int64(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", fmt.Sprint(retryAfter))
+	jsonrpc.Abort(c, errors.RATE_LIMITED, "client temporarily throttled", map[string]any{
+		"reason":     errors.REASON_FINGERPRINT_THROTTLED,
+		"retryAfter": retryAfter,
+	})
+}
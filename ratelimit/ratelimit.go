@@ -0,0 +1,142 @@
+// Package ratelimit throttles rpc requests by client IP, before the api key
+// is looked at, and by client fingerprint.
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/jsonrpc"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/store"
+)
+
+// idleAfter drops the state of clients without requests for this long.
+const idleAfter = 10 * time.Minute
+
+type Config struct {
+	// Rate is the sustained requests per second of a client IP, Burst the
+	// requests it can make at once.
+	Rate  float64
+	Burst int
+	// MaxKeyFailures unknown or disabled api keys within BanFor ban the IP
+	// for BanFor, zero disables bans.
+	MaxKeyFailures int
+	BanFor         time.Duration
+}
+
+type client struct {
+	Tokens   float64   `json:"tokens"`
+	Last     time.Time `json:"last"`
+	Failures int       `json:"failures"`
+	// FailedAt is when the current failure count started
+	FailedAt    time.Time `json:"failedAt"`
+	BannedUntil time.Time `json:"bannedUntil"`
+}
+
+// Limiter is a token bucket per client IP, kept in the store shared by the
+// replicas. Clients guessing api keys are banned for a while.
+type Limiter struct {
+	conf  *Config
+	store store.Store
+}
+
+func NewLimiter(conf *Config, st store.Store) *Limiter {
+	if conf.Burst <= 0 {
+		conf.Burst = 1
+	}
+	if conf.BanFor == 0 {
+		conf.BanFor = 15 * time.Minute
+	}
+	return &Limiter{conf: conf, store: st}
+}
+
+func clientKey(ip string) string {
+	return "ratelimit:ip:" + ip
+}
+
+// update applies fn to the state of ip, a new client when it has none.
+func (l *Limiter) update(ctx context.Context, ip string, fn func(c *client)) error {
+	return l.store.Update(ctx, clientKey(ip), idleAfter+l.conf.BanFor, func(value []byte) ([]byte, error) {
+		c := &client{Tokens: float64(l.conf.Burst), Last: time.Now()}
+		if value != nil {
+			if err := json.Unmarshal(value, c); err != nil {
+				return nil, err
+			}
+		}
+		fn(c)
+		return json.Marshal(c)
+	})
+}
+
+// allow takes a token of ip and returns how long to wait when there is
+// none.
+func (l *Limiter) allow(ctx context.Context, ip string, now time.Time) (time.Duration, error) {
+	var wait time.Duration
+	err := l.update(ctx, ip, func(c *client) {
+		wait = 0
+		if now.Before(c.BannedUntil) {
+			wait = c.BannedUntil.Sub(now)
+			return
+		}
+		c.Tokens = math.Min(float64(l.conf.Burst), c.Tokens+math.Max(0, now.Sub(c.Last).Seconds())*l.conf.Rate)
+		c.Last = now
+		if c.Tokens < 1 {
+			wait = time.Duration((1 - c.Tokens) / l.conf.Rate * float64(time.Second))
+			return
+		}
+		c.Tokens--
+	})
+	return wait, err
+}
+
+// fail counts a request of ip with an unknown api key.
+func (l *Limiter) fail(ctx context.Context, ip string, now time.Time) error {
+	if l.conf.MaxKeyFailures <= 0 {
+		return nil
+	}
+	return l.update(ctx, ip, func(c *client) {
+		if now.Sub(c.FailedAt) > l.conf.BanFor {
+			c.Failures = 0
+			c.FailedAt = now
+		}
+		c.Failures++
+		if c.Failures >= l.conf.MaxKeyFailures {
+			logger.S().Warnf("Banning %s for %s after %d unknown api keys", ip, l.conf.BanFor, c.Failures)
+			c.BannedUntil = now.Add(l.conf.BanFor)
+			c.Failures = 0
+		}
+	})
+}
+
+// Middleware rejects requests over the rate of their client IP, as resolved
+// by gin from the trusted proxies. Requests are let through when the store
+// fails.
+func (l *Limiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		ip := c.ClientIP()
+		wait, err := l.allow(ctx, ip, time.Now())
+		if err != nil {
+			logger.S().Errorf("rate limit store error: %v", err)
+		}
+		if wait > 0 {
+			retryAfter := int64(math.Ceil(wait.Seconds()))
+			c.Header("Retry-After", fmt.Sprint(retryAfter))
+			jsonrpc.Abort(c, errors.RATE_LIMITED, "too many requests", map[string]any{"reason": errors.REASON_RATE_LIMITED, "retryAfter": retryAfter})
+			return
+		}
+		c.Next()
+		if c.GetBool(jsonrpc.KeyRejected) {
+			if err := l.fail(ctx, ip, time.Now()); err != nil {
+				logger.S().Errorf("rate limit store error: %v", err)
+			}
+		}
+	}
+}
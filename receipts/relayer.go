@@ -0,0 +1,125 @@
+package receipts
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/ququzone/verifying-paymaster-service/chain"
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// ReceiptABI is the minting interface expected from the receipt contract.
+const ReceiptABI = `[{"inputs":[{"name":"to","type":"address[]"},{"name":"userOpHashes","type":"bytes32[]"}],"name":"mintBatch","outputs":[],"stateMutability":"nonpayable","type":"function"}]`
+
+type Config struct {
+	Contract common.Address
+	// RelayerKey pays for the mint transactions.
+	RelayerKey *ecdsa.PrivateKey
+	ChainID    *big.Int
+	// StartBlock skips sponsorships included before it.
+	StartBlock uint64
+	// BatchSize is the maximum number of receipts minted per transaction.
+	BatchSize    int
+	PollInterval time.Duration
+}
+
+// Relayer mints a receipt NFT to the sender of every sponsorship included
+// on its chain.
+// Receipts are batched into mintBatch transactions sent by a relayer EOA,
+// a sponsorship is marked once its batch has been mined successfully.
+type Relayer struct {
+	conf     *Config
+	client   chain.Client
+	rep      db.Repository
+	contract *bind.BoundContract
+}
+
+func NewRelayer(conf *Config, client chain.Client, rep db.Repository) (*Relayer, error) {
+	parsed, err := abi.JSON(strings.NewReader(ReceiptABI))
+	if err != nil {
+		return nil, err
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = 100
+	}
+	if conf.PollInterval == 0 {
+		conf.PollInterval = time.Minute
+	}
+	return &Relayer{
+		conf:     conf,
+		client:   client,
+		rep:      rep,
+		contract: bind.NewBoundContract(conf.Contract, parsed, client, client, client),
+	}, nil
+}
+
+// Run mints pending receipts until ctx is cancelled.
+func (r *Relayer) Run(ctx context.Context) {
+	logger.S().Infof("Receipt relayer started for chain %s contract %s", r.conf.ChainID, r.conf.Contract)
+	for {
+		minted, err := r.step(ctx)
+		if err != nil {
+			logger.S().Errorf("receipt relayer error: %v", err)
+		}
+		if err == nil && minted == r.conf.BatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.conf.PollInterval):
+		}
+	}
+}
+
+// step mints the next batch of receipts and returns its size.
+func (r *Relayer) step(ctx context.Context) (int, error) {
+	var recs []models.Sponsorship
+	err := r.rep.Where(`"chain_id" = ? AND "status" = ? AND "receipt_tx_hash" = '' AND "block_number" >= ?`, r.conf.ChainID.Uint64(), models.SponsorshipIncluded, r.conf.StartBlock).
+		Order("block_number").Limit(r.conf.BatchSize).Find(&recs).Error
+	if err != nil || len(recs) == 0 {
+		return 0, err
+	}
+
+	to := make([]common.Address, len(recs))
+	hashes := make([][32]byte, len(recs))
+	for n, rec := range recs {
+		to[n] = common.HexToAddress(rec.Sender)
+		hashes[n] = common.HexToHash(rec.UserOpHash)
+	}
+	opts, err := bind.NewKeyedTransactorWithChainID(r.conf.RelayerKey, r.conf.ChainID)
+	if err != nil {
+		return 0, err
+	}
+	opts.Context = ctx
+	tx, err := r.contract.Transact(opts, "mintBatch", to, hashes)
+	if err != nil {
+		return 0, err
+	}
+	logger.S().Infof("Minting %d sponsorship receipts in %s", len(recs), tx.Hash().Hex())
+
+	receipt, err := bind.WaitMined(ctx, r.client, tx)
+	if err != nil {
+		return 0, err
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return 0, fmt.Errorf("mint transaction %s reverted", tx.Hash().Hex())
+	}
+	ids := make([]uint, len(recs))
+	for n, rec := range recs {
+		ids[n] = rec.ID
+	}
+	err = r.rep.Model(&models.Sponsorship{}).Where(`"id" IN ?`, ids).Update("receipt_tx_hash", tx.Hash().Hex()).Error
+	return len(recs), err
+}
@@ -0,0 +1,168 @@
+package recorder
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ququzone/verifying-paymaster-service/logger"
+)
+
+// Record is a single sanitized request/response pair written as one JSON line.
+type Record struct {
+	Time time.Time `json:"time"`
+	// Key is a stable label derived from the api key, never the key itself.
+	Key       string          `json:"key"`
+	Method    string          `json:"method"`
+	Params    json.RawMessage `json:"params"`
+	ErrorCode int             `json:"errorCode,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+type Recorder struct {
+	file       *os.File
+	records    chan *Record
+	sampleRate float64
+	done       chan struct{}
+}
+
+func NewRecorder(path string, sampleRate float64) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{
+		file:       file,
+		records:    make(chan *Record, 1024),
+		sampleRate: sampleRate,
+		done:       make(chan struct{}),
+	}
+	go r.loop()
+	return r, nil
+}
+
+func (r *Recorder) loop() {
+	defer close(r.done)
+	w := bufio.NewWriter(r.file)
+	enc := json.NewEncoder(w)
+	flush := time.NewTicker(time.Second)
+	defer flush.Stop()
+	for {
+		select {
+		case rec, ok := <-r.records:
+			if !ok {
+				_ = w.Flush()
+				return
+			}
+			if err := enc.Encode(rec); err != nil {
+				logger.S().Errorf("Write capture record error: %v", err)
+			}
+		case <-flush.C:
+			_ = w.Flush()
+		}
+	}
+}
+
+// Close flushes pending records and closes the capture file.
+func (r *Recorder) Close() error {
+	close(r.records)
+	<-r.done
+	return r.file.Close()
+}
+
+func KeyLabel(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:4])
+}
+
+type bodyWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// Middleware captures JSON-RPC requests for later replay. It must run before
+// jsonrpc.Process since it restores the request body after reading it.
+func (r *Recorder) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Body == nil || rand.Float64() >= r.sampleRate {
+			c.Next()
+			return
+		}
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.Next()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		writer := &bodyWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		var req struct {
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil || req.Method == "" {
+			return
+		}
+		rec := &Record{
+			Time:   time.Now(),
+			Key:    KeyLabel(c.Param("key")),
+			Method: req.Method,
+			Params: sanitize(req.Params),
+		}
+		var resp struct {
+			Error *struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(writer.body.Bytes(), &resp); err == nil && resp.Error != nil {
+			rec.ErrorCode = resp.Error.Code
+			rec.Error = resp.Error.Message
+		}
+
+		select {
+		case r.records <- rec:
+		default:
+			logger.S().Warnf("Capture queue full, dropping %s record", rec.Method)
+		}
+	}
+}
+
+// sanitize blanks user operation signatures while keeping their length, so
+// replays still exercise the same gas calculations.
+func sanitize(params json.RawMessage) json.RawMessage {
+	var values []any
+	if err := json.Unmarshal(params, &values); err != nil {
+		return params
+	}
+	for _, v := range values {
+		op, ok := v.(map[string]any)
+		if !ok {
+			continue
+		}
+		if sig, ok := op["signature"].(string); ok && len(sig) > 2 {
+			op["signature"] = "0x" + string(bytes.Repeat([]byte("0"), len(sig)-2))
+		}
+	}
+	sanitized, err := json.Marshal(values)
+	if err != nil {
+		return params
+	}
+	return sanitized
+}
@@ -0,0 +1,137 @@
+package recorder
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/jsonrpc"
+)
+
+type ReplayConfig struct {
+	File string
+	URL  string
+	// Keys maps capture key labels to staging api keys, "*" matches any label.
+	Keys    map[string]string
+	Methods []string
+	Rate    float64
+	Timeout time.Duration
+}
+
+type ReplayResult struct {
+	Total     int
+	Skipped   int
+	Unchanged int
+	// Changed counts outcome transitions such as "ok -> -32501 insufficient gas".
+	Changed map[string]int
+}
+
+func outcome(code int, message string) string {
+	if code == 0 {
+		return "ok"
+	}
+	return fmt.Sprintf("%d %s", code, message)
+}
+
+// Replay re-sends captured requests against a target deployment and compares
+// each outcome to the one recorded in production.
+func Replay(ctx context.Context, conf *ReplayConfig) (*ReplayResult, error) {
+	file, err := os.Open(conf.File)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	methods := make(map[string]bool)
+	for _, m := range conf.Methods {
+		if m = strings.TrimSpace(m); m != "" {
+			methods[m] = true
+		}
+	}
+	var ticker *time.Ticker
+	if conf.Rate > 0 {
+		ticker = time.NewTicker(time.Duration(float64(time.Second) / conf.Rate))
+		defer ticker.Stop()
+	}
+
+	client := &http.Client{Timeout: conf.Timeout}
+	base := strings.TrimRight(conf.URL, "/")
+	result := &ReplayResult{Changed: make(map[string]int)}
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) == 0 && err == io.EOF {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, fmt.Errorf("invalid capture record: %w", err)
+		}
+		result.Total++
+		key, ok := conf.Keys[rec.Key]
+		if !ok {
+			key, ok = conf.Keys["*"]
+		}
+		if !ok || (len(methods) > 0 && !methods[rec.Method]) {
+			result.Skipped++
+			continue
+		}
+		var params []any
+		if err := json.Unmarshal(rec.Params, &params); err != nil {
+			result.Skipped++
+			continue
+		}
+
+		if ticker != nil {
+			select {
+			case <-ctx.Done():
+				return result, ctx.Err()
+			case <-ticker.C:
+			}
+		}
+		err = jsonrpc.Call(ctx, client, fmt.Sprintf("%s/rpc/%s", base, key), rec.Method, params, nil)
+		after := "ok"
+		if err != nil {
+			if rpcErr, ok := err.(*errors.RPCError); ok {
+				after = outcome(rpcErr.Code(), rpcErr.Error())
+			} else {
+				after = "transport error: " + err.Error()
+			}
+		}
+		before := outcome(rec.ErrorCode, rec.Error)
+		if before == after {
+			result.Unchanged++
+		} else {
+			result.Changed[before+" -> "+after]++
+		}
+	}
+	return result, nil
+}
+
+func (r *ReplayResult) Print(w io.Writer) {
+	fmt.Fprintf(w, "replayed %d, skipped %d, unchanged %d\n", r.Total-r.Skipped, r.Skipped, r.Unchanged)
+	if len(r.Changed) == 0 {
+		return
+	}
+	changes := make([]string, 0, len(r.Changed))
+	for change := range r.Changed {
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return r.Changed[changes[i]] > r.Changed[changes[j]] })
+	fmt.Fprintf(w, "\nchanged outcomes:\n")
+	for _, change := range changes {
+		fmt.Fprintf(w, "  %8d  %s\n", r.Changed[change], change)
+	}
+}
@@ -0,0 +1,200 @@
+// Package report detects senders and api keys with abnormal usage growth.
+package report
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// Alert is the payload posted to the anomaly webhook.
+type Alert struct {
+	Kind    string  `json:"kind"`
+	Subject string  `json:"subject"`
+	Spend   string  `json:"spend"`
+	Mean    string  `json:"mean"`
+	ZScore  float64 `json:"zScore"`
+}
+
+type Config struct {
+	// Window is the number of trailing days the last 24 hours are compared to.
+	Window int
+	// ZScore is the deviation from the trailing average reported as anomaly.
+	ZScore float64
+	// Webhook receives anomaly alerts, empty only logs them.
+	Webhook  string
+	Interval time.Duration
+}
+
+// Detector compares the spend of every sender and api key in the last 24
+// hours with its trailing daily average and reports each anomaly once per
+// day.
+type Detector struct {
+	conf   *Config
+	rep    db.Repository
+	client *http.Client
+}
+
+func NewDetector(conf *Config, rep db.Repository) *Detector {
+	if conf.Window < 2 {
+		conf.Window = 14
+	}
+	if conf.ZScore <= 0 {
+		conf.ZScore = 3
+	}
+	if conf.Interval == 0 {
+		conf.Interval = time.Hour
+	}
+	return &Detector{
+		conf:   conf,
+		rep:    rep,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Run checks for anomalies every interval until ctx is cancelled.
+func (d *Detector) Run(ctx context.Context) {
+	for {
+		if err := d.check(ctx, time.Now()); err != nil {
+			logger.S().Errorf("anomaly detector error: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.conf.Interval):
+		}
+	}
+}
+
+func (d *Detector) check(ctx context.Context, now time.Time) error {
+	day := now.UTC().Format("2006-01-02")
+	for _, kind := range []string{models.SubjectSender, models.SubjectApiKey} {
+		spends, err := models.DailySpends(d.rep, kind, now, d.conf.Window+1)
+		if err != nil {
+			return err
+		}
+		for _, alert := range d.detect(kind, spends) {
+			exists, err := (&models.Anomaly{}).Exists(d.rep, day, kind, alert.Subject)
+			if err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+			if err := d.notify(ctx, alert); err != nil {
+				// retried on the next check
+				logger.S().Warnf("anomaly webhook for %s %s error: %v", kind, alert.Subject, err)
+				continue
+			}
+			err = d.rep.Create(&models.Anomaly{
+				Day:     day,
+				Kind:    kind,
+				Subject: alert.Subject,
+				Spend:   alert.Spend,
+				Mean:    alert.Mean,
+				ZScore:  alert.ZScore,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// detect returns the subjects whose spend in the current bucket is ZScore
+// standard deviations above the mean of the trailing buckets. Subjects
+// without history are skipped, a flat history uses its mean as deviation.
+func (d *Detector) detect(kind string, spends []models.DailySpend) []*Alert {
+	history := make(map[string][]float64)
+	current := make(map[string]*big.Int)
+	for _, s := range spends {
+		if s.Age < 0 || s.Age > d.conf.Window {
+			continue
+		}
+		if history[s.Subject] == nil {
+			history[s.Subject] = make([]float64, d.conf.Window)
+		}
+		gas := models.ParseGas(s.TotalGas)
+		if s.Age == 0 {
+			current[s.Subject] = gas
+			continue
+		}
+		history[s.Subject][s.Age-1], _ = new(big.Float).SetInt(gas).Float64()
+	}
+
+	var alerts []*Alert
+	for subject, spend := range current {
+		mean, stddev := meanStdDev(history[subject])
+		if mean == 0 {
+			continue
+		}
+		if stddev == 0 {
+			stddev = mean
+		}
+		value, _ := new(big.Float).SetInt(spend).Float64()
+		z := (value - mean) / stddev
+		if z < d.conf.ZScore {
+			continue
+		}
+		meanInt, _ := big.NewFloat(mean).Int(nil)
+		alerts = append(alerts, &Alert{
+			Kind:    kind,
+			Subject: subject,
+			Spend:   spend.String(),
+			Mean:    meanInt.String(),
+			ZScore:  math.Round(z*100) / 100,
+		})
+	}
+	return alerts
+}
+
+func meanStdDev(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	var variance float64
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)))
+}
+
+func (d *Detector) notify(ctx context.Context, alert *Alert) error {
+	logger.S().Warnf("Abnormal usage of %s %s: spent %s wei in 24h, trailing average %s (z=%.2f)", alert.Kind, alert.Subject, alert.Spend, alert.Mean, alert.ZScore)
+	if d.conf.Webhook == "" {
+		return nil
+	}
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.conf.Webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+// Package screening checks senders against a compliance screening provider,
+// caching its decisions.
+package screening
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	rpcerrors "github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/metrics"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/policy"
+	"github.com/ququzone/verifying-paymaster-service/utils"
+)
+
+type Config struct {
+	// URL receives POST {"address"} and answers {"allowed", "reason"}.
+	URL   string
+	Token string
+	// TTL is how long a provider decision is reused.
+	TTL     time.Duration
+	Timeout time.Duration
+	// FailOpen sponsors senders when the provider fails instead of
+	// refusing them.
+	FailOpen bool
+}
+
+// Screener refuses senders the provider or an operator override blocks.
+type Screener struct {
+	conf   *Config
+	rep    db.Repository
+	client *http.Client
+}
+
+func New(conf *Config, rep db.Repository) *Screener {
+	if conf.TTL == 0 {
+		conf.TTL = 24 * time.Hour
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = 3 * time.Second
+	}
+	return &Screener{conf: conf, rep: rep, client: &http.Client{Timeout: conf.Timeout}}
+}
+
+// Decide returns the decision of address, from the cache while it is
+// valid.
+func (s *Screener) Decide(ctx context.Context, address string) (*models.ScreeningDecision, error) {
+	now := time.Now()
+	cached, err := models.FindScreening(s.rep, address)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil && cached.Valid(now) {
+		if cached.Source == models.ScreeningOverride {
+			metrics.ObserveScreening(metrics.ScreeningOverride, 0)
+		} else {
+			metrics.ObserveScreening(metrics.ScreeningHit, 0)
+		}
+		return cached, nil
+	}
+
+	decision, err := s.screen(ctx, address)
+	if err != nil {
+		metrics.ObserveScreening(metrics.ScreeningError, time.Since(now))
+		return nil, err
+	}
+	metrics.ObserveScreening(metrics.ScreeningMiss, time.Since(now))
+	decision.Address = address
+	decision.ExpiresAt = now.Add(s.conf.TTL)
+	if err := models.SaveScreening(s.rep, decision); err != nil {
+		logger.S().Errorf("save screening decision error: %v", err)
+	}
+	return decision, nil
+}
+
+func (s *Screener) screen(ctx context.Context, address string) (*models.ScreeningDecision, error) {
+	body, err := json.Marshal(map[string]string{"address": address})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.conf.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.conf.Token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("screening status %d", resp.StatusCode)
+	}
+	var result struct {
+		Allowed *bool  `json:"allowed"`
+		Reason  string `json:"reason"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if result.Allowed == nil {
+		return nil, fmt.Errorf("screening response without allowed")
+	}
+	if len(result.Reason) > 255 {
+		result.Reason = result.Reason[:255]
+	}
+	return &models.ScreeningDecision{Allowed: *result.Allowed, Reason: result.Reason}, nil
+}
+
+// Check refuses operations of blocked senders.
+func (s *Screener) Check(ctx context.Context, req *policy.Request) error {
+	sender := utils.LowerAddress(req.Op.Sender)
+	decision, err := s.Decide(ctx, sender)
+	if err != nil {
+		logger.S().Warnf("screening %s error: %v", sender, err)
+		if s.conf.FailOpen {
+			return nil
+		}
+		return rpcerrors.RejectedByPaymaster("compliance screening unavailable", rpcerrors.REASON_SCREENING_UNAVAILABLE)
+	}
+	if decision.Allowed {
+		return nil
+	}
+	return rpcerrors.RejectedByPaymaster("sender blocked by compliance screening, appeal with pm_appealScreening", rpcerrors.REASON_SCREENING_BLOCKED)
+}
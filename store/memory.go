@@ -0,0 +1,131 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often expired keys are dropped.
+const sweepInterval = time.Minute
+
+type subscriber struct {
+	channel string
+	fn      func(message string)
+}
+
+type entry struct {
+	value   []byte
+	members map[string]struct{}
+	expires time.Time
+}
+
+// Memory is a Store private to the process.
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]*entry
+	swept   time.Time
+	subs    map[*subscriber]bool
+}
+
+func NewMemory() *Memory {
+	return &Memory{entries: make(map[string]*entry), subs: make(map[*subscriber]bool)}
+}
+
+// live returns the unexpired entry of key, the lock must be held.
+func (m *Memory) live(key string, now time.Time) *entry {
+	if now.Sub(m.swept) >= sweepInterval {
+		m.swept = now
+		for k, e := range m.entries {
+			if !now.Before(e.expires) {
+				delete(m.entries, k)
+			}
+		}
+	}
+	e, ok := m.entries[key]
+	if !ok || !now.Before(e.expires) {
+		return nil
+	}
+	return e
+}
+
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e := m.live(key, time.Now()); e != nil {
+		return e.value, nil
+	}
+	return nil, nil
+}
+
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = &entry{value: value, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+
+func (m *Memory) Update(ctx context.Context, key string, ttl time.Duration, fn func(value []byte) ([]byte, error)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	var current []byte
+	if e := m.live(key, now); e != nil {
+		current = e.value
+	}
+	value, err := fn(current)
+	if err != nil {
+		return err
+	}
+	m.entries[key] = &entry{value: value, expires: now.Add(ttl)}
+	return nil
+}
+
+func (m *Memory) AddMember(ctx context.Context, key, member string, max int, ttl time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	e := m.live(key, now)
+	if e == nil || e.members == nil {
+		e = &entry{members: make(map[string]struct{})}
+		m.entries[key] = e
+	}
+	if len(e.members) < max {
+		e.members[member] = struct{}{}
+		e.expires = now.Add(ttl)
+	}
+	return len(e.members), nil
+}
+
+func (m *Memory) Publish(ctx context.Context, channel, message string) error {
+	m.mu.Lock()
+	var fns []func(string)
+	for sub := range m.subs {
+		if sub.channel == channel {
+			fns = append(fns, sub.fn)
+		}
+	}
+	m.mu.Unlock()
+	for _, fn := range fns {
+		fn(message)
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe(ctx context.Context, channel string, fn func(message string)) {
+	sub := &subscriber{channel: channel, fn: fn}
+	m.mu.Lock()
+	m.subs[sub] = true
+	m.mu.Unlock()
+	<-ctx.Done()
+	m.mu.Lock()
+	delete(m.subs, sub)
+	m.mu.Unlock()
+}
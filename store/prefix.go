@@ -0,0 +1,47 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// prefixed keeps its keys and channels apart from those of other users of
+// the same store by prefixing them.
+type prefixed struct {
+	store  Store
+	prefix string
+}
+
+// WithPrefix returns a view of st whose keys and channels are prefixed with
+// prefix, so that several networks can share one Redis.
+func WithPrefix(st Store, prefix string) Store {
+	return &prefixed{store: st, prefix: prefix}
+}
+
+func (p *prefixed) Get(ctx context.Context, key string) ([]byte, error) {
+	return p.store.Get(ctx, p.prefix+key)
+}
+
+func (p *prefixed) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return p.store.Set(ctx, p.prefix+key, value, ttl)
+}
+
+func (p *prefixed) Delete(ctx context.Context, key string) error {
+	return p.store.Delete(ctx, p.prefix+key)
+}
+
+func (p *prefixed) Update(ctx context.Context, key string, ttl time.Duration, fn func(value []byte) ([]byte, error)) error {
+	return p.store.Update(ctx, p.prefix+key, ttl, fn)
+}
+
+func (p *prefixed) AddMember(ctx context.Context, key, member string, max int, ttl time.Duration) (int, error) {
+	return p.store.AddMember(ctx, p.prefix+key, member, max, ttl)
+}
+
+func (p *prefixed) Publish(ctx context.Context, channel, message string) error {
+	return p.store.Publish(ctx, p.prefix+channel, message)
+}
+
+func (p *prefixed) Subscribe(ctx context.Context, channel string, fn func(message string)) {
+	p.store.Subscribe(ctx, p.prefix+channel, fn)
+}
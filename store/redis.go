@@ -0,0 +1,418 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/logger"
+)
+
+const (
+	// redisTimeout bounds a command without a context deadline.
+	redisTimeout = 2 * time.Second
+	// redisIdle is the number of idle connections kept open.
+	redisIdle = 16
+	// updateRetries bounds the optimistic transactions of Update.
+	updateRetries = 10
+	// updateStripes serialize the updates of a key within the process, so
+	// transactions only conflict with other replicas.
+	updateStripes = 64
+)
+
+// addMemberScript adds ARGV[1] to the set KEYS[1] when it has less than
+// ARGV[2] members, expires it after ARGV[3] ms and returns its size.
+const addMemberScript = `
+local n = redis.call('SCARD', KEYS[1])
+if n < tonumber(ARGV[2]) then
+	redis.call('SADD', KEYS[1], ARGV[1])
+	redis.call('PEXPIRE', KEYS[1], ARGV[3])
+	n = redis.call('SCARD', KEYS[1])
+end
+return n`
+
+var errConflict = errors.New("redis: concurrent update")
+
+// redisError is an error reply of the server.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// Redis is a Store in a Redis server shared by the replicas. It speaks the
+// RESP protocol over a small pool of connections.
+type Redis struct {
+	addr     string
+	tls      bool
+	username string
+	password string
+	db       int
+	idle     chan *redisConn
+	stripes  [updateStripes]sync.Mutex
+}
+
+// NewRedis returns a store for a redis:// or rediss:// url, e.g.
+// redis://:password@localhost:6379/0.
+func NewRedis(rawURL string) (*Redis, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("unsupported redis url scheme %q", u.Scheme)
+	}
+	r := &Redis{
+		addr: u.Host,
+		tls:  u.Scheme == "rediss",
+		idle: make(chan *redisConn, redisIdle),
+	}
+	if u.Port() == "" {
+		r.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		r.username = u.User.Username()
+		r.password, _ = u.User.Password()
+	}
+	if path := strings.TrimPrefix(u.Path, "/"); path != "" {
+		if r.db, err = strconv.Atoi(path); err != nil {
+			return nil, fmt.Errorf("invalid redis database %q", path)
+		}
+	}
+	return r, nil
+}
+
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	// broken connections are closed instead of reused
+	broken bool
+}
+
+func (r *Redis) dial(ctx context.Context) (*redisConn, error) {
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	var conn net.Conn
+	var err error
+	if r.tls {
+		host, _, _ := net.SplitHostPort(r.addr)
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", r.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", r.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+	if r.password != "" {
+		args := []string{"AUTH", r.password}
+		if r.username != "" {
+			args = []string{"AUTH", r.username, r.password}
+		}
+		if _, err := c.do(ctx, args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if r.db != 0 {
+		if _, err := c.do(ctx, "SELECT", strconv.Itoa(r.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (r *Redis) get(ctx context.Context) (*redisConn, error) {
+	select {
+	case c := <-r.idle:
+		return c, nil
+	default:
+		return r.dial(ctx)
+	}
+}
+
+func (r *Redis) put(c *redisConn) {
+	if c.broken {
+		c.conn.Close()
+		return
+	}
+	select {
+	case r.idle <- c:
+	default:
+		c.conn.Close()
+	}
+}
+
+// do runs a single command on a pooled connection.
+func (r *Redis) do(ctx context.Context, args ...string) (any, error) {
+	c, err := r.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.put(c)
+	return c.do(ctx, args...)
+}
+
+// do sends a command and reads its reply. Error replies are returned as
+// redisError and keep the connection usable.
+func (c *redisConn) do(ctx context.Context, args ...string) (any, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(redisTimeout)
+	}
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		c.broken = true
+		return nil, err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		c.broken = true
+		return nil, err
+	}
+	reply, err := c.read()
+	if err != nil {
+		if _, ok := err.(redisError); !ok {
+			c.broken = true
+		}
+		return nil, err
+	}
+	return reply, nil
+}
+
+// read parses a RESP reply: simple strings as string, integers as int64,
+// bulk strings as []byte and arrays as []any, nil for null replies.
+func (c *redisConn) read() (any, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.reader, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		var failed error
+		for i := range items {
+			item, err := c.read()
+			if _, ok := err.(redisError); ok {
+				// an error inside an array, e.g. in EXEC, fails the whole
+				// reply once it is read to the end, so the next command of
+				// the connection does not read the rest of it
+				if failed == nil {
+					failed = err
+				}
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		if failed != nil {
+			return nil, failed
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
+
+func millis(ttl time.Duration) string {
+	ms := ttl.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	return strconv.FormatInt(ms, 10)
+}
+
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	reply, err := r.do(ctx, "GET", key)
+	if err != nil {
+		return nil, err
+	}
+	value, _ := reply.([]byte)
+	return value, nil
+}
+
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := r.do(ctx, "SET", key, string(value), "PX", millis(ttl))
+	return err
+}
+
+func (r *Redis) Delete(ctx context.Context, key string) error {
+	_, err := r.do(ctx, "DEL", key)
+	return err
+}
+
+// Update reads key under WATCH and writes the new value in a transaction,
+// retrying when another replica changed key in between.
+func (r *Redis) Update(ctx context.Context, key string, ttl time.Duration, fn func(value []byte) ([]byte, error)) error {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	stripe := &r.stripes[h.Sum32()%updateStripes]
+	stripe.Lock()
+	defer stripe.Unlock()
+	for attempt := 0; attempt < updateRetries; attempt++ {
+		err := r.update(ctx, key, ttl, fn)
+		if err != errConflict {
+			return err
+		}
+		backoff := 5 << attempt
+		if backoff > 100 {
+			backoff = 100
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(1+rand.Intn(backoff)) * time.Millisecond):
+		}
+	}
+	return errConflict
+}
+
+func (r *Redis) update(ctx context.Context, key string, ttl time.Duration, fn func(value []byte) ([]byte, error)) error {
+	c, err := r.get(ctx)
+	if err != nil {
+		return err
+	}
+	defer r.put(c)
+	if _, err := c.do(ctx, "WATCH", key); err != nil {
+		return err
+	}
+	reply, err := c.do(ctx, "GET", key)
+	if err != nil {
+		c.broken = true
+		return err
+	}
+	current, _ := reply.([]byte)
+	value, err := fn(current)
+	if err != nil {
+		if _, err := c.do(ctx, "UNWATCH"); err != nil {
+			c.broken = true
+		}
+		return err
+	}
+	for _, args := range [][]string{{"MULTI"}, {"SET", key, string(value), "PX", millis(ttl)}} {
+		if _, err := c.do(ctx, args...); err != nil {
+			c.broken = true
+			return err
+		}
+	}
+	reply, err = c.do(ctx, "EXEC")
+	if err != nil {
+		return err
+	}
+	if reply == nil {
+		return errConflict
+	}
+	return nil
+}
+
+func (r *Redis) AddMember(ctx context.Context, key, member string, max int, ttl time.Duration) (int, error) {
+	reply, err := r.do(ctx, "EVAL", addMemberScript, "1", key, member, strconv.Itoa(max), millis(ttl))
+	if err != nil {
+		return 0, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: unexpected reply %v", reply)
+	}
+	return int(n), nil
+}
+
+func (r *Redis) Publish(ctx context.Context, channel, message string) error {
+	_, err := r.do(ctx, "PUBLISH", channel, message)
+	return err
+}
+
+// Subscribe listens on a dedicated connection, reconnecting after errors.
+func (r *Redis) Subscribe(ctx context.Context, channel string, fn func(message string)) {
+	for {
+		if err := r.subscribe(ctx, channel, fn); err != nil && ctx.Err() == nil {
+			logger.S().Warnf("redis subscription to %s error: %v", channel, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (r *Redis) subscribe(ctx context.Context, channel string, fn func(message string)) error {
+	c, err := r.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.conn.Close()
+	if _, err := c.do(ctx, "SUBSCRIBE", channel); err != nil {
+		return err
+	}
+	// replies only arrive with messages, closing the connection ends the
+	// blocked read
+	if err := c.conn.SetDeadline(time.Time{}); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.conn.Close()
+		case <-done:
+		}
+	}()
+	for {
+		reply, err := c.read()
+		if err != nil {
+			return err
+		}
+		items, ok := reply.([]any)
+		if !ok || len(items) != 3 {
+			continue
+		}
+		if kind, _ := items[0].([]byte); string(kind) != "message" {
+			continue
+		}
+		if payload, ok := items[2].([]byte); ok {
+			fn(string(payload))
+		}
+	}
+}
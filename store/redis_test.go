@@ -0,0 +1,21 @@
+package store
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestRedisReadArrayError(t *testing.T) {
+	// an EXEC reply with a failed command, then the reply of the next command
+	c := &redisConn{reader: bufio.NewReader(strings.NewReader("*3\r\n+OK\r\n-ERR wrong type\r\n:1\r\n+PONG\r\n"))}
+
+	_, err := c.read()
+	if _, ok := err.(redisError); !ok || err != redisError("ERR wrong type") {
+		t.Fatalf("array reply error = %v", err)
+	}
+	reply, err := c.read()
+	if err != nil || reply != "PONG" {
+		t.Fatalf("next reply = %v, %v", reply, err)
+	}
+}
@@ -0,0 +1,38 @@
+// Package store keeps the short lived state of the api tier, such as rate
+// counters and bans, in memory for a single replica or in Redis when
+// several replicas serve behind a load balancer.
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Store is a key value store whose keys expire.
+type Store interface {
+	// Get returns the value of key, nil when it is missing or expired.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set stores value for ttl.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+	// Update atomically replaces the value of key, nil when missing, with
+	// the result of fn and keeps it for ttl. fn may run more than once when
+	// replicas update key concurrently.
+	Update(ctx context.Context, key string, ttl time.Duration, fn func(value []byte) ([]byte, error)) error
+	// AddMember adds member to the set key unless it already has max
+	// members, keeps the set for ttl and returns its size.
+	AddMember(ctx context.Context, key, member string, max int, ttl time.Duration) (int, error)
+	// Publish sends message to the subscribers of channel on every replica.
+	Publish(ctx context.Context, channel, message string) error
+	// Subscribe calls fn with the messages of channel until ctx is
+	// cancelled.
+	Subscribe(ctx context.Context, channel string, fn func(message string))
+}
+
+// New returns a Redis store for url, or a memory store when url is empty.
+func New(url string) (Store, error) {
+	if url == "" {
+		return NewMemory(), nil
+	}
+	return NewRedis(url)
+}
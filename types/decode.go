@@ -0,0 +1,208 @@
+package types
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// userOperations are the operations decoded by DecodeUserOperation, their
+// byte slices reused by the next operation decoded.
+var userOperations = sync.Pool{New: func() any { return new(UserOperation) }}
+
+// ReleaseUserOperation returns an operation of DecodeUserOperation to the
+// pool, neither it nor its byte slices may be used afterwards. Big integers
+// are not reused, the gas limits being shared with the sponsorship.
+func ReleaseUserOperation(op *UserOperation) {
+	if op == nil {
+		return
+	}
+	*op = UserOperation{
+		InitCode:         op.InitCode[:0],
+		CallData:         op.CallData[:0],
+		PaymasterAndData: op.PaymasterAndData[:0],
+		Signature:        op.Signature[:0],
+	}
+	userOperations.Put(op)
+}
+
+func decodeError(name string, err error) error {
+	return fmt.Errorf("error decoding '%s': %v", name, err)
+}
+
+// opAddress decodes an address string leniently like NewUserOperation.
+type opAddress struct {
+	name  string
+	value common.Address
+}
+
+func (a *opAddress) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	s, err := jsonString(data)
+	if err != nil {
+		return decodeError(a.name, err)
+	}
+	a.value = common.HexToAddress(s)
+	return nil
+}
+
+// opBig decodes a hex or decimal string, or an integer number, into a big
+// integer.
+type opBig struct {
+	name  string
+	value *big.Int
+}
+
+func (b *opBig) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if data[0] != '"' {
+		// numbers are parsed exactly, fractions and exponents are refused
+		n, ok := new(big.Int).SetString(string(data), 10)
+		if !ok {
+			return decodeError(b.name, errBigInt)
+		}
+		b.value = n
+		return nil
+	}
+	s, err := jsonString(data)
+	if err != nil {
+		return decodeError(b.name, err)
+	}
+	n, ok := new(big.Int).SetString(s, 0)
+	if !ok {
+		return decodeError(b.name, errBigInt)
+	}
+	b.value = n
+	return nil
+}
+
+// opBytes decodes a 0x prefixed hex string into buf, reusing its capacity.
+type opBytes struct {
+	name string
+	buf  []byte
+	set  bool
+}
+
+func (b *opBytes) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var s []byte
+	if data[0] == '"' && bytes.IndexByte(data, '\\') < 0 {
+		s = data[1 : len(data)-1]
+	} else {
+		str, err := jsonString(data)
+		if err != nil {
+			return decodeError(b.name, err)
+		}
+		s = []byte(str)
+	}
+	if len(s) < 2 || s[0] != '0' || s[1] != 'x' {
+		return decodeError(b.name, errors.New("not byte string"))
+	}
+	s = s[2:]
+	if len(s)%2 == 1 {
+		return decodeError(b.name, hex.ErrLength)
+	}
+	n := len(s) / 2
+	if b.buf == nil || cap(b.buf) < n {
+		b.buf = make([]byte, n)
+	}
+	b.buf = b.buf[:n]
+	if _, err := hex.Decode(b.buf, s); err != nil {
+		return decodeError(b.name, err)
+	}
+	b.set = true
+	return nil
+}
+
+func (b *opBytes) bytes() []byte {
+	if !b.set {
+		return nil
+	}
+	return b.buf
+}
+
+var errBigInt = errors.New("bigInt conversion failed")
+
+func jsonString(data []byte) (string, error) {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return "", fmt.Errorf("expected a string, got %s", data)
+	}
+	return s, nil
+}
+
+// opFields are the fields of a user operation as sent in JSON.
+type opFields struct {
+	Sender               opAddress `json:"sender"`
+	Nonce                opBig     `json:"nonce"`
+	InitCode             opBytes   `json:"initCode"`
+	CallData             opBytes   `json:"callData"`
+	CallGasLimit         opBig     `json:"callGasLimit"`
+	VerificationGasLimit opBig     `json:"verificationGasLimit"`
+	PreVerificationGas   opBig     `json:"preVerificationGas"`
+	MaxFeePerGas         opBig     `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas opBig     `json:"maxPriorityFeePerGas"`
+	PaymasterAndData     opBytes   `json:"paymasterAndData"`
+	Signature            opBytes   `json:"signature"`
+}
+
+// DecodeUserOperation decodes and validates the JSON user operation data
+// straight into a pooled operation, without the intermediate map of
+// NewUserOperation. It accepts the same values, except that JSON numbers
+// must be integers and keep their exact value. The operation should be
+// released with ReleaseUserOperation once the request is done with it.
+func DecodeUserOperation(data []byte) (*UserOperation, error) {
+	op := userOperations.Get().(*UserOperation)
+	fields := opFields{
+		Sender:               opAddress{name: "sender"},
+		Nonce:                opBig{name: "nonce"},
+		InitCode:             opBytes{name: "initCode", buf: op.InitCode},
+		CallData:             opBytes{name: "callData", buf: op.CallData},
+		CallGasLimit:         opBig{name: "callGasLimit"},
+		VerificationGasLimit: opBig{name: "verificationGasLimit"},
+		PreVerificationGas:   opBig{name: "preVerificationGas"},
+		MaxFeePerGas:         opBig{name: "maxFeePerGas"},
+		MaxPriorityFeePerGas: opBig{name: "maxPriorityFeePerGas"},
+		PaymasterAndData:     opBytes{name: "paymasterAndData", buf: op.PaymasterAndData},
+		Signature:            opBytes{name: "signature", buf: op.Signature},
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		ReleaseUserOperation(op)
+		return nil, err
+	}
+	*op = UserOperation{
+		Sender:               fields.Sender.value,
+		Nonce:                fields.Nonce.value,
+		InitCode:             fields.InitCode.bytes(),
+		CallData:             fields.CallData.bytes(),
+		CallGasLimit:         fields.CallGasLimit.value,
+		VerificationGasLimit: fields.VerificationGasLimit.value,
+		PreVerificationGas:   fields.PreVerificationGas.value,
+		MaxFeePerGas:         fields.MaxFeePerGas.value,
+		MaxPriorityFeePerGas: fields.MaxPriorityFeePerGas.value,
+		PaymasterAndData:     fields.PaymasterAndData.bytes(),
+		Signature:            fields.Signature.bytes(),
+	}
+
+	onlyOnce.Do(func() {
+		validate.RegisterCustomTypeFunc(validateAddressType, common.Address{})
+		validate.RegisterCustomTypeFunc(validateBigIntType, big.Int{})
+	})
+	if err := validate.Struct(op); err != nil {
+		ReleaseUserOperation(op)
+		return nil, err
+	}
+	return op, nil
+}
@@ -0,0 +1,44 @@
+package types
+
+import (
+	"fmt"
+	"testing"
+)
+
+func decodeNonce(t *testing.T, nonce string) (*UserOperation, error) {
+	t.Helper()
+	return DecodeUserOperation([]byte(fmt.Sprintf(`{
+		"sender": "0x0000000000000000000000000000000000000001",
+		"nonce": %s,
+		"initCode": "0x",
+		"callData": "0x",
+		"callGasLimit": "0x5208",
+		"verificationGasLimit": "0x5208",
+		"preVerificationGas": "0x5208",
+		"maxFeePerGas": "0x1",
+		"maxPriorityFeePerGas": "0x1",
+		"paymasterAndData": "0x",
+		"signature": "0x"
+	}`, nonce)))
+}
+
+func TestDecodeLargeNumber(t *testing.T) {
+	// 2^53 + 1 is the first integer a float64 cannot hold
+	op, err := decodeNonce(t, "9007199254740993")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ReleaseUserOperation(op)
+	if op.Nonce.String() != "9007199254740993" {
+		t.Fatalf("nonce decoded as %s", op.Nonce)
+	}
+}
+
+func TestDecodeFractionRefused(t *testing.T) {
+	for _, nonce := range []string{"1.5", "1e3"} {
+		if op, err := decodeNonce(t, nonce); err == nil {
+			ReleaseUserOperation(op)
+			t.Errorf("nonce %s accepted", nonce)
+		}
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/go-playground/validator"
 	"github.com/mitchellh/mapstructure"
 )
@@ -33,6 +34,10 @@ var (
 	UserOpType, _ = abi.NewType("tuple", "op", UserOpPrimitives)
 	UserOpArr, _  = abi.NewType("tuple[]", "ops", UserOpPrimitives)
 
+	address, _ = abi.NewType("address", "", nil)
+	uint256, _ = abi.NewType("uint256", "", nil)
+	bytes32, _ = abi.NewType("bytes32", "", nil)
+
 	validate = validator.New()
 	onlyOnce = sync.Once{}
 )
@@ -235,3 +240,62 @@ func (op *UserOperation) ToMap() (map[string]any, error) {
 	}
 	return opData, nil
 }
+
+// PackForSignature returns the abi encoding of op with dynamic fields hashed,
+// matching UserOperationLib.pack in EntryPoint v0.6.
+func (op *UserOperation) PackForSignature() []byte {
+	args := abi.Arguments{
+		{Name: "sender", Type: address},
+		{Name: "nonce", Type: uint256},
+		{Name: "hashInitCode", Type: bytes32},
+		{Name: "hashCallData", Type: bytes32},
+		{Name: "callGasLimit", Type: uint256},
+		{Name: "verificationGasLimit", Type: uint256},
+		{Name: "preVerificationGas", Type: uint256},
+		{Name: "maxFeePerGas", Type: uint256},
+		{Name: "maxPriorityFeePerGas", Type: uint256},
+		{Name: "hashPaymasterAndData", Type: bytes32},
+	}
+	packed, _ := args.Pack(
+		op.Sender,
+		op.Nonce,
+		crypto.Keccak256Hash(op.InitCode),
+		crypto.Keccak256Hash(op.CallData),
+		op.CallGasLimit,
+		op.VerificationGasLimit,
+		op.PreVerificationGas,
+		op.MaxFeePerGas,
+		op.MaxPriorityFeePerGas,
+		crypto.Keccak256Hash(op.PaymasterAndData),
+	)
+	return packed
+}
+
+// GetUserOpHash returns the hash EntryPoint.getUserOpHash would compute for op.
+func (op *UserOperation) GetUserOpHash(entryPoint common.Address, chainID *big.Int) common.Hash {
+	args := abi.Arguments{
+		{Name: "hash", Type: bytes32},
+		{Name: "entryPoint", Type: address},
+		{Name: "chainId", Type: uint256},
+	}
+	packed, _ := args.Pack(
+		crypto.Keccak256Hash(op.PackForSignature()),
+		entryPoint,
+		chainID,
+	)
+	return crypto.Keccak256Hash(packed)
+}
+
+// GetRequiredPrefund returns the prefund EntryPoint v0.6 locks for op. The
+// verification gas is counted three times when a paymaster is used, to cover
+// the postOp call.
+func (op *UserOperation) GetRequiredPrefund(withPaymaster bool) *big.Int {
+	mul := big.NewInt(1)
+	if withPaymaster {
+		mul = big.NewInt(3)
+	}
+	requiredGas := new(big.Int).Mul(op.VerificationGasLimit, mul)
+	requiredGas.Add(requiredGas, op.CallGasLimit)
+	requiredGas.Add(requiredGas, op.PreVerificationGas)
+	return requiredGas.Mul(requiredGas, op.MaxFeePerGas)
+}
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ParseAddress validates a hex address. Mixed case input must carry a valid
+// EIP-55 checksum, all lower or upper case input is accepted as is.
+func ParseAddress(s string) (common.Address, error) {
+	if !common.IsHexAddress(s) {
+		return common.Address{}, fmt.Errorf("invalid address %s", s)
+	}
+	address := common.HexToAddress(s)
+	digits := strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	if digits != strings.ToLower(digits) && digits != strings.ToUpper(digits) &&
+		digits != strings.TrimPrefix(address.Hex(), "0x") {
+		return common.Address{}, fmt.Errorf("invalid address checksum %s", s)
+	}
+	return address, nil
+}
+
+// NormalizeAddress validates s with ParseAddress and returns the lower case
+// form addresses are stored in.
+func NormalizeAddress(s string) (string, error) {
+	address, err := ParseAddress(s)
+	if err != nil {
+		return "", err
+	}
+	return LowerAddress(address), nil
+}
+
+// LowerAddress returns the lower case form addresses are stored in.
+func LowerAddress(address common.Address) string {
+	return strings.ToLower(address.Hex())
+}
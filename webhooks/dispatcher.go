@@ -0,0 +1,244 @@
+// Package webhooks delivers the events queued for the webhooks of api keys.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// Delivery headers. SignatureHeader is "v1=" and the hex HMAC-SHA256 with
+// the webhook secret of the timestamp, a dot and the body.
+const (
+	EventHeader     = "X-Paymaster-Event"
+	DeliveryHeader  = "X-Paymaster-Delivery"
+	TimestampHeader = "X-Paymaster-Timestamp"
+	SignatureHeader = "X-Paymaster-Signature"
+)
+
+const (
+	// batchSize bounds the deliveries sent per check.
+	batchSize = 100
+	// firstRetry is the delay before the second attempt, doubled for each
+	// further attempt up to maxRetry.
+	firstRetry = 30 * time.Second
+	maxRetry   = 6 * time.Hour
+)
+
+// Event is the body posted to a webhook.
+type Event struct {
+	ID        uint            `json:"id"`
+	Event     string          `json:"event"`
+	CreatedAt int64           `json:"createdAt"`
+	Data      json.RawMessage `json:"data"`
+}
+
+type Config struct {
+	Interval time.Duration
+	// MaxAttempts fails a delivery after this many attempts.
+	MaxAttempts int
+	// Retention removes finished deliveries after this long.
+	Retention time.Duration
+	// AllowPrivate lets webhooks reach loopback and private addresses.
+	AllowPrivate bool
+}
+
+// Dispatcher posts the pending deliveries, retrying failed attempts with
+// exponential backoff.
+type Dispatcher struct {
+	conf   *Config
+	rep    db.Repository
+	client *http.Client
+}
+
+func NewDispatcher(conf *Config, rep db.Repository) *Dispatcher {
+	if conf.Interval == 0 {
+		conf.Interval = 5 * time.Second
+	}
+	if conf.MaxAttempts <= 0 {
+		conf.MaxAttempts = 8
+	}
+	if conf.Retention == 0 {
+		conf.Retention = 30 * 24 * time.Hour
+	}
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !conf.AllowPrivate {
+		dialer.Control = publicOnly
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &Dispatcher{
+		conf: conf,
+		rep:  rep,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: transport,
+			// a redirect could point to an address the dialer refuses to
+			// reach directly
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// publicOnly refuses connections to addresses internal to the deployment,
+// so webhooks cannot be used to reach them.
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return fmt.Errorf("webhook address %s is not public", host)
+	}
+	return nil
+}
+
+// Run sends the due deliveries every interval until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	for {
+		if err := d.check(ctx, time.Now()); err != nil {
+			logger.S().Errorf("webhook dispatcher error: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.conf.Interval):
+		}
+	}
+}
+
+func (d *Dispatcher) check(ctx context.Context, now time.Time) error {
+	if err := models.PruneWebhookDeliveries(d.rep, now.Add(-d.conf.Retention)); err != nil {
+		return err
+	}
+	deliveries, err := models.DueWebhookDeliveries(d.rep, now, batchSize)
+	if err != nil {
+		return err
+	}
+	for i := range deliveries {
+		if err := d.deliver(ctx, &deliveries[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliver attempts delivery once and saves the outcome.
+func (d *Dispatcher) deliver(ctx context.Context, delivery *models.WebhookDelivery) error {
+	now := time.Now()
+	delivery.Attempts++
+	if delivery.Webhook.ID == 0 {
+		delivery.Status = models.DeliveryFailed
+		delivery.LastError = "webhook deleted"
+		return d.save(delivery)
+	}
+
+	status, err := d.post(ctx, delivery, now)
+	delivery.LastStatusCode = status
+	delivery.LastError = ""
+	switch {
+	case err == nil:
+		delivery.Status = models.DeliveryDelivered
+		delivery.DeliveredAt = &now
+	case delivery.Attempts >= d.conf.MaxAttempts:
+		delivery.Status = models.DeliveryFailed
+		delivery.LastError = truncate(err.Error(), 255)
+		logger.S().Warnf("Webhook %d gave up delivery %d after %d attempts: %v", delivery.WebhookID, delivery.ID, delivery.Attempts, err)
+	default:
+		delivery.LastError = truncate(err.Error(), 255)
+		delivery.NextAttemptAt = now.Add(backoff(delivery.Attempts))
+	}
+	return d.save(delivery)
+}
+
+// save stores the outcome of an attempt, leaving the webhook as it is.
+func (d *Dispatcher) save(delivery *models.WebhookDelivery) error {
+	return d.rep.Model(&models.WebhookDelivery{}).Where(`"id" = ?`, delivery.ID).Updates(map[string]any{
+		"status":           delivery.Status,
+		"attempts":         delivery.Attempts,
+		"next_attempt_at":  delivery.NextAttemptAt,
+		"last_status_code": delivery.LastStatusCode,
+		"last_error":       delivery.LastError,
+		"delivered_at":     delivery.DeliveredAt,
+	}).Error
+}
+
+// backoff is the delay after the given number of failed attempts.
+func backoff(attempts int) time.Duration {
+	delay := firstRetry
+	for i := 1; i < attempts && delay < maxRetry; i++ {
+		delay *= 2
+	}
+	if delay > maxRetry {
+		delay = maxRetry
+	}
+	return delay
+}
+
+func (d *Dispatcher) post(ctx context.Context, delivery *models.WebhookDelivery, now time.Time) (int, error) {
+	body, err := json.Marshal(&Event{
+		ID:        delivery.ID,
+		Event:     delivery.Event,
+		CreatedAt: delivery.CreatedAt.Unix(),
+		Data:      json.RawMessage(delivery.Payload),
+	})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, "v1="+Sign(delivery.Webhook.Secret, timestamp, body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the hex HMAC-SHA256 of timestamp and body with secret, as
+// sent in SignatureHeader.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}